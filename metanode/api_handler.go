@@ -19,11 +19,19 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
+	"time"
 
 	"bytes"
 
 	"github.com/chubaofs/chubaofs/proto"
 	"github.com/chubaofs/chubaofs/util/log"
+	"golang.org/x/time/rate"
+)
+
+// a verify reads the whole snapshot, so only one runs at a time and a burst of them is spread out
+var (
+	verifySnapshotLimiter = rate.NewLimiter(rate.Every(10*time.Second), 3)
+	verifySnapshotRunning = make(chan struct{}, 1)
 )
 
 // APIResponse defines the structure of the response to an HTTP request
@@ -59,6 +67,10 @@ func (m *MetaNode) registerAPIHandler() (err error) {
 	http.HandleFunc("/getDirectory", m.getDirectoryHandler)
 	http.HandleFunc("/getAllDentry", m.getAllDentriesHandler)
 	http.HandleFunc("/getParams", m.getParamsHandler)
+	http.HandleFunc("/getSnapshotDigest", m.getSnapshotDigestHandler)
+	http.HandleFunc("/describeSnapshot", m.describeSnapshotHandler)
+	http.HandleFunc("/forceFullStore", m.forceFullStoreHandler)
+	http.HandleFunc("/verifySnapshot", m.verifySnapshotHandler)
 	return
 }
 
@@ -116,6 +128,168 @@ func (m *MetaNode) getPartitionByIDHandler(w http.ResponseWriter, r *http.Reques
 	resp.Msg = http.StatusText(http.StatusOK)
 }
 
+func (m *MetaNode) getSnapshotDigestHandler(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	resp := NewAPIResponse(http.StatusBadRequest, "")
+	defer func() {
+		data, _ := resp.Marshal()
+		if _, err := w.Write(data); err != nil {
+			log.LogErrorf("[getSnapshotDigestHandler] response %s", err)
+		}
+	}()
+	pid, err := strconv.ParseUint(r.FormValue("pid"), 10, 64)
+	if err != nil {
+		resp.Msg = err.Error()
+		return
+	}
+	applyID, err := strconv.ParseUint(r.FormValue("applyID"), 10, 64)
+	if err != nil {
+		resp.Msg = err.Error()
+		return
+	}
+	mp, err := m.metadataManager.GetPartition(pid)
+	if err != nil {
+		resp.Code = http.StatusNotFound
+		resp.Msg = err.Error()
+		return
+	}
+	// a digest of the current state encodes the whole partition, so only one is computed at a time
+	m.digestMu.Lock()
+	digest, err := mp.DigestAt(applyID)
+	m.digestMu.Unlock()
+	if err != nil {
+		resp.Code = http.StatusNotFound
+		resp.Msg = err.Error()
+		return
+	}
+	resp.Data = digest
+	resp.Code = http.StatusOK
+	resp.Msg = http.StatusText(http.StatusOK)
+}
+
+func (m *MetaNode) describeSnapshotHandler(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	resp := NewAPIResponse(http.StatusBadRequest, "")
+	defer func() {
+		data, _ := resp.Marshal()
+		if _, err := w.Write(data); err != nil {
+			log.LogErrorf("[describeSnapshotHandler] response %s", err)
+		}
+	}()
+	pid, err := strconv.ParseUint(r.FormValue("pid"), 10, 64)
+	if err != nil {
+		resp.Msg = err.Error()
+		return
+	}
+	mp, err := m.metadataManager.GetPartition(pid)
+	if err != nil {
+		resp.Code = http.StatusNotFound
+		resp.Msg = err.Error()
+		return
+	}
+	conf := mp.GetBaseConfig()
+	snap, err := OpenActiveSnapshot(conf.RootDir, conf.Snapshot)
+	if err != nil {
+		resp.Code = http.StatusNotFound
+		resp.Msg = err.Error()
+		return
+	}
+	defer snap.Close()
+	desc, err := describeSnapshot(snap)
+	if err != nil {
+		resp.Code = http.StatusNotFound
+		resp.Msg = err.Error()
+		return
+	}
+	resp.Data = desc
+	resp.Code = http.StatusOK
+	resp.Msg = http.StatusText(http.StatusOK)
+}
+
+func (m *MetaNode) forceFullStoreHandler(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	resp := NewAPIResponse(http.StatusBadRequest, "")
+	defer func() {
+		data, _ := resp.Marshal()
+		if _, err := w.Write(data); err != nil {
+			log.LogErrorf("[forceFullStoreHandler] response %s", err)
+		}
+	}()
+	pid, err := strconv.ParseUint(r.FormValue("pid"), 10, 64)
+	if err != nil {
+		resp.Msg = err.Error()
+		return
+	}
+	mp, err := m.metadataManager.GetPartition(pid)
+	if err != nil {
+		resp.Code = http.StatusNotFound
+		resp.Msg = err.Error()
+		return
+	}
+	mp.ForceFullStore()
+	log.LogWarnf("forceFullStoreHandler: force the next store: partitionID(%v)", pid)
+	resp.Code = http.StatusOK
+	resp.Msg = http.StatusText(http.StatusOK)
+}
+
+// verifySnapshotHandler checks the snapshot on disk of a partition as CheckSnapshot does and returns the report.
+// The request is refused with 429 while another verify runs or when the rate limit is reached.
+// The files are opened together with OpenActiveSnapshot, so a store that replaces the snapshot during the
+// check does not change what is checked.
+func (m *MetaNode) verifySnapshotHandler(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	resp := NewAPIResponse(http.StatusBadRequest, "")
+	defer func() {
+		data, _ := resp.Marshal()
+		if _, err := w.Write(data); err != nil {
+			log.LogErrorf("[verifySnapshotHandler] response %s", err)
+		}
+	}()
+	pid, err := strconv.ParseUint(r.FormValue("pid"), 10, 64)
+	if err != nil {
+		resp.Msg = err.Error()
+		return
+	}
+	mp, err := m.metadataManager.GetPartition(pid)
+	if err != nil {
+		resp.Code = http.StatusNotFound
+		resp.Msg = err.Error()
+		return
+	}
+	select {
+	case verifySnapshotRunning <- struct{}{}:
+		defer func() { <-verifySnapshotRunning }()
+	default:
+		resp.Code = http.StatusTooManyRequests
+		resp.Msg = "another snapshot verify is running"
+		return
+	}
+	if !verifySnapshotLimiter.Allow() {
+		resp.Code = http.StatusTooManyRequests
+		resp.Msg = "too many snapshot verifies, retry later"
+		return
+	}
+	conf := mp.GetBaseConfig()
+	start := time.Now()
+	snap, err := OpenActiveSnapshot(conf.RootDir, conf.Snapshot)
+	if err != nil {
+		resp.Code = http.StatusNotFound
+		resp.Msg = err.Error()
+		return
+	}
+	defer snap.Close()
+	report := checkSnapshot(snap)
+	if report.OK {
+		log.LogInfof("verifySnapshotHandler: snapshot verified: partitionID(%v) cost(%v)", pid, time.Since(start))
+	} else {
+		log.LogWarnf("verifySnapshotHandler: snapshot corrupted: partitionID(%v) cost(%v) err(%v)",
+			pid, time.Since(start), report.Error)
+	}
+	resp.Data = report
+	resp.Code = http.StatusOK
+	resp.Msg = http.StatusText(http.StatusOK)
+}
+
 func (m *MetaNode) getAllInodesHandler(w http.ResponseWriter, r *http.Request) {
 	var err error
 
@@ -148,6 +148,60 @@ const (
 	cfgTotalMem          = "totalMem"
 	cfgZoneName          = "zoneName"
 
+	cfgStoreMutationThreshold = "storeMutationThreshold"
+	cfgSlowRecordSamples      = "snapshotSlowRecordSamples"
+	cfgWarmSnapshot           = "warmSnapshot"
+	cfgStrictInodeRange       = "strictInodeRange"
+	cfgStrictRangeOverlap     = "strictRangeOverlap"
+	cfgStrictInodeCheck       = "strictInodeCheck"
+	cfgTolerateDentryConflict = "tolerateDentryConflicts"
+	cfgSnapshotLoadFadvise    = "snapshotLoadFadvise"
+	cfgSnapshotDirName        = "snapshotDirName"
+	cfgSnapshotTmpDirName     = "snapshotTmpDirName"
+	cfgSnapshotBackupDirName  = "snapshotBackupDirName"
+	cfgMetadataTmpFileName    = "metadataTmpFileName"
+	cfgDurabilityMode         = "durabilityMode"
+	cfgLoadPartitionWorkers   = "loadPartitionWorkers"
+	cfgLoadPartitionMemoryMB  = "loadPartitionMemoryMB"
+	cfgLoadDiskWorkers        = "loadPartitionDiskWorkers"
+	cfgScrubIntervalHours     = "scrubIntervalHours"
+	cfgScrubWorkers           = "scrubWorkers"
+	cfgScrubDiskBandwidthMB   = "scrubDiskBandwidthMB"
+	cfgGroupInodesByType      = "groupInodesByType"
+	cfgStoreMinFreeSpaceMB    = "storeMinFreeSpaceMB"
+	cfgExtendMmapWindowMB     = "extendMmapWindowMB"
+	cfgStoreSyncIntervalMB    = "storeSyncIntervalMB"
+	cfgSnapshotMmapStore      = "snapshotMmapStore"
+	cfgStoreExtendDedup       = "storeExtendDedup"
+	cfgStoreReadBack          = "storeReadBack"
+	cfgStoreInodeColumns      = "storeInodeColumns"
+	cfgStorePreallocate       = "storePreallocate"
+	cfgSnapshotHMACKeyID      = "snapshotHMACKeyID"
+	cfgSnapshotHMACKeys       = "snapshotHMACKeys"
+	cfgSnapshotVolumeOverride = "snapshotVolumeOverrides"
+	cfgStoreRetryAttempts     = "storeRetryAttempts"
+	cfgStoreRetryBackoffMs    = "storeRetryBackoffMs"
+	cfgStoreRetryTimeoutSec   = "storeRetryTimeoutSec"
+	cfgStoreInodeIndex        = "storeInodeIndex"
+	cfgStoreDentryIndex       = "storeDentryIndex"
+	cfgSnapshotReadRepair     = "snapshotReadRepair"
+	cfgSnapshotLazyLoad       = "snapshotLazyLoad"
+	cfgMultipartIndexMax      = "multipartIndexMax"
+	cfgParentIndexMax         = "dentryParentIndexMax"
+	cfgSnapshotLoadLogLevel   = "snapshotLoadLogLevel"
+	cfgSnapshotStoreLogLevel  = "snapshotStoreLogLevel"
+	cfgSnapshotDebugParts     = "snapshotDebugPartitions"
+	cfgApplyIDRegression      = "applyIDRegression"
+	cfgLoadSinkBuffer         = "loadSinkBuffer"
+	cfgLoadSinkTimeoutMs      = "loadSinkTimeoutMs"
+	cfgAgeCheckpointsAfterHrs = "checkpointAgeAfterHours"
+	cfgStoreReport            = "storeReport"
+	cfgSnapshotQuarantineDir  = "snapshotQuarantineDir"
+	cfgQuarantineMaxMB        = "snapshotQuarantineMaxMB"
+	cfgSnapshotColdDir        = "snapshotColdDir"
+	cfgSnapshotColdAfterHours = "snapshotColdAfterHours"
+	cfgApplyIDCheck           = "applyIDCheck"
+
 	metaNodeDeleteBatchCountKey = "batchCount"
 )
 
@@ -155,6 +209,8 @@ const (
 	// interval of persisting in-memory data
 	intervalToPersistData = time.Minute * 5
 	intervalToSyncCursor  = time.Minute * 1
+	// interval of checking the mutations since the last store
+	intervalToCheckMutation = time.Second * 10
 )
 
 const (
@@ -163,3 +219,23 @@ const (
 	MB
 	GB
 )
+
+const (
+	// minimal size of a snapshot file to be loaded with fadvise
+	fadviseMinFileSize = 64 * MB
+
+	// minimal size of an extend record stored in chunks of storeChunkSize by storeExtend
+	bigExtendSize  = 1 * MB
+	storeChunkSize = 256 * KB
+
+	// defaults of the store retry when it is enabled
+	defaultStoreRetryBackoff = 100 * time.Millisecond
+	defaultStoreRetryTimeout = time.Minute
+
+	// default maximum number of references held by the multipart index of a partition
+	defaultMultipartIndexMax = 1 << 20
+
+	// defaults of the buffer and timeout of the load sink
+	defaultLoadSinkBuffer  = 4096
+	defaultLoadSinkTimeout = time.Second
+)
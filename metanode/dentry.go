@@ -17,6 +17,8 @@ package metanode
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
+	"io"
 )
 
 // Dentry wraps necessary properties of the `dentry` information in file system.
@@ -81,8 +83,11 @@ func (d *Dentry) Unmarshal(raw []byte) (err error) {
 	if err = binary.Read(buff, binary.BigEndian, &keyLen); err != nil {
 		return
 	}
+	if int64(keyLen) > int64(buff.Len()) {
+		return fmt.Errorf("dentry key length %v exceeds the remaining %v bytes", keyLen, buff.Len())
+	}
 	keyBytes := make([]byte, keyLen)
-	if _, err = buff.Read(keyBytes); err != nil {
+	if _, err = io.ReadFull(buff, keyBytes); err != nil {
 		return
 	}
 	if err = d.UnmarshalKey(keyBytes); err != nil {
@@ -91,8 +96,11 @@ func (d *Dentry) Unmarshal(raw []byte) (err error) {
 	if err = binary.Read(buff, binary.BigEndian, &valLen); err != nil {
 		return
 	}
+	if int64(valLen) > int64(buff.Len()) {
+		return fmt.Errorf("dentry value length %v exceeds the remaining %v bytes", valLen, buff.Len())
+	}
 	valBytes := make([]byte, valLen)
-	if _, err = buff.Read(valBytes); err != nil {
+	if _, err = io.ReadFull(buff, valBytes); err != nil {
 		return
 	}
 	err = d.UnmarshalValue(valBytes)
@@ -17,6 +17,9 @@ package metanode
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
 	"sync"
 
 	"github.com/chubaofs/chubaofs/util/btree"
@@ -51,8 +54,11 @@ func NewExtendFromBytes(raw []byte) (*Extend, error) {
 		if length, err = binary.ReadUvarint(buffer); err != nil {
 			return nil, err
 		}
+		if length > uint64(buffer.Len()) {
+			return nil, fmt.Errorf("extend field length %v exceeds the remaining %v bytes", length, buffer.Len())
+		}
 		var data = make([]byte, length)
-		if _, err = buffer.Read(data); err != nil {
+		if _, err = io.ReadFull(buffer, data); err != nil {
 			return nil, err
 		}
 		return data, nil
@@ -155,15 +161,82 @@ func (e *Extend) Bytes() ([]byte, error) {
 		}
 		return nil
 	}
-	for k, v := range e.dataMap {
+	// write the keys in order, so the same attributes are always encoded into the same bytes
+	for _, k := range e.sortedKeys() {
 		// key
 		if err = writeBytes([]byte(k)); err != nil {
 			return nil, err
 		}
 		// value
-		if err = writeBytes(v); err != nil {
+		if err = writeBytes(e.dataMap[k]); err != nil {
 			return nil, err
 		}
 	}
 	return buffer.Bytes(), nil
 }
+
+// sortedKeys returns the keys of the attributes in the order they are encoded.
+func (e *Extend) sortedKeys() []string {
+	var keys = make([]string, 0, len(e.dataMap))
+	for k := range e.dataMap {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func uvarintLen(v uint64) int {
+	var tmp = make([]byte, binary.MaxVarintLen64)
+	return binary.PutUvarint(tmp, v)
+}
+
+// encodedLen returns the length of the bytes returned by Bytes, without encoding them.
+func (e *Extend) encodedLen() int {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	n := uvarintLen(e.inode) + uvarintLen(uint64(len(e.dataMap)))
+	for k, v := range e.dataMap {
+		n += uvarintLen(uint64(len(k))) + len(k) + uvarintLen(uint64(len(v))) + len(v)
+	}
+	return n
+}
+
+// writeChunked writes the bytes returned by Bytes into w, without encoding them into one buffer first:
+// no write is longer than chunk, so a buffered writer never sees a write too large for its buffer.
+func (e *Extend) writeChunked(w io.Writer, chunk int) (err error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	var tmp = make([]byte, binary.MaxVarintLen64)
+	var writeUvarint = func(v uint64) error {
+		_, err := w.Write(tmp[:binary.PutUvarint(tmp, v)])
+		return err
+	}
+	var writeBytes = func(val []byte) error {
+		if err := writeUvarint(uint64(len(val))); err != nil {
+			return err
+		}
+		for len(val) > chunk {
+			if _, err := w.Write(val[:chunk]); err != nil {
+				return err
+			}
+			val = val[chunk:]
+		}
+		_, err := w.Write(val)
+		return err
+	}
+	if err = writeUvarint(e.inode); err != nil {
+		return
+	}
+	if err = writeUvarint(uint64(len(e.dataMap))); err != nil {
+		return
+	}
+	for _, k := range e.sortedKeys() {
+		if err = writeBytes([]byte(k)); err != nil {
+			return
+		}
+		if err = writeBytes(e.dataMap[k]); err != nil {
+			return
+		}
+	}
+	return
+}
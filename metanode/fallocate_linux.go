@@ -0,0 +1,26 @@
+// Copyright 2018 The Chubao Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metanode
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// fallocateKeepSize reserves the blocks of the first size bytes of the file without changing its size.
+func fallocateKeepSize(fp *os.File, size int64) error {
+	return unix.Fallocate(int(fp.Fd()), unix.FALLOC_FL_KEEP_SIZE, 0, size)
+}
@@ -0,0 +1,51 @@
+// Copyright 2018 The Chubao Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// +build gofuzz
+
+package metanode
+
+// Fuzz targets of the record decoders used by the snapshot load, for go-fuzz:
+//
+//	go-fuzz-build github.com/chubaofs/chubaofs/metanode
+//	go-fuzz -bin metanode-fuzz.zip -func FuzzInode -workdir fuzz/inode
+//
+// A target returns 1 if the input decodes, so go-fuzz prefers it for mutation, and 0 otherwise.
+// Any panic is a bug of the decoder. TestRecordDecodersMalformed runs the same decoders over the
+// mutations of valid records as part of the unit tests.
+
+func FuzzInode(data []byte) int {
+	return fuzzResult(NewInode(0, 0).Unmarshal(data))
+}
+
+func FuzzDentry(data []byte) int {
+	return fuzzResult((&Dentry{}).Unmarshal(data))
+}
+
+func FuzzExtend(data []byte) int {
+	_, err := NewExtendFromBytes(data)
+	return fuzzResult(err)
+}
+
+func FuzzMultipart(data []byte) int {
+	_, err := MultipartFromBytes(data)
+	return fuzzResult(err)
+}
+
+func fuzzResult(err error) int {
+	if err != nil {
+		return 0
+	}
+	return 1
+}
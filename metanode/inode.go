@@ -185,8 +185,11 @@ func (i *Inode) Unmarshal(raw []byte) (err error) {
 	if err = binary.Read(buff, binary.BigEndian, &keyLen); err != nil {
 		return
 	}
+	if int64(keyLen) > int64(buff.Len()) {
+		return fmt.Errorf("inode key length %v exceeds the remaining %v bytes", keyLen, buff.Len())
+	}
 	keyBytes := make([]byte, keyLen)
-	if _, err = buff.Read(keyBytes); err != nil {
+	if _, err = io.ReadFull(buff, keyBytes); err != nil {
 		return
 	}
 	if err = i.UnmarshalKey(keyBytes); err != nil {
@@ -195,8 +198,11 @@ func (i *Inode) Unmarshal(raw []byte) (err error) {
 	if err = binary.Read(buff, binary.BigEndian, &valLen); err != nil {
 		return
 	}
+	if int64(valLen) > int64(buff.Len()) {
+		return fmt.Errorf("inode value length %v exceeds the remaining %v bytes", valLen, buff.Len())
+	}
 	valBytes := make([]byte, valLen)
-	if _, err = buff.Read(valBytes); err != nil {
+	if _, err = io.ReadFull(buff, valBytes); err != nil {
 		return
 	}
 	err = i.UnmarshalValue(valBytes)
@@ -262,6 +268,9 @@ func (i *Inode) MarshalKey() (k []byte) {
 
 // UnmarshalKey unmarshals the exporterKey from bytes.
 func (i *Inode) UnmarshalKey(k []byte) (err error) {
+	if len(k) < 8 {
+		return fmt.Errorf("inode key length %v is less than 8", len(k))
+	}
 	i.Inode = binary.BigEndian.Uint64(k)
 	return
 }
@@ -360,6 +369,9 @@ func (i *Inode) UnmarshalValue(val []byte) (err error) {
 	if err = binary.Read(buff, binary.BigEndian, &symSize); err != nil {
 		return
 	}
+	if int64(symSize) > int64(buff.Len()) {
+		return fmt.Errorf("inode link target length %v exceeds the remaining %v bytes", symSize, buff.Len())
+	}
 	if symSize > 0 {
 		i.LinkTarget = make([]byte, symSize)
 		if _, err = io.ReadFull(buff, i.LinkTarget); err != nil {
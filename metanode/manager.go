@@ -27,6 +27,7 @@ import (
 	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/chubaofs/chubaofs/cmd/common"
 	"github.com/chubaofs/chubaofs/proto"
@@ -55,6 +56,9 @@ type MetadataManagerConfig struct {
 	RootDir   string
 	ZoneName  string
 	RaftStore raftstore.RaftStore
+	Snapshot  SnapshotConfig
+	Load      LoadConfig
+	Scrub     ScrubConfig
 }
 
 type metadataManager struct {
@@ -66,8 +70,13 @@ type metadataManager struct {
 	state              uint32
 	mu                 sync.RWMutex
 	partitions         map[uint64]MetaPartition // Key: metaRangeId, Val: metaPartition
+	restoring          map[uint64]struct{}      // IDs reserved by RestoreFromSnapshot, guarded by mu
 	metaNode           *MetaNode
 	flDeleteBatchCount atomic.Value
+	snapshotConfig     SnapshotConfig
+	loadConfig         LoadConfig
+	scrubConfig        ScrubConfig
+	scrubber           *scrubber
 }
 
 // HandleMetadataOperation handles the metadata operations.
@@ -202,12 +211,21 @@ func (m *metadataManager) Stop() {
 // onStart creates the connection pool and loads the partitions.
 func (m *metadataManager) onStart() (err error) {
 	m.connPool = util.NewConnectPool()
-	err = m.loadPartitions()
+	if err = m.loadPartitions(); err != nil {
+		return
+	}
+	if m.scrubConfig.Interval > 0 {
+		m.scrubber = newScrubber(m, m.scrubConfig)
+		m.scrubber.start()
+	}
 	return
 }
 
 // onStop stops each meta partitions.
 func (m *metadataManager) onStop() {
+	if m.scrubber != nil {
+		m.scrubber.stop()
+	}
 	if m.partitions != nil {
 		for _, partition := range m.partitions {
 			partition.Stop()
@@ -259,7 +277,7 @@ func (m *metadataManager) loadPartitions() (err error) {
 	if err != nil {
 		return
 	}
-	var wg sync.WaitGroup
+	var partitionDirs []string
 	for _, fileInfo := range fileInfoList {
 		if fileInfo.IsDir() && strings.HasPrefix(fileInfo.Name(), partitionPrefix) {
 
@@ -271,70 +289,129 @@ func (m *metadataManager) loadPartitions() (err error) {
 				os.Rename(oldName, newName)
 				continue
 			}
-
-			wg.Add(1)
-			go func(fileName string) {
-				var errload error
-				defer func() {
-					if r := recover(); r != nil {
-						log.LogErrorf("loadPartitions partition: %s, "+
-							"error: %s, failed: %v", fileName, errload, r)
-						log.LogFlush()
-						panic(r)
-					}
-					if errload != nil {
-						log.LogErrorf("loadPartitions partition: %s, "+
-							"error: %s", fileName, errload)
-						log.LogFlush()
-						panic(errload)
-					}
-				}()
-				defer wg.Done()
-				if len(fileName) < 10 {
-					log.LogWarnf("ignore unknown partition dir: %s", fileName)
-					return
+			partitionDirs = append(partitionDirs, fileInfo.Name())
+		}
+	}
+	var (
+		wg       sync.WaitGroup
+		limiter  = newLoadLimiter(m.loadConfig)
+		loaded   uint64
+		loadTime = time.Now()
+	)
+	log.LogInfof("loadPartitions: start loading: partitions(%v) config(%+v)", len(partitionDirs), m.loadConfig)
+	for _, partitionDir := range partitionDirs {
+		wg.Add(1)
+		go func(fileName string) {
+			var errload error
+			defer func() {
+				if r := recover(); r != nil {
+					log.LogErrorf("loadPartitions partition: %s, "+
+						"error: %s, failed: %v", fileName, errload, r)
+					log.LogFlush()
+					panic(r)
 				}
-				var id uint64
-				partitionId := fileName[len(partitionPrefix):]
-				id, errload = strconv.ParseUint(partitionId, 10, 64)
 				if errload != nil {
-					log.LogWarnf("ignore path: %s,not partition", partitionId)
-					return
+					log.LogErrorf("loadPartitions partition: %s, "+
+						"error: %s", fileName, errload)
+					log.LogFlush()
+					panic(errload)
 				}
+			}()
+			defer wg.Done()
+			if len(fileName) < 10 {
+				log.LogWarnf("ignore unknown partition dir: %s", fileName)
+				return
+			}
+			var id uint64
+			partitionId := fileName[len(partitionPrefix):]
+			id, errload = strconv.ParseUint(partitionId, 10, 64)
+			if errload != nil {
+				log.LogWarnf("ignore path: %s,not partition", partitionId)
+				return
+			}
 
-				partitionConfig := &MetaPartitionConfig{
-					NodeId:    m.nodeId,
-					RaftStore: m.raftStore,
-					RootDir:   path.Join(m.rootDir, fileName),
-					ConnPool:  m.connPool,
-				}
-				partitionConfig.AfterStop = func() {
-					m.detachPartition(id)
-				}
-				// check snapshot dir or backup
-				snapshotDir := path.Join(partitionConfig.RootDir, snapshotDir)
-				if _, errload = os.Stat(snapshotDir); errload != nil {
-					backupDir := path.Join(partitionConfig.RootDir, snapshotBackup)
-					if _, errload = os.Stat(backupDir); errload == nil {
-						if errload = os.Rename(backupDir, snapshotDir); errload != nil {
-							errload = errors.Trace(errload,
-								fmt.Sprintf(": fail recover backup snapshot %s",
-									snapshotDir))
-							return
-						}
+			partitionConfig := &MetaPartitionConfig{
+				NodeId:    m.nodeId,
+				RaftStore: m.raftStore,
+				RootDir:   path.Join(m.rootDir, fileName),
+				ConnPool:  m.connPool,
+				Snapshot:  m.snapshotConfig,
+			}
+			partitionConfig.AfterStop = func() {
+				m.detachPartition(id)
+			}
+			partitionConfig.Siblings = m.siblingRanges
+			// check snapshot dir or backup
+			snapshotDir := path.Join(partitionConfig.RootDir, partitionConfig.Snapshot.dirName())
+			if _, errload = os.Stat(snapshotDir); errload != nil {
+				backupDir := path.Join(partitionConfig.RootDir, partitionConfig.Snapshot.backupDirName())
+				if _, errload = os.Stat(backupDir); errload == nil {
+					if errload = os.Rename(backupDir, snapshotDir); errload != nil {
+						errload = errors.Trace(errload,
+							fmt.Sprintf(": fail recover backup snapshot %s",
+								snapshotDir))
+						return
 					}
-					errload = nil
 				}
-				partition := NewMetaPartition(partitionConfig, m)
-				errload = m.attachPartition(id, partition)
-				if errload != nil {
-					log.LogErrorf("load partition id=%d failed: %s.",
-						id, errload.Error())
-				}
-			}(fileInfo.Name())
-		}
+				errload = nil
+			}
+			size := snapshotDataSize(snapshotDir)
+			// a partition directory may link to another disk than the metadata directory
+			disk, _ := diskID(partitionConfig.RootDir)
+			limiter.acquire(size, disk)
+			defer limiter.release(size, disk)
+			start := time.Now()
+			partition := NewMetaPartition(partitionConfig, m)
+			errload = m.attachPartition(id, partition)
+			if errload != nil {
+				log.LogErrorf("load partition id=%d failed: %s.",
+					id, errload.Error())
+				return
+			}
+			log.LogInfof("loadPartitions: partition loaded: partitionID(%v) size(%v) cost(%v) progress(%v/%v)",
+				id, size, time.Since(start), atomic.AddUint64(&loaded, 1), len(partitionDirs))
+		}(partitionDir)
 	}
 	wg.Wait()
+	log.LogInfof("loadPartitions: load complete: partitions(%v) loaded(%v) cost(%v)",
+		len(partitionDirs), atomic.LoadUint64(&loaded), time.Since(loadTime))
+	// partitions loaded at the same time could not see each other, so check all of them again
+	err = m.checkRangeOverlaps()
+	return
+}
+
+// checkRangeOverlaps checks the range of every loaded meta partition against its siblings.
+func (m *metadataManager) checkRangeOverlaps() (err error) {
+	m.mu.RLock()
+	partitions := make([]MetaPartition, 0, len(m.partitions))
+	for _, partition := range m.partitions {
+		partitions = append(partitions, partition)
+	}
+	m.mu.RUnlock()
+	for _, partition := range partitions {
+		if mp, ok := partition.(*metaPartition); ok {
+			if err = mp.checkRangeOverlap(); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// siblingRanges returns the ranges of the loaded meta partitions of the volume other than the given one.
+func (m *metadataManager) siblingRanges(volName string, partitionID uint64) (ranges []InodeRange) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for id, partition := range m.partitions {
+		if id == partitionID {
+			continue
+		}
+		config := partition.GetBaseConfig()
+		if config.VolName != volName {
+			continue
+		}
+		ranges = append(ranges, InodeRange{PartitionID: id, Start: config.Start, End: config.End})
+	}
 	return
 }
 
@@ -380,6 +457,7 @@ func (m *metadataManager) createPartition(request *proto.CreateMetaPartitionRequ
 		NodeId:      m.nodeId,
 		RootDir:     path.Join(m.rootDir, partitionPrefix+partitionId),
 		ConnPool:    m.connPool,
+		Snapshot:    m.snapshotConfig,
 	}
 	mpc.AfterStop = func() {
 		m.detachPartition(request.PartitionID)
@@ -389,6 +467,10 @@ func (m *metadataManager) createPartition(request *proto.CreateMetaPartitionRequ
 		err = oldMp.IsEquareCreateMetaPartitionRequst(request)
 		return
 	}
+	if _, ok := m.restoring[request.PartitionID]; ok {
+		err = errors.NewErrorf("[createPartition] partition being restored: partitionID(%v)", request.PartitionID)
+		return
+	}
 
 	partition := NewMetaPartition(mpc, m)
 	if err = partition.PersistMetadata(); err != nil {
@@ -448,12 +530,16 @@ func (m *metadataManager) MarshalJSON() (data []byte, err error) {
 // NewMetadataManager returns a new metadata manager.
 func NewMetadataManager(conf MetadataManagerConfig, metaNode *MetaNode) MetadataManager {
 	return &metadataManager{
-		nodeId:     conf.NodeID,
-		zoneName:   conf.ZoneName,
-		rootDir:    conf.RootDir,
-		raftStore:  conf.RaftStore,
-		partitions: make(map[uint64]MetaPartition),
-		metaNode:   metaNode,
+		nodeId:         conf.NodeID,
+		zoneName:       conf.ZoneName,
+		rootDir:        conf.RootDir,
+		raftStore:      conf.RaftStore,
+		partitions:     make(map[uint64]MetaPartition),
+		restoring:      make(map[uint64]struct{}),
+		metaNode:       metaNode,
+		snapshotConfig: conf.Snapshot,
+		loadConfig:     conf.Load,
+		scrubConfig:    conf.Scrub,
 	}
 }
 
@@ -0,0 +1,306 @@
+// Copyright 2018 The Chubao Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metanode
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/chubaofs/chubaofs/util/errors"
+	"github.com/chubaofs/chubaofs/util/log"
+)
+
+const (
+	archiveMagic        = "CFSARCH2"
+	archiveCheckpoint   = "archive_"
+	archiveRestoreTmp   = ".restore_"
+	archiveMaxBlockSize = 64 * MB
+)
+
+// the tag ahead of each partition and of the index trailer of an archive
+const (
+	archiveTagPartition byte = 'P'
+	archiveTagIndex     byte = 'I'
+)
+
+// ArchiveEntry describes a meta partition in the index of an archive.
+type ArchiveEntry struct {
+	PartitionID uint64 `json:"partition_id"`
+	VolName     string `json:"vol_name"`
+	ApplyID     uint64 `json:"apply_id"`
+	Digest      uint32 `json:"digest"`
+}
+
+// WriteArchive streams a checkpoint of every meta partition of the node into w, so the whole node
+// can be backed up in one artifact. The partitions are checkpointed, streamed and released one at a time,
+// so the disk only needs room for one more copy of the largest partition. The index of the partitions is
+// written last, once they are all in the archive.
+// Archive structure:
+//  +-------+-------+-----------+-----+-----------+----------+----------+-------+----------+
+//  | item  | Magic | Partition | ... | Partition | IndexTag | IndexLen | Index | IndexCRC |
+//  +-------+-------+-----------+-----+-----------+----------+----------+-------+----------+
+//  | bytes |   8   |           |     |           |    1     |    4     |  Len  |    4     |
+//  +-------+-------+-----------+-----+-----------+----------+----------+-------+----------+
+// Partition structure, the entry is the one of the partition in the index:
+//  +-------+--------------+----------+-------+----------+---------+------+---------+-----------------+
+//  | item  | PartitionTag | EntryLen | Entry | EntryCRC | MetaLen | Meta | MetaCRC | Snapshot stream |
+//  +-------+--------------+----------+-------+----------+---------+------+---------+-----------------+
+//  | bytes |      1       |    4     |  Len  |    4     |    4    | Len  |    4    |                 |
+//  +-------+--------------+----------+-------+----------+---------+------+---------+-----------------+
+// The snapshot stream is the one written by SnapshotWriter.
+func (m *metadataManager) WriteArchive(w io.Writer) (index []*ArchiveEntry, err error) {
+	var partitions []*metaPartition
+	m.Range(func(id uint64, p MetaPartition) bool {
+		if mp, ok := p.(*metaPartition); ok {
+			partitions = append(partitions, mp)
+		}
+		return true
+	})
+	sort.Slice(partitions, func(i, j int) bool {
+		return partitions[i].config.PartitionId < partitions[j].config.PartitionId
+	})
+
+	// share the buffer with the snapshot writers, which write through it as it is large enough
+	bw := bufio.NewWriterSize(w, 4*MB)
+	if _, err = bw.WriteString(archiveMagic); err != nil {
+		return
+	}
+	name := archiveCheckpoint + strconv.FormatInt(time.Now().UnixNano(), 10)
+	for _, mp := range partitions {
+		var entry *ArchiveEntry
+		if entry, err = writeArchivePartition(bw, mp, name); err != nil {
+			return
+		}
+		index = append(index, entry)
+	}
+	data, err := json.Marshal(index)
+	if err != nil {
+		return
+	}
+	if err = bw.WriteByte(archiveTagIndex); err != nil {
+		return
+	}
+	if err = writeArchiveBlock(bw, data); err != nil {
+		return
+	}
+	if err = bw.Flush(); err != nil {
+		return
+	}
+	log.LogInfof("WriteArchive: archive complete: partitions(%v)", len(index))
+	return
+}
+
+// writeArchivePartition checkpoints the partition, streams the checkpoint into bw and removes it.
+func writeArchivePartition(bw *bufio.Writer, mp *metaPartition, name string) (entry *ArchiveEntry, err error) {
+	handle, err := mp.Checkpoint(name)
+	if err != nil {
+		return nil, errors.NewErrorf("[WriteArchive] partitionID(%v): %s", mp.config.PartitionId, err.Error())
+	}
+	defer os.RemoveAll(handle.Dir)
+	entry = &ArchiveEntry{
+		PartitionID: mp.config.PartitionId,
+		VolName:     mp.config.VolName,
+		ApplyID:     handle.ApplyID,
+		Digest:      handle.Digest,
+	}
+	if err = bw.WriteByte(archiveTagPartition); err != nil {
+		return
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	if err = writeArchiveBlock(bw, data); err != nil {
+		return
+	}
+	if data, err = ioutil.ReadFile(path.Join(mp.config.RootDir, metadataFile)); err != nil {
+		return nil, errors.NewErrorf("[WriteArchive] read metadata: partitionID(%v): %s", mp.config.PartitionId, err.Error())
+	}
+	if err = writeArchiveBlock(bw, data); err != nil {
+		return
+	}
+	if err = NewSnapshotWriter(bw).WriteDir(handle.Dir); err != nil {
+		return nil, errors.NewErrorf("[WriteArchive] stream snapshot: partitionID(%v): %s", mp.config.PartitionId, err.Error())
+	}
+	return
+}
+
+// RestoreArchive unpacks an archive written by WriteArchive into the partition directories under rootDir,
+// which is the metadata directory of a metanode that is not running. Each partition is unpacked into
+// a temporary directory, verified against the digest and applyID of its entry and then moved into place.
+// An existing partition directory is never overwritten. The partitions restored before a failure are kept,
+// and so are the ones of an archive cut before its index, which is then refused.
+func RestoreArchive(r io.Reader, rootDir string, conf SnapshotConfig) (index []*ArchiveEntry, err error) {
+	// share the buffer with the snapshot readers, so they do not read ahead of each other
+	br := bufio.NewReaderSize(r, 4*MB)
+	magic := make([]byte, len(archiveMagic))
+	if _, err = io.ReadFull(br, magic); err != nil {
+		return nil, errors.NewErrorf("[RestoreArchive] read magic: %s", err.Error())
+	}
+	if !bytes.Equal(magic, []byte(archiveMagic)) {
+		return nil, errors.NewErrorf("[RestoreArchive] not an archive: magic(%q)", magic)
+	}
+	var restored []*ArchiveEntry
+	for {
+		var tag byte
+		if tag, err = br.ReadByte(); err != nil {
+			return nil, errors.NewErrorf("[RestoreArchive] archive without index: restored(%v): %s",
+				len(restored), err.Error())
+		}
+		switch tag {
+		case archiveTagPartition:
+			var entry *ArchiveEntry
+			if entry, err = restoreArchivePartition(br, rootDir, conf); err != nil {
+				return
+			}
+			restored = append(restored, entry)
+			log.LogInfof("RestoreArchive: partition restored: partitionID(%v) volume(%v) applyID(%v) digest(%v)",
+				entry.PartitionID, entry.VolName, entry.ApplyID, entry.Digest)
+		case archiveTagIndex:
+			var data []byte
+			if data, err = readArchiveBlock(br); err != nil {
+				return nil, errors.NewErrorf("[RestoreArchive] read index: %s", err.Error())
+			}
+			if err = json.Unmarshal(data, &index); err != nil {
+				return nil, errors.NewErrorf("[RestoreArchive] decode index: %s", err.Error())
+			}
+			if err = checkArchiveIndex(index, restored); err != nil {
+				return nil, err
+			}
+			return
+		default:
+			return nil, errors.NewErrorf("[RestoreArchive] unknown tag(%v) after partitions(%v)", tag, len(restored))
+		}
+	}
+}
+
+func restoreArchivePartition(br *bufio.Reader, rootDir string, conf SnapshotConfig) (entry *ArchiveEntry, err error) {
+	data, err := readArchiveBlock(br)
+	if err != nil {
+		return nil, errors.NewErrorf("[RestoreArchive] read partition entry: %s", err.Error())
+	}
+	entry = new(ArchiveEntry)
+	if err = json.Unmarshal(data, entry); err != nil {
+		return nil, errors.NewErrorf("[RestoreArchive] decode partition entry: %s", err.Error())
+	}
+	partitionID := entry.PartitionID
+	name := partitionPrefix + strconv.FormatUint(partitionID, 10)
+	dir := path.Join(rootDir, name)
+	if _, err = os.Stat(dir); err == nil {
+		return nil, errors.NewErrorf("[RestoreArchive] partition already exists: %v", dir)
+	}
+	tmpDir := path.Join(rootDir, archiveRestoreTmp+name)
+	os.RemoveAll(tmpDir)
+	if err = os.MkdirAll(tmpDir, 0755); err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			os.RemoveAll(tmpDir)
+		}
+	}()
+	if data, err = readArchiveBlock(br); err != nil {
+		return nil, errors.NewErrorf("[RestoreArchive] read metadata: partitionID(%v): %s", partitionID, err.Error())
+	}
+	if err = ioutil.WriteFile(path.Join(tmpDir, metadataFile), data, 0644); err != nil {
+		return
+	}
+	snapshotPath := path.Join(tmpDir, conf.dirName())
+	if err = NewSnapshotReader(br).ReadDir(snapshotPath); err != nil {
+		return nil, errors.NewErrorf("[RestoreArchive] read snapshot: partitionID(%v): %s", partitionID, err.Error())
+	}
+	if err = checkArchiveEntry(snapshotPath, entry); err != nil {
+		return
+	}
+	err = os.Rename(tmpDir, dir)
+	return
+}
+
+// checkArchiveIndex compares the index trailer of an archive with the entries of the partitions restored.
+func checkArchiveIndex(index, restored []*ArchiveEntry) (err error) {
+	if len(index) != len(restored) {
+		return errors.NewErrorf("[RestoreArchive] index mismatch: index(%v) restored(%v)", len(index), len(restored))
+	}
+	for i, entry := range index {
+		if *entry != *restored[i] {
+			return errors.NewErrorf("[RestoreArchive] index mismatch: index(%+v) restored(%+v)", *entry, *restored[i])
+		}
+	}
+	return
+}
+
+// checkArchiveEntry compares the restored snapshot with the applyID and digest recorded in the index.
+func checkArchiveEntry(dir string, entry *ArchiveEntry) (err error) {
+	data, err := ioutil.ReadFile(path.Join(dir, applyIDFile))
+	if err != nil {
+		return errors.NewErrorf("[RestoreArchive] read applyID: partitionID(%v): %s", entry.PartitionID, err.Error())
+	}
+	var applyID uint64
+	if _, err = fmt.Sscanf(string(data), "%d", &applyID); err != nil {
+		return errors.NewErrorf("[RestoreArchive] decode applyID: partitionID(%v): %s", entry.PartitionID, err.Error())
+	}
+	crcs, err := readSnapshotSign(dir)
+	if err != nil {
+		return
+	}
+	if digest := snapshotDigest(crcs); applyID != entry.ApplyID || digest != entry.Digest {
+		return errors.NewErrorf("[RestoreArchive] partition mismatch: partitionID(%v) expect(%v,%v) actual(%v,%v)",
+			entry.PartitionID, entry.ApplyID, entry.Digest, applyID, digest)
+	}
+	return
+}
+
+func writeArchiveBlock(w io.Writer, data []byte) (err error) {
+	if err = binary.Write(w, binary.BigEndian, uint32(len(data))); err != nil {
+		return
+	}
+	if _, err = w.Write(data); err != nil {
+		return
+	}
+	err = binary.Write(w, binary.BigEndian, crc32.ChecksumIEEE(data))
+	return
+}
+
+func readArchiveBlock(r io.Reader) (data []byte, err error) {
+	var length, crc uint32
+	if err = binary.Read(r, binary.BigEndian, &length); err != nil {
+		return
+	}
+	if length > archiveMaxBlockSize {
+		return nil, fmt.Errorf("block too large: %v", length)
+	}
+	data = make([]byte, length)
+	if _, err = io.ReadFull(r, data); err != nil {
+		return
+	}
+	if err = binary.Read(r, binary.BigEndian, &crc); err != nil {
+		return
+	}
+	if actual := crc32.ChecksumIEEE(data); actual != crc {
+		return nil, fmt.Errorf("crc mismatch: expect(%v) actual(%v)", crc, actual)
+	}
+	return
+}
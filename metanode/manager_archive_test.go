@@ -0,0 +1,104 @@
+// Copyright 2018 The Chubao Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metanode
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"github.com/chubaofs/chubaofs/proto"
+)
+
+// TestArchiveRoundTrip archives two partitions and restores them into another metadata directory,
+// then corrupts a byte of the archive and expects the restore to fail.
+func TestArchiveRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metanode_archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	m := &metadataManager{partitions: make(map[uint64]MetaPartition)}
+	for _, id := range []uint64{7, 3} {
+		mp := newFixturePartition(path.Join(dir, "src", fmt.Sprintf("%v%v", partitionPrefix, id)))
+		mp.config.PartitionId = id
+		mp.config.Peers = []proto.Peer{{ID: 1, Addr: "127.0.0.1:17210"}}
+		if err = mp.persistMetadata(); err != nil {
+			t.Fatal(err)
+		}
+		m.partitions[id] = mp
+	}
+	// each partition is checkpointed once the checkpoint of the one before it is released
+	checkpoints := path.Join(dir, "src", "*", checkpointPrefix+archiveCheckpoint+"*")
+	maxCheckpoints := 0
+	for _, p := range m.partitions {
+		p.(*metaPartition).afterStoreFile = func(filename string) error {
+			if held, _ := filepath.Glob(checkpoints); len(held) > maxCheckpoints {
+				maxCheckpoints = len(held)
+			}
+			return nil
+		}
+	}
+	buf := new(bytes.Buffer)
+	index, err := m.WriteArchive(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(index) != 2 || index[0].PartitionID != 3 || index[1].PartitionID != 7 {
+		t.Fatalf("unexpected index: %v", index)
+	}
+	if held, _ := filepath.Glob(checkpoints); maxCheckpoints != 1 || len(held) != 0 {
+		t.Fatalf("checkpoints held at once(%v) left(%v)", maxCheckpoints, len(held))
+	}
+	archive := buf.Bytes()
+
+	// an archive cut before its index trailer is refused, though the partitions before the cut are restored
+	if _, err = RestoreArchive(bytes.NewReader(archive[:len(archive)-1]), path.Join(dir, "cut"), SnapshotConfig{}); err == nil {
+		t.Fatal("archive without its index restored")
+	}
+
+	dstDir := path.Join(dir, "dst")
+	if _, err = RestoreArchive(bytes.NewReader(archive), dstDir, SnapshotConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range index {
+		restored := NewMetaPartition(&MetaPartitionConfig{
+			RootDir: path.Join(dstDir, fmt.Sprintf("%v%v", partitionPrefix, entry.PartitionID)),
+		}, nil).(*metaPartition)
+		if err = restored.loadMetadata(); err != nil {
+			t.Fatal(err)
+		}
+		if err = restored.LoadSnapshot(path.Join(restored.config.RootDir, snapshotDir)); err != nil {
+			t.Fatal(err)
+		}
+		if restored.config.PartitionId != entry.PartitionID || restored.applyID != entry.ApplyID ||
+			restored.inodeTree.Len() != 4 {
+			t.Fatalf("partition %v: restored id(%v) applyID(%v) inodes(%v)", entry.PartitionID,
+				restored.config.PartitionId, restored.applyID, restored.inodeTree.Len())
+		}
+	}
+	if _, err = RestoreArchive(bytes.NewReader(archive), dstDir, SnapshotConfig{}); err == nil {
+		t.Fatal("existing partition overwritten")
+	}
+
+	archive[len(archive)/2] ^= 0xff
+	if _, err = RestoreArchive(bytes.NewReader(archive), path.Join(dir, "corrupt"), SnapshotConfig{}); err == nil {
+		t.Fatal("corrupted archive restored")
+	}
+}
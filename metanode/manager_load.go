@@ -0,0 +1,109 @@
+// Copyright 2018 The Chubao Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metanode
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+)
+
+// LoadConfig bounds the meta partitions loaded at the same time when the meta node starts.
+// The zero value loads all of them at once.
+type LoadConfig struct {
+	// Number of meta partitions loaded at the same time. Zero means no limit.
+	Workers int
+	// Total snapshot size of the meta partitions loaded at the same time. Zero means no limit.
+	// A meta partition larger than the budget is loaded alone.
+	MemoryBudget uint64
+	// Number of meta partitions loaded at the same time from each disk. Zero means no limit.
+	DiskWorkers int
+}
+
+// loadLimiter admits the loading of a meta partition once the worker count, the memory budget and the
+// worker count of its disk all allow it.
+type loadLimiter struct {
+	config  LoadConfig
+	mu      sync.Mutex
+	cond    *sync.Cond
+	running int
+	size    uint64
+	disks   map[uint64]int // partitions loading from each disk
+}
+
+func newLoadLimiter(config LoadConfig) *loadLimiter {
+	l := &loadLimiter{config: config, disks: make(map[uint64]int)}
+	l.cond = sync.NewCond(&l.mu)
+	return l
+}
+
+func (l *loadLimiter) admit(size, disk uint64) bool {
+	if l.config.Workers > 0 && l.running >= l.config.Workers {
+		return false
+	}
+	if l.config.DiskWorkers > 0 && l.disks[disk] >= l.config.DiskWorkers {
+		return false
+	}
+	if l.config.MemoryBudget > 0 && l.running > 0 && l.size+size > l.config.MemoryBudget {
+		return false
+	}
+	return true
+}
+
+func (l *loadLimiter) acquire(size, disk uint64) {
+	l.mu.Lock()
+	for !l.admit(size, disk) {
+		l.cond.Wait()
+	}
+	l.running++
+	l.size += size
+	l.disks[disk]++
+	l.mu.Unlock()
+}
+
+func (l *loadLimiter) release(size, disk uint64) {
+	l.mu.Lock()
+	l.running--
+	l.size -= size
+	if l.disks[disk]--; l.disks[disk] == 0 {
+		delete(l.disks, disk)
+	}
+	l.mu.Unlock()
+	l.cond.Broadcast()
+}
+
+// partitionDataSize returns the size of the files in the directory, including its subdirectories.
+func partitionDataSize(dir string) (size uint64) {
+	filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			size += uint64(info.Size())
+		}
+		return nil
+	})
+	return
+}
+
+// snapshotDataSize returns the size of the data files of the snapshot in snapshotDir, which is used to
+// estimate the memory needed to load the partition. The indexes, checkpoints and other files of the
+// partition directory are not loaded into memory and are left out.
+func snapshotDataSize(snapshotDir string) (size uint64) {
+	for _, name := range snapshotDataFiles {
+		if info, err := os.Stat(path.Join(snapshotDir, name)); err == nil {
+			size += uint64(info.Size())
+		}
+	}
+	return
+}
@@ -0,0 +1,248 @@
+// Copyright 2018 The Chubao Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metanode
+
+import (
+	"bufio"
+	"context"
+	"hash/crc32"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/chubaofs/chubaofs/util/errors"
+	"github.com/chubaofs/chubaofs/util/exporter"
+	"github.com/chubaofs/chubaofs/util/log"
+	"golang.org/x/time/rate"
+)
+
+const (
+	MetricScrubProgress = "snapshot_scrub_progress"
+	MetricScrubLastTime = "snapshot_scrub_last_time"
+	MetricScrubFailures = "snapshot_scrub_failures"
+)
+
+// interval of checking for the partitions due for a scrub
+const intervalToCheckScrub = time.Minute
+
+// ScrubConfig configures the scrubber, which checks the snapshot files of the meta partitions against their
+// CRCs in the background, see FastVerifySnapshot. The zero value disables it.
+type ScrubConfig struct {
+	// A partition is scrubbed again once its last scrub is older than Interval. Zero disables the scrubber.
+	Interval time.Duration
+	// Number of partitions scrubbed at the same time, 1 by default.
+	Workers int
+	// Bytes read per second by all the workers from each disk. Zero means no limit.
+	DiskBandwidth uint64
+}
+
+// scrubber scrubs the partitions of the manager in rounds. Each round scrubs the partitions due, the ones
+// scrubbed the longest ago first, so no partition is starved by the others.
+type scrubber struct {
+	m        *metadataManager
+	config   ScrubConfig
+	mu       sync.Mutex
+	last     map[uint64]time.Time     // of the last scrub of each partition
+	limiters map[uint64]*rate.Limiter // of each disk
+	ctx      context.Context
+	cancel   context.CancelFunc
+}
+
+func newScrubber(m *metadataManager, config ScrubConfig) *scrubber {
+	if config.Workers <= 0 {
+		config.Workers = 1
+	}
+	s := &scrubber{m: m, config: config, last: make(map[uint64]time.Time), limiters: make(map[uint64]*rate.Limiter)}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	return s
+}
+
+func (s *scrubber) start() {
+	go func() {
+		timer := time.NewTimer(intervalToCheckScrub)
+		defer timer.Stop()
+		for {
+			select {
+			case <-s.ctx.Done():
+				return
+			case <-timer.C:
+				s.round()
+				timer.Reset(intervalToCheckScrub)
+			}
+		}
+	}()
+}
+
+func (s *scrubber) stop() {
+	s.cancel()
+}
+
+// due returns the partitions whose last scrub is older than the interval, the ones scrubbed the longest ago first.
+func (s *scrubber) due() (partitions []*metaPartition) {
+	s.m.Range(func(id uint64, p MetaPartition) bool {
+		if mp, ok := p.(*metaPartition); ok {
+			partitions = append(partitions, mp)
+		}
+		return true
+	})
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, mp := range partitions {
+		if time.Since(s.last[mp.config.PartitionId]) >= s.config.Interval {
+			partitions[n] = mp
+			n++
+		}
+	}
+	partitions = partitions[:n]
+	sort.Slice(partitions, func(i, j int) bool {
+		a, b := s.last[partitions[i].config.PartitionId], s.last[partitions[j].config.PartitionId]
+		if !a.Equal(b) {
+			return a.Before(b)
+		}
+		return partitions[i].config.PartitionId < partitions[j].config.PartitionId
+	})
+	return
+}
+
+// round scrubs the partitions due with the workers, and returns once they are all scrubbed or the scrubber stops.
+func (s *scrubber) round() {
+	partitions := s.due()
+	if len(partitions) == 0 {
+		return
+	}
+	start := time.Now()
+	queue := make(chan *metaPartition)
+	var (
+		wg       sync.WaitGroup
+		doneMu   sync.Mutex
+		done     int
+		failures int
+	)
+	exporter.NewGauge(MetricScrubProgress).Set(0)
+	for i := 0; i < s.config.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for mp := range queue {
+				err := s.scrub(mp)
+				doneMu.Lock()
+				done++
+				if err != nil {
+					failures++
+				}
+				exporter.NewGauge(MetricScrubProgress).Set(float64(done) / float64(len(partitions)))
+				doneMu.Unlock()
+			}
+		}()
+	}
+	for _, mp := range partitions {
+		select {
+		case queue <- mp:
+		case <-s.ctx.Done():
+		}
+	}
+	close(queue)
+	wg.Wait()
+	log.LogInfof("scrubber: round complete: partitions(%v) scrubbed(%v) failures(%v) cost(%v)",
+		len(partitions), done, failures, time.Since(start))
+}
+
+// scrub checks the snapshot of the partition. The files are opened together with OpenActiveSnapshot, so a
+// store that replaces the snapshot during the scrub does not change what is checked.
+func (s *scrubber) scrub(mp *metaPartition) (err error) {
+	labels := map[string]string{"partid": strconv.FormatUint(mp.config.PartitionId, 10)}
+	snap, err := OpenActiveSnapshot(mp.config.RootDir, mp.config.Snapshot)
+	if os.IsNotExist(err) {
+		// nothing stored yet
+		return nil
+	}
+	if err == nil {
+		limiter := s.diskLimiter(snap.Dir)
+		err = fastVerifySnapshot(snap, func(r io.Reader) (uint32, error) {
+			return s.readerCRC(r, limiter)
+		})
+		snap.Close()
+	}
+	if s.ctx.Err() != nil {
+		return
+	}
+	if err != nil {
+		exporter.NewCounter(MetricScrubFailures).AddWithLabels(1, labels)
+		log.LogErrorf("scrubber: snapshot failed its scrub: partitionID(%v) volume(%v) dir(%v) err(%v)",
+			mp.config.PartitionId, mp.config.VolName, mp.config.RootDir, err)
+	}
+	now := time.Now()
+	s.mu.Lock()
+	s.last[mp.config.PartitionId] = now
+	s.mu.Unlock()
+	exporter.NewGauge(MetricScrubLastTime).SetWithLabels(float64(now.Unix()), labels)
+	return
+}
+
+// diskLimiter returns the limiter of the disk holding the snapshot, nil without a bandwidth limit.
+func (s *scrubber) diskLimiter(snapshotPath string) *rate.Limiter {
+	if s.config.DiskBandwidth == 0 {
+		return nil
+	}
+	disk, _ := diskID(snapshotPath)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	limiter := s.limiters[disk]
+	if limiter == nil {
+		burst := int(s.config.DiskBandwidth)
+		if burst > MB {
+			burst = MB
+		}
+		limiter = rate.NewLimiter(rate.Limit(s.config.DiskBandwidth), burst)
+		s.limiters[disk] = limiter
+	}
+	return limiter
+}
+
+// readerCRC computes the CRC of r as readerCRC does, reading it within the bandwidth of the limiter.
+func (s *scrubber) readerCRC(r io.Reader, limiter *rate.Limiter) (crc uint32, err error) {
+	if limiter == nil {
+		return readerCRC(r)
+	}
+	sign := crc32.NewIEEE()
+	throttled := &throttledReader{r: bufio.NewReaderSize(r, limiter.Burst()), limiter: limiter, ctx: s.ctx}
+	if _, err = io.Copy(sign, throttled); err != nil {
+		return 0, errors.NewErrorf("[scrubber] ReadFile: %s", err.Error())
+	}
+	return sign.Sum32(), nil
+}
+
+// throttledReader reads no faster than its limiter allows, until its context is done.
+type throttledReader struct {
+	r       io.Reader
+	limiter *rate.Limiter
+	ctx     context.Context
+}
+
+func (t *throttledReader) Read(p []byte) (n int, err error) {
+	if len(p) > t.limiter.Burst() {
+		p = p[:t.limiter.Burst()]
+	}
+	if n, err = t.r.Read(p); n > 0 {
+		if waitErr := t.limiter.WaitN(t.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return
+}
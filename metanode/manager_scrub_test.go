@@ -0,0 +1,71 @@
+// Copyright 2018 The Chubao Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metanode
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestScrubber(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metanode_scrub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	m := &metadataManager{partitions: make(map[uint64]MetaPartition)}
+	for id := uint64(1); id <= 3; id++ {
+		mp := newFixturePartition(path.Join(dir, fmt.Sprintf("partition_%v", id)))
+		mp.config.PartitionId = id
+		if err = mp.store(capturedStoreMsg(mp)); err != nil {
+			t.Fatal(err)
+		}
+		m.partitions[id] = mp
+	}
+	s := newScrubber(m, ScrubConfig{Interval: time.Hour, Workers: 2, DiskBandwidth: 64 * KB})
+	defer s.stop()
+	s.round()
+	for id := range m.partitions {
+		if s.last[id].IsZero() {
+			t.Fatalf("partition %v not scrubbed", id)
+		}
+	}
+	if due := s.due(); len(due) != 0 {
+		t.Fatalf("%v partitions due right after their scrub", len(due))
+	}
+	// the partition scrubbed the longest ago comes first
+	s.last[2] = time.Now().Add(-3 * time.Hour)
+	s.last[3] = time.Now().Add(-2 * time.Hour)
+	if due := s.due(); len(due) != 2 || due[0].config.PartitionId != 2 {
+		t.Fatalf("unexpected due partitions %v", len(due))
+	}
+	corrupted := m.partitions[1].(*metaPartition)
+	inode := path.Join(dir, "partition_1", corrupted.config.Snapshot.dirName(), inodeFile)
+	data, err := ioutil.ReadFile(inode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-1] ^= 0xff
+	if err = ioutil.WriteFile(inode, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.scrub(corrupted); err == nil {
+		t.Fatal("corrupted snapshot passed its scrub")
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright 2018 The Chubao Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metanode
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+// TestRangeOverlap loads partitions whose ranges overlap within a volume, and expects the overlap to fail
+// only the strict check while partitions of other volumes are ignored.
+func TestRangeOverlap(t *testing.T) {
+	m := &metadataManager{partitions: make(map[uint64]MetaPartition)}
+	for _, config := range []*MetaPartitionConfig{
+		{PartitionId: 1, VolName: "vol", Start: 1, End: 1000},
+		{PartitionId: 2, VolName: "vol", Start: 1001, End: 2000},
+		{PartitionId: 3, VolName: "other", Start: 500, End: 1500},
+	} {
+		config.Siblings = m.siblingRanges
+		m.partitions[config.PartitionId] = NewMetaPartition(config, m)
+	}
+	if err := m.checkRangeOverlaps(); err != nil {
+		t.Fatalf("disjoint ranges: %v", err)
+	}
+	overlap := NewMetaPartition(&MetaPartitionConfig{PartitionId: 4, VolName: "vol", Start: 900, End: 1100,
+		Siblings: m.siblingRanges}, m).(*metaPartition)
+	m.partitions[4] = overlap
+	if err := overlap.checkRangeOverlap(); err != nil {
+		t.Fatalf("lenient check failed: %v", err)
+	}
+	overlap.config.Snapshot.StrictRangeOverlap = true
+	if err := overlap.checkRangeOverlap(); err == nil {
+		t.Fatal("overlapping range not detected")
+	}
+	if err := m.checkRangeOverlaps(); err == nil {
+		t.Fatal("overlapping range not detected by the manager")
+	}
+}
+
+// TestLoadLimiterDisk expects the limiter to hold back a partition whose disk already loads as many
+// partitions as allowed, while a partition on another disk is admitted.
+func TestLoadLimiterDisk(t *testing.T) {
+	l := newLoadLimiter(LoadConfig{Workers: 4, DiskWorkers: 1})
+	l.acquire(10, 1)
+	if l.admit(10, 1) {
+		t.Fatal("second partition of a busy disk admitted")
+	}
+	if !l.admit(10, 2) {
+		t.Fatal("partition of an idle disk held back")
+	}
+	l.release(10, 1)
+	if !l.admit(10, 1) || len(l.disks) != 0 {
+		t.Fatalf("disk not released: %v", l.disks)
+	}
+}
+
+// TestSnapshotDataSize expects only the data files of the snapshot to be counted for the load admission.
+func TestSnapshotDataSize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metanode_data_size")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mp := newFixturePartition(dir)
+	mp.config.Snapshot.InodeIndex = true
+	if err = mp.storeToDir(dir, capturedStoreMsg(mp)); err != nil {
+		t.Fatal(err)
+	}
+	var expect uint64
+	for _, name := range snapshotDataFiles {
+		info, err := os.Stat(path.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		expect += uint64(info.Size())
+	}
+	if size := snapshotDataSize(dir); size != expect || size >= partitionDataSize(dir) {
+		t.Fatalf("size(%v) expect(%v) directory(%v)", size, expect, partitionDataSize(dir))
+	}
+}
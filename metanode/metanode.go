@@ -15,9 +15,11 @@
 package metanode
 
 import (
+	"encoding/json"
 	"os"
 	syslog "log"
 	"strings"
+	"sync"
 	"time"
 
 	masterSDK "github.com/chubaofs/chubaofs/sdk/master"
@@ -57,6 +59,10 @@ type MetaNode struct {
 	raftReplicatePort string
 	zoneName          string
 	httpStopC         chan uint8
+	snapshotConfig    SnapshotConfig
+	loadConfig        LoadConfig
+	scrubConfig       ScrubConfig
+	digestMu          sync.Mutex // serializes the digests computed for getSnapshotDigest
 
 	control common.Control
 }
@@ -177,6 +183,141 @@ func (m *MetaNode) parseConfig(cfg *config.Config) (err error) {
 		return fmt.Errorf("bad totalMem config,Recommended to be configured as 80 percent of physical machine memory")
 	}
 
+	if threshold := cfg.GetInt64(cfgStoreMutationThreshold); threshold > 0 {
+		m.snapshotConfig.StoreMutationThreshold = uint64(threshold)
+	}
+	if samples := cfg.GetInt64(cfgSlowRecordSamples); samples > 0 {
+		m.snapshotConfig.SlowRecordSamples = int(samples)
+	}
+	m.snapshotConfig.WarmSnapshot = cfg.GetBool(cfgWarmSnapshot)
+	m.snapshotConfig.StrictInodeRange = cfg.GetBool(cfgStrictInodeRange)
+	m.snapshotConfig.StrictRangeOverlap = cfg.GetBool(cfgStrictRangeOverlap)
+	m.snapshotConfig.StrictInodeCheck = cfg.GetBool(cfgStrictInodeCheck)
+	m.snapshotConfig.TolerateDentryConflicts = cfg.GetBool(cfgTolerateDentryConflict)
+	m.snapshotConfig.MmapStore = cfg.GetBool(cfgSnapshotMmapStore)
+	m.snapshotConfig.ExtendDedup = cfg.GetBool(cfgStoreExtendDedup)
+	m.snapshotConfig.StoreReadBack = cfg.GetBool(cfgStoreReadBack)
+	m.snapshotConfig.InodeColumns = cfg.GetBool(cfgStoreInodeColumns)
+	m.snapshotConfig.StorePreallocate = cfg.GetBool(cfgStorePreallocate)
+	m.snapshotConfig.StoreReport = cfg.GetBool(cfgStoreReport)
+	m.snapshotConfig.LoadFadvise = cfg.GetBool(cfgSnapshotLoadFadvise)
+	m.snapshotConfig.GroupInodesByType = cfg.GetBool(cfgGroupInodesByType)
+	m.snapshotConfig.InodeIndex = cfg.GetBool(cfgStoreInodeIndex)
+	m.snapshotConfig.DentryIndex = cfg.GetBool(cfgStoreDentryIndex)
+	m.snapshotConfig.ReadRepair = cfg.GetBool(cfgSnapshotReadRepair)
+	m.snapshotConfig.LazyLoad = cfg.GetBool(cfgSnapshotLazyLoad)
+	m.snapshotConfig.MultipartIndexMax = defaultMultipartIndexMax
+	if max := cfg.GetInt64(cfgMultipartIndexMax); max != 0 {
+		if max < 0 {
+			max = 0
+		}
+		m.snapshotConfig.MultipartIndexMax = int(max)
+	}
+	if max := cfg.GetInt64(cfgParentIndexMax); max > 0 {
+		m.snapshotConfig.ParentIndexMax = int(max)
+	}
+	m.snapshotConfig.QuarantineDir = cfg.GetString(cfgSnapshotQuarantineDir)
+	if quarantineMax := cfg.GetInt64(cfgQuarantineMaxMB); quarantineMax > 0 {
+		m.snapshotConfig.QuarantineMaxSize = uint64(quarantineMax) * util.MB
+	}
+	m.snapshotConfig.ColdDir = cfg.GetString(cfgSnapshotColdDir)
+	if coldAfter := cfg.GetInt64(cfgSnapshotColdAfterHours); coldAfter > 0 {
+		m.snapshotConfig.ColdAfter = time.Duration(coldAfter) * time.Hour
+	}
+	if ageAfter := cfg.GetInt64(cfgAgeCheckpointsAfterHrs); ageAfter > 0 {
+		m.snapshotConfig.AgeCheckpointsAfter = time.Duration(ageAfter) * time.Hour
+	}
+	if minFree := cfg.GetInt64(cfgStoreMinFreeSpaceMB); minFree > 0 {
+		m.snapshotConfig.StoreMinFreeSpace = uint64(minFree) * util.MB
+	}
+	if window := cfg.GetInt64(cfgExtendMmapWindowMB); window > 0 {
+		m.snapshotConfig.ExtendMmapWindow = uint64(window) * util.MB
+	}
+	if interval := cfg.GetInt64(cfgStoreSyncIntervalMB); interval > 0 {
+		m.snapshotConfig.StoreSyncInterval = uint64(interval) * util.MB
+	}
+	if attempts := cfg.GetInt64(cfgStoreRetryAttempts); attempts > 0 {
+		m.snapshotConfig.StoreRetryAttempts = int(attempts)
+		m.snapshotConfig.StoreRetryBackoff = defaultStoreRetryBackoff
+		if backoff := cfg.GetInt64(cfgStoreRetryBackoffMs); backoff > 0 {
+			m.snapshotConfig.StoreRetryBackoff = time.Duration(backoff) * time.Millisecond
+		}
+		m.snapshotConfig.StoreRetryTimeout = defaultStoreRetryTimeout
+		if timeout := cfg.GetInt64(cfgStoreRetryTimeoutSec); timeout > 0 {
+			m.snapshotConfig.StoreRetryTimeout = time.Duration(timeout) * time.Second
+		}
+	}
+	if overrides := cfg.GetValue(cfgSnapshotVolumeOverride); overrides != nil {
+		data, _ := json.Marshal(overrides)
+		if err = json.Unmarshal(data, &m.snapshotConfig.VolumeOverrides); err != nil {
+			return fmt.Errorf("bad snapshot config: %v: %v", cfgSnapshotVolumeOverride, err)
+		}
+	}
+	m.snapshotConfig.DirName = cfg.GetString(cfgSnapshotDirName)
+	m.snapshotConfig.TmpDirName = cfg.GetString(cfgSnapshotTmpDirName)
+	m.snapshotConfig.BackupDirName = cfg.GetString(cfgSnapshotBackupDirName)
+	m.snapshotConfig.MetadataTmpName = cfg.GetString(cfgMetadataTmpFileName)
+	if err = m.snapshotConfig.checkNames(); err != nil {
+		return fmt.Errorf("bad snapshot config: %v", err)
+	}
+	if workers := cfg.GetInt64(cfgLoadPartitionWorkers); workers > 0 {
+		m.loadConfig.Workers = int(workers)
+	}
+	if budget := cfg.GetInt64(cfgLoadPartitionMemoryMB); budget > 0 {
+		m.loadConfig.MemoryBudget = uint64(budget) * util.MB
+	}
+	if workers := cfg.GetInt64(cfgLoadDiskWorkers); workers > 0 {
+		m.loadConfig.DiskWorkers = int(workers)
+	}
+	if interval := cfg.GetInt64(cfgScrubIntervalHours); interval > 0 {
+		m.scrubConfig.Interval = time.Duration(interval) * time.Hour
+	}
+	if workers := cfg.GetInt64(cfgScrubWorkers); workers > 0 {
+		m.scrubConfig.Workers = int(workers)
+	}
+	if bandwidth := cfg.GetInt64(cfgScrubDiskBandwidthMB); bandwidth > 0 {
+		m.scrubConfig.DiskBandwidth = uint64(bandwidth) * util.MB
+	}
+	m.snapshotConfig.DurabilityMode = cfg.GetString(cfgDurabilityMode)
+	if err = m.snapshotConfig.checkDurability(); err != nil {
+		return fmt.Errorf("bad snapshot config: %v", err)
+	}
+	m.snapshotConfig.ApplyIDCheck = cfg.GetString(cfgApplyIDCheck)
+	if err = m.snapshotConfig.checkApplyIDCheck(); err != nil {
+		return fmt.Errorf("bad snapshot config: %v", err)
+	}
+	m.snapshotConfig.ApplyIDRegression = cfg.GetString(cfgApplyIDRegression)
+	if err = m.snapshotConfig.checkApplyIDRegression(); err != nil {
+		return fmt.Errorf("bad snapshot config: %v", err)
+	}
+	if keys := cfg.GetValue(cfgSnapshotHMACKeys); keys != nil {
+		data, _ := json.Marshal(keys)
+		if err = json.Unmarshal(data, &m.snapshotConfig.HMACKeys); err != nil {
+			return fmt.Errorf("bad snapshot config: %v: %v", cfgSnapshotHMACKeys, err)
+		}
+	}
+	m.snapshotConfig.LoadLogLevel = cfg.GetString(cfgSnapshotLoadLogLevel)
+	m.snapshotConfig.StoreLogLevel = cfg.GetString(cfgSnapshotStoreLogLevel)
+	if err = m.snapshotConfig.checkLogLevels(); err != nil {
+		return fmt.Errorf("bad snapshot config: %v", err)
+	}
+	if partitions := cfg.GetValue(cfgSnapshotDebugParts); partitions != nil {
+		data, _ := json.Marshal(partitions)
+		if err = json.Unmarshal(data, &m.snapshotConfig.DebugPartitions); err != nil {
+			return fmt.Errorf("bad snapshot config: %v: %v", cfgSnapshotDebugParts, err)
+		}
+	}
+	if buffer := cfg.GetInt64(cfgLoadSinkBuffer); buffer > 0 {
+		m.snapshotConfig.LoadSinkBuffer = int(buffer)
+	}
+	if timeout := cfg.GetInt64(cfgLoadSinkTimeoutMs); timeout > 0 {
+		m.snapshotConfig.LoadSinkTimeout = time.Duration(timeout) * time.Millisecond
+	}
+	m.snapshotConfig.HMACKeyID = cfg.GetString(cfgSnapshotHMACKeyID)
+	if err = m.snapshotConfig.checkHMAC(); err != nil {
+		return fmt.Errorf("bad snapshot config: %v", err)
+	}
+
 	deleteBatchCount := cfg.GetInt64(cfgDeleteBatchCount)
 	if deleteBatchCount > 1 {
 		updateDeleteBatchCount(uint64(deleteBatchCount))
@@ -221,6 +362,9 @@ func (m *MetaNode) parseConfig(cfg *config.Config) (err error) {
 	log.LogInfof("[parseConfig] load raftHeartbeatPort[%v].", m.raftHeartbeatPort)
 	log.LogInfof("[parseConfig] load raftReplicatePort[%v].", m.raftReplicatePort)
 	log.LogInfof("[parseConfig] load zoneName[%v].", m.zoneName)
+	log.LogInfof("[parseConfig] load snapshotConfig[%+v].", m.snapshotConfig)
+	log.LogInfof("[parseConfig] load loadConfig[%+v].", m.loadConfig)
+	log.LogInfof("[parseConfig] load scrubConfig[%+v].", m.scrubConfig)
 
 	addrs := cfg.GetSlice(proto.MasterAddr)
 	masters := make([]string, 0, len(addrs))
@@ -262,6 +406,9 @@ func (m *MetaNode) startMetaManager() (err error) {
 		RootDir:   m.metadataDir,
 		RaftStore: m.raftStore,
 		ZoneName:  m.zoneName,
+		Snapshot:  m.snapshotConfig,
+		Load:      m.loadConfig,
+		Scrub:     m.scrubConfig,
 	}
 	m.metadataManager = NewMetadataManager(conf, m)
 	if err = m.metadataManager.Start(); err == nil {
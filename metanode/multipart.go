@@ -17,6 +17,7 @@ package metanode
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
 	"sort"
 	"sync"
 	"time"
@@ -76,38 +77,19 @@ func (m Part) Bytes() ([]byte, error) {
 	return buffer.Bytes(), nil
 }
 
-func PartFromBytes(raw []byte) *Part {
-	var offset, n int
-	// decode ID
-	var u64ID uint64
-	u64ID, n = binary.Uvarint(raw)
-	offset += n
-	// decode upload time
-	var uploadTimeI64 int64
-	uploadTimeI64, n = binary.Varint(raw[offset:])
-	offset += n
-	// decode MD5
-	var md5Len uint64
-	md5Len, n = binary.Uvarint(raw[offset:])
-	offset += n
-	var md5Content = string(raw[offset : offset+int(md5Len)])
-	offset += int(md5Len)
-	// decode size
-	var sizeU64 uint64
-	sizeU64, n = binary.Uvarint(raw[offset:])
-	offset += n
-	// decode inode
-	var inode uint64
-	inode, n = binary.Uvarint(raw[offset:])
-
+func PartFromBytes(raw []byte) (*Part, error) {
+	var d = &multipartDecoder{raw: raw}
 	var muPart = &Part{
-		ID:         uint16(u64ID),
-		UploadTime: time.Unix(0, uploadTimeI64),
-		MD5:        md5Content,
-		Size:       sizeU64,
-		Inode:      inode,
+		ID:         uint16(d.uvarint()),
+		UploadTime: time.Unix(0, d.varint()),
+		MD5:        string(d.bytes()),
+		Size:       d.uvarint(),
+		Inode:      d.uvarint(),
+	}
+	if d.err != nil {
+		return nil, d.err
 	}
-	return muPart
+	return muPart, nil
 }
 
 type Parts []*Part
@@ -214,21 +196,32 @@ func (m Parts) Bytes() ([]byte, error) {
 	return buffer.Bytes(), nil
 }
 
-func PartsFromBytes(raw []byte) Parts {
-	var offset, n int
-	var numPartsU64 uint64
-	numPartsU64, n = binary.Uvarint(raw)
-	offset += n
-	var muParts = make([]*Part, int(numPartsU64))
-	for i := 0; i < int(numPartsU64); i++ {
-		var partLengthU64 uint64
-		partLengthU64, n = binary.Uvarint(raw[offset:])
-		offset += n
-		part := PartFromBytes(raw[offset : offset+int(partLengthU64)])
-		muParts[i] = part
-		offset += int(partLengthU64)
-	}
-	return muParts
+func PartsFromBytes(raw []byte) (Parts, error) {
+	if len(raw) == 0 {
+		return make(Parts, 0), nil
+	}
+	var d = &multipartDecoder{raw: raw}
+	var numPartsU64 = d.uvarint()
+	// each part takes one byte at least, a larger count is corrupted and must not be allocated
+	if d.err == nil && numPartsU64 > uint64(len(raw)-d.offset) {
+		return nil, fmt.Errorf("corrupted multipart parts: count(%v) exceeds size(%v)", numPartsU64, len(raw))
+	}
+	var muParts = make(Parts, 0, int(numPartsU64))
+	for i := 0; i < int(numPartsU64) && d.err == nil; i++ {
+		partBytes := d.bytes()
+		if d.err != nil {
+			break
+		}
+		part, err := PartFromBytes(partBytes)
+		if err != nil {
+			return nil, err
+		}
+		muParts = append(muParts, part)
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	return muParts, nil
 }
 
 type MultipartExtend map[string]string
@@ -256,41 +249,42 @@ func (me MultipartExtend) Bytes() ([]byte, error) {
 		}
 		return nil
 	}
-	for key, val := range me {
+	// write the keys in order, so the same extend is always encoded into the same bytes
+	var keys = make([]string, 0, len(me))
+	for key := range me {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
 		if err = marshalStr(key); err != nil {
 			return nil, err
 		}
-		if err = marshalStr(val); err != nil {
+		if err = marshalStr(me[key]); err != nil {
 			return nil, err
 		}
 	}
 	return buffer.Bytes(), nil
 }
 
-func MultipartExtendFromBytes(raw []byte) MultipartExtend {
-	var offset, n int
-	var el uint64
-	me := NewMultipartExtend()
-	var unmarshalStr = func(data []byte) (string, int) {
-		var n int
-		var lengthU64 uint64
-		lengthU64, n = binary.Uvarint(data)
-		return string(data[n : n+int(lengthU64)]), n + int(lengthU64)
-	}
-	el, n = binary.Uvarint(raw)
-	if el <= 0 {
-		return nil
+func MultipartExtendFromBytes(raw []byte) (MultipartExtend, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	var d = &multipartDecoder{raw: raw}
+	el := d.uvarint()
+	if el == 0 {
+		return nil, d.err
 	}
-	offset += n
-	for i := 0; i < int(el); i++ {
-		var key, val string
-		key, n = unmarshalStr(raw[offset:])
-		offset += n
-		val, n = unmarshalStr(raw[offset:])
-		offset += n
+	me := NewMultipartExtend()
+	for i := uint64(0); i < el && d.err == nil; i++ {
+		key := string(d.bytes())
+		val := string(d.bytes())
 		me[key] = val
 	}
-	return me
+	if d.err != nil {
+		return nil, d.err
+	}
+	return me, nil
 }
 
 // Multipart defined necessary fields for multipart session management.
@@ -328,7 +322,7 @@ func (m *Multipart) LoadOrStorePart(part *Part) (actual *Part, stored bool) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	if m.parts == nil {
-		m.parts = PartsFromBytes(nil)
+		m.parts = make(Parts, 0)
 	}
 	actual, stored = m.parts.LoadOrStore(part)
 	return
@@ -339,7 +333,7 @@ func (m *Multipart) InsertPart(part *Part, replace bool) (success bool) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	if m.parts == nil {
-		m.parts = PartsFromBytes(nil)
+		m.parts = make(Parts, 0)
 	}
 	success = m.parts.Insert(part, replace)
 	return
@@ -407,44 +401,77 @@ func (m *Multipart) Bytes() ([]byte, error) {
 	return buffer.Bytes(), nil
 }
 
-func MultipartFromBytes(raw []byte) *Multipart {
-	var unmarshalStr = func(data []byte) (string, int) {
-		var n int
-		var lengthU64 uint64
-		lengthU64, n = binary.Uvarint(data)
-		return string(data[n : n+int(lengthU64)]), n + int(lengthU64)
-	}
-	var offset, n int
-	// decode id
-	var id string
-	id, n = unmarshalStr(raw)
-	offset += n
-	// decode key
-	var key string
-	key, n = unmarshalStr(raw[offset:])
-	offset += n
-	// decode init time
-	var initTimeI64 int64
-	initTimeI64, n = binary.Varint(raw[offset:])
-	offset += n
-	// decode parts
-	var partsLengthU64 uint64
-	partsLengthU64, n = binary.Uvarint(raw[offset:])
-	offset += n
-	var parts = PartsFromBytes(raw[offset : offset+int(partsLengthU64)])
-	offset += int(partsLengthU64)
-	// decode multipart extend
-	var extendLengthU64 uint64
-	extendLengthU64, n = binary.Uvarint(raw[offset:])
-	offset += n
-	var me = MultipartExtendFromBytes(raw[offset : offset+int(extendLengthU64)])
-
+func MultipartFromBytes(raw []byte) (*Multipart, error) {
+	if len(raw) == 0 {
+		return &Multipart{initTime: time.Unix(0, 0), parts: make(Parts, 0)}, nil
+	}
+	var err error
+	var d = &multipartDecoder{raw: raw}
 	var muSession = &Multipart{
-		id:       id,
-		key:      key,
-		initTime: time.Unix(0, initTimeI64),
-		parts:    parts,
-		extend:   me,
+		id:       string(d.bytes()),
+		key:      string(d.bytes()),
+		initTime: time.Unix(0, d.varint()),
+	}
+	var partsBytes = d.bytes()
+	var extendBytes = d.bytes()
+	if d.err != nil {
+		return nil, d.err
 	}
-	return muSession
+	if muSession.parts, err = PartsFromBytes(partsBytes); err != nil {
+		return nil, err
+	}
+	if muSession.extend, err = MultipartExtendFromBytes(extendBytes); err != nil {
+		return nil, err
+	}
+	return muSession, nil
+}
+
+// multipartDecoder decodes the fields of an encoded part, parts, multipart extend or multipart session in order.
+// A field out of the bounds of the raw bytes fails the decoding and the following fields are skipped.
+type multipartDecoder struct {
+	raw    []byte
+	offset int
+	err    error
+}
+
+func (d *multipartDecoder) uvarint() (v uint64) {
+	if d.err != nil {
+		return
+	}
+	var n int
+	if v, n = binary.Uvarint(d.raw[d.offset:]); n <= 0 {
+		d.err = fmt.Errorf("corrupted multipart record: invalid uvarint at offset %v", d.offset)
+		return 0
+	}
+	d.offset += n
+	return
+}
+
+func (d *multipartDecoder) varint() (v int64) {
+	if d.err != nil {
+		return
+	}
+	var n int
+	if v, n = binary.Varint(d.raw[d.offset:]); n <= 0 {
+		d.err = fmt.Errorf("corrupted multipart record: invalid varint at offset %v", d.offset)
+		return 0
+	}
+	d.offset += n
+	return
+}
+
+// bytes decodes a length-prefixed field. The returned slice refers to the raw bytes.
+func (d *multipartDecoder) bytes() (b []byte) {
+	length := d.uvarint()
+	if d.err != nil {
+		return
+	}
+	if length > uint64(len(d.raw)-d.offset) {
+		d.err = fmt.Errorf("corrupted multipart record: length(%v) at offset %v exceeds size(%v)",
+			length, d.offset, len(d.raw))
+		return
+	}
+	b = d.raw[d.offset : d.offset+int(length)]
+	d.offset += int(length)
+	return
 }
@@ -43,7 +43,10 @@ func TestMUPart_Bytes(t *testing.T) {
 	if partBytes, err = part1.Bytes(); err != nil {
 		t.Fatalf("get bytes of part fail cause: %v", err)
 	}
-	part2 := PartFromBytes(partBytes)
+	part2, err := PartFromBytes(partBytes)
+	if err != nil {
+		t.Fatalf("decode part fail cause: %v", err)
+	}
 	if !reflect.DeepEqual(part1, part2) {
 		t.Fatalf("result mismatch:\n\tpart1:%v\n\tpart2:%v", part1, part2)
 	}
@@ -53,7 +56,7 @@ func TestMUPart_Bytes(t *testing.T) {
 func TestMUParts_Bytes(t *testing.T) {
 	var err error
 	var random = rand.New(rand.NewSource(time.Now().UnixNano()))
-	var parts1 = PartsFromBytes(nil)
+	var parts1, _ = PartsFromBytes(nil)
 	for i := 0; i < 100; i++ {
 		part := &Part{
 			ID:         uint16(i),
@@ -69,7 +72,10 @@ func TestMUParts_Bytes(t *testing.T) {
 	if partsBytes, err = parts1.Bytes(); err != nil {
 		t.Fatalf("get bytes of part fail cause: %v", err)
 	}
-	parts2 := PartsFromBytes(partsBytes)
+	parts2, err := PartsFromBytes(partsBytes)
+	if err != nil {
+		t.Fatalf("decode parts fail cause: %v", err)
+	}
 	if !reflect.DeepEqual(parts1, parts2) {
 		t.Fatalf("result mismatch:\n\tpart1:%v\n\tpart2:%v", parts1, parts2)
 	}
@@ -78,7 +84,7 @@ func TestMUParts_Bytes(t *testing.T) {
 
 func TestMUParts_Modify(t *testing.T) {
 	var random = rand.New(rand.NewSource(time.Now().UnixNano()))
-	var parts = PartsFromBytes(nil)
+	var parts, _ = PartsFromBytes(nil)
 	for i := 0; i < 100; i++ {
 		part := &Part{
 			ID:         uint16(i),
@@ -124,7 +130,7 @@ func TestMUParts_Modify(t *testing.T) {
 func TestMUSession_Bytes(t *testing.T) {
 	var err error
 	var random = rand.New(rand.NewSource(time.Now().UnixNano()))
-	var session1 = MultipartFromBytes(nil)
+	var session1, _ = MultipartFromBytes(nil)
 
 	me := NewMultipartExtend()
 	me["oss::tag"] = "name=123&age456"
@@ -148,7 +154,10 @@ func TestMUSession_Bytes(t *testing.T) {
 	if err != nil {
 		t.Fatalf("encode session to bytes fail caue: %v", err)
 	}
-	session2 := MultipartFromBytes(sessionBytes)
+	session2, err := MultipartFromBytes(sessionBytes)
+	if err != nil {
+		t.Fatalf("decode session fail cause: %v", err)
+	}
 	if !reflect.DeepEqual(session1, session2) {
 		t.Fatalf("result mismatch:\n\tsession1:%v\n\tsession2:%v", session1, session2)
 	}
@@ -163,7 +172,10 @@ func TestMultipartExtend_Bytes(t *testing.T) {
 	if err != nil {
 		t.Errorf("Encode multipart extend fail cause : %v", err)
 	}
-	me2 := MultipartExtendFromBytes(bytes)
+	me2, err := MultipartExtendFromBytes(bytes)
+	if err != nil {
+		t.Fatalf("decode multipart extend fail cause: %v", err)
+	}
 	if !reflect.DeepEqual(me, me2) {
 		t.Fatalf("result mismatch:\n\tme1:%v\n\tme2:%v", me, me2)
 	}
@@ -20,7 +20,9 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
+	"time"
 
 	"fmt"
 	"io/ioutil"
@@ -32,6 +34,7 @@ import (
 	"github.com/chubaofs/chubaofs/raftstore"
 	"github.com/chubaofs/chubaofs/util"
 	"github.com/chubaofs/chubaofs/util/errors"
+	"github.com/chubaofs/chubaofs/util/exporter"
 	"github.com/chubaofs/chubaofs/util/log"
 	raftproto "github.com/tiglabs/raft/proto"
 )
@@ -76,6 +79,286 @@ type MetaPartitionConfig struct {
 	AfterStop   func()              `json:"-"`
 	RaftStore   raftstore.RaftStore `json:"-"`
 	ConnPool    *util.ConnectPool   `json:"-"`
+	Snapshot    SnapshotConfig      `json:"-"`
+	Siblings    RangeProvider       `json:"-"` // Ranges of the other meta partitions of the volume, checked at load
+}
+
+// InodeRange is the range of inode IDs claimed by a meta partition.
+type InodeRange struct {
+	PartitionID uint64
+	Start       uint64
+	End         uint64
+}
+
+// RangeProvider returns the ranges of the meta partitions of the volume other than the given one.
+type RangeProvider func(volName string, partitionID uint64) []InodeRange
+
+// SnapshotConfig defines the configures for storing and loading the snapshot of a meta partition.
+// The zero value keeps the default behavior.
+type SnapshotConfig struct {
+	// Store the snapshot once the number of fsm mutations since the last store reaches this threshold,
+	// and skip the scheduled store while there is no mutation at all. Zero disables the policy.
+	StoreMutationThreshold uint64
+	// Number of the slowest records to sample and log while decoding each snapshot file. Zero disables it.
+	SlowRecordSamples int
+	// Persist the free list and the cursor next to the inode file, so a restart can restore them without
+	// checking every loaded inode. A missing or invalid warm state falls back to the full check.
+	WarmSnapshot bool
+	// Fail the load when a loaded inode is outside [Start, End] of the partition. Otherwise such inodes are
+	// loaded and reported, but they never advance the cursor.
+	StrictInodeRange bool
+	// Fail the load when the range of the partition overlaps a sibling partition of the same volume.
+	// Otherwise overlapping ranges are only reported.
+	StrictRangeOverlap bool
+	// Fail the load when a loaded inode has a zero inode number or an unknown type.
+	// Otherwise such inodes are skipped and reported with the offset of their record.
+	StrictInodeCheck bool
+	// Skip a loaded dentry whose name is already taken by another dentry of the same parent, keeping the
+	// first one, instead of failing the load. See isDentryConflict for the statuses which are tolerated.
+	TolerateDentryConflicts bool
+	// Advise the kernel to read large snapshot files sequentially while loading them,
+	// and to drop them from the page cache afterwards.
+	LoadFadvise bool
+	// Names of the snapshot directories and the temporary metadata file inside the partition directory.
+	// The default names are used if they are empty.
+	DirName         string
+	TmpDirName      string
+	BackupDirName   string
+	MetadataTmpName string
+	// Durability of the snapshot and metadata files, DurabilityFull if empty. DurabilityNoSync skips fsync.
+	// It is only accepted by binaries built with the nosync tag and is meant for throwaway test partitions.
+	DurabilityMode string
+	// Store the inodes grouped by type (directories, regular files, symlinks, others) instead of in inode order,
+	// and record the offset of each group so a reader can scan one type only. The load accepts both layouts.
+	GroupInodesByType bool
+	// Refuse to store when the free space of the disk minus the estimated size of the snapshot
+	// would drop below this number of bytes, keeping the previous snapshot. Zero disables the check.
+	StoreMinFreeSpace uint64
+	// Map the extend and multipart files this many bytes at a time while loading them, instead of mapping
+	// the whole file, to bound the resident memory for giant files. Zero maps the whole file.
+	ExtendMmapWindow uint64
+	// Sync each snapshot data file after every this many bytes written while storing it, so the dirty pages
+	// of a very large file are flushed along the way instead of all at once at the end. Zero syncs only at the end.
+	StoreSyncInterval uint64
+	// Write the records of the inode file through a shared mapping of the file preallocated to its estimated
+	// size, instead of a write syscall per record. The file is identical to the one written without it.
+	MmapStore bool
+	// Write each distinct set of extended attributes once in the extend file, and a reference to it for each
+	// inode, instead of the attributes of every inode, which shrinks the file when many inodes share them.
+	ExtendDedup bool
+	// Read each data file back once it is stored and synced, and fail the store if its CRC is not the one
+	// computed while it was written. It doubles the IO of a store, and turns a silent write error into a
+	// store failure, which is retried like a transient IO error.
+	StoreReadBack bool
+	// Also store the fields of the inodes in columns, in the inode_columns directory of the snapshot, for
+	// analytics tools which do not decode the inode file, see ExportInodeColumns. They are never loaded.
+	InodeColumns bool
+	// Preallocate each data file of a store to the size it is expected to reach, and release the blocks left
+	// beyond its end once it is written, which keeps the files of frequent stores from fragmenting.
+	StorePreallocate bool
+	// Sign each snapshot with an HMAC-SHA256 of its content keyed by the key of HMACKeys with this ID, and
+	// recorded with the ID in the manifest. Once set, the load fails on a snapshot whose HMAC is missing or
+	// does not match, see checkSnapshotHMAC. The former keys stay in HMACKeys while the key rotates.
+	HMACKeyID string
+	HMACKeys  map[string]string
+	// Overrides of the store settings above for the partitions of each volume, keyed by volume name.
+	// They are resolved at each store, see storeConfig.
+	VolumeOverrides map[string]*SnapshotOverride
+	// Retry a store failed by a transient IO error (EIO, EAGAIN, or a file not read back, see StoreReadBack)
+	// up to this many times, waiting StoreRetryBackoff before the first retry and doubling it after each one,
+	// as long as StoreRetryTimeout has not elapsed since the first attempt. Each retry stores into a fresh
+	// temporary directory. Zero disables the retry.
+	StoreRetryAttempts int
+	StoreRetryBackoff  time.Duration
+	StoreRetryTimeout  time.Duration
+	// Store an index of the inode records sorted by inode next to the inode file, so SeekInode can read
+	// a single inode without scanning the file.
+	InodeIndex bool
+	// Store an index of the range of the dentries of each parent next to the dentry file, so ListChildren
+	// can list a directory without loading the other dentries.
+	DentryIndex bool
+	// Store the snapshot again right after the partition is loaded from the backup because the snapshot
+	// could not be loaded, so the corrupted snapshot is replaced at startup instead of at the next store.
+	ReadRepair bool
+	// Load the extends and multiparts of a snapshot on the first access to either of them, or before the
+	// next store, instead of with the inodes and dentries. A corrupted extend or multipart file is then
+	// found late: the backup snapshot is not loaded instead. The failed load fails the reads and stores and leaves
+	// the partition read-only, and is fatal like one at startup to a replica applying a command which needs them.
+	LazyLoad bool
+	// Maximum number of references held by the index of the multiparts of each part inode, built while
+	// the multiparts are loaded. Past it the index is dropped and MultipartsOfInode scans the multiparts.
+	// Zero disables the index.
+	MultipartIndexMax int
+	// Maximum number of references held by the index of the dentries of each inode, built while the dentries
+	// are loaded. Past it the index is dropped and DentriesOfInode scans the dentries. Zero disables the index.
+	ParentIndexMax int
+	// Directory the snapshot files are copied into, with a report of the failure, when a partition is loaded
+	// from the backup because its snapshot could not be loaded, before they are replaced. The oldest copies
+	// are removed while the directory is larger than QuarantineMaxSize, unless it is zero.
+	QuarantineDir     string
+	QuarantineMaxSize uint64
+	// Directory the snapshot files of a partition are moved into once it has been neither loaded nor stored
+	// for ColdAfter, e.g. on a slower and cheaper disk. The snapshot directory then links to them. They are
+	// moved back when the partition is loaded, and a store always writes into the partition directory.
+	ColdDir   string
+	ColdAfter time.Duration
+	// Age the checkpoints once they were stored AgeCheckpointsAfter ago, see AgeSnapshot. 0 disables it.
+	AgeCheckpointsAfter time.Duration
+	// Compare the applyID of the loaded snapshot with the range of the raft log before the raft partition is
+	// created, see checkApplyIDRange: ApplyIDCheckWarn logs a mismatch, ApplyIDCheckRefuse fails the start.
+	// Empty disables the check.
+	ApplyIDCheck string
+	// Log levels of the load and of the store of the snapshots: SnapshotLogWarn drops their progress logs,
+	// SnapshotLogDebug logs their details at info level. The partitions of DebugPartitions log both at
+	// SnapshotLogDebug, to follow one partition without the logs of all the others. Empty is SnapshotLogInfo.
+	LoadLogLevel    string
+	StoreLogLevel   string
+	DebugPartitions []uint64
+	// Compare the applyID of the snapshot with the applyID of the last snapshot stored by the partition before
+	// it is loaded, see checkApplyIDRegression: ApplyIDCheckWarn logs an older snapshot, ApplyIDCheckRefuse fails
+	// the load. Empty disables the check.
+	ApplyIDRegression string
+	// Receives the records of the loads, see LoadSink, with at most LoadSinkBuffer records waiting for it.
+	// A sink which leaves no room in the buffer for LoadSinkTimeout is dropped for the rest of the load.
+	LoadSink        LoadSink
+	LoadSinkBuffer  int
+	LoadSinkTimeout time.Duration
+	// Write the report of each store into the snapshot directory, see StoreReport.
+	StoreReport bool
+}
+
+// durability modes of the snapshot and metadata files
+const (
+	DurabilityFull   = "full"
+	DurabilityNoSync = "nosync"
+)
+
+// modes of the check of the applyID against the raft log
+const (
+	ApplyIDCheckWarn   = "warn"
+	ApplyIDCheckRefuse = "refuse"
+)
+
+func (c SnapshotConfig) checkApplyIDCheck() error {
+	switch c.ApplyIDCheck {
+	case "", ApplyIDCheckWarn, ApplyIDCheckRefuse:
+		return nil
+	default:
+		return fmt.Errorf("unknown applyID check mode: %v", c.ApplyIDCheck)
+	}
+}
+
+// String prints the configuration with the IDs of the HMAC keys but never the keys themselves.
+func (c SnapshotConfig) String() string {
+	type plain SnapshotConfig
+	p := plain(c)
+	p.HMACKeys = make(map[string]string, len(c.HMACKeys))
+	for id := range c.HMACKeys {
+		p.HMACKeys[id] = "******"
+	}
+	return fmt.Sprintf("%+v", p)
+}
+
+func (c SnapshotConfig) checkDurability() error {
+	switch c.DurabilityMode {
+	case "", DurabilityFull:
+		return nil
+	case DurabilityNoSync:
+		if !noSyncAllowed {
+			return fmt.Errorf("durability mode %v is not supported by this build", c.DurabilityMode)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown durability mode: %v", c.DurabilityMode)
+	}
+}
+
+// SnapshotOverride overrides the store settings of the partitions of a volume. A nil field keeps
+// the setting of the node. The JSON names are the config keys of the node settings.
+type SnapshotOverride struct {
+	GroupInodesByType   *bool   `json:"groupInodesByType,omitempty"`
+	InodeIndex          *bool   `json:"storeInodeIndex,omitempty"`
+	DentryIndex         *bool   `json:"storeDentryIndex,omitempty"`
+	MmapStore           *bool   `json:"snapshotMmapStore,omitempty"`
+	StoreSyncIntervalMB *uint64 `json:"storeSyncIntervalMB,omitempty"`
+	ExtendDedup         *bool   `json:"storeExtendDedup,omitempty"`
+	StoreReadBack       *bool   `json:"storeReadBack,omitempty"`
+}
+
+// forVolume returns the config with the overrides of the given volume applied. The loaders do not
+// need it, as every layout these settings choose is loaded the same way.
+func (c SnapshotConfig) forVolume(volName string) SnapshotConfig {
+	o, ok := c.VolumeOverrides[volName]
+	if !ok || o == nil {
+		return c
+	}
+	if o.GroupInodesByType != nil {
+		c.GroupInodesByType = *o.GroupInodesByType
+	}
+	if o.InodeIndex != nil {
+		c.InodeIndex = *o.InodeIndex
+	}
+	if o.DentryIndex != nil {
+		c.DentryIndex = *o.DentryIndex
+	}
+	if o.MmapStore != nil {
+		c.MmapStore = *o.MmapStore
+	}
+	if o.StoreSyncIntervalMB != nil {
+		c.StoreSyncInterval = *o.StoreSyncIntervalMB * MB
+	}
+	if o.ExtendDedup != nil {
+		c.ExtendDedup = *o.ExtendDedup
+	}
+	if o.StoreReadBack != nil {
+		c.StoreReadBack = *o.StoreReadBack
+	}
+	return c
+}
+
+func (c SnapshotConfig) dirName() string {
+	if c.DirName == "" {
+		return snapshotDir
+	}
+	return c.DirName
+}
+
+func (c SnapshotConfig) tmpDirName() string {
+	if c.TmpDirName == "" {
+		return snapshotDirTmp
+	}
+	return c.TmpDirName
+}
+
+func (c SnapshotConfig) backupDirName() string {
+	if c.BackupDirName == "" {
+		return snapshotBackup
+	}
+	return c.BackupDirName
+}
+
+func (c SnapshotConfig) metadataTmpName() string {
+	if c.MetadataTmpName == "" {
+		return metadataFileTmp
+	}
+	return c.MetadataTmpName
+}
+
+// checkNames checks that the configured names are plain file names which do not collide
+// with each other or with the other files in the partition directory.
+func (c SnapshotConfig) checkNames() error {
+	names := []string{c.dirName(), c.tmpDirName(), c.backupDirName(), c.metadataTmpName(), metadataFile}
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		if name == "." || name == ".." || strings.Contains(name, "/") || strings.HasPrefix(name, checkpointPrefix) {
+			return fmt.Errorf("invalid snapshot name: %v", name)
+		}
+		if seen[name] {
+			return fmt.Errorf("duplicate snapshot name: %v", name)
+		}
+		seen[name] = true
+	}
+	return nil
 }
 
 func (c *MetaPartitionConfig) checkMeta() (err error) {
@@ -189,6 +472,10 @@ type MetaPartition interface {
 	Stop()
 	OpMeta
 	LoadSnapshot(path string) error
+	Checkpoint(name string) (*CheckpointHandle, error)
+	DigestAt(applyID uint64) (*SnapshotDigest, error)
+	EstimateResidentBytes() uint64
+	ForceFullStore()
 	ForceSetMetaPartitionToLoadding()
 	ForceSetMetaPartitionToFininshLoad()
 }
@@ -218,6 +505,33 @@ type metaPartition struct {
 	vol                    *Vol
 	manager                *metadataManager
 	isLoadingMetaPartition bool
+	mutations              uint64 // number of fsm mutations applied since start
+	storedMutations        uint64 // value of mutations covered by the last successful store
+	// held while a command is applied, so the trees can be captured between two commands
+	applyMu sync.Mutex
+	// approximate memory taken by the trees, see EstimateResidentBytes
+	residentBytes uint64
+	// set to 1 to store at the next schedule whatever happened since the last store, see ForceFullStore
+	forceFullStore uint32
+	// sizes and throughput of the past stores, used to estimate the cost of the next one
+	storeCost storeCostModel
+	// extends and multiparts not loaded yet, see SnapshotConfig.LazyLoad
+	deferred deferredLoad
+	// multiparts of each part inode, see MultipartsOfInode
+	multipartIndex *multipartInodeIndex
+	// dentries of each inode, see DentriesOfInode
+	parentIndex *dentryParentIndex
+	loadFeed    *loadSinkFeed // of the load in progress, see LoadSink, guarded by deferred
+	// last time the snapshot was loaded or stored in unix nanoseconds, see SnapshotConfig.ColdAfter
+	snapshotAccess int64
+	// held while the snapshot directory is replaced by a store or moved to or from the cold directory
+	tierMu sync.Mutex
+	// 1 while maintainSnapshot runs, so the ticks of the store schedule never start it twice
+	maintaining int32
+	// flock of the serving lock file while the partition is started, see registerServing
+	servingLock *os.File
+	// called after each data file is stored by storeToDir, nil except in tests injecting failures
+	afterStoreFile func(filename string) error
 }
 
 func (mp *metaPartition) ForceSetMetaPartitionToLoadding() {
@@ -282,12 +596,21 @@ func (mp *metaPartition) onStart() (err error) {
 			mp.config.PartitionId, err.Error())
 		return
 	}
+	if err = mp.registerServing(); err != nil {
+		err = errors.NewErrorf("[onStart] partition id=%d: %s", mp.config.PartitionId, err.Error())
+		return
+	}
 	mp.startSchedule(mp.applyID)
 	if err = mp.startFreeList(); err != nil {
 		err = errors.NewErrorf("[onStart] start free list id=%d: %s",
 			mp.config.PartitionId, err.Error())
 		return
 	}
+	if err = mp.checkRaftLogRange(); err != nil {
+		err = errors.NewErrorf("[onStart] check raft log id=%d: %s",
+			mp.config.PartitionId, err.Error())
+		return
+	}
 	if err = mp.startRaft(); err != nil {
 		err = errors.NewErrorf("[onStart]start raft id=%d: %s",
 			mp.config.PartitionId, err.Error())
@@ -297,6 +620,7 @@ func (mp *metaPartition) onStart() (err error) {
 }
 
 func (mp *metaPartition) onStop() {
+	mp.unregisterServing()
 	mp.stopRaft()
 	mp.stop()
 	if mp.delInodeFp != nil {
@@ -341,6 +665,45 @@ func (mp *metaPartition) startRaft() (err error) {
 	return
 }
 
+// checkApplyIDRange checks that the raft log [first, last] can be replayed over a snapshot stored at applyID:
+// the log must hold the entry following applyID, unless it is empty past applyID, and must not end before applyID.
+// Otherwise the entries between the snapshot and the log are lost, or the snapshot is ahead of the log.
+func checkApplyIDRange(applyID, first, last uint64) error {
+	if applyID+1 < first {
+		return fmt.Errorf("raft log starts after the snapshot, entries [%v, %v] are missing: applyID(%v) "+
+			"expected range [%v, %v]", applyID+1, first-1, applyID, first-1, last)
+	}
+	if applyID > last {
+		return fmt.Errorf("snapshot is ahead of the raft log: applyID(%v) expected range [%v, %v]",
+			applyID, first-1, last)
+	}
+	return nil
+}
+
+// checkRaftLogRange checks the applyID loaded from the snapshot against the raft log of the partition,
+// as configured by SnapshotConfig.ApplyIDCheck.
+func (mp *metaPartition) checkRaftLogRange() (err error) {
+	mode := mp.config.Snapshot.ApplyIDCheck
+	if mode == "" || mp.config.RaftStore == nil {
+		return
+	}
+	first, last, err := mp.config.RaftStore.LogRange(&raftstore.PartitionConfig{ID: mp.config.PartitionId})
+	if err != nil {
+		return errors.NewErrorf("[checkRaftLogRange] read raft log: %s", err.Error())
+	}
+	if err = checkApplyIDRange(mp.applyID, first, last); err == nil {
+		log.LogInfof("checkRaftLogRange: applyID in range: partitionID(%v) applyID(%v) first(%v) last(%v)",
+			mp.config.PartitionId, mp.applyID, first, last)
+		return
+	}
+	if mode == ApplyIDCheckRefuse {
+		return errors.NewErrorf("[checkRaftLogRange] %s", err.Error())
+	}
+	log.LogWarnf("checkRaftLogRange: snapshot does not match the raft log: partitionID(%v) volume(%v) err(%v)",
+		mp.config.PartitionId, mp.config.VolName, err)
+	return nil
+}
+
 func (mp *metaPartition) stopRaft() {
 	if mp.raftPartition != nil {
 		// TODO Unhandled errors
@@ -388,6 +751,8 @@ func NewMetaPartition(conf *MetaPartitionConfig, manager *metadataManager) MetaP
 		vol:           NewVol(),
 		manager:       manager,
 	}
+	mp.resetMultipartIndex()
+	mp.resetParentIndex()
 	return mp
 }
 
@@ -421,11 +786,37 @@ func (mp *metaPartition) GetPeers() (peers []string) {
 	return
 }
 
+// ForceFullStore makes the next scheduled store run even if nothing was applied or mutated since the last one,
+// so a partition loaded from a degraded snapshot stores a clean one soon. It is cleared by a successful store.
+func (mp *metaPartition) ForceFullStore() {
+	atomic.StoreUint32(&mp.forceFullStore, 1)
+}
+
+func (mp *metaPartition) isForceFullStore() bool {
+	return atomic.LoadUint32(&mp.forceFullStore) == 1
+}
+
+// mutationsSinceStore returns the number of fsm mutations which are not covered by the last store.
+func (mp *metaPartition) mutationsSinceStore() uint64 {
+	return atomic.LoadUint64(&mp.mutations) - atomic.LoadUint64(&mp.storedMutations)
+}
+
 // GetCursor returns the cursor stored in the config.
 func (mp *metaPartition) GetCursor() uint64 {
 	return atomic.LoadUint64(&mp.config.Cursor)
 }
 
+// advanceCursor moves the cursor forward to the given inode ID, and never moves it backwards.
+// The loaders of the inode file, the apply file and the warm state call it in any order.
+func (mp *metaPartition) advanceCursor(id uint64) {
+	for {
+		cur := atomic.LoadUint64(&mp.config.Cursor)
+		if cur >= id || atomic.CompareAndSwapUint64(&mp.config.Cursor, cur, id) {
+			return
+		}
+	}
+}
+
 // PersistMetadata is the wrapper of persistMetadata.
 func (mp *metaPartition) PersistMetadata() (err error) {
 	mp.config.sortPeers()
@@ -433,20 +824,69 @@ func (mp *metaPartition) PersistMetadata() (err error) {
 	return
 }
 
+// LoadSnapshot loads the snapshot files in snapshotPath. A missing or empty data file holds no record, so
+// the snapshot of an empty partition, whose inode and dentry files are empty and whose extend and multipart
+// files only hold a zero count, loads into an empty partition at the applyID of its apply file.
+// The load fails if a file of the snapshot is changed, created or removed while it is loaded, and at once
+// if a file listed by the manifest is missing, naming every missing file.
 func (mp *metaPartition) LoadSnapshot(snapshotPath string) (err error) {
+	feed := mp.openLoadFeed()
+	mp.deferred.Lock()
+	mp.loadFeed = feed
+	mp.deferred.Unlock()
+	defer func() {
+		mp.deferred.Lock()
+		mp.loadFeed = nil
+		mp.deferred.Unlock()
+		feed.close(err)
+	}()
+	stamps := stampSnapshotFiles(snapshotPath, snapshotStreamFiles)
+	cache, start := measureSnapshotCache(snapshotPath), time.Now()
+	defer func() {
+		if err == nil {
+			err = checkSnapshotStamps(snapshotPath, stamps)
+		}
+		if err == nil {
+			mp.reportLoadCache(snapshotPath, cache, time.Since(start))
+		}
+	}()
+	extra, err := reconcileSnapshotManifest(snapshotPath)
+	if err != nil {
+		return
+	}
+	if len(extra) != 0 {
+		log.LogWarnf("LoadSnapshot: files not listed by the manifest: partitionID(%v) volume(%v) dir(%v) files(%v)",
+			mp.config.PartitionId, mp.config.VolName, snapshotPath, extra)
+	}
+	if err = checkSnapshotManifest(snapshotPath); err != nil {
+		return
+	}
+	if err = checkSnapshotHMAC(snapshotPath, mp.config.Snapshot); err != nil {
+		return
+	}
 	if err = mp.loadInode(snapshotPath); err != nil {
 		return
 	}
 	if err = mp.loadDentry(snapshotPath); err != nil {
 		return
 	}
-	if err = mp.loadExtend(snapshotPath); err != nil {
-		return
+	if mp.config.Snapshot.LazyLoad {
+		mp.deferLoad(snapshotPath, stamps)
+	} else {
+		if err = mp.loadExtend(snapshotPath); err != nil {
+			return
+		}
+		if err = mp.loadMultipart(snapshotPath); err != nil {
+			return
+		}
 	}
-	if err = mp.loadMultipart(snapshotPath); err != nil {
+	if err = mp.loadApplyID(snapshotPath); err != nil {
 		return
 	}
-	err = mp.loadApplyID(snapshotPath)
+	if !stamps[applyIDFile].exists {
+		// the apply file recovered from the manifest is written by the load itself
+		stamps[applyIDFile] = stampSnapshotFiles(snapshotPath, []string{applyIDFile})[applyIDFile]
+	}
 	return
 }
 
@@ -454,25 +894,100 @@ func (mp *metaPartition) load() (err error) {
 	if err = mp.loadMetadata(); err != nil {
 		return
 	}
-	snapshotPath := path.Join(mp.config.RootDir, snapshotDir)
-	if err = mp.loadInode(snapshotPath); err != nil {
+	mp.sweepTempFiles()
+	if err = mp.checkRangeOverlap(); err != nil {
 		return
 	}
-	if err = mp.loadDentry(snapshotPath); err != nil {
+	snapshotPath := path.Join(mp.config.RootDir, mp.config.Snapshot.dirName())
+	cursor := mp.config.Cursor
+	defer func() {
+		if err == nil {
+			mp.reportResidentBytes()
+			mp.touchSnapshot()
+		}
+	}()
+	if err = mp.promoteSnapshot(); err != nil {
+		// the snapshot is still read through the link to the cold directory
+		log.LogWarnf("load: promote cold snapshot failed: partitionID(%v) volume(%v) err(%v)",
+			mp.config.PartitionId, mp.config.VolName, err)
+	}
+	if err = mp.checkApplyIDRegression(snapshotPath); err != nil {
 		return
 	}
-	if err = mp.loadExtend(snapshotPath); err != nil {
+	if err = mp.LoadSnapshot(snapshotPath); err == nil {
 		return
 	}
-	if err = mp.loadMultipart(snapshotPath); err != nil {
+	// a store interrupted before removing the backup leaves the previous snapshot, which is still good
+	backupPath := path.Join(mp.config.RootDir, mp.config.Snapshot.backupDirName())
+	if _, statErr := os.Stat(backupPath); statErr != nil {
+		return
+	}
+	log.LogWarnf("load: load snapshot failed, load the backup instead: partitionID(%v) volume(%v) err(%v)",
+		mp.config.PartitionId, mp.config.VolName, err)
+	if _, qErr := mp.quarantineSnapshot(snapshotPath, err); qErr != nil {
+		log.LogErrorf("load: quarantine snapshot failed: partitionID(%v) volume(%v) err(%v)",
+			mp.config.PartitionId, mp.config.VolName, qErr)
+	}
+	mp.resetLoadedState(cursor)
+	if err = mp.LoadSnapshot(backupPath); err != nil {
 		return
 	}
-	err = mp.loadApplyID(snapshotPath)
+	mp.ForceFullStore()
+	if mp.config.Snapshot.ReadRepair {
+		mp.readRepair()
+	}
 	return
 }
 
+// resetLoadedState drops everything loaded by a failed LoadSnapshot, so another snapshot can be loaded.
+func (mp *metaPartition) resetLoadedState(cursor uint64) {
+	mp.inodeTree = NewBtree()
+	mp.dentryTree = NewBtree()
+	mp.extendTree = NewBtree()
+	mp.multipartTree = NewBtree()
+	mp.resetMultipartIndex()
+	mp.resetParentIndex()
+	mp.cancelDeferredLoad()
+	mp.freeList = newFreeList()
+	mp.config.Cursor = cursor
+	mp.applyID = 0
+	mp.residentBytes = 0
+}
+
+// readRepair stores the state loaded from the backup as the snapshot, replacing the corrupted one.
+// A failure only leaves the corrupted snapshot until the next store.
+func (mp *metaPartition) readRepair() {
+	mp.applyMu.Lock()
+	sm, err := mp.captureStoreMsg(mp.applyID)
+	mp.applyMu.Unlock()
+	if err != nil {
+		log.LogErrorf("readRepair: capture failed: partitionID(%v) volume(%v) err(%v)",
+			mp.config.PartitionId, mp.config.VolName, err)
+		return
+	}
+	if err = mp.store(sm); err != nil {
+		log.LogErrorf("readRepair: store failed: partitionID(%v) volume(%v) applyID(%v) err(%v)",
+			mp.config.PartitionId, mp.config.VolName, sm.applyIndex, err)
+		return
+	}
+	log.LogWarnf("readRepair: snapshot restored from the backup: partitionID(%v) volume(%v) applyID(%v)",
+		mp.config.PartitionId, mp.config.VolName, sm.applyIndex)
+}
+
+// store writes the snapshot into the temporary directory and publishes it by renaming it to the active one.
+// The files of the active directory are never rewritten in place, see ActiveSnapshot for the reader side.
 func (mp *metaPartition) store(sm *storeMsg) (err error) {
-	tmpDir := path.Join(mp.config.RootDir, snapshotDirTmp)
+	// the temporary directory is also used to move the snapshot to and from the cold directory
+	mp.tierMu.Lock()
+	defer mp.tierMu.Unlock()
+	if mp.storeUnchanged(sm) {
+		return
+	}
+	start := time.Now()
+	if err = mp.checkStoreSpace(); err != nil {
+		return
+	}
+	tmpDir := path.Join(mp.config.RootDir, mp.config.Snapshot.tmpDirName())
 	if _, err = os.Stat(tmpDir); err == nil {
 		// TODO Unhandled errors
 		os.RemoveAll(tmpDir)
@@ -488,6 +1003,75 @@ func (mp *metaPartition) store(sm *storeMsg) (err error) {
 			os.RemoveAll(tmpDir)
 		}
 	}()
+	if err = mp.storeToDir(tmpDir, sm); err != nil {
+		return
+	}
+	mp.refreshResidentBytes(sm)
+	snapshotDir := path.Join(mp.config.RootDir, mp.config.Snapshot.dirName())
+	cold, _ := coldTarget(snapshotDir)
+	// check snapshot backup
+	backupDir := path.Join(mp.config.RootDir, mp.config.Snapshot.backupDirName())
+	if _, err = os.Lstat(backupDir); err == nil {
+		if err = os.RemoveAll(backupDir); err != nil {
+			return
+		}
+	}
+	err = nil
+
+	// rename snapshot
+	if _, err = os.Lstat(snapshotDir); err == nil {
+		if err = os.Rename(snapshotDir, backupDir); err != nil {
+			return
+		}
+	}
+	err = nil
+
+	if err = os.Rename(tmpDir, snapshotDir); err != nil {
+		_ = os.Rename(backupDir, snapshotDir)
+		return
+	}
+	mp.touchSnapshot()
+	mp.recordStoredApplyID(sm.applyIndex)
+	mp.reportStore(snapshotDir, start)
+	if cold != "" {
+		os.RemoveAll(cold)
+	}
+	err = os.RemoveAll(backupDir)
+	return
+}
+
+// storeWithRetry stores the snapshot, retrying the whole store with backoff while it fails with a transient
+// IO error, as configured by SnapshotConfig. The previous snapshot is kept between the attempts.
+func (mp *metaPartition) storeWithRetry(sm *storeMsg) (err error) {
+	conf := mp.config.Snapshot
+	deadline := time.Now().Add(conf.StoreRetryTimeout)
+	backoff := conf.StoreRetryBackoff
+	for attempt := 0; ; attempt++ {
+		if err = mp.store(sm); err == nil {
+			return
+		}
+		reason := storeFailureReason(err)
+		if attempt >= conf.StoreRetryAttempts ||
+			(reason != storeFailureIO && reason != storeFailureAgain && reason != storeFailureReadBack) ||
+			time.Now().Add(backoff).After(deadline) {
+			return
+		}
+		exporter.NewCounter(MetricSnapshotStoreRetries).AddWithLabels(1, map[string]string{"reason": reason})
+		log.LogWarnf("storeWithRetry: retry store: partitionID(%v) volume(%v) attempt(%v) backoff(%v) err(%v)",
+			mp.config.PartitionId, mp.config.VolName, attempt+1, backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// storeToDir writes the data files, the apply file and the sign file of the snapshot into dir.
+func (mp *metaPartition) storeToDir(dir string, sm *storeMsg) (err error) {
+	exporter.NewCounter(MetricSnapshotStoreAttempts).Add(1)
+	defer func() {
+		if err != nil {
+			mp.reportStoreFailure(dir, err)
+		}
+	}()
 	var crcBuffer = bytes.NewBuffer(make([]byte, 0, 16))
 	var storeFuncs = []func(dir string, sm *storeMsg) (uint32, error){
 		mp.storeInode,
@@ -495,46 +1079,109 @@ func (mp *metaPartition) store(sm *storeMsg) (err error) {
 		mp.storeExtend,
 		mp.storeMultipart,
 	}
-	for _, storeFunc := range storeFuncs {
+	var crcs = make([]uint32, 0, len(storeFuncs))
+	var counts = storeMsgCounts(sm)
+	for i, storeFunc := range storeFuncs {
 		var crc uint32
-		if crc, err = storeFunc(tmpDir, sm); err != nil {
+		start := time.Now()
+		if crc, err = storeFunc(dir, sm); err != nil {
 			return
 		}
+		if err = mp.trimStoreFile(path.Join(dir, snapshotDataFiles[i])); err != nil {
+			return
+		}
+		if info, statErr := os.Stat(path.Join(dir, snapshotDataFiles[i])); statErr == nil {
+			mp.storeCost.observe(snapshotDataFiles[i], info.Size(), counts[i], time.Since(start))
+		}
+		if mp.afterStoreFile != nil {
+			if err = mp.afterStoreFile(path.Join(dir, snapshotDataFiles[i])); err != nil {
+				return
+			}
+		}
+		if mp.storeConfig().StoreReadBack {
+			if err = mp.readBackStoredFile(path.Join(dir, snapshotDataFiles[i]), crc); err != nil {
+				return
+			}
+		}
 		if crcBuffer.Len() != 0 {
 			crcBuffer.WriteString(" ")
 		}
 		crcBuffer.WriteString(fmt.Sprintf("%d", crc))
+		crcs = append(crcs, crc)
 	}
-	if err = mp.storeApplyID(tmpDir, sm); err != nil {
+	if mp.config.Snapshot.InodeColumns {
+		if err = mp.storeInodeColumns(dir, sm); err != nil {
+			return
+		}
+	}
+	if mp.config.Snapshot.WarmSnapshot {
+		if err = mp.storeWarmState(dir, sm, crcs[0]); err != nil {
+			return
+		}
+	}
+	if err = mp.storeApplyID(dir, sm); err != nil {
+		return
+	}
+	if err = mp.storeManifest(dir, sm, crcs); err != nil {
 		return
 	}
 	// write crc to file
-	if err = ioutil.WriteFile(path.Join(tmpDir, SnapshotSign), crcBuffer.Bytes(), 0775); err != nil {
+	err = ioutil.WriteFile(path.Join(dir, SnapshotSign), crcBuffer.Bytes(), 0775)
+	return
+}
+
+// CheckpointHandle describes a verified snapshot set produced by Checkpoint.
+type CheckpointHandle struct {
+	Dir     string `json:"dir"`
+	ApplyID uint64 `json:"apply_id"`
+	Digest  uint32 `json:"digest"`
+}
+
+// Checkpoint stores the current state of the meta partition into a dedicated checkpoint directory
+// named after the given name, verifies the written files against the sign file and returns a handle of it.
+// The live snapshot directory is not touched.
+func (mp *metaPartition) Checkpoint(name string) (handle *CheckpointHandle, err error) {
+	if name == "" || strings.Contains(name, "/") {
+		err = errors.NewErrorf("[Checkpoint] illegal checkpoint name: %v", name)
 		return
 	}
-	snapshotDir := path.Join(mp.config.RootDir, snapshotDir)
-	// check snapshot backup
-	backupDir := path.Join(mp.config.RootDir, snapshotBackup)
-	if _, err = os.Stat(backupDir); err == nil {
-		if err = os.RemoveAll(backupDir); err != nil {
-			return
-		}
+	dir := path.Join(mp.config.RootDir, checkpointPrefix+name)
+	if _, err = os.Stat(dir); err == nil {
+		err = errors.NewErrorf("[Checkpoint] checkpoint already exists: %v", dir)
+		return
 	}
-	err = nil
-
-	// rename snapshot
-	if _, err = os.Stat(snapshotDir); err == nil {
-		if err = os.Rename(snapshotDir, backupDir); err != nil {
-			return
+	if err = os.MkdirAll(dir, 0775); err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			os.RemoveAll(dir)
 		}
+	}()
+	// wait for the command being applied, so the trees match the applyID
+	mp.applyMu.Lock()
+	sm, err := mp.captureStoreMsg(atomic.LoadUint64(&mp.applyID))
+	mp.applyMu.Unlock()
+	if err != nil {
+		err = errors.NewErrorf("[Checkpoint] capture: %s", err.Error())
+		return
 	}
-	err = nil
-
-	if err = os.Rename(tmpDir, snapshotDir); err != nil {
-		_ = os.Rename(backupDir, snapshotDir)
+	if err = mp.storeToDir(dir, sm); err != nil {
+		err = errors.NewErrorf("[Checkpoint] store: %s", err.Error())
 		return
 	}
-	err = os.RemoveAll(backupDir)
+	var crcs []uint32
+	if crcs, err = checkSnapshotSign(dir); err != nil {
+		err = errors.NewErrorf("[Checkpoint] verify: %s", err.Error())
+		return
+	}
+	handle = &CheckpointHandle{
+		Dir:     dir,
+		ApplyID: sm.applyIndex,
+		Digest:  snapshotDigest(crcs),
+	}
+	log.LogInfof("Checkpoint: checkpoint complete: partitionID(%v) volume(%v) dir(%v) applyID(%v) digest(%v)",
+		mp.config.PartitionId, mp.config.VolName, handle.Dir, handle.ApplyID, handle.Digest)
 	return
 }
 
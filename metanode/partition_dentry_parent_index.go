@@ -0,0 +1,134 @@
+// Copyright 2018 The Chubao Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metanode
+
+import (
+	"sync"
+
+	"github.com/chubaofs/chubaofs/util/log"
+)
+
+// dentryRef is the key of a dentry in the dentry tree.
+type dentryRef struct {
+	parentID uint64
+	name     string
+}
+
+// dentryParentIndex maps each inode to the dentries linking to it, so its parents are found without
+// scanning the dentries, e.g. to rebuild its paths or count its hard links. It is built while the dentries
+// are loaded and kept up to date by the fsm operations. It holds at most max references: past that it is
+// dropped, and lookups scan the dentries until it is built again by the next load.
+type dentryParentIndex struct {
+	sync.RWMutex
+	max      int
+	entries  int
+	disabled bool
+	refs     map[uint64][]dentryRef
+}
+
+func newDentryParentIndex(max int) *dentryParentIndex {
+	return &dentryParentIndex{max: max, disabled: max <= 0, refs: make(map[uint64][]dentryRef)}
+}
+
+func (x *dentryParentIndex) add(d *Dentry) {
+	if x == nil {
+		return
+	}
+	x.Lock()
+	defer x.Unlock()
+	if x.disabled {
+		return
+	}
+	if x.entries >= x.max {
+		log.LogWarnf("dentryParentIndex: index dropped, too many references: max(%v)", x.max)
+		x.disabled, x.entries, x.refs = true, 0, nil
+		return
+	}
+	x.refs[d.Inode] = append(x.refs[d.Inode], dentryRef{parentID: d.ParentId, name: d.Name})
+	x.entries++
+}
+
+// remove removes the reference of the dentry to the given inode.
+func (x *dentryParentIndex) remove(d *Dentry, inode uint64) {
+	if x == nil {
+		return
+	}
+	x.Lock()
+	defer x.Unlock()
+	if x.disabled {
+		return
+	}
+	ref := dentryRef{parentID: d.ParentId, name: d.Name}
+	refs := x.refs[inode]
+	for i, r := range refs {
+		if r == ref {
+			refs = append(refs[:i], refs[i+1:]...)
+			x.entries--
+			break
+		}
+	}
+	if len(refs) == 0 {
+		delete(x.refs, inode)
+	} else {
+		x.refs[inode] = refs
+	}
+}
+
+// lookup returns the references of the dentries of the inode, and false if there is no index.
+func (x *dentryParentIndex) lookup(inode uint64) (refs []dentryRef, ok bool) {
+	if x == nil {
+		return nil, false
+	}
+	x.RLock()
+	defer x.RUnlock()
+	if x.disabled {
+		return nil, false
+	}
+	return append([]dentryRef(nil), x.refs[inode]...), true
+}
+
+// resetParentIndex drops the index, before the dentry tree is loaded or replaced.
+func (mp *metaPartition) resetParentIndex() {
+	mp.parentIndex = newDentryParentIndex(mp.config.Snapshot.ParentIndexMax)
+}
+
+// rebuildParentIndex builds the index from the dentry tree, after it is replaced by a raft snapshot.
+func (mp *metaPartition) rebuildParentIndex() {
+	mp.resetParentIndex()
+	mp.dentryTree.Ascend(func(i BtreeItem) bool {
+		mp.parentIndex.add(i.(*Dentry))
+		return true
+	})
+}
+
+// DentriesOfInode returns the dentries linking to the given inode, one per hard link.
+// The dentries are scanned if the index is dropped or disabled.
+func (mp *metaPartition) DentriesOfInode(inode uint64) (dentries []*Dentry) {
+	if refs, ok := mp.parentIndex.lookup(inode); ok {
+		for _, ref := range refs {
+			if item := mp.dentryTree.Get(&Dentry{ParentId: ref.parentID, Name: ref.name}); item != nil {
+				dentries = append(dentries, item.(*Dentry))
+			}
+		}
+		return
+	}
+	mp.dentryTree.Ascend(func(i BtreeItem) bool {
+		if d := i.(*Dentry); d.Inode == inode {
+			dentries = append(dentries, d)
+		}
+		return true
+	})
+	return
+}
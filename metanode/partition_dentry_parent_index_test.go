@@ -0,0 +1,65 @@
+// Copyright 2018 The Chubao Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metanode
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/chubaofs/chubaofs/proto"
+)
+
+func TestDentryParentIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metanode_parent_index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mp := newFixturePartition(dir)
+	if err = mp.storeToDir(dir, capturedStoreMsg(mp)); err != nil {
+		t.Fatal(err)
+	}
+	for _, max := range []int{16, 2, 0} {
+		conf := &MetaPartitionConfig{PartitionId: 1, RootDir: dir, Start: 1, End: 1000}
+		conf.Snapshot.ParentIndexMax = max
+		loaded := NewMetaPartition(conf, nil).(*metaPartition)
+		if err = loaded.LoadSnapshot(dir); err != nil {
+			t.Fatal(err)
+		}
+		if refs, ok := loaded.parentIndex.lookup(2); ok != (max > 0) || (ok && len(refs) != 1) {
+			t.Fatalf("max(%v): loaded index of inode 2: %v %v", max, refs, ok)
+		}
+		// a hard link to the file, past the maximum of 2 the index is dropped and the dentries are scanned
+		if status := loaded.fsmCreateDentry(&Dentry{ParentId: 1, Name: "hardlink", Inode: 2, Type: proto.Mode(0644)}, false); status != proto.OpOk {
+			t.Fatalf("create dentry: status(%v)", status)
+		}
+		if _, ok := loaded.parentIndex.lookup(2); ok != (max > 2) {
+			t.Fatalf("max(%v): index kept(%v)", max, ok)
+		}
+		if found := loaded.DentriesOfInode(2); len(found) != 2 {
+			t.Fatalf("max(%v): dentries of inode 2: %v", max, found)
+		}
+		// the link is made to point to the file
+		loaded.fsmUpdateDentry(&Dentry{ParentId: 1, Name: "link", Inode: 2})
+		if found := loaded.DentriesOfInode(2); len(found) != 3 || len(loaded.DentriesOfInode(3)) != 0 {
+			t.Fatalf("max(%v): dentries of inode 2 after update: %v", max, found)
+		}
+		loaded.fsmDeleteDentry(&Dentry{ParentId: 1, Name: "file", Inode: 2}, true)
+		if found := loaded.DentriesOfInode(2); len(found) != 2 {
+			t.Fatalf("max(%v): dentries of inode 2 after delete: %v", max, found)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+// Copyright 2018 The Chubao Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metanode
+
+import (
+	"encoding/binary"
+	"hash"
+	"hash/crc32"
+	"io/ioutil"
+	"path"
+	"strings"
+	"sync/atomic"
+
+	"github.com/chubaofs/chubaofs/util/errors"
+)
+
+// SnapshotDigest identifies the metadata of a meta partition at an applyID. Replicas holding the same
+// metadata at the same applyID have equal digests, whatever the settings they store their snapshots with.
+type SnapshotDigest struct {
+	ApplyID uint64   `json:"apply_id"`
+	Digest  uint32   `json:"digest"`
+	CRCs    []uint32 `json:"crcs"` // CRCs of the canonical encoding of the inodes, dentries, extends and multiparts
+}
+
+// DigestAt returns the digest of the meta partition at the given applyID. It is taken from the manifest of
+// the stored snapshot or a checkpoint at that applyID if there is one, otherwise it is computed in memory
+// from the current state if the partition is at that applyID. Nothing is written to the disk.
+func (mp *metaPartition) DigestAt(applyID uint64) (digest *SnapshotDigest, err error) {
+	if digest = mp.storedDigestAt(applyID); digest != nil {
+		return
+	}
+	mp.applyMu.Lock()
+	if current := atomic.LoadUint64(&mp.applyID); current != applyID {
+		mp.applyMu.Unlock()
+		err = errors.NewErrorf("[DigestAt] no snapshot at applyID(%v): partitionID(%v) current applyID(%v)",
+			applyID, mp.config.PartitionId, current)
+		return
+	}
+	sm, err := mp.captureStoreMsg(applyID)
+	mp.applyMu.Unlock()
+	if err != nil {
+		err = errors.NewErrorf("[DigestAt] partitionID(%v): %s", mp.config.PartitionId, err.Error())
+		return
+	}
+
+	var crcs []uint32
+	if crcs, err = canonicalCRCs(sm, nil, SnapshotConfig{}); err != nil {
+		err = errors.NewErrorf("[DigestAt] partitionID(%v): %s", mp.config.PartitionId, err.Error())
+		return
+	}
+	digest = &SnapshotDigest{ApplyID: applyID, Digest: snapshotDigest(crcs), CRCs: crcs}
+	return
+}
+
+// storedDigestAt looks for the stored snapshot or a checkpoint at the given applyID whose manifest
+// records the canonical CRCs.
+func (mp *metaPartition) storedDigestAt(applyID uint64) *SnapshotDigest {
+	dirs := []string{mp.config.Snapshot.dirName()}
+	if infos, err := ioutil.ReadDir(mp.config.RootDir); err == nil {
+		for _, info := range infos {
+			if info.IsDir() && strings.HasPrefix(info.Name(), checkpointPrefix) {
+				dirs = append(dirs, info.Name())
+			}
+		}
+	}
+	for _, name := range dirs {
+		manifest, err := readSnapshotManifest(path.Join(mp.config.RootDir, name))
+		if err != nil || manifest == nil || manifest.ApplyID != applyID || len(manifest.CanonicalCRCs) == 0 {
+			continue
+		}
+		crcs := manifest.CanonicalCRCs
+		return &SnapshotDigest{ApplyID: applyID, Digest: snapshotDigest(crcs), CRCs: crcs}
+	}
+	return nil
+}
+
+// canonicalCRCs returns the CRCs of the data files of sm as stored without GroupInodesByType and ExtendDedup,
+// which change the bytes of the files but not the metadata. Given the CRCs of the files stored from sm with
+// conf, only the files whose bytes depend on conf are encoded again, in memory.
+func canonicalCRCs(sm *storeMsg, stored []uint32, conf SnapshotConfig) (crcs []uint32, err error) {
+	encoders := []func(*storeMsg, hash.Hash32) error{
+		canonicalInodes,
+		canonicalDentries,
+		canonicalExtends,
+		canonicalMultiparts,
+	}
+	crcs = make([]uint32, len(encoders))
+	for i, encode := range encoders {
+		if stored != nil && !(i == 0 && conf.GroupInodesByType) && !(i == 2 && conf.ExtendDedup) {
+			crcs[i] = stored[i]
+			continue
+		}
+		sign := crc32.NewIEEE()
+		if err = encode(sm, sign); err != nil {
+			return nil, err
+		}
+		crcs[i] = sign.Sum32()
+	}
+	return
+}
+
+// hashRecord writes the record behind its 4 bytes big endian length, as in the inode and dentry files.
+func hashRecord(w hash.Hash32, data []byte) {
+	lenBuf := make([]byte, 4)
+	binary.BigEndian.PutUint32(lenBuf, uint32(len(data)))
+	w.Write(lenBuf)
+	w.Write(data)
+}
+
+// hashUvarint writes v as in the extend and multipart files.
+func hashUvarint(w hash.Hash32, v uint64) {
+	tmp := make([]byte, binary.MaxVarintLen64)
+	w.Write(tmp[:binary.PutUvarint(tmp, v)])
+}
+
+func canonicalInodes(sm *storeMsg, w hash.Hash32) (err error) {
+	sm.inodeTree.Ascend(func(i BtreeItem) bool {
+		var data []byte
+		if data, err = i.(*Inode).Marshal(); err != nil {
+			return false
+		}
+		hashRecord(w, data)
+		return true
+	})
+	return
+}
+
+func canonicalDentries(sm *storeMsg, w hash.Hash32) (err error) {
+	sm.dentryTree.Ascend(func(i BtreeItem) bool {
+		var data []byte
+		if data, err = i.(*Dentry).Marshal(); err != nil {
+			return false
+		}
+		hashRecord(w, data)
+		return true
+	})
+	return
+}
+
+func canonicalExtends(sm *storeMsg, w hash.Hash32) (err error) {
+	hashUvarint(w, uint64(sm.extendTree.Len()))
+	sm.extendTree.Ascend(func(i BtreeItem) bool {
+		e := i.(*Extend)
+		// a giant record is hashed in chunks instead of through one buffer of its size
+		hashUvarint(w, uint64(e.encodedLen()))
+		err = e.writeChunked(w, storeChunkSize)
+		return err == nil
+	})
+	return
+}
+
+func canonicalMultiparts(sm *storeMsg, w hash.Hash32) (err error) {
+	hashUvarint(w, uint64(sm.multipartTree.Len()))
+	sm.multipartTree.Ascend(func(i BtreeItem) bool {
+		var raw []byte
+		if raw, err = i.(*Multipart).Bytes(); err != nil {
+			return false
+		}
+		hashUvarint(w, uint64(len(raw)))
+		w.Write(raw)
+		return true
+	})
+	return
+}
@@ -0,0 +1,72 @@
+// Copyright 2018 The Chubao Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metanode
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+// TestDigestAt expects replicas storing their snapshots with different settings to report the same digest,
+// from their manifests and from their current state, without writing any file for the latter.
+func TestDigestAt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metanode_digest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var expect *SnapshotDigest
+	for i, conf := range []SnapshotConfig{{}, {GroupInodesByType: true, ExtendDedup: true}} {
+		rootDir := path.Join(dir, string('a'+rune(i)))
+		mp := newFixturePartition(rootDir)
+		mp.config.Snapshot = conf
+		if err = os.MkdirAll(rootDir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		current, err := mp.DigestAt(mp.applyID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if infos, _ := ioutil.ReadDir(rootDir); len(infos) != 0 {
+			t.Fatalf("digest of the current state wrote %v files", len(infos))
+		}
+		if _, err = mp.DigestAt(mp.applyID + 1); err == nil {
+			t.Fatal("digest at an applyID the partition is not at")
+		}
+		if err = mp.store(capturedStoreMsg(mp)); err != nil {
+			t.Fatal(err)
+		}
+		stored := mp.storedDigestAt(mp.applyID)
+		if stored == nil || !reflect.DeepEqual(stored, current) {
+			t.Fatalf("settings %+v: stored digest %+v, current %+v", conf, stored, current)
+		}
+		if expect == nil {
+			// the canonical encoding is the one of a store with the default settings
+			crcs, err := readSnapshotSign(path.Join(rootDir, snapshotDir))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(crcs, current.CRCs) {
+				t.Fatalf("canonical CRCs %v, stored %v", current.CRCs, crcs)
+			}
+			expect = current
+		} else if !reflect.DeepEqual(expect, current) {
+			t.Fatalf("settings %+v: digest %+v, want %+v", conf, current, expect)
+		}
+	}
+}
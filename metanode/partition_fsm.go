@@ -36,15 +36,33 @@ import (
 
 // Apply applies the given operational commands.
 func (mp *metaPartition) Apply(command []byte, index uint64) (resp interface{}, err error) {
-	msg := &MetaItem{}
+	// a store tick is captured under applyMu but sent to the store loop once applyMu is released,
+	// so a busy store loop never holds up the readers waiting on applyMu
+	var sm *storeMsg
 	defer func() {
-		if err == nil {
-			mp.uploadApplyID(index)
+		if sm != nil {
+			mp.storeChan <- sm
 		}
 	}()
+	mp.applyMu.Lock()
+	defer mp.applyMu.Unlock()
+	msg := &MetaItem{}
 	if err = msg.UnmarshalJson(command); err != nil {
 		return
 	}
+	switch msg.Op {
+	case opFSMSetXAttr, opFSMRemoveXAttr, opFSMCreateMultipart, opFSMRemoveMultipart, opFSMAppendMultipart:
+		// a failed load panics before the applyID can move past the command
+		mp.mustLoadDeferred("apply")
+	}
+	defer func() {
+		if err == nil {
+			mp.uploadApplyID(index)
+			if msg.Op != opFSMStoreTick {
+				atomic.AddUint64(&mp.mutations, 1)
+			}
+		}
+	}()
 
 	switch msg.Op {
 	case opFSMCreateInode:
@@ -136,19 +154,11 @@ func (mp *metaPartition) Apply(command []byte, index uint64) (resp interface{},
 		}
 		resp = mp.fsmAppendExtents(ino)
 	case opFSMStoreTick:
-		inodeTree := mp.getInodeTree()
-		dentryTree := mp.getDentryTree()
-		extendTree := mp.extendTree.GetTree()
-		multipartTree := mp.multipartTree.GetTree()
-		msg := &storeMsg{
-			command:       opFSMStoreTick,
-			applyIndex:    index,
-			inodeTree:     inodeTree,
-			dentryTree:    dentryTree,
-			extendTree:    extendTree,
-			multipartTree: multipartTree,
-		}
-		mp.storeChan <- msg
+		// the trees of a partition whose deferred load failed are not stored without the extends and multiparts
+		if sm, err = mp.captureStoreMsg(index); err != nil {
+			log.LogErrorf("Apply: store tick skipped: partitionID(%v) applyID(%v) err(%v)", mp.config.PartitionId, index, err)
+			sm, err = nil, nil
+		}
 	case opFSMInternalDeleteInode:
 		err = mp.internalDelete(msg.V)
 	case opFSMInternalDeleteInodeBatch:
@@ -171,15 +181,21 @@ func (mp *metaPartition) Apply(command []byte, index uint64) (resp interface{},
 		err = mp.fsmRemoveXAttr(extend)
 	case opFSMCreateMultipart:
 		var multipart *Multipart
-		multipart = MultipartFromBytes(msg.V)
+		if multipart, err = MultipartFromBytes(msg.V); err != nil {
+			return
+		}
 		resp = mp.fsmCreateMultipart(multipart)
 	case opFSMRemoveMultipart:
 		var multipart *Multipart
-		multipart = MultipartFromBytes(msg.V)
+		if multipart, err = MultipartFromBytes(msg.V); err != nil {
+			return
+		}
 		resp = mp.fsmRemoveMultipart(multipart)
 	case opFSMAppendMultipart:
 		var multipart *Multipart
-		multipart = MultipartFromBytes(msg.V)
+		if multipart, err = MultipartFromBytes(msg.V); err != nil {
+			return
+		}
 		resp = mp.fsmAppendMultipart(multipart)
 	case opFSMSyncCursor:
 		var cursor uint64
@@ -252,22 +268,30 @@ func (mp *metaPartition) ApplySnapshot(peers []raftproto.Peer, iter raftproto.Sn
 	)
 	defer func() {
 		if err == io.EOF {
+			// the trees are swapped under applyMu like any applied command, the store tick is sent once
+			// applyMu is released, as Apply does
+			mp.applyMu.Lock()
 			mp.applyID = appIndexID
 			mp.inodeTree = inodeTree
 			mp.dentryTree = dentryTree
 			mp.extendTree = extendTree
 			mp.multipartTree = multipartTree
+			mp.rebuildMultipartIndex()
+			mp.rebuildParentIndex()
+			mp.cancelDeferredLoad()
 			mp.config.Cursor = cursor
-			err = nil
-			// store message
-			mp.storeChan <- &storeMsg{
+			sm := &storeMsg{
 				command:       opFSMStoreTick,
 				applyIndex:    mp.applyID,
+				mutations:     atomic.LoadUint64(&mp.mutations),
 				inodeTree:     mp.inodeTree,
 				dentryTree:    mp.dentryTree,
 				extendTree:    mp.extendTree,
 				multipartTree: mp.multipartTree,
 			}
+			mp.applyMu.Unlock()
+			err = nil
+			mp.storeChan <- sm
 			mp.extReset <- struct{}{}
 			log.LogDebugf("ApplySnapshot: finish with EOF: partitionID(%v) applyID(%v)", mp.config.PartitionId, mp.applyID)
 			return
@@ -320,7 +344,10 @@ func (mp *metaPartition) ApplySnapshot(peers []raftproto.Peer, iter raftproto.Sn
 			log.LogDebugf("ApplySnapshot: set extend attributes: partitionID(%v) extend(%v)",
 				mp.config.PartitionId, extend)
 		case opFSMCreateMultipart:
-			var multipart = MultipartFromBytes(snap.V)
+			var multipart *Multipart
+			if multipart, err = MultipartFromBytes(snap.V); err != nil {
+				return
+			}
 			multipartTree.ReplaceOrInsert(multipart, true)
 			log.LogDebugf("ApplySnapshot: create multipart: partitionID(%v) multipart(%v)", mp.config.PartitionId, multipart)
 		case opExtentFileSnapshot:
@@ -384,6 +411,9 @@ func (mp *metaPartition) HandleLeaderChange(leader uint64) {
 
 // Put puts the given key-value pair (operation key and operation request) into the raft store.
 func (mp *metaPartition) submit(op uint32, data []byte) (resp interface{}, err error) {
+	if err = mp.deferredLoadErr(); err != nil {
+		return
+	}
 	snap := NewMetaItem(0, nil, nil)
 	snap.Op = op
 	if data != nil {
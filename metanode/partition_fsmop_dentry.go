@@ -69,6 +69,7 @@ func (mp *metaPartition) fsmCreateDentry(dentry *Dentry,
 
 		status = proto.OpExistErr
 	} else {
+		mp.parentIndex.add(dentry)
 		if !forceUpdate {
 			parIno.IncNLink()
 			parIno.SetMtime()
@@ -116,6 +117,7 @@ func (mp *metaPartition) fsmDeleteDentry(dentry *Dentry, checkInode bool) (
 		resp.Status = proto.OpNotExistErr
 		return
 	} else {
+		mp.parentIndex.remove(item.(*Dentry), item.(*Dentry).Inode)
 		mp.inodeTree.CopyFind(NewInode(dentry.ParentId, 0),
 			func(item BtreeItem) {
 				if item != nil {
@@ -151,6 +153,8 @@ func (mp *metaPartition) fsmUpdateDentry(dentry *Dentry) (
 		}
 		d := item.(*Dentry)
 		d.Inode, dentry.Inode = dentry.Inode, d.Inode
+		mp.parentIndex.remove(d, dentry.Inode)
+		mp.parentIndex.add(d)
 		resp.Msg = dentry
 	})
 	return
@@ -21,6 +21,7 @@ func (mp *metaPartition) fsmCreateMultipart(multipart *Multipart) (status uint8)
 	if !ok {
 		return proto.OpExistErr
 	}
+	mp.multipartIndex.add(multipart, multipart.Parts())
 	return proto.OpOk
 }
 
@@ -29,6 +30,7 @@ func (mp *metaPartition) fsmRemoveMultipart(multipart *Multipart) (status uint8)
 	if deletedItem == nil {
 		return proto.OpNotExistErr
 	}
+	mp.multipartIndex.remove(deletedItem.(*Multipart))
 	return proto.OpOk
 }
 
@@ -46,6 +48,9 @@ func (mp *metaPartition) fsmAppendMultipart(multipart *Multipart) (status uint8)
 		if !stored && !actual.Equal(part) {
 			return proto.OpExistErr
 		}
+		if stored {
+			mp.multipartIndex.add(storedMultipart, []*Part{part})
+		}
 	}
 	return proto.OpOk
 }
@@ -141,6 +141,9 @@ type MetaItemIterator struct {
 
 // newMetaItemIterator returns a new MetaItemIterator.
 func newMetaItemIterator(mp *metaPartition) (si *MetaItemIterator, err error) {
+	if err = mp.loadDeferred("snapshot"); err != nil {
+		return
+	}
 	si = new(MetaItemIterator)
 	si.fileRootDir = mp.config.RootDir
 	si.applyID = mp.applyID
@@ -0,0 +1,133 @@
+// Copyright 2018 The Chubao Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metanode
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/chubaofs/chubaofs/util/errors"
+	"github.com/chubaofs/chubaofs/util/exporter"
+	"github.com/chubaofs/chubaofs/util/log"
+)
+
+const (
+	MetricLazyLoadHits = "snapshot_lazy_load_hits"
+	MetricLazyLoadTime = "snapshot_lazy_load_ns"
+)
+
+// deferredLoad holds the snapshot directory whose extend and multipart files are not loaded yet,
+// see SnapshotConfig.LazyLoad. Its lock also guards loadFeed of the partition.
+type deferredLoad struct {
+	sync.Mutex
+	pending uint32 // 1 while dir is not loaded, read without the lock
+	dir     string
+	stamps  map[string]fileStamp // of the files when the snapshot was loaded
+	err     error                // of a failed load, which leaves the partition read-only
+}
+
+// deferLoad records the snapshot directory to load the extends and multiparts from on first access.
+// The files must not change until then, as the inodes and dentries are loaded from the same snapshot.
+func (mp *metaPartition) deferLoad(dir string, stamps map[string]fileStamp) {
+	mp.deferred.Lock()
+	mp.deferred.dir = dir
+	mp.deferred.stamps = map[string]fileStamp{extendFile: stamps[extendFile], multipartFile: stamps[multipartFile]}
+	atomic.StoreUint32(&mp.deferred.pending, 1)
+	mp.deferred.Unlock()
+	log.LogInfof("deferLoad: extends and multiparts deferred: partitionID(%v) volume(%v) dir(%v)",
+		mp.config.PartitionId, mp.config.VolName, dir)
+}
+
+// cancelDeferredLoad drops the deferred load, when the trees are replaced by another snapshot.
+func (mp *metaPartition) cancelDeferredLoad() {
+	mp.deferred.Lock()
+	mp.deferred.dir = ""
+	mp.deferred.stamps = nil
+	mp.deferred.err = nil
+	atomic.StoreUint32(&mp.deferred.pending, 0)
+	mp.deferred.Unlock()
+}
+
+// loadDeferred loads the extends and multiparts deferred by deferLoad, and returns at once if there are none.
+// It must be called before the extend or multipart tree is read or changed, and before the trees are captured,
+// so nothing is missed or stored without them. The files of the directory are not replaced before then, as
+// a store captures the trees first. A failed load is not retried: its error is returned to every later caller
+// and the partition is read-only, see deferredLoadErr, until the trees are replaced by another snapshot.
+func (mp *metaPartition) loadDeferred(trigger string) (err error) {
+	if atomic.LoadUint32(&mp.deferred.pending) == 0 {
+		return
+	}
+	mp.deferred.Lock()
+	defer mp.deferred.Unlock()
+	if atomic.LoadUint32(&mp.deferred.pending) == 0 {
+		return
+	}
+	if mp.deferred.err != nil {
+		return mp.deferred.err
+	}
+	start := time.Now()
+	feed := mp.openLoadFeed()
+	mp.loadFeed = feed
+	err = mp.loadExtend(mp.deferred.dir)
+	if err == nil {
+		err = mp.loadMultipart(mp.deferred.dir)
+	}
+	if err == nil {
+		err = checkSnapshotStamps(mp.deferred.dir, mp.deferred.stamps)
+	}
+	mp.loadFeed = nil
+	feed.close(err)
+	if err != nil {
+		err = errors.NewErrorf("[loadDeferred] partitionID(%v) dir(%v) trigger(%v): %s",
+			mp.config.PartitionId, mp.deferred.dir, trigger, err.Error())
+		exporter.Warning(err.Error())
+		log.LogErrorf("loadDeferred: deferred load failed, partition is read-only: partitionID(%v) volume(%v) err(%v)",
+			mp.config.PartitionId, mp.config.VolName, err)
+		mp.deferred.err = err
+		return
+	}
+	atomic.StoreUint32(&mp.deferred.pending, 0)
+	cost := time.Since(start)
+	labels := map[string]string{"partid": strconv.FormatUint(mp.config.PartitionId, 10), "trigger": trigger}
+	exporter.NewCounter(MetricLazyLoadHits).AddWithLabels(1, labels)
+	exporter.NewGauge(MetricLazyLoadTime).SetWithLabels(float64(cost.Nanoseconds()), labels)
+	mp.reportResidentBytes()
+	mp.phaseInfof(snapshotPhaseLoad, "loadDeferred: deferred load complete: partitionID(%v) volume(%v) trigger(%v) extends(%v) "+
+		"multiparts(%v) cost(%v)", mp.config.PartitionId, mp.config.VolName, trigger, mp.extendTree.Len(),
+		mp.multipartTree.Len(), cost)
+	return
+}
+
+// mustLoadDeferred is loadDeferred for the commands being applied, which cannot be skipped: a failed load
+// is fatal like a failed load at startup.
+func (mp *metaPartition) mustLoadDeferred(trigger string) {
+	if err := mp.loadDeferred(trigger); err != nil {
+		log.LogFlush()
+		panic(err)
+	}
+}
+
+// deferredLoadErr returns the error of a failed deferred load. The partition submits no command then,
+// as the extends and multiparts are neither served nor stored.
+func (mp *metaPartition) deferredLoadErr() error {
+	if atomic.LoadUint32(&mp.deferred.pending) == 0 {
+		return nil
+	}
+	mp.deferred.Lock()
+	defer mp.deferred.Unlock()
+	return mp.deferred.err
+}
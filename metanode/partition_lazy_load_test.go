@@ -0,0 +1,125 @@
+// Copyright 2018 The Chubao Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metanode
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/chubaofs/chubaofs/proto"
+)
+
+func TestLazyLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metanode_lazy_load")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src, dst := path.Join(dir, "src"), path.Join(dir, "dst")
+	for _, d := range []string{src, dst} {
+		if err = os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mp := newFixturePartition(dir)
+	if err = mp.storeToDir(src, capturedStoreMsg(mp)); err != nil {
+		t.Fatal(err)
+	}
+	newLazyPartition := func() *metaPartition {
+		return NewMetaPartition(&MetaPartitionConfig{PartitionId: 1, RootDir: dir, Start: 1, End: 1000,
+			Snapshot: SnapshotConfig{LazyLoad: true}}, nil).(*metaPartition)
+	}
+	loaded := newLazyPartition()
+	if err = loaded.LoadSnapshot(src); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.inodeTree.Len() != 4 || loaded.extendTree.Len() != 0 || loaded.multipartTree.Len() != 0 {
+		t.Fatalf("extends or multiparts loaded before access: %v %v", loaded.extendTree.Len(), loaded.multipartTree.Len())
+	}
+	// a store loads them first, so they are not lost
+	if err = loaded.storeToDir(dst, capturedStoreMsg(loaded)); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.extendTree.Len() != 1 || loaded.multipartTree.Len() != 1 {
+		t.Fatalf("deferred load: %v %v", loaded.extendTree.Len(), loaded.multipartTree.Len())
+	}
+	srcSign, err := ioutil.ReadFile(path.Join(src, SnapshotSign))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dstSign, _ := ioutil.ReadFile(path.Join(dst, SnapshotSign)); !bytes.Equal(srcSign, dstSign) {
+		t.Fatalf("store after a deferred load differs: %s %s", srcSign, dstSign)
+	}
+
+	// a corrupted extend file fails the accesses and the stores and leaves the partition read-only, instead
+	// of going on without the extends, and is fatal to the applies
+	filename := path.Join(src, extendFile)
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a wrong count keeps the size recorded in the manifest
+	data[0]++
+	if err = ioutil.WriteFile(filename, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	loaded = newLazyPartition()
+	if err = loaded.LoadSnapshot(src); err != nil {
+		t.Fatal(err)
+	}
+	if err = loaded.loadDeferred("test"); err == nil {
+		t.Fatalf("corrupted extend file loaded")
+	}
+	if _, err = loaded.captureStoreMsg(loaded.applyID); err == nil {
+		t.Fatalf("trees captured without the extends")
+	}
+	p := &Packet{}
+	if err = loaded.GetXAttr(&proto.GetXAttrRequest{Inode: 2, Key: "user.key"}, p); err == nil || p.ResultCode != proto.OpErr {
+		t.Fatalf("extend read without the extends: err(%v) result(%v)", err, p.ResultCode)
+	}
+	if _, err = loaded.submit(opFSMCreateInode, nil); err == nil {
+		t.Fatalf("command submitted by a read-only partition")
+	}
+	raw, err := json.Marshal(&proto.SetXAttrRequest{Inode: 2, Key: "user.other", Value: "value"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd, err := NewMetaItem(opFSMSetXAttr, nil, raw).MarshalJson()
+	if err != nil {
+		t.Fatal(err)
+	}
+	applyID := loaded.applyID
+	if err = catchDeferredLoad(func() { loaded.Apply(cmd, applyID+1) }); err == nil {
+		t.Fatalf("command applied without the extends")
+	}
+	if loaded.applyID != applyID {
+		t.Fatalf("applyID advanced to %v by a failed apply", loaded.applyID)
+	}
+}
+
+// catchDeferredLoad runs f and returns the error of the deferred load it panicked with, see mustLoadDeferred.
+func catchDeferredLoad(f func()) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = r.(error)
+		}
+	}()
+	f()
+	return
+}
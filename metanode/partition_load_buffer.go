@@ -0,0 +1,123 @@
+// Copyright 2018 The Chubao Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metanode
+
+import (
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/chubaofs/chubaofs/util/exporter"
+	"github.com/chubaofs/chubaofs/util/log"
+)
+
+const (
+	MetricSnapshotLoadBufferBytes = "snapshot_load_buffer_bytes"
+	MetricSnapshotLoadThroughput  = "snapshot_load_throughput"
+)
+
+const (
+	loadBufferMinSize = 256 * KB // also the first size of the buffer, before any throughput is measured
+	loadBufferMaxSize = 32 * MB
+	// the adaptive buffer is sized to be filled in about this time at the measured throughput
+	loadBufferFillTime = 10 * time.Millisecond
+	// number of fills measured before the size of the adaptive buffer is fixed
+	loadBufferProbeFills = 4
+)
+
+// adaptiveReader buffers a snapshot file like a bufio.Reader, but measures the throughput of the first
+// fills of its buffer and resizes the buffer after each of them, so it can be filled in about
+// loadBufferFillTime. The buffer starts at its smallest size and grows on a fast disk, so a slow one
+// never holds a large buffer for nothing.
+type adaptiveReader struct {
+	r          io.Reader
+	buf        []byte
+	start, end int
+	err        error
+	fills      int
+	readBytes  int64
+	readTime   time.Duration
+	now        func() time.Time // time.Now, replaced by tests measuring a fixed throughput
+}
+
+func newAdaptiveReader(r io.Reader) *adaptiveReader {
+	return &adaptiveReader{r: r, buf: make([]byte, loadBufferMinSize), now: time.Now}
+}
+
+func (a *adaptiveReader) Read(p []byte) (n int, err error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if a.start == a.end {
+		if a.err != nil {
+			return 0, a.err
+		}
+		a.fill()
+		if a.start == a.end {
+			return 0, a.err
+		}
+	}
+	n = copy(p, a.buf[a.start:a.end])
+	a.start += n
+	return
+}
+
+func (a *adaptiveReader) fill() {
+	if a.fills > 0 && a.fills <= loadBufferProbeFills {
+		a.resize()
+	}
+	begin := a.now()
+	n, err := io.ReadFull(a.r, a.buf)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	a.readTime += a.now().Sub(begin)
+	a.readBytes += int64(n)
+	a.fills++
+	a.start, a.end, a.err = 0, n, err
+}
+
+func (a *adaptiveReader) resize() {
+	size := int(a.throughput() * int64(loadBufferFillTime) / int64(time.Second))
+	if size < loadBufferMinSize {
+		size = loadBufferMinSize
+	}
+	if size > loadBufferMaxSize {
+		size = loadBufferMaxSize
+	}
+	if size != len(a.buf) {
+		a.buf = make([]byte, size)
+	}
+}
+
+// throughput returns the measured read throughput in bytes per second.
+func (a *adaptiveReader) throughput() int64 {
+	if a.readTime <= 0 {
+		return loadBufferMaxSize * int64(time.Second) / int64(loadBufferFillTime)
+	}
+	return a.readBytes * int64(time.Second) / int64(a.readTime)
+}
+
+// reportLoadReader exports the buffer size chosen and the throughput measured by an adaptive reader.
+func (mp *metaPartition) reportLoadReader(a *adaptiveReader, file string) {
+	if a.fills == 0 {
+		return
+	}
+	labels := map[string]string{"partid": strconv.FormatUint(mp.config.PartitionId, 10), "file": file}
+	exporter.NewGauge(MetricSnapshotLoadBufferBytes).SetWithLabels(float64(len(a.buf)), labels)
+	exporter.NewGauge(MetricSnapshotLoadThroughput).SetWithLabels(float64(a.throughput()), labels)
+	log.LogInfof("reportLoadReader: adaptive buffer: partitionID(%v) file(%v) bufferSize(%v) throughput(%v) fills(%v)",
+		mp.config.PartitionId, file, len(a.buf), a.throughput(), a.fills)
+}
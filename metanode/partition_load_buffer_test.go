@@ -0,0 +1,102 @@
+// Copyright 2018 The Chubao Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metanode
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// fakeDisk advances a fake clock by the time a disk of the given throughput takes for every read.
+type fakeDisk struct {
+	r     io.Reader
+	clock time.Time
+	rate  int64 // bytes per second
+}
+
+func (d *fakeDisk) Read(p []byte) (int, error) {
+	n, err := d.r.Read(p)
+	d.clock = d.clock.Add(time.Duration(int64(n) * int64(time.Second) / d.rate))
+	return n, err
+}
+
+func newFakeDiskReader(r io.Reader, rate int64) *adaptiveReader {
+	disk := &fakeDisk{r: r, rate: rate}
+	reader := newAdaptiveReader(disk)
+	reader.now = func() time.Time { return disk.clock }
+	return reader
+}
+
+// zeroReader never ends.
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+func TestAdaptiveReaderSize(t *testing.T) {
+	for _, c := range []struct {
+		rate int64
+		size int
+	}{
+		{rate: 10 * MB, size: loadBufferMinSize}, // 100KB per fill time
+		{rate: 250 * MB, size: 2*MB + 512*KB},    // 2.5MB per fill time
+		{rate: 10 * GB, size: loadBufferMaxSize}, // 100MB per fill time
+	} {
+		reader := newFakeDiskReader(zeroReader{}, c.rate)
+		if len(reader.buf) != loadBufferMinSize {
+			t.Fatalf("first buffer size: rate(%v) size(%v)", c.rate, len(reader.buf))
+		}
+		for i := 0; i < loadBufferProbeFills+2; i++ {
+			reader.fill()
+		}
+		if size := len(reader.buf); size != c.size {
+			t.Fatalf("buffer size: rate(%v) size(%v) expect(%v) throughput(%v)", c.rate, size, c.size, reader.throughput())
+		}
+	}
+}
+
+func TestAdaptiveReader(t *testing.T) {
+	data := make([]byte, 12*MB+123)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	reader := newFakeDiskReader(bytes.NewReader(data), 250*MB)
+	// read in the small pieces of the decode loops
+	var out bytes.Buffer
+	buf := make([]byte, 4+rand.Intn(100))
+	for {
+		n, err := io.ReadFull(reader, buf)
+		out.Write(buf[:n])
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !bytes.Equal(out.Bytes(), data) {
+		t.Fatalf("data mismatch: len(%v)", out.Len())
+	}
+	if size := len(reader.buf); size != 2*MB+512*KB {
+		t.Fatalf("buffer size: size(%v) throughput(%v)", size, reader.throughput())
+	}
+}
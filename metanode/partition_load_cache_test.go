@@ -0,0 +1,46 @@
+// Copyright 2018 The Chubao Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metanode
+
+import (
+	"io/ioutil"
+	"os"
+	"runtime"
+	"testing"
+)
+
+func TestMeasureSnapshotCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metanode_load_cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mp := newFixturePartition(dir)
+	if err = mp.storeToDir(dir, capturedStoreMsg(mp)); err != nil {
+		t.Fatal(err)
+	}
+	// the files just written are in the page cache
+	if state := measureSnapshotCache(dir); runtime.GOOS == "linux" && (!state.measured || state.label() != loadCacheWarm) {
+		t.Fatalf("state of a snapshot just stored: %+v", state)
+	}
+	for _, c := range []struct {
+		cached int64
+		label  string
+	}{{1000, loadCacheWarm}, {900, loadCacheWarm}, {500, loadCacheMixed}, {100, loadCacheCold}, {0, loadCacheCold}} {
+		if label := (snapshotCacheState{measured: true, size: 1000, cached: c.cached}).label(); label != c.label {
+			t.Fatalf("cached(%v): label(%v) expect(%v)", c.cached, label, c.label)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright 2018 The Chubao Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metanode
+
+import (
+	"os"
+	"path"
+	"time"
+
+	"github.com/chubaofs/chubaofs/util/errors"
+)
+
+// fileStamp is the size and the modification time of a snapshot file, or exists false if it is missing.
+type fileStamp struct {
+	exists  bool
+	size    int64
+	modTime time.Time
+}
+
+// stampSnapshotFiles records the stamps of the given files of dir before they are loaded.
+func stampSnapshotFiles(dir string, names []string) map[string]fileStamp {
+	stamps := make(map[string]fileStamp, len(names))
+	for _, name := range names {
+		if info, err := os.Stat(path.Join(dir, name)); err == nil {
+			stamps[name] = fileStamp{exists: true, size: info.Size(), modTime: info.ModTime()}
+		} else {
+			stamps[name] = fileStamp{}
+		}
+	}
+	return stamps
+}
+
+// checkSnapshotStamps fails if a file of dir was changed, created or removed since its stamp was recorded,
+// e.g. by a copy or a tool writing into the snapshot directory while it was loaded, as the loaded records
+// could then come from two versions of the file.
+func checkSnapshotStamps(dir string, stamps map[string]fileStamp) error {
+	names := make([]string, 0, len(stamps))
+	for name := range stamps {
+		names = append(names, name)
+	}
+	for name, actual := range stampSnapshotFiles(dir, names) {
+		expect := stamps[name]
+		if actual.exists == expect.exists && actual.size == expect.size && actual.modTime.Equal(expect.modTime) {
+			continue
+		}
+		return errors.NewErrorf("[checkSnapshotStamps] snapshot file changed during load: file(%v) exists(%v -> %v) "+
+			"size(%v -> %v) modTime(%v -> %v)", path.Join(dir, name), expect.exists, actual.exists, expect.size,
+			actual.size, expect.modTime, actual.modTime)
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+// Copyright 2018 The Chubao Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metanode
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadDetectsChangedFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metanode_load_guard")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mp := newFixturePartition(dir)
+	if err = mp.storeToDir(dir, capturedStoreMsg(mp)); err != nil {
+		t.Fatal(err)
+	}
+	stamps := stampSnapshotFiles(dir, snapshotStreamFiles)
+	if err = checkSnapshotStamps(dir, stamps); err != nil {
+		t.Fatal(err)
+	}
+	// the extends are loaded after the files are touched by a copy keeping their size
+	conf := &MetaPartitionConfig{PartitionId: 1, RootDir: dir, Start: 1, End: 1000}
+	conf.Snapshot.LazyLoad = true
+	loaded := NewMetaPartition(conf, nil).(*metaPartition)
+	if err = loaded.LoadSnapshot(dir); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Hour)
+	if err = os.Chtimes(path.Join(dir, extendFile), later, later); err != nil {
+		t.Fatal(err)
+	}
+	if err = loaded.loadDeferred("test"); err == nil || !strings.Contains(err.Error(), "changed during load") {
+		t.Fatalf("changed extend file loaded: %v", err)
+	}
+	if err = checkSnapshotStamps(dir, stamps); err == nil {
+		t.Fatalf("changed extend file not detected")
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright 2018 The Chubao Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metanode
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/chubaofs/chubaofs/util/errors"
+	"github.com/chubaofs/chubaofs/util/log"
+)
+
+// storedApplyIDFile records in the partition directory the applyID of the last snapshot stored by the
+// partition. It is outside the snapshot directory, so it is kept when the snapshot is replaced by hand.
+const storedApplyIDFile = "stored_apply"
+
+func (c SnapshotConfig) checkApplyIDRegression() error {
+	switch c.ApplyIDRegression {
+	case "", ApplyIDCheckWarn, ApplyIDCheckRefuse:
+		return nil
+	default:
+		return fmt.Errorf("unknown applyID regression mode: %v", c.ApplyIDRegression)
+	}
+}
+
+// recordStoredApplyID records the applyID of a published snapshot. A failure only weakens the next check.
+func (mp *metaPartition) recordStoredApplyID(applyID uint64) {
+	filename := path.Join(mp.config.RootDir, storedApplyIDFile)
+	if err := ioutil.WriteFile(filename, []byte(strconv.FormatUint(applyID, 10)), 0644); err != nil {
+		log.LogWarnf("recordStoredApplyID: write failed: partitionID(%v) volume(%v) applyID(%v) err(%v)",
+			mp.config.PartitionId, mp.config.VolName, applyID, err)
+	}
+}
+
+// snapshotApplyID returns the applyID of the snapshot in dir, from its manifest, or from its apply file.
+func snapshotApplyID(dir string) (applyID uint64, err error) {
+	manifest, err := readSnapshotManifest(dir)
+	if err != nil {
+		return
+	}
+	if manifest != nil {
+		return manifest.ApplyID, nil
+	}
+	data, err := ioutil.ReadFile(path.Join(dir, applyIDFile))
+	if err != nil {
+		return
+	}
+	_, err = fmt.Sscanf(strings.SplitN(string(data), "|", 2)[0], "%d", &applyID)
+	return
+}
+
+// checkApplyIDRegression compares the applyID of the snapshot in dir with the applyID of the last snapshot
+// stored by the partition, before it is loaded, as configured by SnapshotConfig.ApplyIDRegression: an older
+// snapshot, e.g. a backup restored by mistake over a live partition, would roll its state back.
+// ApplyIDCheckWarn logs it, ApplyIDCheckRefuse fails the load, which does not fall back to the backup
+// snapshot then. The check is skipped when the partition never recorded a store.
+func (mp *metaPartition) checkApplyIDRegression(dir string) (err error) {
+	mode := mp.config.Snapshot.ApplyIDRegression
+	if mode == "" {
+		return
+	}
+	data, err := ioutil.ReadFile(path.Join(mp.config.RootDir, storedApplyIDFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.NewErrorf("[checkApplyIDRegression] read stored applyID: %s", err.Error())
+	}
+	stored, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		log.LogWarnf("checkApplyIDRegression: unreadable stored applyID, skip: partitionID(%v) volume(%v) err(%v)",
+			mp.config.PartitionId, mp.config.VolName, err)
+		return nil
+	}
+	applyID, err := snapshotApplyID(dir)
+	if err != nil {
+		// the load itself reports the snapshot which can not be read
+		return nil
+	}
+	if applyID >= stored {
+		log.LogInfof("checkApplyIDRegression: snapshot not older than the last store: partitionID(%v) volume(%v) "+
+			"applyID(%v) stored applyID(%v)", mp.config.PartitionId, mp.config.VolName, applyID, stored)
+		return nil
+	}
+	if mode == ApplyIDCheckRefuse {
+		return errors.NewErrorf("[checkApplyIDRegression] snapshot older than the last store: partitionID(%v) "+
+			"dir(%v) applyID(%v) stored applyID(%v)", mp.config.PartitionId, dir, applyID, stored)
+	}
+	log.LogWarnf("checkApplyIDRegression: snapshot older than the last store, the state rolls back: partitionID(%v) "+
+		"volume(%v) dir(%v) applyID(%v) stored applyID(%v)", mp.config.PartitionId, mp.config.VolName, dir, applyID, stored)
+	return nil
+}
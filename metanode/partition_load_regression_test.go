@@ -0,0 +1,58 @@
+// Copyright 2018 The Chubao Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metanode
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestApplyIDRegression(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metanode_apply_regression")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mp := newFixturePartition(dir)
+	mp.config.Snapshot.ApplyIDRegression = ApplyIDCheckRefuse
+	if err = mp.store(capturedStoreMsg(mp)); err != nil {
+		t.Fatal(err)
+	}
+	snapshotPath := path.Join(dir, mp.config.Snapshot.dirName())
+	if err = mp.checkApplyIDRegression(snapshotPath); err != nil {
+		t.Fatalf("snapshot at the stored applyID refused: %v", err)
+	}
+	// the partition stored a later snapshot than the one on disk
+	watermark := path.Join(dir, storedApplyIDFile)
+	if err = ioutil.WriteFile(watermark, []byte(fmt.Sprintf("%d", mp.applyID+10)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = mp.checkApplyIDRegression(snapshotPath); err == nil {
+		t.Fatal("older snapshot loaded")
+	}
+	mp.config.Snapshot.ApplyIDRegression = ApplyIDCheckWarn
+	if err = mp.checkApplyIDRegression(snapshotPath); err != nil {
+		t.Fatalf("older snapshot refused in warn mode: %v", err)
+	}
+	// removing the watermark forces the load
+	mp.config.Snapshot.ApplyIDRegression = ApplyIDCheckRefuse
+	os.Remove(watermark)
+	if err = mp.checkApplyIDRegression(snapshotPath); err != nil {
+		t.Fatalf("snapshot refused without a watermark: %v", err)
+	}
+}
@@ -0,0 +1,157 @@
+// Copyright 2018 The Chubao Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metanode
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/chubaofs/chubaofs/util/errors"
+	"github.com/chubaofs/chubaofs/util/log"
+)
+
+// LoadSink receives the records of the snapshots as they are loaded, e.g. to maintain an external index of
+// the metadata without reading the snapshots a second time, see MetaNode.SetLoadSink. It is called from
+// a goroutine of its own per load, so it never runs on the load itself: a sink which falls behind by more
+// than SnapshotConfig.LoadSinkBuffer records for longer than SnapshotConfig.LoadSinkTimeout is dropped for
+// the rest of the load, and gets ErrLoadSinkDropped.
+//
+// The records are copies, which the sink may keep. Only the records loaded into the partition are sent.
+type LoadSink interface {
+	Inode(partitionID uint64, ino *Inode)
+	Dentry(partitionID uint64, dentry *Dentry)
+	Extend(partitionID uint64, extend *Extend)
+	// Done ends a load of the partition. A load which failed, or whose sink was dropped, is incomplete: a later
+	// load of the same partition, e.g. of the backup snapshot, sends every record again. With
+	// SnapshotConfig.LazyLoad, the extends are sent by a load of their own on first access.
+	Done(partitionID uint64, err error)
+}
+
+// ErrLoadSinkDropped is passed to LoadSink.Done when the sink was dropped for falling behind.
+var ErrLoadSinkDropped = errors.New("load sink dropped: too slow")
+
+// SetLoadSink sets the sink of the records of the loads. It must be called before Start.
+func (m *MetaNode) SetLoadSink(sink LoadSink) {
+	m.snapshotConfig.LoadSink = sink
+}
+
+// loadSinkRecord is one record sent to the sink, only one of the fields is set.
+type loadSinkRecord struct {
+	ino    *Inode
+	dentry *Dentry
+	extend *Extend
+}
+
+// loadSinkFeed sends the records of one load to the sink through a bounded buffer. Its methods do nothing
+// on a nil feed, which is the feed of a load without a sink.
+type loadSinkFeed struct {
+	sink        LoadSink
+	partitionID uint64
+	records     chan loadSinkRecord
+	timeout     time.Duration
+	dropped     uint32
+	err         error // of the load, set before records is closed
+	sent        uint64
+}
+
+// openLoadFeed starts feeding the sink of the partition, if any, for a load.
+func (mp *metaPartition) openLoadFeed() *loadSinkFeed {
+	conf := mp.config.Snapshot
+	if conf.LoadSink == nil {
+		return nil
+	}
+	f := &loadSinkFeed{sink: conf.LoadSink, partitionID: mp.config.PartitionId, timeout: conf.LoadSinkTimeout}
+	buffer := conf.LoadSinkBuffer
+	if buffer <= 0 {
+		buffer = defaultLoadSinkBuffer
+	}
+	if f.timeout <= 0 {
+		f.timeout = defaultLoadSinkTimeout
+	}
+	f.records = make(chan loadSinkRecord, buffer)
+	go f.run()
+	return f
+}
+
+func (f *loadSinkFeed) run() {
+	for r := range f.records {
+		if atomic.LoadUint32(&f.dropped) == 1 {
+			continue
+		}
+		switch {
+		case r.ino != nil:
+			f.sink.Inode(f.partitionID, r.ino)
+		case r.dentry != nil:
+			f.sink.Dentry(f.partitionID, r.dentry)
+		case r.extend != nil:
+			f.sink.Extend(f.partitionID, r.extend)
+		}
+	}
+	if atomic.LoadUint32(&f.dropped) == 1 {
+		f.sink.Done(f.partitionID, ErrLoadSinkDropped)
+		return
+	}
+	f.sink.Done(f.partitionID, f.err)
+}
+
+// send queues a record, and waits at most the timeout of the feed for room in the buffer.
+func (f *loadSinkFeed) send(r loadSinkRecord) {
+	if f == nil || atomic.LoadUint32(&f.dropped) == 1 {
+		return
+	}
+	select {
+	case f.records <- r:
+		f.sent++
+		return
+	default:
+	}
+	timer := time.NewTimer(f.timeout)
+	defer timer.Stop()
+	select {
+	case f.records <- r:
+		f.sent++
+	case <-timer.C:
+		atomic.StoreUint32(&f.dropped, 1)
+		log.LogWarnf("loadSinkFeed: sink dropped, no room in the buffer for %v: partitionID(%v) sent(%v) buffer(%v)",
+			f.timeout, f.partitionID, f.sent, cap(f.records))
+	}
+}
+
+func (f *loadSinkFeed) inode(ino *Inode) {
+	if f != nil {
+		f.send(loadSinkRecord{ino: ino.Copy().(*Inode)})
+	}
+}
+
+func (f *loadSinkFeed) dentry(dentry *Dentry) {
+	if f != nil {
+		f.send(loadSinkRecord{dentry: dentry.Copy().(*Dentry)})
+	}
+}
+
+func (f *loadSinkFeed) extend(extend *Extend) {
+	if f != nil {
+		f.send(loadSinkRecord{extend: extend.Copy().(*Extend)})
+	}
+}
+
+// close ends the load with its error. It does not wait for the sink, which drains the buffer on its own.
+func (f *loadSinkFeed) close(err error) {
+	if f == nil {
+		return
+	}
+	f.err = err
+	close(f.records)
+}
@@ -0,0 +1,82 @@
+// Copyright 2018 The Chubao Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metanode
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+// recordingSink counts the records of a load, and blocks on each of them while block is open.
+type recordingSink struct {
+	inodes, dentries, extends int
+	block                     chan struct{}
+	done                      chan error
+}
+
+func (s *recordingSink) wait() {
+	if s.block != nil {
+		<-s.block
+	}
+}
+
+func (s *recordingSink) Inode(partitionID uint64, ino *Inode) { s.wait(); s.inodes++ }
+
+func (s *recordingSink) Dentry(partitionID uint64, dentry *Dentry) { s.wait(); s.dentries++ }
+
+func (s *recordingSink) Extend(partitionID uint64, extend *Extend) { s.wait(); s.extends++ }
+
+func (s *recordingSink) Done(partitionID uint64, err error) { s.done <- err }
+
+func TestLoadSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metanode_load_sink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mp := newFixturePartition(dir)
+	if err = mp.storeToDir(dir, capturedStoreMsg(mp)); err != nil {
+		t.Fatal(err)
+	}
+	sink := &recordingSink{done: make(chan error, 1)}
+	loaded := NewMetaPartition(&MetaPartitionConfig{PartitionId: 1, Start: 1, End: 1000,
+		Snapshot: SnapshotConfig{LoadSink: sink}}, nil).(*metaPartition)
+	if err = loaded.LoadSnapshot(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err = <-sink.done; err != nil {
+		t.Fatalf("sink done with %v", err)
+	}
+	if sink.inodes != loaded.inodeTree.Len() || sink.dentries != loaded.dentryTree.Len() ||
+		sink.extends != loaded.extendTree.Len() {
+		t.Fatalf("sink got inodes(%v) dentries(%v) extends(%v)", sink.inodes, sink.dentries, sink.extends)
+	}
+	// a stuck sink is dropped, and the load goes on without it
+	stuck := &recordingSink{block: make(chan struct{}), done: make(chan error, 1)}
+	loaded = NewMetaPartition(&MetaPartitionConfig{PartitionId: 1, Start: 1, End: 1000,
+		Snapshot: SnapshotConfig{LoadSink: stuck, LoadSinkBuffer: 1, LoadSinkTimeout: 10 * time.Millisecond}}, nil).(*metaPartition)
+	if err = loaded.LoadSnapshot(dir); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.inodeTree.Len() != mp.inodeTree.Len() {
+		t.Fatalf("loaded %v inodes, want %v", loaded.inodeTree.Len(), mp.inodeTree.Len())
+	}
+	close(stuck.block)
+	if err = <-stuck.done; err != ErrLoadSinkDropped {
+		t.Fatalf("stuck sink done with %v", err)
+	}
+}
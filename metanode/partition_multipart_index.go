@@ -0,0 +1,170 @@
+// Copyright 2018 The Chubao Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metanode
+
+import (
+	"sync"
+
+	"github.com/chubaofs/chubaofs/util/log"
+)
+
+// multipartRef is the key of a multipart in the multipart tree.
+type multipartRef struct {
+	key string
+	id  string
+}
+
+// multipartInodeIndex maps the inode of each part to the multiparts holding it, so the multiparts of an
+// inode are found without scanning them all. It is built while the multiparts are loaded and kept up to
+// date by the fsm operations. It holds at most max references: past that it is dropped, and lookups
+// scan the multiparts until it is built again by the next load.
+type multipartInodeIndex struct {
+	sync.RWMutex
+	max      int
+	entries  int
+	disabled bool
+	refs     map[uint64][]multipartRef
+}
+
+func newMultipartInodeIndex(max int) *multipartInodeIndex {
+	return &multipartInodeIndex{max: max, disabled: max <= 0, refs: make(map[uint64][]multipartRef)}
+}
+
+func (x *multipartInodeIndex) add(m *Multipart, parts []*Part) {
+	if x == nil {
+		return
+	}
+	x.Lock()
+	defer x.Unlock()
+	if x.disabled {
+		return
+	}
+	ref := multipartRef{key: m.key, id: m.id}
+	for _, part := range parts {
+		refs := x.refs[part.Inode]
+		found := false
+		for _, r := range refs {
+			found = found || r == ref
+		}
+		if found {
+			continue
+		}
+		if x.entries >= x.max {
+			log.LogWarnf("multipartInodeIndex: index dropped, too many references: max(%v)", x.max)
+			x.disabled, x.entries, x.refs = true, 0, nil
+			return
+		}
+		x.refs[part.Inode] = append(refs, ref)
+		x.entries++
+	}
+}
+
+func (x *multipartInodeIndex) remove(m *Multipart) {
+	if x == nil {
+		return
+	}
+	x.Lock()
+	defer x.Unlock()
+	if x.disabled {
+		return
+	}
+	ref := multipartRef{key: m.key, id: m.id}
+	for _, part := range m.Parts() {
+		refs := x.refs[part.Inode]
+		for i, r := range refs {
+			if r == ref {
+				refs = append(refs[:i], refs[i+1:]...)
+				x.entries--
+				break
+			}
+		}
+		if len(refs) == 0 {
+			delete(x.refs, part.Inode)
+		} else {
+			x.refs[part.Inode] = refs
+		}
+	}
+}
+
+// lookup returns the references of the multiparts of the inode, and false if there is no index.
+func (x *multipartInodeIndex) lookup(inode uint64) (refs []multipartRef, ok bool) {
+	if x == nil {
+		return nil, false
+	}
+	x.RLock()
+	defer x.RUnlock()
+	if x.disabled {
+		return nil, false
+	}
+	return append([]multipartRef(nil), x.refs[inode]...), true
+}
+
+// resetMultipartIndex drops the index, before the multipart tree is loaded or replaced.
+func (mp *metaPartition) resetMultipartIndex() {
+	mp.multipartIndex = newMultipartInodeIndex(mp.config.Snapshot.MultipartIndexMax)
+}
+
+// rebuildMultipartIndex builds the index from the multipart tree, after it is replaced by a raft snapshot.
+func (mp *metaPartition) rebuildMultipartIndex() {
+	mp.resetMultipartIndex()
+	mp.multipartTree.Ascend(func(i BtreeItem) bool {
+		m := i.(*Multipart)
+		mp.multipartIndex.add(m, m.Parts())
+		return true
+	})
+}
+
+// MultipartsOfInode returns the multiparts having a part stored in the given inode, e.g. to clean up
+// the uploads abandoned with the inode. The multiparts are scanned if the index is dropped.
+func (mp *metaPartition) MultipartsOfInode(inode uint64) (multiparts []*Multipart, err error) {
+	if err = mp.loadDeferred("MultipartsOfInode"); err != nil {
+		return
+	}
+	if refs, ok := mp.multipartIndex.lookup(inode); ok {
+		for _, ref := range refs {
+			if item := mp.multipartTree.Get(&Multipart{key: ref.key, id: ref.id}); item != nil {
+				multiparts = append(multiparts, item.(*Multipart))
+			}
+		}
+		return
+	}
+	mp.multipartTree.Ascend(func(i BtreeItem) bool {
+		m := i.(*Multipart)
+		for _, part := range m.Parts() {
+			if part.Inode == inode {
+				multiparts = append(multiparts, m)
+				break
+			}
+		}
+		return true
+	})
+	return
+}
+
+// MultipartsOfPath returns the multiparts of the given object path, which are adjacent in the multipart tree.
+func (mp *metaPartition) MultipartsOfPath(key string) (multiparts []*Multipart, err error) {
+	if err = mp.loadDeferred("MultipartsOfPath"); err != nil {
+		return
+	}
+	mp.multipartTree.AscendGreaterOrEqual(&Multipart{key: key}, func(i BtreeItem) bool {
+		m := i.(*Multipart)
+		if m.key != key {
+			return false
+		}
+		multiparts = append(multiparts, m)
+		return true
+	})
+	return
+}
@@ -0,0 +1,66 @@
+// Copyright 2018 The Chubao Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metanode
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/chubaofs/chubaofs/proto"
+)
+
+func TestMultipartInodeIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metanode_multipart_index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mp := newFixturePartition(dir)
+	if err = mp.storeToDir(dir, capturedStoreMsg(mp)); err != nil {
+		t.Fatal(err)
+	}
+	for _, max := range []int{16, 1} {
+		conf := &MetaPartitionConfig{PartitionId: 1, RootDir: dir, Start: 1, End: 1000}
+		conf.Snapshot.MultipartIndexMax = max
+		loaded := NewMetaPartition(conf, nil).(*metaPartition)
+		if err = loaded.LoadSnapshot(dir); err != nil {
+			t.Fatal(err)
+		}
+		if refs, ok := loaded.multipartIndex.lookup(2); !ok || len(refs) != 1 {
+			t.Fatalf("max(%v): loaded index of inode 2: %v %v", max, refs, ok)
+		}
+		if status := loaded.fsmAppendMultipart(&Multipart{key: "object", id: "upload-id",
+			parts: Parts{{ID: 2, Inode: 5}}}); status != proto.OpOk {
+			t.Fatalf("append part: status(%v)", status)
+		}
+		// past the maximum the index is dropped and the multiparts are scanned
+		if _, ok := loaded.multipartIndex.lookup(5); ok != (max > 1) {
+			t.Fatalf("max(%v): index kept(%v)", max, ok)
+		}
+		for _, inode := range []uint64{2, 5} {
+			if found, err := loaded.MultipartsOfInode(inode); err != nil || len(found) != 1 || found[0].id != "upload-id" {
+				t.Fatalf("max(%v): multiparts of inode %v: %v %v", max, inode, found, err)
+			}
+		}
+		if found, err := loaded.MultipartsOfPath("object"); err != nil || len(found) != 1 {
+			t.Fatalf("max(%v): multiparts of path: %v %v", max, found, err)
+		}
+		loaded.fsmRemoveMultipart(&Multipart{key: "object", id: "upload-id"})
+		if found, err := loaded.MultipartsOfInode(5); err != nil || len(found) != 0 {
+			t.Fatalf("max(%v): multiparts of inode 5 after remove: %v %v", max, found, err)
+		}
+	}
+}
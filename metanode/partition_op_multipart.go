@@ -25,6 +25,10 @@ import (
 )
 
 func (mp *metaPartition) GetMultipart(req *proto.GetMultipartRequest, p *Packet) (err error) {
+	if err = mp.loadDeferred("GetMultipart"); err != nil {
+		p.PacketErrorWithBody(proto.OpErr, []byte(err.Error()))
+		return
+	}
 	item := mp.multipartTree.Get(&Multipart{key: req.Path, id: req.MultipartId})
 	if item == nil {
 		p.PacketErrorWithBody(proto.OpNotExistErr, nil)
@@ -59,6 +63,10 @@ func (mp *metaPartition) GetMultipart(req *proto.GetMultipartRequest, p *Packet)
 }
 
 func (mp *metaPartition) AppendMultipart(req *proto.AddMultipartPartRequest, p *Packet) (err error) {
+	if err = mp.loadDeferred("AppendMultipart"); err != nil {
+		p.PacketErrorWithBody(proto.OpErr, []byte(err.Error()))
+		return
+	}
 	if req.Part == nil {
 		p.PacketOkReply()
 		return
@@ -96,6 +104,10 @@ func (mp *metaPartition) AppendMultipart(req *proto.AddMultipartPartRequest, p *
 }
 
 func (mp *metaPartition) RemoveMultipart(req *proto.RemoveMultipartRequest, p *Packet) (err error) {
+	if err = mp.loadDeferred("RemoveMultipart"); err != nil {
+		p.PacketErrorWithBody(proto.OpErr, []byte(err.Error()))
+		return
+	}
 	multipart := &Multipart{
 		id:  req.MultipartId,
 		key: req.Path,
@@ -115,6 +127,10 @@ func (mp *metaPartition) RemoveMultipart(req *proto.RemoveMultipartRequest, p *P
 }
 
 func (mp *metaPartition) CreateMultipart(req *proto.CreateMultipartRequest, p *Packet) (err error) {
+	if err = mp.loadDeferred("CreateMultipart"); err != nil {
+		p.PacketErrorWithBody(proto.OpErr, []byte(err.Error()))
+		return
+	}
 	var (
 		multipartId string
 	)
@@ -153,6 +169,10 @@ func (mp *metaPartition) CreateMultipart(req *proto.CreateMultipartRequest, p *P
 }
 
 func (mp *metaPartition) ListMultipart(req *proto.ListMultipartRequest, p *Packet) (err error) {
+	if err = mp.loadDeferred("ListMultipart"); err != nil {
+		p.PacketErrorWithBody(proto.OpErr, []byte(err.Error()))
+		return
+	}
 
 	max := int(req.Max)
 	keyMarker := req.Marker
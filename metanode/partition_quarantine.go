@@ -0,0 +1,135 @@
+// Copyright 2018 The Chubao Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metanode
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/chubaofs/chubaofs/util/errors"
+	"github.com/chubaofs/chubaofs/util/log"
+)
+
+// quarantineReportFile is written into each quarantined snapshot, next to the copied files.
+const quarantineReportFile = "quarantine.json"
+
+// quarantineMu serializes the quarantines of the partitions loaded concurrently, as they share the
+// quarantine directory and its retention.
+var quarantineMu sync.Mutex
+
+// QuarantineReport tells why a snapshot was quarantined: the error of its load, and the check of each
+// of its data files, which tells the file and the offset of the corruption.
+type QuarantineReport struct {
+	PartitionID uint64                `json:"partition_id"`
+	VolName     string                `json:"vol_name"`
+	Time        time.Time             `json:"time"`
+	Dir         string                `json:"dir"`
+	LoadError   string                `json:"load_error"`
+	Check       *SnapshotVerifyReport `json:"check"`
+}
+
+// quarantineSnapshot copies the snapshot files of dir, which failed to load with loadErr, into a new
+// directory of the quarantine directory named after the partition and the time, with a report of the
+// failure, so the corruption can be looked into after the snapshot is replaced. The oldest quarantined
+// snapshots are then removed while the quarantine directory is larger than SnapshotConfig.QuarantineMaxSize.
+// It does nothing if no quarantine directory is configured.
+func (mp *metaPartition) quarantineSnapshot(dir string, loadErr error) (dst string, err error) {
+	conf := mp.config.Snapshot
+	if conf.QuarantineDir == "" {
+		return "", nil
+	}
+	quarantineMu.Lock()
+	defer quarantineMu.Unlock()
+	now := time.Now()
+	dst = path.Join(conf.QuarantineDir, fmt.Sprintf("partition_%v_%v", mp.config.PartitionId,
+		now.Format("20060102150405.000000")))
+	if err = os.MkdirAll(dst, 0755); err != nil {
+		return "", errors.NewErrorf("[quarantineSnapshot] create %v: %s", dst, err.Error())
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		os.RemoveAll(dst)
+		return "", errors.NewErrorf("[quarantineSnapshot] read %v: %s", dir, err.Error())
+	}
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		if err = copySnapshotFile(path.Join(dir, file.Name()), path.Join(dst, file.Name())); err != nil {
+			os.RemoveAll(dst)
+			return "", errors.NewErrorf("[quarantineSnapshot] copy %v: %s", file.Name(), err.Error())
+		}
+	}
+	report := &QuarantineReport{
+		PartitionID: mp.config.PartitionId,
+		VolName:     mp.config.VolName,
+		Time:        now,
+		Dir:         dir,
+		LoadError:   loadErr.Error(),
+		Check:       CheckSnapshot(dir),
+	}
+	data, _ := json.MarshalIndent(report, "", "  ")
+	if err = ioutil.WriteFile(path.Join(dst, quarantineReportFile), data, 0644); err != nil {
+		os.RemoveAll(dst)
+		return "", errors.NewErrorf("[quarantineSnapshot] write report: %s", err.Error())
+	}
+	log.LogWarnf("quarantineSnapshot: corrupted snapshot quarantined: partitionID(%v) volume(%v) dir(%v) dst(%v) "+
+		"loadErr(%v)", mp.config.PartitionId, mp.config.VolName, dir, dst, loadErr)
+	pruneQuarantine(conf.QuarantineDir, conf.QuarantineMaxSize, dst)
+	return dst, nil
+}
+
+// pruneQuarantine removes the oldest quarantined snapshots until the quarantine directory holds at most
+// maxSize bytes, or only keep, the snapshot just quarantined. Zero maxSize keeps everything.
+func pruneQuarantine(dir string, maxSize uint64, keep string) {
+	if maxSize == 0 {
+		return
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].ModTime().Before(entries[j].ModTime()) })
+	sizes := make([]uint64, len(entries))
+	var total uint64
+	for i, entry := range entries {
+		sizes[i] = partitionDataSize(path.Join(dir, entry.Name()))
+		total += sizes[i]
+	}
+	for i, entry := range entries {
+		if total <= maxSize {
+			return
+		}
+		name := path.Join(dir, entry.Name())
+		if name == keep {
+			continue
+		}
+		if err = os.RemoveAll(name); err != nil {
+			log.LogWarnf("pruneQuarantine: remove failed: dir(%v) err(%v)", name, err)
+			continue
+		}
+		total -= sizes[i]
+		log.LogInfof("pruneQuarantine: quarantined snapshot removed: dir(%v) size(%v)", name, sizes[i])
+	}
+	if total > maxSize {
+		log.LogWarnf("pruneQuarantine: quarantine over its maximum size: dir(%v) size(%v) max(%v)", dir, total, maxSize)
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright 2018 The Chubao Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metanode
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/chubaofs/chubaofs/proto"
+)
+
+func TestQuarantineSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metanode_quarantine")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mp := newFixturePartition(dir)
+	mp.config.Peers = []proto.Peer{{ID: 1, Addr: "127.0.0.1:17210"}}
+	if err = mp.persistMetadata(); err != nil {
+		t.Fatal(err)
+	}
+	snapshotPath := path.Join(dir, snapshotDir)
+	quarantine := path.Join(dir, "quarantine")
+	for round := 1; round <= 2; round++ {
+		for _, d := range []string{snapshotPath, path.Join(dir, snapshotBackup)} {
+			os.RemoveAll(d)
+			if err = os.MkdirAll(d, 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err = mp.storeToDir(d, capturedStoreMsg(mp)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err = os.Truncate(path.Join(snapshotPath, inodeFile), 10); err != nil {
+			t.Fatal(err)
+		}
+		conf := &MetaPartitionConfig{PartitionId: 1, RootDir: dir}
+		conf.Snapshot.QuarantineDir = quarantine
+		conf.Snapshot.QuarantineMaxSize = 1 // only the last quarantined snapshot is kept
+		loaded := NewMetaPartition(conf, nil).(*metaPartition)
+		if err = loaded.load(); err != nil {
+			t.Fatal(err)
+		}
+		if loaded.inodeTree.Len() != 4 {
+			t.Fatalf("round %v: inodes loaded from the backup: %v", round, loaded.inodeTree.Len())
+		}
+		entries, err := ioutil.ReadDir(quarantine)
+		if err != nil || len(entries) != 1 {
+			t.Fatalf("round %v: quarantined snapshots: %v %v", round, len(entries), err)
+		}
+		entry := path.Join(quarantine, entries[0].Name())
+		if info, err := os.Stat(path.Join(entry, inodeFile)); err != nil || info.Size() != 10 {
+			t.Fatalf("round %v: quarantined inode file: %v", round, err)
+		}
+		data, err := ioutil.ReadFile(path.Join(entry, quarantineReportFile))
+		if err != nil {
+			t.Fatal(err)
+		}
+		report := &QuarantineReport{}
+		if err = json.Unmarshal(data, report); err != nil {
+			t.Fatal(err)
+		}
+		if report.LoadError == "" || report.Check == nil || report.Check.OK {
+			t.Fatalf("round %v: quarantine report: %s", round, data)
+		}
+	}
+}
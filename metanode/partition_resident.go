@@ -0,0 +1,119 @@
+// Copyright 2018 The Chubao Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metanode
+
+import (
+	"strconv"
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/chubaofs/chubaofs/proto"
+	"github.com/chubaofs/chubaofs/util/exporter"
+)
+
+const MetricPartitionResidentBytes = "partition_resident_bytes"
+
+// approximate memory overheads which are not visible from the structures themselves
+const (
+	residentItemOverhead  = 16 // slot and pointer of an item in a btree node
+	residentEntryOverhead = 48 // entry of a map, including its share of the buckets
+)
+
+var (
+	inodeResidentBase     = uint64(unsafe.Sizeof(Inode{}) + unsafe.Sizeof(SortedExtents{}))
+	extentKeyResidentSize = uint64(unsafe.Sizeof(proto.ExtentKey{}))
+	dentryResidentBase    = uint64(unsafe.Sizeof(Dentry{}))
+	extendResidentBase    = uint64(unsafe.Sizeof(Extend{}))
+	multipartResidentBase = uint64(unsafe.Sizeof(Multipart{}))
+	partResidentBase      = uint64(unsafe.Sizeof(Part{}) + unsafe.Sizeof(&Part{}))
+)
+
+func inodeResidentBytes(ino *Inode) uint64 {
+	ino.RLock()
+	defer ino.RUnlock()
+	size := residentItemOverhead + inodeResidentBase + uint64(cap(ino.LinkTarget))
+	if ino.Extents != nil {
+		ino.Extents.RLock()
+		size += uint64(cap(ino.Extents.eks)) * extentKeyResidentSize
+		ino.Extents.RUnlock()
+	}
+	return size
+}
+
+func dentryResidentBytes(d *Dentry) uint64 {
+	return residentItemOverhead + dentryResidentBase + uint64(len(d.Name))
+}
+
+func extendResidentBytes(e *Extend) uint64 {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	size := residentItemOverhead + extendResidentBase
+	for key, value := range e.dataMap {
+		size += residentEntryOverhead + uint64(len(key)+cap(value))
+	}
+	return size
+}
+
+func multipartResidentBytes(m *Multipart) uint64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	size := residentItemOverhead + multipartResidentBase + uint64(len(m.id)+len(m.key))
+	for _, part := range m.parts {
+		size += partResidentBase + uint64(len(part.MD5))
+	}
+	for key, value := range m.extend {
+		size += residentEntryOverhead + uint64(len(key)+len(value))
+	}
+	return size
+}
+
+// EstimateResidentBytes returns the approximate memory taken by the inodes, dentries, extends and multiparts
+// of the meta partition. It is accumulated while the snapshot is loaded and computed again after each store,
+// so the mutations applied since the last store are not counted.
+func (mp *metaPartition) EstimateResidentBytes() uint64 {
+	return atomic.LoadUint64(&mp.residentBytes)
+}
+
+func (mp *metaPartition) addResidentBytes(n uint64) {
+	atomic.AddUint64(&mp.residentBytes, n)
+}
+
+// refreshResidentBytes computes the resident bytes from the trees captured for a store.
+func (mp *metaPartition) refreshResidentBytes(sm *storeMsg) {
+	var size uint64
+	sm.inodeTree.Ascend(func(i BtreeItem) bool {
+		size += inodeResidentBytes(i.(*Inode))
+		return true
+	})
+	sm.dentryTree.Ascend(func(i BtreeItem) bool {
+		size += dentryResidentBytes(i.(*Dentry))
+		return true
+	})
+	sm.extendTree.Ascend(func(i BtreeItem) bool {
+		size += extendResidentBytes(i.(*Extend))
+		return true
+	})
+	sm.multipartTree.Ascend(func(i BtreeItem) bool {
+		size += multipartResidentBytes(i.(*Multipart))
+		return true
+	})
+	atomic.StoreUint64(&mp.residentBytes, size)
+	mp.reportResidentBytes()
+}
+
+func (mp *metaPartition) reportResidentBytes() {
+	exporter.NewGauge(MetricPartitionResidentBytes).SetWithLabels(float64(mp.EstimateResidentBytes()),
+		map[string]string{"partid": strconv.FormatUint(mp.config.PartitionId, 10), "vol": mp.config.VolName})
+}
@@ -0,0 +1,47 @@
+// Copyright 2018 The Chubao Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metanode
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// TestEstimateResidentBytes expects the bytes accumulated while loading to match those computed
+// from the loaded trees by a store.
+func TestEstimateResidentBytes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metanode_resident")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mp := newFixturePartition(dir)
+	if err = mp.storeToDir(dir, capturedStoreMsg(mp)); err != nil {
+		t.Fatal(err)
+	}
+	loaded := NewMetaPartition(&MetaPartitionConfig{PartitionId: 1, VolName: "fixture", Start: 1, End: 1000}, nil).(*metaPartition)
+	if err = loaded.LoadSnapshot(dir); err != nil {
+		t.Fatal(err)
+	}
+	accumulated := loaded.EstimateResidentBytes()
+	if accumulated == 0 {
+		t.Fatal("no resident bytes accumulated")
+	}
+	loaded.refreshResidentBytes(capturedStoreMsg(loaded))
+	if computed := loaded.EstimateResidentBytes(); computed != accumulated {
+		t.Fatalf("resident bytes: accumulated(%v) computed(%v)", accumulated, computed)
+	}
+}
@@ -0,0 +1,168 @@
+// Copyright 2018 The Chubao Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metanode
+
+import (
+	"io"
+	"os"
+	"path"
+	"strconv"
+
+	"github.com/chubaofs/chubaofs/util/errors"
+	"github.com/chubaofs/chubaofs/util/log"
+)
+
+// RestoreFromSnapshot brings up a new meta partition of the node from the snapshot files found in rootDir, such
+// as the files of a downloaded backup, without going through raft. The snapshot must have a manifest. It is
+// verified against its sign file and decoded record by record, then copied into the directory of the partition
+// next to a new meta file. The partition is then started and registered like a partition created by the master:
+// it loads the restored snapshot and raft resumes from its applyID. config gives the ID, volume, range and peers
+// of the partition, the manager sets the rest. The partition must not exist on the node yet, and its directory
+// is removed if the restore fails. The ID is reserved while the snapshot is copied, verified and loaded, without
+// holding the lock of the manager, so the other partitions are served meanwhile.
+func (m *metadataManager) RestoreFromSnapshot(config *MetaPartitionConfig, rootDir string) (partition MetaPartition, err error) {
+	m.mu.Lock()
+	if _, ok := m.partitions[config.PartitionId]; ok {
+		m.mu.Unlock()
+		err = errors.NewErrorf("[RestoreFromSnapshot] partition already exists: partitionID(%v)", config.PartitionId)
+		return
+	}
+	if _, ok := m.restoring[config.PartitionId]; ok {
+		m.mu.Unlock()
+		err = errors.NewErrorf("[RestoreFromSnapshot] partition being restored: partitionID(%v)", config.PartitionId)
+		return
+	}
+	m.restoring[config.PartitionId] = struct{}{}
+	m.mu.Unlock()
+	defer func() {
+		m.mu.Lock()
+		delete(m.restoring, config.PartitionId)
+		if err == nil {
+			m.partitions[config.PartitionId] = partition
+		}
+		m.mu.Unlock()
+	}()
+	mpc := &MetaPartitionConfig{
+		PartitionId: config.PartitionId,
+		VolName:     config.VolName,
+		Start:       config.Start,
+		End:         config.End,
+		Peers:       config.Peers,
+		RaftStore:   m.raftStore,
+		NodeId:      m.nodeId,
+		RootDir:     path.Join(m.rootDir, partitionPrefix+strconv.FormatUint(config.PartitionId, 10)),
+		ConnPool:    m.connPool,
+		Snapshot:    m.snapshotConfig,
+	}
+	mpc.AfterStop = func() {
+		m.detachPartition(mpc.PartitionId)
+	}
+	if err = restoreSnapshotFiles(mpc, rootDir); err != nil {
+		return
+	}
+	partition = NewMetaPartition(mpc, m)
+	if err = partition.Start(); err != nil {
+		os.RemoveAll(mpc.RootDir)
+		err = errors.NewErrorf("[RestoreFromSnapshot] %s", err.Error())
+		return nil, err
+	}
+	log.LogInfof("RestoreFromSnapshot: partition restored and started: partitionID(%v) volume(%v) dir(%v)",
+		mpc.PartitionId, mpc.VolName, mpc.RootDir)
+	return
+}
+
+// restoreSnapshotFiles verifies the snapshot in rootDir and writes it into the snapshot directory under
+// config.RootDir next to a new meta file, which must not exist yet. Both are removed if it fails, and so is
+// config.RootDir if it is left empty.
+func restoreSnapshotFiles(config *MetaPartitionConfig, rootDir string) (err error) {
+	if err = config.checkMeta(); err != nil {
+		err = errors.NewErrorf("[RestoreFromSnapshot] %s", err.Error())
+		return
+	}
+	if _, err = os.Stat(path.Join(config.RootDir, metadataFile)); err == nil {
+		err = errors.NewErrorf("[RestoreFromSnapshot] partition already exists: %v", config.RootDir)
+		return
+	}
+	manifest, err := readSnapshotManifest(rootDir)
+	if err != nil {
+		err = errors.NewErrorf("[RestoreFromSnapshot] %s", err.Error())
+		return
+	}
+	if manifest == nil {
+		err = errors.NewErrorf("[RestoreFromSnapshot] no manifest in snapshot: %v", rootDir)
+		return
+	}
+	if _, err = checkSnapshotSign(rootDir); err != nil {
+		err = errors.NewErrorf("[RestoreFromSnapshot] %s", err.Error())
+		return
+	}
+	if err = VerifySnapshot(rootDir); err != nil {
+		err = errors.NewErrorf("[RestoreFromSnapshot] %s", err.Error())
+		return
+	}
+
+	snapshotPath := path.Join(config.RootDir, config.Snapshot.dirName())
+	if err = os.MkdirAll(snapshotPath, 0755); err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(path.Join(config.RootDir, metadataFile))
+			os.RemoveAll(snapshotPath)
+			os.Remove(config.RootDir)
+		}
+	}()
+	for _, name := range snapshotStreamFiles {
+		if err = copySnapshotFile(path.Join(rootDir, name), path.Join(snapshotPath, name)); os.IsNotExist(err) {
+			err = nil
+		} else if err != nil {
+			err = errors.NewErrorf("[RestoreFromSnapshot] copy %v: %s", name, err.Error())
+			return
+		}
+	}
+
+	config.Cursor = config.Start
+	mp := NewMetaPartition(config, nil).(*metaPartition)
+	if err = mp.persistMetadata(); err != nil {
+		err = errors.NewErrorf("[RestoreFromSnapshot] %s", err.Error())
+		return
+	}
+	log.LogInfof("RestoreFromSnapshot: snapshot restored: partitionID(%v) volume(%v) dir(%v) applyID(%v)",
+		config.PartitionId, config.VolName, snapshotPath, manifest.ApplyID)
+	return
+}
+
+// copySnapshotFile copies src into dst, which must not exist, and syncs it.
+func copySnapshotFile(src, dst string) (err error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0755)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	if _, err = io.Copy(out, in); err != nil {
+		return
+	}
+	err = out.Sync()
+	return
+}
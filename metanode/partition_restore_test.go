@@ -0,0 +1,84 @@
+// Copyright 2018 The Chubao Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metanode
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/chubaofs/chubaofs/proto"
+)
+
+func TestRestoreFromSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metanode_restore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mp := newFixturePartition(path.Join(dir, "src"))
+	if err = mp.store(capturedStoreMsg(mp)); err != nil {
+		t.Fatal(err)
+	}
+	snapshotPath := path.Join(dir, "src", snapshotDir)
+	newConfig := func(name string) *MetaPartitionConfig {
+		return &MetaPartitionConfig{PartitionId: 1, VolName: "fixture", Start: 1, End: 1000,
+			Peers: []proto.Peer{{ID: 1, Addr: "127.0.0.1:17210"}}, RootDir: path.Join(dir, name)}
+	}
+	if err = restoreSnapshotFiles(newConfig("dst"), snapshotPath); err != nil {
+		t.Fatal(err)
+	}
+	// what the partition started by the manager loads
+	restored := NewMetaPartition(&MetaPartitionConfig{RootDir: path.Join(dir, "dst")}, nil).(*metaPartition)
+	if err = restored.load(); err != nil {
+		t.Fatal(err)
+	}
+	if restored.applyID != mp.applyID || restored.inodeTree.Len() != mp.inodeTree.Len() ||
+		restored.dentryTree.Len() != mp.dentryTree.Len() {
+		t.Fatalf("restored applyID(%v) inodes(%v) dentries(%v)", restored.applyID,
+			restored.inodeTree.Len(), restored.dentryTree.Len())
+	}
+	if err = restoreSnapshotFiles(newConfig("dst"), snapshotPath); err == nil {
+		t.Fatal("existing partition overwritten")
+	}
+	m := &metadataManager{rootDir: dir, partitions: map[uint64]MetaPartition{1: restored},
+		restoring: map[uint64]struct{}{2: {}}}
+	if _, err = m.RestoreFromSnapshot(newConfig("dst"), snapshotPath); err == nil {
+		t.Fatal("registered partition restored again")
+	}
+	config := newConfig("dst")
+	config.PartitionId = 2
+	if _, err = m.RestoreFromSnapshot(config, snapshotPath); err == nil || !strings.Contains(err.Error(), "being restored") {
+		t.Fatalf("partition restored twice at once: %v", err)
+	}
+
+	filename := path.Join(snapshotPath, dentryFile)
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-1] ^= 0xff
+	if err = ioutil.WriteFile(filename, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = restoreSnapshotFiles(newConfig("corrupt"), snapshotPath); err == nil {
+		t.Fatal("corrupted snapshot restored")
+	}
+	if _, err = os.Stat(path.Join(dir, "corrupt")); !os.IsNotExist(err) {
+		t.Fatalf("partition directory left after a failed restore: %v", err)
+	}
+}
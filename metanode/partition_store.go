@@ -16,6 +16,7 @@ package metanode
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/binary"
 	"encoding/json"
 	"fmt"
@@ -24,14 +25,15 @@ import (
 	"io/ioutil"
 	"os"
 	"path"
+	"strconv"
 	"strings"
 	"sync/atomic"
+	"syscall"
 
 	"github.com/chubaofs/chubaofs/util/log"
 
 	"github.com/chubaofs/chubaofs/proto"
 	"github.com/chubaofs/chubaofs/util/errors"
-	mmap "github.com/edsrzf/mmap-go"
 )
 
 const (
@@ -42,12 +44,100 @@ const (
 	dentryFile      = "dentry"
 	extendFile      = "extend"
 	multipartFile   = "multipart"
+	warmFile        = "warm"
 	applyIDFile     = "apply"
 	SnapshotSign    = ".sign"
 	metadataFile    = "meta"
 	metadataFileTmp = ".meta"
+
+	checkpointPrefix = "checkpoint_"
 )
 
+// snapshotDataFiles lists the data files of a snapshot in the order their CRCs are written into the sign file.
+var snapshotDataFiles = []string{inodeFile, dentryFile, extendFile, multipartFile}
+
+// readSnapshotSign reads the CRCs of the data files recorded in the sign file of dir.
+func readSnapshotSign(dir string) (crcs []uint32, err error) {
+	var data []byte
+	if data, err = ioutil.ReadFile(path.Join(dir, SnapshotSign)); err != nil {
+		err = errors.NewErrorf("[readSnapshotSign] ReadSign: %s", err.Error())
+		return
+	}
+	return parseSnapshotSign(dir, data)
+}
+
+// parseSnapshotSign parses the content of the sign file of the snapshot in dir.
+func parseSnapshotSign(dir string, data []byte) (crcs []uint32, err error) {
+	fields := strings.Fields(string(data))
+	if len(fields) != len(snapshotDataFiles) {
+		err = errors.NewErrorf("[readSnapshotSign] sign mismatch: dir(%v) expect(%v) actual(%v)",
+			dir, len(snapshotDataFiles), len(fields))
+		return
+	}
+	crcs = make([]uint32, len(fields))
+	for i, field := range fields {
+		var crc uint64
+		if crc, err = strconv.ParseUint(field, 10, 32); err != nil {
+			err = errors.NewErrorf("[readSnapshotSign] ParseSign: %s", err.Error())
+			return
+		}
+		crcs[i] = uint32(crc)
+	}
+	return
+}
+
+// checkSnapshotSign recomputes the CRC of every data file in dir and compares them with the sign file.
+func checkSnapshotSign(dir string) (crcs []uint32, err error) {
+	if crcs, err = readSnapshotSign(dir); err != nil {
+		return
+	}
+	for i, filename := range snapshotDataFiles {
+		var actual uint32
+		if actual, err = fileCRC(path.Join(dir, filename)); err != nil {
+			return
+		}
+		if actual != crcs[i] {
+			err = errors.NewErrorf("[checkSnapshotSign] crc mismatch: file(%v) expect(%v) actual(%v)",
+				path.Join(dir, filename), crcs[i], actual)
+			return
+		}
+	}
+	return
+}
+
+// fileCRC computes the CRC of the whole file, which is the same as the one computed by the store functions.
+func fileCRC(filename string) (crc uint32, err error) {
+	fp, err := os.Open(filename)
+	if err != nil {
+		err = errors.NewErrorf("[fileCRC] OpenFile: %s", err.Error())
+		return
+	}
+	defer fp.Close()
+	return readerCRC(fp)
+}
+
+// readerCRC computes the CRC of what is left to read from r, as fileCRC does for a file.
+func readerCRC(r io.Reader) (crc uint32, err error) {
+	sign := crc32.NewIEEE()
+	if _, err = io.Copy(sign, bufio.NewReaderSize(r, 4*1024*1024)); err != nil {
+		err = errors.NewErrorf("[fileCRC] ReadFile: %s", err.Error())
+		return
+	}
+	crc = sign.Sum32()
+	return
+}
+
+// snapshotDigest combines the CRCs of the data files into a single comparable digest.
+func snapshotDigest(crcs []uint32) uint32 {
+	sign := crc32.NewIEEE()
+	buf := make([]byte, 4)
+	for _, crc := range crcs {
+		binary.BigEndian.PutUint32(buf, crc)
+		sign.Write(buf)
+	}
+	return sign.Sum32()
+}
+
 func (mp *metaPartition) loadMetadata() (err error) {
 	metaFile := path.Join(mp.config.RootDir, metadataFile)
 	fp, err := os.OpenFile(metaFile, os.O_RDONLY, 0644)
@@ -79,16 +169,120 @@ func (mp *metaPartition) loadMetadata() (err error) {
 	mp.config.Peers = mConf.Peers
 	mp.config.Cursor = mp.config.Start
 
-	log.LogInfof("loadMetadata: load complete: partitionID(%v) volume(%v) range(%v,%v) cursor(%v)",
+	mp.phaseInfof(snapshotPhaseLoad, "loadMetadata: load complete: partitionID(%v) volume(%v) range(%v,%v) cursor(%v)",
 		mp.config.PartitionId, mp.config.VolName, mp.config.Start, mp.config.End, mp.config.Cursor)
 	return
 }
 
+// syncFile flushes the file to disk unless the partition runs without durability.
+func (mp *metaPartition) syncFile(fp *os.File) error {
+	if noSyncAllowed && mp.config.Snapshot.DurabilityMode == DurabilityNoSync {
+		return nil
+	}
+	return fp.Sync()
+}
+
+// storeSyncer syncs a snapshot data file each time StoreSyncInterval bytes have been written into it.
+// flush pushes the data buffered in front of the file, if any.
+type storeSyncer struct {
+	mp       *metaPartition
+	fp       *os.File
+	flush    func() error
+	interval uint64
+	pending  uint64
+}
+
+func (mp *metaPartition) newStoreSyncer(fp *os.File, flush func() error) *storeSyncer {
+	return &storeSyncer{mp: mp, fp: fp, flush: flush, interval: mp.storeConfig().StoreSyncInterval}
+}
+
+// storeConfig returns the snapshot config of the partition with the overrides of its volume applied.
+func (mp *metaPartition) storeConfig() SnapshotConfig {
+	return mp.config.Snapshot.forVolume(mp.config.VolName)
+}
+
+// wrote accounts n bytes written into the file and syncs it once the interval is reached.
+func (s *storeSyncer) wrote(n int) (err error) {
+	if s.interval == 0 {
+		return
+	}
+	if s.pending += uint64(n); s.pending < s.interval {
+		return
+	}
+	s.pending = 0
+	if s.flush != nil {
+		if err = s.flush(); err != nil {
+			return
+		}
+	}
+	return s.mp.syncFile(s.fp)
+}
+
+// sweepTempFiles removes the temporary files left by a store or a metadata persist which was interrupted,
+// e.g. by a crash. Nothing stores into the partition before it is loaded, so they can not be in use.
+func (mp *metaPartition) sweepTempFiles() {
+	for _, name := range []string{mp.config.Snapshot.tmpDirName(), mp.config.Snapshot.metadataTmpName()} {
+		filename := path.Join(mp.config.RootDir, name)
+		info, err := os.Lstat(filename)
+		if err != nil {
+			continue
+		}
+		if err = os.RemoveAll(filename); err != nil {
+			log.LogWarnf("sweepTempFiles: remove failed: partitionID(%v) file(%v) err(%v)",
+				mp.config.PartitionId, filename, err)
+			continue
+		}
+		log.LogWarnf("sweepTempFiles: removed leftover: partitionID(%v) file(%v) modTime(%v)",
+			mp.config.PartitionId, filename, info.ModTime())
+	}
+}
+
+// checkStoreSpace refuses a store which would leave less free space on the disk than configured.
+// The size of the store is estimated by the size of the current snapshot.
+func (mp *metaPartition) checkStoreSpace() (err error) {
+	minFree := mp.config.Snapshot.StoreMinFreeSpace
+	if minFree == 0 {
+		return
+	}
+	fs := syscall.Statfs_t{}
+	if err = syscall.Statfs(mp.config.RootDir, &fs); err != nil {
+		err = errors.NewErrorf("[checkStoreSpace] Statfs: %s", err.Error())
+		return
+	}
+	available := fs.Bavail * uint64(fs.Bsize)
+	estimate := partitionDataSize(path.Join(mp.config.RootDir, mp.config.Snapshot.dirName()))
+	if available < estimate+minFree {
+		err = errors.NewErrorf("[checkStoreSpace] not enough free space to store: partitionID(%v) dir(%v) available(%v) estimate(%v) minFree(%v)",
+			mp.config.PartitionId, mp.config.RootDir, available, estimate, minFree)
+	}
+	return
+}
+
+// checkRangeOverlap checks the range of the meta partition against the ranges of its siblings.
+func (mp *metaPartition) checkRangeOverlap() (err error) {
+	if mp.config.Siblings == nil {
+		return
+	}
+	for _, r := range mp.config.Siblings(mp.config.VolName, mp.config.PartitionId) {
+		if r.Start > mp.config.End || mp.config.Start > r.End {
+			continue
+		}
+		log.LogErrorf("checkRangeOverlap: range overlaps sibling: partitionID(%v) volume(%v) range(%v,%v) sibling(%v) siblingRange(%v,%v)",
+			mp.config.PartitionId, mp.config.VolName, mp.config.Start, mp.config.End, r.PartitionID, r.Start, r.End)
+		if mp.config.Snapshot.StrictRangeOverlap {
+			err = errors.NewErrorf("[checkRangeOverlap] range(%v,%v) overlaps partition(%v) range(%v,%v)",
+				mp.config.Start, mp.config.End, r.PartitionID, r.Start, r.End)
+			return
+		}
+	}
+	return
+}
+
 func (mp *metaPartition) loadInode(rootDir string) (err error) {
 	var numInodes uint64
 	defer func() {
 		if err == nil {
-			log.LogInfof("loadInode: load complete: partitonID(%v) volume(%v) numInodes(%v)",
+			mp.phaseInfof(snapshotPhaseLoad, "loadInode: load complete: partitonID(%v) volume(%v) numInodes(%v)",
 				mp.config.PartitionId, mp.config.VolName, numInodes)
 		}
 	}()
@@ -103,8 +297,20 @@ func (mp *metaPartition) loadInode(rootDir string) (err error) {
 		return
 	}
 	defer fp.Close()
-	reader := bufio.NewReaderSize(fp, 4*1024*1024)
+	defer mp.adviseSequentialLoad(fp)()
+	reader := newAdaptiveReader(fp)
+	defer mp.reportLoadReader(reader, inodeFile)
 	inoBuf := make([]byte, 4)
+	profiler := newSlowRecordTracker(inodeFile, mp.config.Snapshot.SlowRecordSamples)
+	defer profiler.report(mp.config.PartitionId)
+	// the free list and cursor are restored from the warm state after all the inodes are loaded if it is trusted
+	warm := mp.loadWarmState(rootDir)
+	outOfRange := &inodeRangeStat{}
+	defer outOfRange.report(mp.config)
+	var (
+		offset     int64
+		maxInRange uint64
+	)
 	for {
 		inoBuf = inoBuf[:4]
 		// first read length
@@ -112,6 +318,13 @@ func (mp *metaPartition) loadInode(rootDir string) (err error) {
 		if err != nil {
 			if err == io.EOF {
 				err = nil
+				if warm != nil {
+					if outOfRange.count > 0 {
+						// the stored cursor may come from an out-of-range inode
+						warm.cursor = maxInRange
+					}
+					mp.applyWarmState(warm)
+				}
 				return
 			}
 			err = errors.NewErrorf("[loadInode] ReadHeader: %s", err.Error())
@@ -131,26 +344,105 @@ func (mp *metaPartition) loadInode(rootDir string) (err error) {
 			return
 		}
 		ino := NewInode(0, 0)
+		start := profiler.begin()
 		if err = ino.Unmarshal(inoBuf); err != nil {
 			err = errors.NewErrorf("[loadInode] Unmarshal: %s", err.Error())
 			return
 		}
+		profiler.add(offset, len(inoBuf), start)
+		recordOffset := offset
+		offset += 4 + int64(length)
+		if reason := checkLoadedInode(ino); reason != "" {
+			if mp.config.Snapshot.StrictInodeCheck {
+				err = errors.NewErrorf("[loadInode] invalid inode: partitionID(%v) inode(%v) type(%v) offset(%v) reason(%v)",
+					mp.config.PartitionId, ino.Inode, ino.Type, recordOffset, reason)
+				return
+			}
+			log.LogWarnf("loadInode: skip invalid inode: partitionID(%v) inode(%v) type(%v) offset(%v) reason(%v)",
+				mp.config.PartitionId, ino.Inode, ino.Type, recordOffset, reason)
+			mp.ForceFullStore()
+			continue
+		}
+		inRange := ino.Inode >= mp.config.Start && ino.Inode <= mp.config.End
+		if !inRange {
+			outOfRange.add(ino.Inode)
+			if mp.config.Snapshot.StrictInodeRange {
+				err = errors.NewErrorf("[loadInode] inode out of range: partitionID(%v) inode(%v) range(%v,%v)",
+					mp.config.PartitionId, ino.Inode, mp.config.Start, mp.config.End)
+				return
+			}
+		} else if maxInRange < ino.Inode {
+			maxInRange = ino.Inode
+		}
 		mp.fsmCreateInode(ino)
-		mp.checkAndInsertFreeList(ino)
-		if mp.config.Cursor < ino.Inode {
-			mp.config.Cursor = ino.Inode
+		mp.addResidentBytes(inodeResidentBytes(ino))
+		mp.loadFeed.inode(ino)
+		if warm == nil {
+			mp.checkAndInsertFreeList(ino)
+			// an out-of-range inode must not move the cursor into the range of another partition
+			if inRange {
+				mp.advanceCursor(ino.Inode)
+			}
 		}
 		numInodes += 1
 	}
 }
 
+// validInodeModeBits are all the bits a stored inode type may carry.
+const validInodeModeBits = os.ModeType | os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky
+
+// checkLoadedInode returns why a decoded inode can not be trusted, or an empty string if it is valid.
+// Unmarshal accepts any record which is long enough, so a damaged record may still decode into
+// a plausible but wrong inode.
+func checkLoadedInode(ino *Inode) string {
+	if ino.Inode == 0 {
+		return "zero inode number"
+	}
+	if proto.OsMode(ino.Type)&^validInodeModeBits != 0 {
+		return "unknown type bits"
+	}
+	return ""
+}
+
+// adviseSequentialLoad hints the kernel that a large snapshot file is about to be read sequentially.
+// The returned function drops the pages of the file from the page cache once the load is done.
+func (mp *metaPartition) adviseSequentialLoad(fp *os.File) func() {
+	if !mp.config.Snapshot.LoadFadvise {
+		return func() {}
+	}
+	info, err := fp.Stat()
+	if err != nil || info.Size() < fadviseMinFileSize {
+		return func() {}
+	}
+	if err = fadviseSequential(fp); err != nil {
+		log.LogWarnf("adviseSequentialLoad: fadvise sequential failed: partitionID(%v) file(%v) err(%v)",
+			mp.config.PartitionId, fp.Name(), err)
+	}
+	return func() {
+		if err := fadviseDontNeed(fp); err != nil {
+			log.LogWarnf("adviseSequentialLoad: fadvise dontneed failed: partitionID(%v) file(%v) err(%v)",
+				mp.config.PartitionId, fp.Name(), err)
+		}
+	}
+}
+
+// isDentryConflict tells whether a status returned by fsmCreateDentry while loading means the dentry
+// conflicts with a dentry of the same parent and name loaded before it:
+//  OpOk              the dentry is loaded, or is identical to the one loaded before
+//  OpExistErr        conflict: the name is already taken by another inode
+//  OpArgMismatchErr  conflict: the name is already taken by a dentry of another type
+// Any other status is never tolerated.
+func isDentryConflict(status uint8) bool {
+	return status == proto.OpExistErr || status == proto.OpArgMismatchErr
+}
+
 // Load dentry from the dentry snapshot.
 func (mp *metaPartition) loadDentry(rootDir string) (err error) {
-	var numDentries uint64
+	var numDentries, numSkipped uint64
 	defer func() {
 		if err == nil {
-			log.LogInfof("loadDentry: load complete: partitonID(%v) volume(%v) numDentries(%v)",
-				mp.config.PartitionId, mp.config.VolName, numDentries)
+			mp.phaseInfof(snapshotPhaseLoad, "loadDentry: load complete: partitonID(%v) volume(%v) numDentries(%v) numSkipped(%v)",
+				mp.config.PartitionId, mp.config.VolName, numDentries, numSkipped)
 		}
 	}()
 	filename := path.Join(rootDir, dentryFile)
@@ -169,8 +461,13 @@ func (mp *metaPartition) loadDentry(rootDir string) (err error) {
 	}
 
 	defer fp.Close()
-	reader := bufio.NewReaderSize(fp, 4*1024*1024)
+	defer mp.adviseSequentialLoad(fp)()
+	reader := newAdaptiveReader(fp)
+	defer mp.reportLoadReader(reader, dentryFile)
 	dentryBuf := make([]byte, 4)
+	profiler := newSlowRecordTracker(dentryFile, mp.config.Snapshot.SlowRecordSamples)
+	defer profiler.report(mp.config.PartitionId)
+	var offset int64
 	for {
 		dentryBuf = dentryBuf[:4]
 		// First Read 4byte header length
@@ -198,14 +495,27 @@ func (mp *metaPartition) loadDentry(rootDir string) (err error) {
 			return
 		}
 		dentry := &Dentry{}
+		start := profiler.begin()
 		if err = dentry.Unmarshal(dentryBuf); err != nil {
 			err = errors.NewErrorf("[loadDentry] Unmarshal: %s", err.Error())
 			return
 		}
+		profiler.add(offset, len(dentryBuf), start)
+		recordOffset := offset
+		offset += 4 + int64(length)
 		if status := mp.fsmCreateDentry(dentry, true); status != proto.OpOk {
-			err = errors.NewErrorf("[loadDentry] createDentry dentry: %v, resp code: %d", dentry, status)
-			return
+			if !mp.config.Snapshot.TolerateDentryConflicts || !isDentryConflict(status) {
+				err = errors.NewErrorf("[loadDentry] createDentry dentry: %v, resp code: %d", dentry, status)
+				return
+			}
+			log.LogWarnf("loadDentry: skip conflicting dentry: partitionID(%v) dentry(%v) offset(%v) status(%v)",
+				mp.config.PartitionId, dentry, recordOffset, status)
+			numSkipped++
+			mp.ForceFullStore()
+			continue
 		}
+		mp.addResidentBytes(dentryResidentBytes(dentry))
+		mp.loadFeed.dentry(dentry)
 		numDentries += 1
 	}
 }
@@ -213,7 +523,8 @@ func (mp *metaPartition) loadDentry(rootDir string) (err error) {
 func (mp *metaPartition) loadExtend(rootDir string) error {
 	var err error
 	filename := path.Join(rootDir, extendFile)
-	if _, err = os.Stat(filename); err != nil {
+	if info, statErr := os.Stat(filename); statErr != nil || info.Size() == 0 {
+		// an empty file holds no extend, as a missing one, and can not be mapped
 		return nil
 	}
 	fp, err := os.OpenFile(filename, os.O_RDONLY, 0644)
@@ -223,33 +534,64 @@ func (mp *metaPartition) loadExtend(rootDir string) error {
 	defer func() {
 		_ = fp.Close()
 	}()
-	var mem mmap.MMap
-	if mem, err = mmap.Map(fp, mmap.RDONLY, 0); err != nil {
+	var mem *mmapWindow
+	if mem, err = newMmapWindow(fp, mp.config.Snapshot.ExtendMmapWindow); err != nil {
 		return err
 	}
 	defer func() {
-		_ = mem.Unmap()
+		_ = mem.unmap()
 	}()
-	var offset, n int
+	defer mp.adviseSequentialLoad(fp)()
+	if head, headErr := mem.bytes(0, len(extendDedupMarker)); headErr == nil && isExtendDedup(head) {
+		return mp.loadExtendDedup(filename, mem)
+	}
+	profiler := newSlowRecordTracker(extendFile, mp.config.Snapshot.SlowRecordSamples)
+	defer profiler.report(mp.config.PartitionId)
+	var offset int64
+	var n int
 	// read number of extends
 	var numExtends uint64
-	numExtends, n = binary.Uvarint(mem)
-	offset += n
+	if numExtends, n, err = mem.uvarint(0); err != nil {
+		return errors.NewErrorf("[loadExtend] corrupted extend file: read count failed: filename(%v) size(%v)",
+			filename, mem.size)
+	}
+	offset += int64(n)
 	for i := uint64(0); i < numExtends; i++ {
 		// read length
 		var numBytes uint64
-		numBytes, n = binary.Uvarint(mem[offset:])
-		offset += n
+		if numBytes, n, err = mem.uvarint(offset); err != nil {
+			return errors.NewErrorf("[loadExtend] corrupted extend file: read length failed: filename(%v) offset(%v) size(%v)",
+				filename, offset, mem.size)
+		}
+		offset += int64(n)
+		var end int64
+		if end, err = recordEnd(offset, numBytes, mem.size); err != nil {
+			return errors.NewErrorf("[loadExtend] corrupted extend file: filename(%v): %s", filename, err.Error())
+		}
+		var raw []byte
+		if raw, err = mem.bytes(offset, int(end-offset)); err != nil {
+			return errors.NewErrorf("[loadExtend] map extend record failed: filename(%v) offset(%v) length(%v): %s",
+				filename, offset, numBytes, err.Error())
+		}
 		var extend *Extend
-		if extend, err = NewExtendFromBytes(mem[offset : offset+int(numBytes)]); err != nil {
+		start := profiler.begin()
+		if extend, err = NewExtendFromBytes(raw); err != nil {
 			return err
 		}
-		log.LogDebugf("loadExtend: new extend from bytes: partitionID（%v) volume(%v) inode(%v)",
+		profiler.add(offset, int(numBytes), start)
+		mp.phaseDebugf(snapshotPhaseLoad, "loadExtend: new extend from bytes: partitionID（%v) volume(%v) inode(%v)",
 			mp.config.PartitionId, mp.config.VolName, extend.inode)
 		_ = mp.fsmSetXAttr(extend)
-		offset += int(numBytes)
+		mp.addResidentBytes(extendResidentBytes(extend))
+		mp.loadFeed.extend(extend)
+		offset = end
+	}
+	// the count must cover the whole file, trailing bytes mean a corrupted count or garbage
+	if offset != mem.size {
+		return errors.NewErrorf("[loadExtend] corrupted extend file: trailing bytes after %v extends: filename(%v) offset(%v) size(%v)",
+			numExtends, filename, offset, mem.size)
 	}
-	log.LogInfof("loadExtend: load complete: partitionID(%v) volume(%v) numExtends(%v) filename(%v)",
+	mp.phaseInfof(snapshotPhaseLoad, "loadExtend: load complete: partitionID(%v) volume(%v) numExtends(%v) filename(%v)",
 		mp.config.PartitionId, mp.config.VolName, numExtends, filename)
 	return nil
 }
@@ -257,7 +599,8 @@ func (mp *metaPartition) loadExtend(rootDir string) error {
 func (mp *metaPartition) loadMultipart(rootDir string) error {
 	var err error
 	filename := path.Join(rootDir, multipartFile)
-	if _, err = os.Stat(filename); err != nil {
+	if info, statErr := os.Stat(filename); statErr != nil || info.Size() == 0 {
+		// an empty file holds no multipart, as a missing one, and can not be mapped
 		return nil
 	}
 	fp, err := os.OpenFile(filename, os.O_RDONLY, 0644)
@@ -267,30 +610,61 @@ func (mp *metaPartition) loadMultipart(rootDir string) error {
 	defer func() {
 		_ = fp.Close()
 	}()
-	var mem mmap.MMap
-	if mem, err = mmap.Map(fp, mmap.RDONLY, 0); err != nil {
+	var mem *mmapWindow
+	if mem, err = newMmapWindow(fp, mp.config.Snapshot.ExtendMmapWindow); err != nil {
 		return err
 	}
 	defer func() {
-		_ = mem.Unmap()
+		_ = mem.unmap()
 	}()
-	var offset, n int
+	defer mp.adviseSequentialLoad(fp)()
+	profiler := newSlowRecordTracker(multipartFile, mp.config.Snapshot.SlowRecordSamples)
+	defer profiler.report(mp.config.PartitionId)
+	// offsets are 64-bit whatever the size of an int, and each record is checked to fit in the file
+	var offset, end int64
+	var n int
+	size := mem.size
 	// read number of extends
 	var numMultiparts uint64
-	numMultiparts, n = binary.Uvarint(mem)
-	offset += n
+	if numMultiparts, n, err = mem.uvarint(0); err != nil {
+		return errors.NewErrorf("[loadMultipart] corrupted multipart file: read count failed: filename(%v) size(%v)",
+			filename, size)
+	}
+	offset += int64(n)
 	for i := uint64(0); i < numMultiparts; i++ {
 		// read length
 		var numBytes uint64
-		numBytes, n = binary.Uvarint(mem[offset:])
-		offset += n
+		if numBytes, n, err = mem.uvarint(offset); err != nil {
+			return errors.NewErrorf("[loadMultipart] corrupted multipart file: read length failed: filename(%v) offset(%v) size(%v)",
+				filename, offset, size)
+		}
+		offset += int64(n)
+		if end, err = recordEnd(offset, numBytes, size); err != nil {
+			return errors.NewErrorf("[loadMultipart] corrupted multipart file: filename(%v): %s", filename, err.Error())
+		}
+		var raw []byte
+		if raw, err = mem.bytes(offset, int(end-offset)); err != nil {
+			return errors.NewErrorf("[loadMultipart] map multipart record failed: filename(%v) offset(%v) length(%v): %s",
+				filename, offset, numBytes, err.Error())
+		}
 		var multipart *Multipart
-		multipart = MultipartFromBytes(mem[offset : offset+int(numBytes)])
-		log.LogDebugf("loadMultipart: create multipart from bytes: partitionID（%v) multipartID(%v)", mp.config.PartitionId, multipart.id)
+		start := profiler.begin()
+		if multipart, err = MultipartFromBytes(raw); err != nil {
+			return errors.NewErrorf("[loadMultipart] corrupted multipart file: filename(%v) offset(%v): %s",
+				filename, offset, err.Error())
+		}
+		profiler.add(offset, int(end-offset), start)
+		mp.phaseDebugf(snapshotPhaseLoad, "loadMultipart: create multipart from bytes: partitionID（%v) multipartID(%v)", mp.config.PartitionId, multipart.id)
 		mp.fsmCreateMultipart(multipart)
-		offset += int(numBytes)
+		mp.addResidentBytes(multipartResidentBytes(multipart))
+		offset = end
+	}
+	// the count must cover the whole file, trailing bytes mean a corrupted count or garbage
+	if offset != size {
+		return errors.NewErrorf("[loadMultipart] corrupted multipart file: trailing bytes after %v multiparts: filename(%v) offset(%v) size(%v)",
+			numMultiparts, filename, offset, size)
 	}
-	log.LogInfof("loadMultipart: load complete: partitionID(%v) numMultiparts(%v) filename(%v)",
+	mp.phaseInfof(snapshotPhaseLoad, "loadMultipart: load complete: partitionID(%v) numMultiparts(%v) filename(%v)",
 		mp.config.PartitionId, numMultiparts, filename)
 	return nil
 }
@@ -298,7 +672,7 @@ func (mp *metaPartition) loadMultipart(rootDir string) error {
 func (mp *metaPartition) loadApplyID(rootDir string) (err error) {
 	filename := path.Join(rootDir, applyIDFile)
 	if _, err = os.Stat(filename); err != nil {
-		err = nil
+		err = mp.recoverApplyID(rootDir)
 		return
 	}
 	data, err := ioutil.ReadFile(filename)
@@ -325,10 +699,27 @@ func (mp *metaPartition) loadApplyID(rootDir string) (err error) {
 		return
 	}
 
-	if cursor > atomic.LoadUint64(&mp.config.Cursor) {
-		atomic.StoreUint64(&mp.config.Cursor, cursor)
+	mp.advanceCursor(cursor)
+	mp.phaseInfof(snapshotPhaseLoad, "loadApplyID: load complete: partitionID(%v) volume(%v) applyID(%v) filename(%v)",
+		mp.config.PartitionId, mp.config.VolName, mp.applyID, filename)
+	return
+}
+
+// recoverApplyID restores the applyID from the manifest when the apply file of the snapshot is lost,
+// and rewrites the apply file. Snapshots without a manifest keep starting from applyID zero.
+func (mp *metaPartition) recoverApplyID(rootDir string) (err error) {
+	var manifest *snapshotManifest
+	if manifest, err = readSnapshotManifest(rootDir); err != nil || manifest == nil {
+		return
+	}
+	mp.applyID = manifest.ApplyID
+	filename := path.Join(rootDir, applyIDFile)
+	data := fmt.Sprintf("%d|%d", mp.applyID, atomic.LoadUint64(&mp.config.Cursor))
+	if err = ioutil.WriteFile(filename, []byte(data), 0755); err != nil {
+		err = errors.NewErrorf("[recoverApplyID] WriteApplyID: %s", err.Error())
+		return
 	}
-	log.LogInfof("loadApplyID: load complete: partitionID(%v) volume(%v) applyID(%v) filename(%v)",
+	log.LogWarnf("recoverApplyID: apply file recovered from manifest: partitionID(%v) volume(%v) applyID(%v) filename(%v)",
 		mp.config.PartitionId, mp.config.VolName, mp.applyID, filename)
 	return
 }
@@ -341,14 +732,14 @@ func (mp *metaPartition) persistMetadata() (err error) {
 
 	// TODO Unhandled errors
 	os.MkdirAll(mp.config.RootDir, 0755)
-	filename := path.Join(mp.config.RootDir, metadataFileTmp)
+	filename := path.Join(mp.config.RootDir, mp.config.Snapshot.metadataTmpName())
 	fp, err := os.OpenFile(filename, os.O_RDWR|os.O_TRUNC|os.O_APPEND|os.O_CREATE, 0755)
 	if err != nil {
 		return
 	}
 	defer func() {
 		// TODO Unhandled errors
-		fp.Sync()
+		mp.syncFile(fp)
 		fp.Close()
 		os.Remove(filename)
 	}()
@@ -376,83 +767,163 @@ func (mp *metaPartition) storeApplyID(rootDir string, sm *storeMsg) (err error)
 		return
 	}
 	defer func() {
-		err = fp.Sync()
+		err = mp.syncFile(fp)
 		fp.Close()
 	}()
 	if _, err = fp.WriteString(fmt.Sprintf("%d|%d", sm.applyIndex, atomic.LoadUint64(&mp.config.Cursor))); err != nil {
 		return
 	}
-	log.LogInfof("storeApplyID: store complete: partitionID(%v) volume(%v) applyID(%v)",
+	mp.phaseInfof(snapshotPhaseStore, "storeApplyID: store complete: partitionID(%v) volume(%v) applyID(%v)",
 		mp.config.PartitionId, mp.config.VolName, sm.applyIndex)
 	return
 }
 
 func (mp *metaPartition) storeInode(rootDir string,
 	sm *storeMsg) (crc uint32, err error) {
-	filename := path.Join(rootDir, inodeFile)
-	fp, err := os.OpenFile(filename, os.O_RDWR|os.O_TRUNC|os.O_APPEND|os.
-		O_CREATE, 0755)
+	fp, err := mp.openStoreFile(rootDir, inodeFile, sm)
 	if err != nil {
 		return
 	}
+	conf := mp.storeConfig()
+	// the records go through the mapping of the file if it is enabled, the index and the groups do not
+	var (
+		writer   io.Writer = fp
+		mmWriter *mmapFileWriter
+		syncer   = mp.newStoreSyncer(fp, nil)
+	)
+	if conf.MmapStore {
+		sizeHint := mp.EstimateStoreCost(sm).FileBytes[inodeFile]
+		if mmWriter, err = newMmapFileWriter(fp, sizeHint); err != nil {
+			fp.Close()
+			return
+		}
+		writer = mmWriter
+		syncer = mp.newStoreSyncer(fp, mmWriter.Flush)
+	}
 	defer func() {
-		err = fp.Sync()
+		if mmWriter != nil {
+			if closeErr := mmWriter.Close(); err == nil {
+				err = closeErr
+			}
+		}
+		// a failed write must not be hidden by a successful sync
+		if syncErr := mp.syncFile(fp); err == nil {
+			err = syncErr
+		}
 		// TODO Unhandled errors
 		fp.Close()
 	}()
 	var data []byte
+	var offset int64
 	lenBuf := make([]byte, 4)
 	sign := crc32.NewIEEE()
-	sm.inodeTree.Ascend(func(i BtreeItem) bool {
+	var index []inodeIndexEntry
+	if conf.InodeIndex {
+		index = make([]inodeIndexEntry, 0, sm.inodeTree.Len())
+	}
+	writeInode := func(i BtreeItem) bool {
 		ino := i.(*Inode)
 		if data, err = ino.Marshal(); err != nil {
 			return false
 		}
+		if index != nil {
+			index = append(index, inodeIndexEntry{inode: ino.Inode, offset: offset, length: uint32(len(lenBuf) + len(data))})
+		}
 		// set length
 		binary.BigEndian.PutUint32(lenBuf, uint32(len(data)))
-		if _, err = fp.Write(lenBuf); err != nil {
+		if _, err = writer.Write(lenBuf); err != nil {
 			return false
 		}
 		if _, err = sign.Write(lenBuf); err != nil {
 			return false
 		}
 		// set body
-		if _, err = fp.Write(data); err != nil {
+		if _, err = writer.Write(data); err != nil {
 			return false
 		}
 		if _, err = sign.Write(data); err != nil {
 			return false
 		}
+		offset += int64(len(lenBuf) + len(data))
+		if err = syncer.wrote(len(lenBuf) + len(data)); err != nil {
+			return false
+		}
 		return true
-	})
+	}
+	if !conf.GroupInodesByType {
+		sm.inodeTree.Ascend(writeInode)
+	} else {
+		// write the inodes group by group, and record where each group is
+		var groups = make([]*inodeGroup, 0, len(inodeGroups))
+		for _, groupType := range inodeGroups {
+			group := &inodeGroup{Type: groupType, Offset: offset}
+			sm.inodeTree.Ascend(func(i BtreeItem) bool {
+				if inodeGroupOf(i.(*Inode).Type) != groupType {
+					return true
+				}
+				group.Count++
+				return writeInode(i)
+			})
+			if err != nil {
+				return
+			}
+			group.Length = offset - group.Offset
+			groups = append(groups, group)
+		}
+		if err = storeInodeGroups(rootDir, groups); err != nil {
+			return
+		}
+	}
+	if err != nil {
+		return
+	}
+	if index != nil {
+		if err = mp.storeInodeIndex(rootDir, index); err != nil {
+			return
+		}
+	}
 	crc = sign.Sum32()
-	log.LogInfof("storeInode: store complete: partitoinID(%v) volume(%v) numInodes(%v) crc(%v)",
+	mp.phaseInfof(snapshotPhaseStore, "storeInode: store complete: partitoinID(%v) volume(%v) numInodes(%v) crc(%v)",
 		mp.config.PartitionId, mp.config.VolName, sm.inodeTree.Len(), crc)
 	return
 }
 
 func (mp *metaPartition) storeDentry(rootDir string,
 	sm *storeMsg) (crc uint32, err error) {
-	filename := path.Join(rootDir, dentryFile)
-	fp, err := os.OpenFile(filename, os.O_RDWR|os.O_TRUNC|os.O_APPEND|os.
-		O_CREATE, 0755)
+	fp, err := mp.openStoreFile(rootDir, dentryFile, sm)
 	if err != nil {
 		return
 	}
 	defer func() {
-		err = fp.Sync()
+		// a failed write must not be hidden by a successful sync
+		if syncErr := mp.syncFile(fp); err == nil {
+			err = syncErr
+		}
 		// TODO Unhandled errors
 		fp.Close()
 	}()
 	var data []byte
+	var offset int64
 	lenBuf := make([]byte, 4)
 	sign := crc32.NewIEEE()
+	syncer := mp.newStoreSyncer(fp, nil)
+	dentryIndex := mp.storeConfig().DentryIndex
+	// the dentries are stored in the order of (ParentId, Name), so those of a parent are contiguous
+	var index []dentryIndexEntry
 	sm.dentryTree.Ascend(func(i BtreeItem) bool {
 		dentry := i.(*Dentry)
 		data, err = dentry.Marshal()
 		if err != nil {
 			return false
 		}
+		if dentryIndex {
+			if len(index) == 0 || index[len(index)-1].parentID != dentry.ParentId {
+				index = append(index, dentryIndexEntry{parentID: dentry.ParentId, offset: offset})
+			}
+			index[len(index)-1].length += int64(len(lenBuf) + len(data))
+			index[len(index)-1].count++
+		}
+		offset += int64(len(lenBuf) + len(data))
 		// set length
 		binary.BigEndian.PutUint32(lenBuf, uint32(len(data)))
 		if _, err = fp.Write(lenBuf); err != nil {
@@ -467,19 +938,29 @@ func (mp *metaPartition) storeDentry(rootDir string,
 		if _, err = sign.Write(data); err != nil {
 			return false
 		}
+		if err = syncer.wrote(len(lenBuf) + len(data)); err != nil {
+			return false
+		}
 		return true
 	})
+	if err != nil {
+		return
+	}
+	if dentryIndex {
+		if err = mp.storeDentryIndex(rootDir, index); err != nil {
+			return
+		}
+	}
 	crc = sign.Sum32()
-	log.LogInfof("storeDentry: store complete: partitoinID(%v) volume(%v) numDentries(%v) crc(%v)",
+	mp.phaseInfof(snapshotPhaseStore, "storeDentry: store complete: partitoinID(%v) volume(%v) numDentries(%v) crc(%v)",
 		mp.config.PartitionId, mp.config.VolName, sm.dentryTree.Len(), crc)
 	return
 }
 
 func (mp *metaPartition) storeExtend(rootDir string, sm *storeMsg) (crc uint32, err error) {
 	var extendTree = sm.extendTree
-	var fp = path.Join(rootDir, extendFile)
 	var f *os.File
-	f, err = os.OpenFile(fp, os.O_RDWR|os.O_TRUNC|os.O_APPEND|os.O_CREATE, 0755)
+	f, err = mp.openStoreFile(rootDir, extendFile, sm)
 	if err != nil {
 		return
 	}
@@ -490,7 +971,24 @@ func (mp *metaPartition) storeExtend(rootDir string, sm *storeMsg) (crc uint32,
 		}
 	}()
 	var writer = bufio.NewWriterSize(f, 4*1024*1024)
+	var syncer = mp.newStoreSyncer(f, writer.Flush)
 	var crc32 = crc32.NewIEEE()
+	if mp.storeConfig().ExtendDedup {
+		var distinct int
+		if distinct, err = mp.writeExtendDedup(writer, crc32, syncer, extendTree); err != nil {
+			return
+		}
+		if err = writer.Flush(); err != nil {
+			return
+		}
+		if err = mp.syncFile(f); err != nil {
+			return
+		}
+		crc = crc32.Sum32()
+		mp.phaseInfof(snapshotPhaseStore, "storeExtend: store complete: partitoinID(%v) volume(%v) numExtends(%v) distinct(%v) crc(%v)",
+			mp.config.PartitionId, mp.config.VolName, extendTree.Len(), distinct, crc)
+		return
+	}
 	var varintTmp = make([]byte, binary.MaxVarintLen64)
 	var n int
 	// write number of extends
@@ -501,8 +999,25 @@ func (mp *metaPartition) storeExtend(rootDir string, sm *storeMsg) (crc uint32,
 	if _, err = crc32.Write(varintTmp[:n]); err != nil {
 		return
 	}
+	var numBig int
 	extendTree.Ascend(func(i BtreeItem) bool {
 		e := i.(*Extend)
+		if length := e.encodedLen(); length > bigExtendSize {
+			// a giant record is written in chunks behind its length, instead of through one buffer of its size
+			n = binary.PutUvarint(varintTmp, uint64(length))
+			if _, err = writer.Write(varintTmp[:n]); err != nil {
+				return false
+			}
+			if _, err = crc32.Write(varintTmp[:n]); err != nil {
+				return false
+			}
+			if err = e.writeChunked(io.MultiWriter(writer, crc32), storeChunkSize); err != nil {
+				return false
+			}
+			numBig++
+			err = syncer.wrote(n + length)
+			return err == nil
+		}
 		var raw []byte
 		if raw, err = e.Bytes(); err != nil {
 			return false
@@ -522,6 +1037,9 @@ func (mp *metaPartition) storeExtend(rootDir string, sm *storeMsg) (crc uint32,
 		if _, err = crc32.Write(raw); err != nil {
 			return false
 		}
+		if err = syncer.wrote(n + len(raw)); err != nil {
+			return false
+		}
 		return true
 	})
 	if err != nil {
@@ -531,20 +1049,19 @@ func (mp *metaPartition) storeExtend(rootDir string, sm *storeMsg) (crc uint32,
 	if err = writer.Flush(); err != nil {
 		return
 	}
-	if err = f.Sync(); err != nil {
+	if err = mp.syncFile(f); err != nil {
 		return
 	}
 	crc = crc32.Sum32()
-	log.LogInfof("storeExtend: store complete: partitoinID(%v) volume(%v) numExtends(%v) crc(%v)",
-		mp.config.PartitionId, mp.config.VolName, extendTree.Len(), crc)
+	mp.phaseInfof(snapshotPhaseStore, "storeExtend: store complete: partitoinID(%v) volume(%v) numExtends(%v) numBig(%v) crc(%v)",
+		mp.config.PartitionId, mp.config.VolName, extendTree.Len(), numBig, crc)
 	return
 }
 
 func (mp *metaPartition) storeMultipart(rootDir string, sm *storeMsg) (crc uint32, err error) {
 	var multipartTree = sm.multipartTree
-	var fp = path.Join(rootDir, multipartFile)
 	var f *os.File
-	f, err = os.OpenFile(fp, os.O_RDWR|os.O_TRUNC|os.O_APPEND|os.O_CREATE, 0755)
+	f, err = mp.openStoreFile(rootDir, multipartFile, sm)
 	if err != nil {
 		return
 	}
@@ -555,6 +1072,7 @@ func (mp *metaPartition) storeMultipart(rootDir string, sm *storeMsg) (crc uint3
 		}
 	}()
 	var writer = bufio.NewWriterSize(f, 4*1024*1024)
+	var syncer = mp.newStoreSyncer(f, writer.Flush)
 	var crc32 = crc32.NewIEEE()
 	var varintTmp = make([]byte, binary.MaxVarintLen64)
 	var n int
@@ -587,6 +1105,9 @@ func (mp *metaPartition) storeMultipart(rootDir string, sm *storeMsg) (crc uint3
 		if _, err = crc32.Write(raw); err != nil {
 			return false
 		}
+		if err = syncer.wrote(n + len(raw)); err != nil {
+			return false
+		}
 		return true
 	})
 	if err != nil {
@@ -596,11 +1117,148 @@ func (mp *metaPartition) storeMultipart(rootDir string, sm *storeMsg) (crc uint3
 	if err = writer.Flush(); err != nil {
 		return
 	}
-	if err = f.Sync(); err != nil {
+	if err = mp.syncFile(f); err != nil {
 		return
 	}
 	crc = crc32.Sum32()
-	log.LogInfof("storeMultipart: store complete: partitoinID(%v) volume(%v) numMultiparts(%v) crc(%v)",
+	mp.phaseInfof(snapshotPhaseStore, "storeMultipart: store complete: partitoinID(%v) volume(%v) numMultiparts(%v) crc(%v)",
 		mp.config.PartitionId, mp.config.VolName, multipartTree.Len(), crc)
 	return
 }
+
+// warmState is the free list and cursor computed from the inode tree at store time.
+// It is bound to the inode file by the CRC of the latter, so it can only be used with the inode file it was stored with.
+type warmState struct {
+	freeInodes []uint64
+	cursor     uint64
+	inodeCRC   uint32
+}
+
+// storeWarmState writes the warm state of the inode tree, which lets the next load skip scanning every inode
+// for the free list and the cursor.
+// Warm file structure:
+//  +-------+-------+-------------+--------+----------+-----+
+//  | item  | Count | FreeInodes  | Cursor | InodeCRC | CRC |
+//  +-------+-------+-------------+--------+----------+-----+
+//  | bytes | varint| Count*varint| varint |     4    |  4  |
+//  +-------+-------+-------------+--------+----------+-----+
+func (mp *metaPartition) storeWarmState(rootDir string, sm *storeMsg, inodeCRC uint32) (err error) {
+	var freeInodes []uint64
+	sm.inodeTree.Ascend(func(i BtreeItem) bool {
+		ino := i.(*Inode)
+		if !proto.IsDir(ino.Type) && (ino.ShouldDelete() || ino.IsTempFile()) {
+			freeInodes = append(freeInodes, ino.Inode)
+		}
+		return true
+	})
+	var cursor uint64
+	if item := sm.inodeTree.MaxItem(); item != nil {
+		cursor = item.(*Inode).Inode
+	}
+	var buff = bytes.NewBuffer(make([]byte, 0, (len(freeInodes)+2)*binary.MaxVarintLen64+8))
+	var varintTmp = make([]byte, binary.MaxVarintLen64)
+	buff.Write(varintTmp[:binary.PutUvarint(varintTmp, uint64(len(freeInodes)))])
+	for _, ino := range freeInodes {
+		buff.Write(varintTmp[:binary.PutUvarint(varintTmp, ino)])
+	}
+	buff.Write(varintTmp[:binary.PutUvarint(varintTmp, cursor)])
+	binary.Write(buff, binary.BigEndian, inodeCRC)
+	binary.Write(buff, binary.BigEndian, crc32.ChecksumIEEE(buff.Bytes()))
+	if err = ioutil.WriteFile(path.Join(rootDir, warmFile), buff.Bytes(), 0755); err != nil {
+		return
+	}
+	mp.phaseInfof(snapshotPhaseStore, "storeWarmState: store complete: partitionID(%v) volume(%v) numFreeInodes(%v) cursor(%v)",
+		mp.config.PartitionId, mp.config.VolName, len(freeInodes), cursor)
+	return
+}
+
+// loadWarmState returns the warm state in rootDir if it exists, its CRC is valid and it matches the inode file
+// recorded in the sign file. Otherwise nil is returned and the load recomputes the free list and cursor.
+func (mp *metaPartition) loadWarmState(rootDir string) (warm *warmState) {
+	data, err := ioutil.ReadFile(path.Join(rootDir, warmFile))
+	if err != nil {
+		return nil
+	}
+	defer func() {
+		if err != nil {
+			log.LogWarnf("loadWarmState: ignore warm state: partitionID(%v) volume(%v) err(%v)",
+				mp.config.PartitionId, mp.config.VolName, err)
+			warm = nil
+		}
+	}()
+	if len(data) < 8 {
+		err = errors.NewErrorf("warm file too short: size(%v)", len(data))
+		return
+	}
+	body := data[:len(data)-4]
+	if crc := binary.BigEndian.Uint32(data[len(data)-4:]); crc != crc32.ChecksumIEEE(body) {
+		err = errors.NewErrorf("warm file crc mismatch: expect(%v) actual(%v)", crc, crc32.ChecksumIEEE(body))
+		return
+	}
+	warm = &warmState{
+		inodeCRC: binary.BigEndian.Uint32(body[len(body)-4:]),
+	}
+	reader := bytes.NewReader(body[:len(body)-4])
+	var count uint64
+	if count, err = binary.ReadUvarint(reader); err != nil {
+		return
+	}
+	if count > uint64(reader.Len()) {
+		err = errors.NewErrorf("warm file count out of bounds: count(%v)", count)
+		return
+	}
+	warm.freeInodes = make([]uint64, count)
+	for i := range warm.freeInodes {
+		if warm.freeInodes[i], err = binary.ReadUvarint(reader); err != nil {
+			return
+		}
+	}
+	if warm.cursor, err = binary.ReadUvarint(reader); err != nil {
+		return
+	}
+	var crcs []uint32
+	if crcs, err = readSnapshotSign(rootDir); err != nil {
+		return
+	}
+	if crcs[0] != warm.inodeCRC {
+		err = errors.NewErrorf("warm state does not match inode file: expect(%v) actual(%v)", crcs[0], warm.inodeCRC)
+	}
+	return
+}
+
+// applyWarmState inserts the inodes of the warm state into the free list and advances the cursor.
+func (mp *metaPartition) applyWarmState(warm *warmState) {
+	for _, id := range warm.freeInodes {
+		if item := mp.inodeTree.Get(NewInode(id, 0)); item != nil {
+			mp.checkAndInsertFreeList(item.(*Inode))
+		}
+	}
+	mp.advanceCursor(warm.cursor)
+	log.LogInfof("applyWarmState: partitionID(%v) volume(%v) numFreeInodes(%v) cursor(%v)",
+		mp.config.PartitionId, mp.config.VolName, len(warm.freeInodes), warm.cursor)
+}
+
+// inodeRangeStat collects the inodes loaded from a snapshot which are outside the range of the partition.
+type inodeRangeStat struct {
+	count uint64
+	min   uint64
+	max   uint64
+}
+
+func (s *inodeRangeStat) add(ino uint64) {
+	if s.count == 0 || ino < s.min {
+		s.min = ino
+	}
+	if ino > s.max {
+		s.max = ino
+	}
+	s.count++
+}
+
+func (s *inodeRangeStat) report(config *MetaPartitionConfig) {
+	if s.count == 0 {
+		return
+	}
+	log.LogWarnf("loadInode: inodes out of range: partitionID(%v) volume(%v) range(%v,%v) count(%v) min(%v) max(%v) strict(%v)",
+		config.PartitionId, config.VolName, config.Start, config.End, s.count, s.min, s.max, config.Snapshot.StrictInodeRange)
+}
@@ -0,0 +1,110 @@
+// Copyright 2018 The Chubao Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metanode
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/chubaofs/chubaofs/util/errors"
+	"github.com/chubaofs/chubaofs/util/log"
+)
+
+// A checkpoint is kept for rollback, and is only ever loaded or verified: the files which only speed up the
+// reads of a live snapshot are dead weight in it. Once older than SnapshotConfig.AgeCheckpointsAfter, it is
+// aged: these files are removed, and its manifest records it. The active snapshot is never aged.
+
+// agedSnapshotFiles are the files an aged snapshot does without: it still loads into the same partition.
+var agedSnapshotFiles = []string{inodeIndexFile, dentryIndexFile, warmFile, inodeColumnsDir}
+
+// AgeSnapshot removes the files of the snapshot in rootDir which are not needed to load it, and marks its
+// manifest as aged, so tooling knows the indexes, the warm state and the inode columns are gone. It returns
+// the number of bytes freed. The data files, the sign file and the HMAC are unchanged. A snapshot without
+// a manifest can not record it, and is not aged.
+func AgeSnapshot(rootDir string) (freed int64, err error) {
+	manifest, err := readSnapshotManifest(rootDir)
+	if err != nil {
+		return
+	}
+	if manifest == nil {
+		return 0, errors.NewErrorf("[AgeSnapshot] no manifest: dir(%v)", rootDir)
+	}
+	if manifest.Aged {
+		return
+	}
+	// the manifest is written first: a snapshot it marks as aged may only miss files it can do without
+	manifest.Aged = true
+	if manifest.Settings != nil {
+		manifest.Settings.InodeIndex, manifest.Settings.DentryIndex = false, false
+	}
+	if err = writeSnapshotManifest(rootDir, manifest); err != nil {
+		return 0, errors.NewErrorf("[AgeSnapshot] write manifest: %s", err.Error())
+	}
+	for _, name := range agedSnapshotFiles {
+		filename := path.Join(rootDir, name)
+		size := diskUsage(filename)
+		if err = os.RemoveAll(filename); err != nil {
+			return freed, errors.NewErrorf("[AgeSnapshot] remove %v: %s", name, err.Error())
+		}
+		freed += size
+	}
+	log.LogInfof("AgeSnapshot: snapshot aged: dir(%v) applyID(%v) freed(%v)", rootDir, manifest.ApplyID, freed)
+	return
+}
+
+// diskUsage returns the size of the file, or of the files of the directory, zero if it does not exist.
+func diskUsage(filename string) (size int64) {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return
+	}
+	if !info.IsDir() {
+		return info.Size()
+	}
+	files, _ := ioutil.ReadDir(filename)
+	for _, file := range files {
+		size += file.Size()
+	}
+	return
+}
+
+// ageCheckpoints ages the checkpoints of the partition stored more than SnapshotConfig.AgeCheckpointsAfter ago.
+func (mp *metaPartition) ageCheckpoints() {
+	after := mp.config.Snapshot.AgeCheckpointsAfter
+	if after <= 0 {
+		return
+	}
+	infos, err := ioutil.ReadDir(mp.config.RootDir)
+	if err != nil {
+		return
+	}
+	for _, info := range infos {
+		if !info.IsDir() || !strings.HasPrefix(info.Name(), checkpointPrefix) {
+			continue
+		}
+		dir := path.Join(mp.config.RootDir, info.Name())
+		manifest, err := readSnapshotManifest(dir)
+		if err != nil || manifest == nil || manifest.Aged || time.Since(time.Unix(manifest.StoreTime, 0)) < after {
+			continue
+		}
+		if _, err = AgeSnapshot(dir); err != nil {
+			log.LogWarnf("ageCheckpoints: age failed: partitionID(%v) volume(%v) dir(%v) err(%v)",
+				mp.config.PartitionId, mp.config.VolName, dir, err)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright 2018 The Chubao Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metanode
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestAgeCheckpoints(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metanode_age")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mp := newFixturePartition(dir)
+	mp.config.Snapshot.InodeIndex, mp.config.Snapshot.DentryIndex = true, true
+	mp.config.Snapshot.WarmSnapshot, mp.config.Snapshot.InodeColumns = true, true
+	handle, err := mp.Checkpoint("old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range agedSnapshotFiles {
+		if _, err = os.Stat(path.Join(handle.Dir, name)); err != nil {
+			t.Fatalf("checkpoint without %v: %v", name, err)
+		}
+	}
+	// not old enough yet
+	mp.config.Snapshot.AgeCheckpointsAfter = time.Hour
+	mp.ageCheckpoints()
+	if manifest, _ := readSnapshotManifest(handle.Dir); manifest.Aged {
+		t.Fatal("young checkpoint aged")
+	}
+	mp.config.Snapshot.AgeCheckpointsAfter = time.Nanosecond
+	mp.ageCheckpoints()
+	if manifest, _ := readSnapshotManifest(handle.Dir); !manifest.Aged || manifest.Settings.InodeIndex {
+		t.Fatalf("checkpoint not aged: %+v", manifest)
+	}
+	for _, name := range agedSnapshotFiles {
+		if _, err = os.Stat(path.Join(handle.Dir, name)); !os.IsNotExist(err) {
+			t.Fatalf("aged checkpoint keeps %v", name)
+		}
+	}
+	if err = VerifySnapshot(handle.Dir); err != nil {
+		t.Fatal(err)
+	}
+	loaded := NewMetaPartition(&MetaPartitionConfig{PartitionId: 1, Start: 1, End: 1000}, nil).(*metaPartition)
+	if err = loaded.LoadSnapshot(handle.Dir); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.inodeTree.Len() != mp.inodeTree.Len() || loaded.dentryTree.Len() != mp.dentryTree.Len() {
+		t.Fatal("aged checkpoint loaded different trees")
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright 2018 The Chubao Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metanode
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestInodeColumns(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metanode_columns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mp := newFixturePartition(dir)
+	mp.config.Snapshot.InodeColumns = true
+	mp.config.Snapshot.GroupInodesByType = true
+	if err = mp.storeToDir(dir, capturedStoreMsg(mp)); err != nil {
+		t.Fatal(err)
+	}
+	exported := path.Join(dir, "exported")
+	if err = ExportInodeColumns(dir, exported); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(path.Join(dir, inodeColumnsDir, inodeColumnsSchema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := &InodeColumnsSchema{}
+	if err = json.Unmarshal(data, schema); err != nil || schema.Rows != 4 {
+		t.Fatalf("schema: %s %v", data, err)
+	}
+	sizes, _ := ioutil.ReadFile(path.Join(dir, inodeColumnsDir, "size"))
+	if len(sizes) != 4*8 || binary.LittleEndian.Uint64(sizes[8:]) != 8192 {
+		t.Fatalf("size column: %v", sizes)
+	}
+	// the export of the grouped inode file holds the same values, in the order of the file
+	inodes, _ := ioutil.ReadFile(path.Join(exported, "inode"))
+	extents, _ := ioutil.ReadFile(path.Join(exported, "extents"))
+	for i := 0; i < 4; i++ {
+		if binary.LittleEndian.Uint64(inodes[i*8:]) == 2 && binary.LittleEndian.Uint32(extents[i*4:]) != 2 {
+			t.Fatalf("extents of inode 2: %v", extents)
+		}
+	}
+	if err = VerifySnapshot(dir); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright 2018 The Chubao Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metanode
+
+import (
+	"path"
+	"sync"
+	"time"
+)
+
+// weight of the latest store in the moving average of the store throughput
+const storeThroughputWeight = 0.3
+
+// StoreCost is the estimated cost of storing a snapshot.
+type StoreCost struct {
+	// estimated size of each data file and of all of them
+	FileBytes  map[string]int64
+	TotalBytes int64
+	// estimated duration of the store, zero if no store of the partition has been measured yet
+	Duration time.Duration
+}
+
+type storeFileStat struct {
+	size       int64
+	count      uint64
+	throughput float64 // bytes per second
+}
+
+// storeCostModel keeps the size, the number of records and the moving average of the throughput of each
+// data file stored by the partition.
+type storeCostModel struct {
+	sync.Mutex
+	files map[string]*storeFileStat
+}
+
+func (m *storeCostModel) observe(name string, size int64, count uint64, elapsed time.Duration) {
+	m.Lock()
+	defer m.Unlock()
+	if m.files == nil {
+		m.files = make(map[string]*storeFileStat)
+	}
+	stat, ok := m.files[name]
+	if !ok {
+		stat = &storeFileStat{}
+		m.files[name] = stat
+	}
+	stat.size, stat.count = size, count
+	if elapsed <= 0 {
+		return
+	}
+	throughput := float64(size) / elapsed.Seconds()
+	if stat.throughput == 0 {
+		stat.throughput = throughput
+	} else {
+		stat.throughput = storeThroughputWeight*throughput + (1-storeThroughputWeight)*stat.throughput
+	}
+}
+
+func storeMsgCounts(sm *storeMsg) []uint64 {
+	return []uint64{
+		uint64(sm.inodeTree.Len()),
+		uint64(sm.dentryTree.Len()),
+		uint64(sm.extendTree.Len()),
+		uint64(sm.multipartTree.Len()),
+	}
+}
+
+// EstimateStoreCost estimates the bytes and the duration of storing the given snapshot. The size of each
+// data file is scaled from the bytes per record of the last store, or of the manifest of the active snapshot
+// after a restart, and the duration comes from the moving average of the throughput of the past stores.
+// It does no IO besides reading the manifest once, so a scheduler can call it before every store.
+func (mp *metaPartition) EstimateStoreCost(sm *storeMsg) *StoreCost {
+	cost := &StoreCost{FileBytes: make(map[string]int64)}
+	model := &mp.storeCost
+	model.Lock()
+	if model.files == nil {
+		model.files = make(map[string]*storeFileStat)
+		dir := path.Join(mp.config.RootDir, mp.config.Snapshot.dirName())
+		if manifest, err := readSnapshotManifest(dir); err == nil && manifest != nil {
+			for _, c := range manifest.Components {
+				model.files[c.Name] = &storeFileStat{size: c.Size, count: c.Count}
+			}
+		}
+	}
+	counts := storeMsgCounts(sm)
+	var seconds float64
+	for i, name := range snapshotDataFiles {
+		stat, ok := model.files[name]
+		if !ok || stat.count == 0 {
+			continue
+		}
+		bytes := int64(float64(stat.size) / float64(stat.count) * float64(counts[i]))
+		cost.FileBytes[name] = bytes
+		cost.TotalBytes += bytes
+		if stat.throughput > 0 {
+			seconds += float64(bytes) / stat.throughput
+		}
+	}
+	model.Unlock()
+	cost.Duration = time.Duration(seconds * float64(time.Second))
+	return cost
+}
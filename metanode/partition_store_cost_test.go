@@ -0,0 +1,74 @@
+// Copyright 2018 The Chubao Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metanode
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+// TestEstimateStoreCost estimates the cost of a store before any store, after a store and after a restart,
+// and expects the estimate to match the stored files and to scale with the number of records.
+func TestEstimateStoreCost(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metanode_store_cost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mp := newFixturePartition(dir)
+	sm := capturedStoreMsg(mp)
+	if cost := mp.EstimateStoreCost(sm); cost.TotalBytes != 0 || cost.Duration != 0 {
+		t.Fatalf("estimate without any store: %+v", cost)
+	}
+	if err = mp.store(sm); err != nil {
+		t.Fatal(err)
+	}
+	sizes := make(map[string]int64)
+	var total int64
+	for _, name := range snapshotDataFiles {
+		info, err := os.Stat(path.Join(dir, snapshotDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() != 0 {
+			sizes[name] = info.Size()
+			total += info.Size()
+		}
+	}
+	cost := mp.EstimateStoreCost(sm)
+	if cost.TotalBytes != total || cost.Duration <= 0 {
+		t.Fatalf("estimate after a store: %+v, want %v bytes", cost, total)
+	}
+	for name, size := range sizes {
+		if cost.FileBytes[name] != size {
+			t.Errorf("file %v: estimate %v, want %v", name, cost.FileBytes[name], size)
+		}
+	}
+
+	// after a restart the sizes come from the manifest, and there is no throughput yet
+	restarted := newFixturePartition(dir)
+	if cost = restarted.EstimateStoreCost(sm); cost.TotalBytes != total || cost.Duration != 0 {
+		t.Fatalf("estimate after a restart: %+v, want %v bytes", cost, total)
+	}
+	inodes := mp.inodeTree.Len()
+	for i := 0; i < inodes; i++ {
+		restarted.inodeTree.ReplaceOrInsert(NewInode(uint64(100+i), 0644), true)
+	}
+	if cost = restarted.EstimateStoreCost(capturedStoreMsg(restarted)); cost.FileBytes[inodeFile] != 2*sizes[inodeFile] {
+		t.Fatalf("inode estimate %v for twice the inodes of %v bytes", cost.FileBytes[inodeFile], sizes[inodeFile])
+	}
+}
@@ -0,0 +1,53 @@
+// Copyright 2018 The Chubao Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metanode
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestDecodeSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metanode_decode")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mp := newFixturePartition(dir)
+	if err = mp.storeToDir(dir, capturedStoreMsg(mp)); err != nil {
+		t.Fatal(err)
+	}
+	trees, err := DecodeSnapshot(dir, SnapshotConfig{LazyLoad: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if trees.ApplyID != 100 || trees.Cursor != 4 || trees.Inodes.Len() != 4 || trees.Dentries.Len() != 2 ||
+		trees.Extends.Len() != 1 || trees.Multiparts.Len() != 1 {
+		t.Fatalf("decoded: %+v", trees)
+	}
+	if ino := trees.Inodes.Get(NewInode(2, 0)); ino == nil || ino.(*Inode).Extents.Len() != 2 {
+		t.Fatalf("decoded inode 2: %v", ino)
+	}
+	// a data file which does not match the sign file is not decoded
+	fp, _ := os.OpenFile(path.Join(dir, dentryFile), os.O_WRONLY|os.O_APPEND, 0)
+	fp.Write([]byte{0})
+	fp.Close()
+	if _, err = DecodeSnapshot(dir, SnapshotConfig{}); err == nil || !strings.Contains(err.Error(), "crc mismatch") {
+		t.Fatalf("decode of a corrupted snapshot: %v", err)
+	}
+}
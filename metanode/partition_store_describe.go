@@ -0,0 +1,139 @@
+// Copyright 2018 The Chubao Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metanode
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"path"
+
+	"github.com/chubaofs/chubaofs/util/errors"
+)
+
+// the encoding of the snapshot files, which is fixed: the files carry no version header
+const (
+	snapshotChecksum = "crc32-ieee"
+	snapshotCodec    = "none"
+)
+
+// SnapshotDescription is what DescribeSnapshot tells about a snapshot directory.
+type SnapshotDescription struct {
+	Dir         string                     `json:"dir"`
+	Checksum    string                     `json:"checksum"`
+	Codec       string                     `json:"codec"`
+	ApplyID     uint64                     `json:"apply_id"`
+	Cursor      uint64                     `json:"cursor"`
+	StoreTime   int64                      `json:"store_time"` // zero without a manifest
+	HasManifest bool                       `json:"has_manifest"`
+	Settings    *manifestSettings          `json:"settings,omitempty"` // nil if the manifest does not record them
+	ColdDir     string                     `json:"cold_dir,omitempty"` // of a snapshot moved to the cold directory
+	Files       []*SnapshotFileDescription `json:"files"`
+}
+
+// SnapshotFileDescription describes a file of a snapshot. Count is -1 when the number of records
+// can not be told without decoding them. CRC is only set for the data files.
+type SnapshotFileDescription struct {
+	Name  string `json:"name"`
+	Size  int64  `json:"size"`
+	Count int64  `json:"count"`
+	CRC   uint32 `json:"crc"`
+}
+
+// DescribeSnapshot describes the snapshot in rootDir from its manifest, sign file, apply file and file sizes,
+// without decoding any record. Nothing in rootDir is written, so it works on a read-only copy.
+// It is the cheap check to run before the full VerifySnapshot.
+func DescribeSnapshot(rootDir string) (desc *SnapshotDescription, err error) {
+	snap, err := openSnapshotDir(rootDir)
+	if err != nil {
+		return nil, errors.NewErrorf("[DescribeSnapshot] %s", err.Error())
+	}
+	defer snap.Close()
+	return describeSnapshot(snap)
+}
+
+// describeSnapshot does what DescribeSnapshot does on the opened files of snap.
+func describeSnapshot(snap *ActiveSnapshot) (desc *SnapshotDescription, err error) {
+	desc = &SnapshotDescription{Dir: snap.Dir, Checksum: snapshotChecksum, Codec: snapshotCodec}
+	manifest, err := snap.readManifest()
+	if err != nil {
+		return nil, errors.NewErrorf("[DescribeSnapshot] %s", err.Error())
+	}
+	counts := make(map[string]int64)
+	if manifest != nil {
+		desc.HasManifest = true
+		desc.ApplyID = manifest.ApplyID
+		desc.StoreTime = manifest.StoreTime
+		desc.Settings = manifest.Settings
+		desc.ColdDir = manifest.ColdDir
+		for _, c := range manifest.Components {
+			counts[c.Name] = int64(c.Count)
+		}
+	}
+	crcs := make(map[string]uint32)
+	if signs, signErr := snap.readSign(); signErr == nil {
+		for i, name := range snapshotDataFiles {
+			crcs[name] = signs[i]
+		}
+	}
+	if data, readErr := snap.readFile(applyIDFile); readErr == nil {
+		// the apply file wins over the manifest, as on load
+		if _, err = fmt.Sscanf(string(data), "%d|%d", &desc.ApplyID, &desc.Cursor); err != nil {
+			if _, err = fmt.Sscanf(string(data), "%d", &desc.ApplyID); err != nil {
+				return nil, errors.NewErrorf("[DescribeSnapshot] ReadApplyID: %s", err.Error())
+			}
+		}
+	}
+	for _, name := range snapshotStreamFiles {
+		r, openErr := snap.open(name)
+		if openErr != nil {
+			continue
+		}
+		file := &SnapshotFileDescription{Name: name, Size: r.Size(), Count: -1, CRC: crcs[name]}
+		if count, ok := counts[name]; ok {
+			file.Count = count
+		} else if name == extendFile || name == multipartFile {
+			// these files start with the number of their records
+			if file.Count, err = readRecordCount(path.Join(snap.Dir, name), r); err != nil {
+				return nil, errors.NewErrorf("[DescribeSnapshot] %s", err.Error())
+			}
+		}
+		desc.Files = append(desc.Files, file)
+	}
+	if len(desc.Files) == 0 {
+		return nil, errors.NewErrorf("[DescribeSnapshot] no snapshot files in %v", snap.Dir)
+	}
+	return
+}
+
+// readRecordCount reads the record count at the head of an extend or multipart file from r.
+func readRecordCount(filename string, r io.Reader) (count int64, err error) {
+	reader := bufio.NewReaderSize(r, binary.MaxVarintLen64)
+	if head, _ := reader.Peek(len(extendDedupMarker)); isExtendDedup(head) {
+		// the number of extends follows the deduplicated attributes
+		return -1, nil
+	}
+	n, err := binary.ReadUvarint(reader)
+	if err == io.EOF {
+		return 0, nil
+	}
+	if err != nil {
+		err = fmt.Errorf("read count: filename(%v): %v", filename, err)
+		return
+	}
+	count = int64(n)
+	return
+}
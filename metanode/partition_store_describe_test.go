@@ -0,0 +1,66 @@
+// Copyright 2018 The Chubao Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metanode
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestDescribeSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metanode_describe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mp := newFixturePartition(dir)
+	if err = mp.storeToDir(dir, capturedStoreMsg(mp)); err != nil {
+		t.Fatal(err)
+	}
+	crcs, err := readSnapshotSign(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	desc, err := DescribeSnapshot(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if desc.ApplyID != mp.applyID || !desc.HasManifest || desc.StoreTime == 0 {
+		t.Fatalf("unexpected description: %+v", desc)
+	}
+	expect := map[string]int64{inodeFile: 4, dentryFile: 2, extendFile: 1, multipartFile: 1}
+	for _, file := range desc.Files {
+		for i, name := range snapshotDataFiles {
+			if file.Name == name && (file.Count != expect[name] || file.CRC != crcs[i]) {
+				t.Errorf("%v: count(%v) crc(%v), want count(%v) crc(%v)", name, file.Count, file.CRC, expect[name], crcs[i])
+			}
+		}
+	}
+
+	// without a manifest, only the extend and multipart files can tell their counts
+	if err = os.Remove(path.Join(dir, manifestFile)); err != nil {
+		t.Fatal(err)
+	}
+	if desc, err = DescribeSnapshot(dir); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range desc.Files {
+		if file.Name == extendFile && file.Count != 1 || file.Name == inodeFile && file.Count != -1 {
+			t.Errorf("%v: count(%v) without manifest", file.Name, file.Count)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright 2018 The Chubao Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metanode
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestExportSnapshotFilter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metanode_export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mp := newFixturePartition(dir)
+	mp.config.Snapshot.InodeIndex = true
+	export := path.Join(dir, "export")
+	// drop the file, its extend and its dentry, and the dentry of the link
+	filter := InodeRangeFilter([2]uint64{2, 2})
+	filter.DropDentry = func(d *Dentry) bool { return d.Name == "link" }
+	if err = mp.ExportSnapshot(export, filter); err != nil {
+		t.Fatal(err)
+	}
+	if err = VerifySnapshot(export); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = checkSnapshotSign(export); err != nil {
+		t.Fatal(err)
+	}
+	if inodes, _ := ListInodeNumbers(export); fmt.Sprint(inodes) != "[1 3 4]" {
+		t.Fatalf("exported inodes: %v", inodes)
+	}
+	loaded := NewMetaPartition(&MetaPartitionConfig{PartitionId: 1, RootDir: dir, Start: 1, End: 1000}, nil).(*metaPartition)
+	if err = loaded.LoadSnapshot(export); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.inodeTree.Len() != 3 || loaded.dentryTree.Len() != 0 || loaded.extendTree.Len() != 0 ||
+		loaded.multipartTree.Len() != 1 {
+		t.Fatalf("exported records: inodes(%v) dentries(%v) extends(%v) multiparts(%v)", loaded.inodeTree.Len(),
+			loaded.dentryTree.Len(), loaded.extendTree.Len(), loaded.multipartTree.Len())
+	}
+	if mp.inodeTree.Len() != 4 || mp.dentryTree.Len() != 2 || mp.extendTree.Len() != 1 {
+		t.Fatalf("export changed the partition")
+	}
+}
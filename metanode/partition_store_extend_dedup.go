@@ -0,0 +1,259 @@
+// Copyright 2018 The Chubao Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metanode
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"io"
+
+	"github.com/chubaofs/chubaofs/util/errors"
+)
+
+// extendDedupMarker starts an extend file stored with the ExtendDedup option. An extend file in the plain
+// format holding no extend is the single byte 0, so no plain file longer than that starts with the marker,
+// and a loader which does not know the marker fails on the trailing bytes instead of loading no extend.
+var extendDedupMarker = []byte{0, 'D'}
+
+func isExtendDedup(head []byte) bool {
+	return bytes.HasPrefix(head, extendDedupMarker)
+}
+
+// splitExtendBytes splits the encoding of an extend into its inode and its attributes, which are the same
+// bytes for the same attributes whatever the inode, as the keys are encoded in order.
+func splitExtendBytes(raw []byte) (inode uint64, attrs []byte, err error) {
+	var n int
+	if inode, n = binary.Uvarint(raw); n <= 0 {
+		return 0, nil, fmt.Errorf("invalid extend inode")
+	}
+	return inode, raw[n:], nil
+}
+
+// joinExtendBytes builds the encoding of the extend of the inode with the given attributes.
+func joinExtendBytes(buf []byte, inode uint64, attrs []byte) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], inode)
+	return append(append(buf[:0], tmp[:n]...), attrs...)
+}
+
+// writeExtendDedup writes the extends of the tree with each distinct set of attributes written once.
+// Deduplicated extend file structure:
+//  +-------+--------+----------+---------------+-----+----------+----------------+-----+
+//  | item  | Marker | NumAttrs | Len|Attrs     | ... | NumExtds | Inode|AttrsIdx | ... |
+//  +-------+--------+----------+---------------+-----+----------+----------------+-----+
+//  | bytes |   2    |  varint  | varint|Len    | ... |  varint  | varint|varint  | ... |
+//  +-------+--------+----------+---------------+-----+----------+----------------+-----+
+// The extends come in inode order, and the attributes in the order of their first extend.
+func (mp *metaPartition) writeExtendDedup(writer io.Writer, sign hash.Hash32, syncer *storeSyncer,
+	extendTree *BTree) (distinct int, err error) {
+	type extendRef struct {
+		inode uint64
+		index uint64
+	}
+	var (
+		indexes = make(map[string]uint64)
+		attrs   [][]byte
+		refs    = make([]extendRef, 0, extendTree.Len())
+	)
+	extendTree.Ascend(func(i BtreeItem) bool {
+		var raw []byte
+		if raw, err = i.(*Extend).Bytes(); err != nil {
+			return false
+		}
+		inode, attr, _ := splitExtendBytes(raw)
+		index, ok := indexes[string(attr)]
+		if !ok {
+			index = uint64(len(attrs))
+			indexes[string(attr)] = index
+			attrs = append(attrs, attr)
+		}
+		refs = append(refs, extendRef{inode: inode, index: index})
+		return true
+	})
+	if err != nil {
+		return
+	}
+	out := io.MultiWriter(writer, sign)
+	varintTmp := make([]byte, binary.MaxVarintLen64)
+	writeUvarint := func(v uint64) (int, error) {
+		return out.Write(varintTmp[:binary.PutUvarint(varintTmp, v)])
+	}
+	if _, err = out.Write(extendDedupMarker); err != nil {
+		return
+	}
+	if _, err = writeUvarint(uint64(len(attrs))); err != nil {
+		return
+	}
+	for _, attr := range attrs {
+		var n int
+		if n, err = writeUvarint(uint64(len(attr))); err != nil {
+			return
+		}
+		if _, err = out.Write(attr); err != nil {
+			return
+		}
+		if err = syncer.wrote(n + len(attr)); err != nil {
+			return
+		}
+	}
+	if _, err = writeUvarint(uint64(len(refs))); err != nil {
+		return
+	}
+	for _, ref := range refs {
+		var n, m int
+		if n, err = writeUvarint(ref.inode); err != nil {
+			return
+		}
+		if m, err = writeUvarint(ref.index); err != nil {
+			return
+		}
+		if err = syncer.wrote(n + m); err != nil {
+			return
+		}
+	}
+	return len(attrs), nil
+}
+
+// loadExtendDedup loads the extends of an extend file written by writeExtendDedup.
+func (mp *metaPartition) loadExtendDedup(filename string, mem *mmapWindow) (err error) {
+	var (
+		offset = int64(len(extendDedupMarker))
+		n      int
+		count  uint64
+		end    int64
+		raw    []byte // refers to the mapping
+		buf    []byte
+	)
+	readUvarint := func(what string) (v uint64, err error) {
+		if v, n, err = mem.uvarint(offset); err != nil {
+			return 0, errors.NewErrorf("[loadExtend] corrupted extend file: read %v failed: filename(%v) offset(%v) size(%v)",
+				what, filename, offset, mem.size)
+		}
+		offset += int64(n)
+		return
+	}
+	if count, err = readUvarint("attributes count"); err != nil {
+		return
+	}
+	// the attributes are copied out of the window, which moves on
+	attrs := make([][]byte, 0)
+	for i := uint64(0); i < count; i++ {
+		var length uint64
+		if length, err = readUvarint("attributes length"); err != nil {
+			return
+		}
+		if end, err = recordEnd(offset, length, mem.size); err != nil {
+			return errors.NewErrorf("[loadExtend] corrupted extend file: filename(%v): %s", filename, err.Error())
+		}
+		if raw, err = mem.bytes(offset, int(end-offset)); err != nil {
+			return errors.NewErrorf("[loadExtend] map extend attributes failed: filename(%v) offset(%v) length(%v): %s",
+				filename, offset, length, err.Error())
+		}
+		attrs = append(attrs, append([]byte(nil), raw...))
+		offset = end
+	}
+	if count, err = readUvarint("count"); err != nil {
+		return
+	}
+	for i := uint64(0); i < count; i++ {
+		var inode, index uint64
+		if inode, err = readUvarint("inode"); err != nil {
+			return
+		}
+		if index, err = readUvarint("attributes index"); err != nil {
+			return
+		}
+		if index >= uint64(len(attrs)) {
+			return errors.NewErrorf("[loadExtend] corrupted extend file: attributes index out of range: filename(%v) "+
+				"offset(%v) index(%v) attributes(%v)", filename, offset, index, len(attrs))
+		}
+		buf = joinExtendBytes(buf, inode, attrs[index])
+		var extend *Extend
+		if extend, err = NewExtendFromBytes(buf); err != nil {
+			return errors.NewErrorf("[loadExtend] corrupted extend file: filename(%v) inode(%v): %s",
+				filename, inode, err.Error())
+		}
+		_ = mp.fsmSetXAttr(extend)
+		mp.addResidentBytes(extendResidentBytes(extend))
+		mp.loadFeed.extend(extend)
+	}
+	if offset != mem.size {
+		return errors.NewErrorf("[loadExtend] corrupted extend file: trailing bytes after %v extends: filename(%v) offset(%v) size(%v)",
+			count, filename, offset, mem.size)
+	}
+	mp.phaseInfof(snapshotPhaseLoad, "loadExtend: load complete: partitionID(%v) volume(%v) numExtends(%v) attributes(%v) filename(%v)",
+		mp.config.PartitionId, mp.config.VolName, count, len(attrs), filename)
+	return nil
+}
+
+// verifyExtendRecords walks the records of an extend file in either format.
+func verifyExtendRecords(reader *bufio.Reader, size int64, decode func(raw []byte) error) (err error) {
+	if head, _ := reader.Peek(len(extendDedupMarker)); !isExtendDedup(head) {
+		return verifyCountedRecords(reader, size, decode)
+	}
+	counter := &countingByteReader{reader: reader}
+	if _, err = io.ReadFull(counter, make([]byte, len(extendDedupMarker))); err != nil {
+		return
+	}
+	var count uint64
+	if count, err = binary.ReadUvarint(counter); err != nil {
+		return fmt.Errorf("read attributes count: %v", err)
+	}
+	var attrs [][]byte
+	for i := uint64(0); i < count; i++ {
+		offset := counter.offset
+		var length uint64
+		if length, err = binary.ReadUvarint(counter); err != nil {
+			return fmt.Errorf("read length of attributes %v at offset %v: %v", i, offset, err)
+		}
+		if length > uint64(size-counter.offset) {
+			return fmt.Errorf("attributes %v out of bounds at offset %v: length(%v) size(%v)", i, offset, length, size)
+		}
+		attr := make([]byte, length)
+		if _, err = io.ReadFull(counter, attr); err != nil {
+			return fmt.Errorf("truncated attributes %v at offset %v: length(%v)", i, offset, length)
+		}
+		attrs = append(attrs, attr)
+	}
+	if count, err = binary.ReadUvarint(counter); err != nil {
+		return fmt.Errorf("read count: %v", err)
+	}
+	var raw []byte
+	for i := uint64(0); i < count; i++ {
+		offset := counter.offset
+		var inode, index uint64
+		if inode, err = binary.ReadUvarint(counter); err != nil {
+			return fmt.Errorf("read inode of record %v at offset %v: %v", i, offset, err)
+		}
+		if index, err = binary.ReadUvarint(counter); err != nil {
+			return fmt.Errorf("read attributes index of record %v at offset %v: %v", i, offset, err)
+		}
+		if index >= uint64(len(attrs)) {
+			return fmt.Errorf("attributes index of record %v out of range at offset %v: index(%v) attributes(%v)",
+				i, offset, index, len(attrs))
+		}
+		raw = joinExtendBytes(raw, inode, attrs[index])
+		if err = decode(raw); err != nil {
+			return fmt.Errorf("decode record %v at offset %v: %v", i, offset, err)
+		}
+	}
+	if counter.offset != size {
+		return fmt.Errorf("trailing bytes after %v records: offset(%v) size(%v)", count, counter.offset, size)
+	}
+	return nil
+}
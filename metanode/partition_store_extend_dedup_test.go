@@ -0,0 +1,80 @@
+// Copyright 2018 The Chubao Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metanode
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestExtendDedup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metanode_extend_dedup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mp := newFixturePartition(dir)
+	acl := bytes.Repeat([]byte("a"), 300)
+	for ino := uint64(10); ino < 200; ino++ {
+		extend := NewExtend(ino)
+		extend.Put([]byte("system.posix_acl_access"), acl)
+		if ino%50 == 0 {
+			extend.Put([]byte("user.key"), []byte(fmt.Sprintf("value%v", ino)))
+		}
+		mp.extendTree.ReplaceOrInsert(extend, true)
+	}
+	plain, dedup := path.Join(dir, "plain"), path.Join(dir, "dedup")
+	for _, d := range []string{plain, dedup} {
+		if err = os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+		mp.config.Snapshot.ExtendDedup = d == dedup
+		if err = mp.storeToDir(d, capturedStoreMsg(mp)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	plainInfo, _ := os.Stat(path.Join(plain, extendFile))
+	dedupInfo, _ := os.Stat(path.Join(dedup, extendFile))
+	if dedupInfo.Size()*10 > plainInfo.Size() {
+		t.Fatalf("extend file not deduplicated: plain(%v) dedup(%v)", plainInfo.Size(), dedupInfo.Size())
+	}
+	if report := CheckSnapshot(dedup); !report.OK || report.Files[2].Count != int64(mp.extendTree.Len()) {
+		t.Fatalf("verify deduplicated snapshot: %+v %+v", report, report.Files[2])
+	}
+	// a one-page window makes the attributes and the references straddle the window boundaries
+	loaded := NewMetaPartition(&MetaPartitionConfig{PartitionId: 1, RootDir: dir, Start: 1, End: 1000,
+		Snapshot: SnapshotConfig{ExtendMmapWindow: 1}}, nil).(*metaPartition)
+	if err = loaded.loadExtend(dedup); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.extendTree.Len() != mp.extendTree.Len() {
+		t.Fatalf("expect %v extends, got %v", mp.extendTree.Len(), loaded.extendTree.Len())
+	}
+	mp.extendTree.Ascend(func(i BtreeItem) bool {
+		expect, _ := i.(*Extend).Bytes()
+		item := loaded.extendTree.Get(i)
+		if item == nil {
+			t.Fatalf("extend of inode %v not loaded", i.(*Extend).inode)
+		}
+		if actual, _ := item.(*Extend).Bytes(); !bytes.Equal(expect, actual) {
+			t.Fatalf("extend of inode %v mismatch", i.(*Extend).inode)
+		}
+		return true
+	})
+}
@@ -0,0 +1,123 @@
+// Copyright 2018 The Chubao Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metanode
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+
+	"github.com/chubaofs/chubaofs/proto"
+	"github.com/chubaofs/chubaofs/util/errors"
+)
+
+// inodeGroupFile records where each group of inodes is in an inode file stored with grouped inodes.
+const inodeGroupFile = "inode_groups"
+
+// groups of inodes in their order in the inode file
+const (
+	InodeGroupDir     = "dir"
+	InodeGroupFile    = "file"
+	InodeGroupSymlink = "symlink"
+	InodeGroupOther   = "other"
+)
+
+var inodeGroups = []string{InodeGroupDir, InodeGroupFile, InodeGroupSymlink, InodeGroupOther}
+
+// inodeGroup is a contiguous range of the inode file holding the inodes of one type, in inode order.
+type inodeGroup struct {
+	Type   string `json:"type"`
+	Offset int64  `json:"offset"`
+	Length int64  `json:"length"`
+	Count  uint64 `json:"count"`
+}
+
+func inodeGroupOf(mode uint32) string {
+	switch {
+	case proto.IsDir(mode):
+		return InodeGroupDir
+	case proto.IsRegular(mode):
+		return InodeGroupFile
+	case proto.IsSymlink(mode):
+		return InodeGroupSymlink
+	default:
+		return InodeGroupOther
+	}
+}
+
+func storeInodeGroups(rootDir string, groups []*inodeGroup) (err error) {
+	var data []byte
+	if data, err = json.Marshal(groups); err != nil {
+		return
+	}
+	err = ioutil.WriteFile(path.Join(rootDir, inodeGroupFile), data, 0755)
+	return
+}
+
+// ScanInodeGroup calls fn for each inode of the given group in the snapshot in rootDir, in inode order,
+// until fn returns false. It only reads the part of the inode file holding the group, so the snapshot
+// must have been stored with grouped inodes.
+func ScanInodeGroup(rootDir, group string, fn func(ino *Inode) bool) (err error) {
+	data, err := ioutil.ReadFile(path.Join(rootDir, inodeGroupFile))
+	if err != nil {
+		return errors.NewErrorf("[ScanInodeGroup] inodes are not grouped: %s", err.Error())
+	}
+	var groups []*inodeGroup
+	if err = json.Unmarshal(data, &groups); err != nil {
+		return errors.NewErrorf("[ScanInodeGroup] Unmarshal: %s", err.Error())
+	}
+	var target *inodeGroup
+	for _, g := range groups {
+		if g.Type == group {
+			target = g
+		}
+	}
+	if target == nil {
+		return errors.NewErrorf("[ScanInodeGroup] unknown group: %v", group)
+	}
+	fp, err := os.Open(path.Join(rootDir, inodeFile))
+	if err != nil {
+		return errors.NewErrorf("[ScanInodeGroup] OpenFile: %s", err.Error())
+	}
+	defer fp.Close()
+	reader := bufio.NewReader(io.NewSectionReader(fp, target.Offset, target.Length))
+	inoBuf := make([]byte, 4)
+	for i := uint64(0); i < target.Count; i++ {
+		if _, err = io.ReadFull(reader, inoBuf[:4]); err != nil {
+			return errors.NewErrorf("[ScanInodeGroup] ReadHeader: %s", err.Error())
+		}
+		length := binary.BigEndian.Uint32(inoBuf)
+		if uint32(cap(inoBuf)) >= length {
+			inoBuf = inoBuf[:length]
+		} else {
+			inoBuf = make([]byte, length)
+		}
+		if _, err = io.ReadFull(reader, inoBuf); err != nil {
+			return errors.NewErrorf("[ScanInodeGroup] ReadBody: %s", err.Error())
+		}
+		ino := NewInode(0, 0)
+		if err = ino.Unmarshal(inoBuf); err != nil {
+			return errors.NewErrorf("[ScanInodeGroup] Unmarshal: %s", err.Error())
+		}
+		if !fn(ino) {
+			break
+		}
+	}
+	return
+}
@@ -0,0 +1,129 @@
+// Copyright 2018 The Chubao Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metanode
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"hash"
+	"io"
+	"os"
+	"path"
+
+	"github.com/chubaofs/chubaofs/util/errors"
+)
+
+// The CRCs of the sign file detect bit rot, but anyone able to edit a snapshot can recompute them.
+// The HMAC recorded in the manifest is keyed by a secret of the deployment, see SnapshotConfig.HMACKeyID,
+// so a snapshot edited without the secret fails its load.
+
+// manifestHMAC is the HMAC-SHA256 of the snapshot, and the ID of the key it was computed with.
+type manifestHMAC struct {
+	KeyID string `json:"key_id"`
+	Sum   string `json:"sum"`
+}
+
+// snapshotHMACFiles are the files covered by the HMAC, in order: everything the load reads into the partition.
+var snapshotHMACFiles = append(append([]string{}, snapshotDataFiles...), applyIDFile)
+
+// writeHMACTrailer ends the content of a file in the HMAC with its name and size, so the content of
+// a file can not be moved to another one. A missing file is covered as an empty one, as it is loaded.
+func writeHMACTrailer(mac hash.Hash, name string, size int64) {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, uint64(size))
+	mac.Write([]byte(name))
+	mac.Write(buf)
+}
+
+// checkHMAC checks that the key signing the snapshots is one of the configured keys.
+func (c *SnapshotConfig) checkHMAC() error {
+	if c.HMACKeyID != "" && c.HMACKeys[c.HMACKeyID] == "" {
+		return errors.NewErrorf("no snapshot HMAC key with ID %v", c.HMACKeyID)
+	}
+	return nil
+}
+
+// snapshotHMAC computes the HMAC of the snapshot in rootDir with the given key.
+func snapshotHMAC(rootDir, key string) (sum string, err error) {
+	mac := hmac.New(sha256.New, []byte(key))
+	for _, name := range snapshotHMACFiles {
+		var size int64
+		if size, err = hashSnapshotFile(mac, path.Join(rootDir, name)); err != nil {
+			return
+		}
+		writeHMACTrailer(mac, name, size)
+	}
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+func hashSnapshotFile(mac hash.Hash, filename string) (size int64, err error) {
+	fp, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return
+	}
+	defer fp.Close()
+	return io.Copy(mac, bufio.NewReaderSize(fp, 4*MB))
+}
+
+// signSnapshotManifest records in manifest the HMAC of the snapshot in rootDir, if snapshots are signed.
+func signSnapshotManifest(rootDir string, manifest *snapshotManifest, conf SnapshotConfig) (err error) {
+	if conf.HMACKeyID == "" {
+		return
+	}
+	sum, err := snapshotHMAC(rootDir, conf.HMACKeys[conf.HMACKeyID])
+	if err != nil {
+		return errors.NewErrorf("[signSnapshotManifest] %s", err.Error())
+	}
+	manifest.HMAC = &manifestHMAC{KeyID: conf.HMACKeyID, Sum: sum}
+	return
+}
+
+// checkSnapshotHMAC checks the HMAC of the snapshot in rootDir against the one of its manifest. Once snapshots
+// are signed, a snapshot without an HMAC fails, as stripping it would otherwise be enough to tamper with one.
+// A snapshot signed with a key which is no longer configured fails too: keep the former key while rotating.
+func checkSnapshotHMAC(rootDir string, conf SnapshotConfig) (err error) {
+	if conf.HMACKeyID == "" && len(conf.HMACKeys) == 0 {
+		return
+	}
+	manifest, err := readSnapshotManifest(rootDir)
+	if err != nil {
+		return
+	}
+	if manifest == nil || manifest.HMAC == nil {
+		if conf.HMACKeyID == "" {
+			return
+		}
+		return errors.NewErrorf("[checkSnapshotHMAC] unsigned snapshot: dir(%v)", rootDir)
+	}
+	key, ok := conf.HMACKeys[manifest.HMAC.KeyID]
+	if !ok {
+		return errors.NewErrorf("[checkSnapshotHMAC] unknown key: dir(%v) keyID(%v)", rootDir, manifest.HMAC.KeyID)
+	}
+	sum, err := snapshotHMAC(rootDir, key)
+	if err != nil {
+		return errors.NewErrorf("[checkSnapshotHMAC] %s", err.Error())
+	}
+	if !hmac.Equal([]byte(sum), []byte(manifest.HMAC.Sum)) {
+		return errors.NewErrorf("[checkSnapshotHMAC] HMAC mismatch, the snapshot was modified: dir(%v) keyID(%v)",
+			rootDir, manifest.HMAC.KeyID)
+	}
+	return
+}
@@ -0,0 +1,86 @@
+// Copyright 2018 The Chubao Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metanode
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestSnapshotHMAC(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metanode_hmac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mp := newFixturePartition(dir)
+	mp.config.Snapshot.HMACKeyID = "k1"
+	mp.config.Snapshot.HMACKeys = map[string]string{"k1": "secret"}
+	if err = mp.storeToDir(dir, capturedStoreMsg(mp)); err != nil {
+		t.Fatal(err)
+	}
+	load := func(conf SnapshotConfig) error {
+		loaded := NewMetaPartition(&MetaPartitionConfig{PartitionId: 1, Start: 1, End: 1000, Snapshot: conf}, nil).(*metaPartition)
+		return loaded.LoadSnapshot(dir)
+	}
+	if err = load(mp.config.Snapshot); err != nil {
+		t.Fatal(err)
+	}
+	// a snapshot signed with the former key loads while the key rotates
+	if err = load(SnapshotConfig{HMACKeyID: "k2", HMACKeys: map[string]string{"k1": "secret", "k2": "new"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err = load(SnapshotConfig{HMACKeyID: "k2", HMACKeys: map[string]string{"k2": "new"}}); err == nil {
+		t.Fatal("snapshot signed with an unknown key loaded")
+	}
+
+	// the stream is signed as the store is
+	stream := bytes.NewBuffer(nil)
+	if err = mp.StoreToStream(stream); err != nil {
+		t.Fatal(err)
+	}
+	received := path.Join(dir, "received")
+	if err = NewSnapshotReader(stream).ReadDir(received); err != nil {
+		t.Fatal(err)
+	}
+	stored, _ := readSnapshotManifest(dir)
+	streamed, _ := readSnapshotManifest(received)
+	if streamed.HMAC == nil || *streamed.HMAC != *stored.HMAC {
+		t.Fatalf("streamed HMAC(%v) stored HMAC(%v)", streamed.HMAC, stored.HMAC)
+	}
+
+	// an edit whose CRC is recomputed is still detected
+	data, _ := ioutil.ReadFile(path.Join(dir, applyIDFile))
+	if err = ioutil.WriteFile(path.Join(dir, applyIDFile), append([]byte("9"), data...), 0644); err != nil {
+		t.Fatal(err)
+	}
+	stored.ApplyID = 9100
+	writeSnapshotManifest(dir, stored)
+	if err = load(mp.config.Snapshot); err == nil || !strings.Contains(err.Error(), "HMAC mismatch") {
+		t.Fatalf("load of an edited snapshot: %v", err)
+	}
+	stored.HMAC = nil
+	writeSnapshotManifest(dir, stored)
+	if err = load(mp.config.Snapshot); err == nil || !strings.Contains(err.Error(), "unsigned snapshot") {
+		t.Fatalf("load of a stripped snapshot: %v", err)
+	}
+	if err = load(SnapshotConfig{}); err != nil {
+		t.Fatal(err)
+	}
+}
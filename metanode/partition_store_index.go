@@ -0,0 +1,336 @@
+// Copyright 2018 The Chubao Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metanode
+
+import (
+	"bufio"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"os"
+	"path"
+	"sort"
+
+	"github.com/chubaofs/chubaofs/util/errors"
+	mmap "github.com/edsrzf/mmap-go"
+)
+
+// inodeIndexFile maps each inode of the inode file to its record, so a single inode can be read without
+// scanning the file. dentryIndexFile maps each parent to the range of its dentries in the dentry file,
+// which is sorted by parent, so a directory can be listed without loading the other dentries.
+const (
+	inodeIndexFile  = "inode_index"
+	dentryIndexFile = "dentry_index"
+)
+
+const (
+	inodeIndexEntrySize  = 20
+	dentryIndexEntrySize = 32
+)
+
+var ErrInodeNotFound = errors.New("inode not found")
+
+// inodeIndexEntry locates the record of an inode, including its 4-byte length header, in the inode file.
+type inodeIndexEntry struct {
+	inode  uint64
+	offset int64
+	length uint32
+}
+
+// dentryIndexEntry locates the records of the dentries of a parent in the dentry file.
+type dentryIndexEntry struct {
+	parentID uint64
+	offset   int64
+	length   int64
+	count    uint64
+}
+
+// storeInodeIndex writes the index of the inode records, sorted by inode.
+// Inode index file structure:
+//  +-------+-------------------+-----+-------------------+-----+
+//  | item  | Inode|Offset|Len  | ... | Inode|Offset|Len  | CRC |
+//  +-------+-------------------+-----+-------------------+-----+
+//  | bytes |    8 |   8  |  4  | ... |    8 |   8  |  4  |  4  |
+//  +-------+-------------------+-----+-------------------+-----+
+// The CRC covers all the entries.
+func (mp *metaPartition) storeInodeIndex(rootDir string, entries []inodeIndexEntry) (err error) {
+	// grouped inodes are not stored in inode order
+	sort.Slice(entries, func(i, j int) bool { return entries[i].inode < entries[j].inode })
+	return mp.storeIndexFile(path.Join(rootDir, inodeIndexFile), len(entries), inodeIndexEntrySize,
+		func(i int, buf []byte) {
+			binary.BigEndian.PutUint64(buf[0:8], entries[i].inode)
+			binary.BigEndian.PutUint64(buf[8:16], uint64(entries[i].offset))
+			binary.BigEndian.PutUint32(buf[16:20], entries[i].length)
+		})
+}
+
+// storeDentryIndex writes the index of the dentries of each parent, in the order of the dentry file.
+// Dentry index file structure:
+//  +-------+-----------------------+-----+-----------------------+-----+
+//  | item  | Parent|Offset|Len|Cnt | ... | Parent|Offset|Len|Cnt | CRC |
+//  +-------+-----------------------+-----+-----------------------+-----+
+//  | bytes |    8  |   8  | 8 | 8  | ... |    8  |   8  | 8 | 8  |  4  |
+//  +-------+-----------------------+-----+-----------------------+-----+
+// The CRC covers all the entries.
+func (mp *metaPartition) storeDentryIndex(rootDir string, entries []dentryIndexEntry) (err error) {
+	return mp.storeIndexFile(path.Join(rootDir, dentryIndexFile), len(entries), dentryIndexEntrySize,
+		func(i int, buf []byte) {
+			binary.BigEndian.PutUint64(buf[0:8], entries[i].parentID)
+			binary.BigEndian.PutUint64(buf[8:16], uint64(entries[i].offset))
+			binary.BigEndian.PutUint64(buf[16:24], uint64(entries[i].length))
+			binary.BigEndian.PutUint64(buf[24:32], entries[i].count)
+		})
+}
+
+// storeIndexFile writes count entries of entrySize bytes encoded by encode, followed by their CRC.
+// The first 8 bytes of each entry are the key the entries are sorted by.
+func (mp *metaPartition) storeIndexFile(filename string, count, entrySize int, encode func(i int, buf []byte)) (err error) {
+	fp, err := os.OpenFile(filename, os.O_RDWR|os.O_TRUNC|os.O_CREATE, 0755)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if syncErr := mp.syncFile(fp); err == nil {
+			err = syncErr
+		}
+		fp.Close()
+	}()
+	writer := bufio.NewWriter(fp)
+	sign := crc32.NewIEEE()
+	buf := make([]byte, entrySize)
+	for i := 0; i < count; i++ {
+		encode(i, buf)
+		sign.Write(buf)
+		if _, err = writer.Write(buf); err != nil {
+			return
+		}
+	}
+	binary.BigEndian.PutUint32(buf[:4], sign.Sum32())
+	if _, err = writer.Write(buf[:4]); err != nil {
+		return
+	}
+	err = writer.Flush()
+	return
+}
+
+// searchIndexFile maps the index file and returns a copy of the entry with the given key,
+// or ErrInodeNotFound if there is none.
+func searchIndexFile(filename string, entrySize int, key uint64) (entry []byte, err error) {
+	fp, err := os.Open(filename)
+	if err != nil {
+		err = errors.NewErrorf("[searchIndexFile] index is not stored: %s", err.Error())
+		return
+	}
+	defer fp.Close()
+	var info os.FileInfo
+	if info, err = fp.Stat(); err != nil {
+		return
+	}
+	if info.Size() < 4 || (info.Size()-4)%int64(entrySize) != 0 {
+		err = errors.NewErrorf("[searchIndexFile] corrupted index: filename(%v) size(%v)", filename, info.Size())
+		return
+	}
+	count := int((info.Size() - 4) / int64(entrySize))
+	if count == 0 {
+		err = ErrInodeNotFound
+		return
+	}
+	var mem mmap.MMap
+	if mem, err = mmap.Map(fp, mmap.RDONLY, 0); err != nil {
+		return
+	}
+	defer mem.Unmap()
+	i := sort.Search(count, func(i int) bool {
+		return binary.BigEndian.Uint64(mem[i*entrySize:]) >= key
+	})
+	if i == count || binary.BigEndian.Uint64(mem[i*entrySize:]) != key {
+		err = ErrInodeNotFound
+		return
+	}
+	entry = make([]byte, entrySize)
+	copy(entry, mem[i*entrySize:])
+	return
+}
+
+// SeekInode reads the given inode from the snapshot in rootDir through its inode index, which is stored
+// with the storeInodeIndex option. Only the index is mapped, and a single record is read from the inode file.
+// It returns ErrInodeNotFound if the snapshot has no such inode.
+func SeekInode(rootDir string, inode uint64) (ino *Inode, err error) {
+	raw, err := searchIndexFile(path.Join(rootDir, inodeIndexFile), inodeIndexEntrySize, inode)
+	if err != nil {
+		return
+	}
+	entry := inodeIndexEntry{
+		inode:  inode,
+		offset: int64(binary.BigEndian.Uint64(raw[8:16])),
+		length: binary.BigEndian.Uint32(raw[16:20]),
+	}
+	fp, err := os.Open(path.Join(rootDir, inodeFile))
+	if err != nil {
+		return nil, errors.NewErrorf("[SeekInode] OpenFile: %s", err.Error())
+	}
+	defer fp.Close()
+	buf := make([]byte, entry.length)
+	if _, err = fp.ReadAt(buf, entry.offset); err != nil {
+		return nil, errors.NewErrorf("[SeekInode] ReadAt: inode(%v) offset(%v) length(%v): %s",
+			inode, entry.offset, entry.length, err.Error())
+	}
+	if length := binary.BigEndian.Uint32(buf[:4]); length != entry.length-4 {
+		return nil, errors.NewErrorf("[SeekInode] index does not match the inode file: inode(%v) offset(%v) "+
+			"length(%v) recordLength(%v)", inode, entry.offset, entry.length-4, length)
+	}
+	ino = NewInode(0, 0)
+	if err = ino.Unmarshal(buf[4:]); err != nil {
+		return nil, errors.NewErrorf("[SeekInode] Unmarshal: inode(%v) offset(%v): %s", inode, entry.offset, err.Error())
+	}
+	if ino.Inode != inode {
+		return nil, errors.NewErrorf("[SeekInode] index does not match the inode file: inode(%v) offset(%v) found(%v)",
+			inode, entry.offset, ino.Inode)
+	}
+	return
+}
+
+// ListInodeNumbers returns the numbers of the inodes of the snapshot in rootDir in ascending order. Only
+// the inode index, which is stored with the storeInodeIndex option, is read, so no inode is decoded.
+func ListInodeNumbers(rootDir string) (inodes []uint64, err error) {
+	err = RangeInodeNumbers(rootDir, func(inode uint64) bool {
+		inodes = append(inodes, inode)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// RangeInodeNumbers calls f with the numbers of the inodes of the snapshot in rootDir in ascending order,
+// until f returns false, reading the inode index as a stream, so the memory is bounded whatever the size
+// of the partition. The CRC of the index is only checked once all the numbers are read: if it does not
+// match, an error is returned after f has seen the numbers.
+func RangeInodeNumbers(rootDir string, f func(inode uint64) bool) (err error) {
+	filename := path.Join(rootDir, inodeIndexFile)
+	fp, err := os.Open(filename)
+	if err != nil {
+		return errors.NewErrorf("[RangeInodeNumbers] index is not stored: %s", err.Error())
+	}
+	defer fp.Close()
+	var info os.FileInfo
+	if info, err = fp.Stat(); err != nil {
+		return
+	}
+	if info.Size() < 4 || (info.Size()-4)%inodeIndexEntrySize != 0 {
+		return errors.NewErrorf("[RangeInodeNumbers] corrupted index: filename(%v) size(%v)", filename, info.Size())
+	}
+	count := (info.Size() - 4) / inodeIndexEntrySize
+	reader := bufio.NewReader(fp)
+	sign := crc32.NewIEEE()
+	buf := make([]byte, inodeIndexEntrySize)
+	for i := int64(0); i < count; i++ {
+		if _, err = io.ReadFull(reader, buf); err != nil {
+			return errors.NewErrorf("[RangeInodeNumbers] read index: filename(%v) entry(%v): %s",
+				filename, i, err.Error())
+		}
+		sign.Write(buf)
+		if !f(binary.BigEndian.Uint64(buf[0:8])) {
+			return
+		}
+	}
+	if _, err = io.ReadFull(reader, buf[:4]); err != nil {
+		return errors.NewErrorf("[RangeInodeNumbers] read CRC: filename(%v): %s", filename, err.Error())
+	}
+	if crc := sign.Sum32(); crc != binary.BigEndian.Uint32(buf[:4]) {
+		return errors.NewErrorf("[RangeInodeNumbers] index CRC mismatch: filename(%v) crc(%v) stored(%v)",
+			filename, crc, binary.BigEndian.Uint32(buf[:4]))
+	}
+	return
+}
+
+// ListChildren returns the dentries of the given parent from the snapshot in rootDir through its dentry index,
+// which is stored with the storeDentryIndex option. Only the dentries of the parent are read, so the memory
+// is bounded by the size of the directory. It returns no dentry if the parent has no child.
+func ListChildren(rootDir string, parentID uint64) (dentries []*Dentry, err error) {
+	raw, err := searchIndexFile(path.Join(rootDir, dentryIndexFile), dentryIndexEntrySize, parentID)
+	if err == ErrInodeNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return
+	}
+	entry := dentryIndexEntry{
+		parentID: parentID,
+		offset:   int64(binary.BigEndian.Uint64(raw[8:16])),
+		length:   int64(binary.BigEndian.Uint64(raw[16:24])),
+		count:    binary.BigEndian.Uint64(raw[24:32]),
+	}
+	fp, err := os.Open(path.Join(rootDir, dentryFile))
+	if err != nil {
+		return nil, errors.NewErrorf("[ListChildren] OpenFile: %s", err.Error())
+	}
+	defer fp.Close()
+	reader := bufio.NewReader(io.NewSectionReader(fp, entry.offset, entry.length))
+	dentries = make([]*Dentry, 0, int(entry.count))
+	lenBuf := make([]byte, 4)
+	for i := uint64(0); i < entry.count; i++ {
+		if _, err = io.ReadFull(reader, lenBuf); err != nil {
+			return nil, errors.NewErrorf("[ListChildren] ReadHeader: parentID(%v) offset(%v): %s",
+				parentID, entry.offset, err.Error())
+		}
+		buf := make([]byte, binary.BigEndian.Uint32(lenBuf))
+		if _, err = io.ReadFull(reader, buf); err != nil {
+			return nil, errors.NewErrorf("[ListChildren] ReadBody: parentID(%v) offset(%v): %s",
+				parentID, entry.offset, err.Error())
+		}
+		dentry := &Dentry{}
+		if err = dentry.Unmarshal(buf); err != nil {
+			return nil, errors.NewErrorf("[ListChildren] Unmarshal: parentID(%v) offset(%v): %s",
+				parentID, entry.offset, err.Error())
+		}
+		if dentry.ParentId != parentID {
+			return nil, errors.NewErrorf("[ListChildren] index does not match the dentry file: parentID(%v) "+
+				"offset(%v) found(%v)", parentID, entry.offset, dentry.ParentId)
+		}
+		dentries = append(dentries, dentry)
+	}
+	return
+}
+
+// verifyIndexFiles checks the CRCs of the opened inode and dentry indexes of snap, if there are.
+func verifyIndexFiles(snap *ActiveSnapshot) (err error) {
+	for _, index := range []struct {
+		name      string
+		entrySize int
+	}{
+		{inodeIndexFile, inodeIndexEntrySize},
+		{dentryIndexFile, dentryIndexEntrySize},
+	} {
+		filename := path.Join(snap.Dir, index.name)
+		var data []byte
+		if data, err = snap.readFile(index.name); os.IsNotExist(err) {
+			err = nil
+			continue
+		} else if err != nil {
+			return errors.NewErrorf("[VerifySnapshot] read index: filename(%v): %s", filename, err.Error())
+		}
+		if len(data) < 4 || (len(data)-4)%index.entrySize != 0 {
+			return errors.NewErrorf("[VerifySnapshot] corrupted index: filename(%v) size(%v)", filename, len(data))
+		}
+		body := data[:len(data)-4]
+		if crc := crc32.ChecksumIEEE(body); crc != binary.BigEndian.Uint32(data[len(body):]) {
+			return errors.NewErrorf("[VerifySnapshot] index CRC mismatch: filename(%v) crc(%v) stored(%v)",
+				filename, crc, binary.BigEndian.Uint32(data[len(body):]))
+		}
+	}
+	return
+}
@@ -0,0 +1,121 @@
+// Copyright 2018 The Chubao Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metanode
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/chubaofs/chubaofs/proto"
+)
+
+func TestListInodeNumbers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metanode_list_inodes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mp := newFixturePartition(dir)
+	mp.config.Snapshot.InodeIndex = true
+	if err = mp.storeToDir(dir, capturedStoreMsg(mp)); err != nil {
+		t.Fatal(err)
+	}
+	inodes, err := ListInodeNumbers(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(inodes) != "[1 2 3 4]" {
+		t.Fatalf("inode numbers: %v", inodes)
+	}
+	var first []uint64
+	if err = RangeInodeNumbers(dir, func(inode uint64) bool {
+		first = append(first, inode)
+		return len(first) < 2
+	}); err != nil || fmt.Sprint(first) != "[1 2]" {
+		t.Fatalf("stopped range: %v %v", first, err)
+	}
+}
+
+func TestSeekInode(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metanode_seek_inode")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mp := newFixturePartition(dir)
+	mp.config.Snapshot.InodeIndex = true
+	if err = mp.storeToDir(dir, capturedStoreMsg(mp)); err != nil {
+		t.Fatal(err)
+	}
+	for _, inode := range []uint64{1, 2, 3, 4} {
+		ino, err := SeekInode(dir, inode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expect := mp.inodeTree.Get(&Inode{Inode: inode}).(*Inode)
+		if ino.Inode != inode || ino.Size != expect.Size || ino.Extents.Size() != expect.Extents.Size() {
+			t.Fatalf("inode %v: got %+v", inode, ino)
+		}
+	}
+	for _, inode := range []uint64{0, 5, 1000} {
+		if _, err = SeekInode(dir, inode); err != ErrInodeNotFound {
+			t.Fatalf("inode %v: %v", inode, err)
+		}
+	}
+	if err = VerifySnapshot(dir); err != nil {
+		t.Fatal(err)
+	}
+	// an index which no longer matches the inode file is detected
+	plain := newFixturePartition(dir)
+	plain.inodeTree.Delete(&Inode{Inode: 1})
+	if _, err = plain.storeInode(dir, capturedStoreMsg(plain)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = SeekInode(dir, 2); err == nil {
+		t.Fatal("stale index not detected")
+	}
+}
+
+func TestListChildren(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metanode_list_children")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mp := newFixturePartition(dir)
+	mp.dentryTree.ReplaceOrInsert(&Dentry{ParentId: 3, Name: "child", Inode: 4, Type: proto.Mode(0644)}, true)
+	mp.config.Snapshot.DentryIndex = true
+	if err = mp.storeToDir(dir, capturedStoreMsg(mp)); err != nil {
+		t.Fatal(err)
+	}
+	for parent, expect := range map[uint64]string{1: "[file link]", 3: "[child]", 2: "[]"} {
+		dentries, err := ListChildren(dir, parent)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names := make([]string, 0, len(dentries))
+		for _, d := range dentries {
+			names = append(names, d.Name)
+		}
+		if fmt.Sprint(names) != expect {
+			t.Errorf("children of %v: %v, want %v", parent, names, expect)
+		}
+	}
+	if err = VerifySnapshot(dir); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright 2018 The Chubao Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metanode
+
+import (
+	"fmt"
+
+	"github.com/chubaofs/chubaofs/util/log"
+)
+
+// phases of the snapshot logs, see SnapshotConfig.LoadLogLevel and SnapshotConfig.StoreLogLevel
+const (
+	snapshotPhaseLoad  = "load"
+	snapshotPhaseStore = "store"
+)
+
+// log levels of the snapshot phases
+const (
+	SnapshotLogDebug = "debug"
+	SnapshotLogInfo  = "info"
+	SnapshotLogWarn  = "warn"
+)
+
+func (c SnapshotConfig) checkLogLevels() error {
+	for _, level := range []string{c.LoadLogLevel, c.StoreLogLevel} {
+		switch level {
+		case "", SnapshotLogDebug, SnapshotLogInfo, SnapshotLogWarn:
+		default:
+			return fmt.Errorf("unknown snapshot log level: %v", level)
+		}
+	}
+	return nil
+}
+
+// phaseLogLevel returns the log level of the phase for the partition.
+func (mp *metaPartition) phaseLogLevel(phase string) string {
+	conf := mp.config.Snapshot
+	for _, id := range conf.DebugPartitions {
+		if id == mp.config.PartitionId {
+			return SnapshotLogDebug
+		}
+	}
+	level := conf.LoadLogLevel
+	if phase == snapshotPhaseStore {
+		level = conf.StoreLogLevel
+	}
+	if level == "" {
+		return SnapshotLogInfo
+	}
+	return level
+}
+
+// phaseInfof logs the progress of a phase, unless the phase logs warnings only.
+func (mp *metaPartition) phaseInfof(phase, format string, v ...interface{}) {
+	if mp.phaseLogLevel(phase) == SnapshotLogWarn {
+		return
+	}
+	log.LogInfof(format, v...)
+}
+
+// phaseDebugf logs the details of a phase. They are logged at info level once the phase or the partition
+// logs at debug level, so they show without the debug logs of the whole node.
+func (mp *metaPartition) phaseDebugf(phase, format string, v ...interface{}) {
+	if mp.phaseLogLevel(phase) == SnapshotLogDebug {
+		log.LogInfof(format, v...)
+		return
+	}
+	log.LogDebugf(format, v...)
+}
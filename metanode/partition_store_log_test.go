@@ -0,0 +1,35 @@
+// Copyright 2018 The Chubao Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metanode
+
+import (
+	"testing"
+)
+
+func TestPhaseLogLevel(t *testing.T) {
+	conf := &MetaPartitionConfig{PartitionId: 7, Start: 1, End: 1000}
+	conf.Snapshot.LoadLogLevel = SnapshotLogWarn
+	mp := NewMetaPartition(conf, nil).(*metaPartition)
+	if mp.phaseLogLevel(snapshotPhaseLoad) != SnapshotLogWarn || mp.phaseLogLevel(snapshotPhaseStore) != SnapshotLogInfo {
+		t.Fatalf("levels: load(%v) store(%v)", mp.phaseLogLevel(snapshotPhaseLoad), mp.phaseLogLevel(snapshotPhaseStore))
+	}
+	mp.config.Snapshot.DebugPartitions = []uint64{3, 7}
+	if mp.phaseLogLevel(snapshotPhaseLoad) != SnapshotLogDebug || mp.phaseLogLevel(snapshotPhaseStore) != SnapshotLogDebug {
+		t.Fatal("debugged partition does not log at debug level")
+	}
+	if err := (SnapshotConfig{StoreLogLevel: "verbose"}).checkLogLevels(); err == nil {
+		t.Fatal("unknown level accepted")
+	}
+}
@@ -0,0 +1,331 @@
+// Copyright 2018 The Chubao Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metanode
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/chubaofs/chubaofs/util/errors"
+	"github.com/chubaofs/chubaofs/util/log"
+)
+
+const (
+	manifestFile    = "manifest"
+	manifestTmpFile = ".manifest"
+)
+
+// storeClock returns the store time recorded in the manifest. The manifest is metadata of the snapshot:
+// it is not covered by the sign file nor the digest, which only depend on the content of the data files.
+// Tests replace the clock to produce identical manifests.
+var storeClock = time.Now
+
+// snapshotManifest describes a snapshot set. It records the size of each component, so a snapshot
+// assembled from the files of different stores can be detected on load.
+type snapshotManifest struct {
+	ApplyID    uint64               `json:"apply_id"`
+	StoreTime  int64                `json:"store_time"`
+	Components []*manifestComponent `json:"components"`
+	Settings   *manifestSettings    `json:"settings,omitempty"`
+	HMAC       *manifestHMAC        `json:"hmac,omitempty"`
+	// CRCs of the data files as stored with the default settings, see SnapshotDigest
+	CanonicalCRCs []uint32 `json:"canonical_crcs,omitempty"`
+	// the snapshot was aged by AgeSnapshot: it has no index, warm state nor inode columns
+	Aged bool `json:"aged,omitempty"`
+	// the cold directory holding the files, empty while they are in the partition directory, see SnapshotConfig.ColdDir
+	ColdDir string `json:"cold_dir,omitempty"`
+}
+
+// manifestSettings are the store settings the snapshot was stored with, after the overrides of the volume.
+type manifestSettings struct {
+	GroupInodesByType bool   `json:"group_inodes_by_type"`
+	InodeIndex        bool   `json:"inode_index"`
+	DentryIndex       bool   `json:"dentry_index"`
+	MmapStore         bool   `json:"mmap_store"`
+	StoreSyncInterval uint64 `json:"store_sync_interval"`
+	ExtendDedup       bool   `json:"extend_dedup"`
+}
+
+type manifestComponent struct {
+	Name  string `json:"name"`
+	Size  int64  `json:"size"`
+	CRC   uint32 `json:"crc"`
+	Count uint64 `json:"count"`
+	// time the CRC was recomputed by RecomputeChecksum, zero if it is the one of the store
+	RecomputeTime int64 `json:"recompute_time,omitempty"`
+}
+
+func (mp *metaPartition) storeManifest(rootDir string, sm *storeMsg, crcs []uint32) (err error) {
+	var sizes = make([]int64, len(snapshotDataFiles))
+	for i, name := range snapshotDataFiles {
+		var info os.FileInfo
+		if info, err = os.Stat(path.Join(rootDir, name)); err != nil {
+			return
+		}
+		sizes[i] = info.Size()
+	}
+	conf := mp.storeConfig()
+	manifest := mp.newSnapshotManifest(sm, crcs, sizes, conf)
+	if manifest.CanonicalCRCs, err = canonicalCRCs(sm, crcs, conf); err != nil {
+		return
+	}
+	if err = signSnapshotManifest(rootDir, manifest, conf); err != nil {
+		return
+	}
+	err = writeSnapshotManifest(rootDir, manifest)
+	return
+}
+
+// newSnapshotManifest describes the data files stored from sm with the given CRCs and sizes and settings.
+func (mp *metaPartition) newSnapshotManifest(sm *storeMsg, crcs []uint32, sizes []int64,
+	conf SnapshotConfig) *snapshotManifest {
+	var counts = storeMsgCounts(sm)
+	manifest := &snapshotManifest{
+		ApplyID:   sm.applyIndex,
+		StoreTime: storeClock().Unix(),
+		Settings: &manifestSettings{
+			GroupInodesByType: conf.GroupInodesByType,
+			InodeIndex:        conf.InodeIndex,
+			DentryIndex:       conf.DentryIndex,
+			MmapStore:         conf.MmapStore,
+			StoreSyncInterval: conf.StoreSyncInterval,
+			ExtendDedup:       conf.ExtendDedup,
+		},
+	}
+	for i, name := range snapshotDataFiles {
+		manifest.Components = append(manifest.Components, &manifestComponent{
+			Name:  name,
+			Size:  sizes[i],
+			CRC:   crcs[i],
+			Count: counts[i],
+		})
+	}
+	return manifest
+}
+
+// writeSnapshotManifest replaces the manifest of the snapshot in rootDir through a temporary file, so a reader
+// never sees a partly written manifest, even when a stored snapshot is rewritten in place.
+func writeSnapshotManifest(rootDir string, manifest *snapshotManifest) (err error) {
+	var data []byte
+	if data, err = json.Marshal(manifest); err != nil {
+		return
+	}
+	tmp := path.Join(rootDir, manifestTmpFile)
+	fp, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(tmp)
+		}
+	}()
+	if _, err = fp.Write(data); err == nil {
+		err = fp.Sync()
+	}
+	if closeErr := fp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return
+	}
+	err = os.Rename(tmp, path.Join(rootDir, manifestFile))
+	return
+}
+
+// readSnapshotManifest returns nil without an error if the snapshot in rootDir has no manifest.
+func readSnapshotManifest(rootDir string) (manifest *snapshotManifest, err error) {
+	data, err := ioutil.ReadFile(path.Join(rootDir, manifestFile))
+	if os.IsNotExist(err) {
+		err = nil
+		return
+	}
+	if err != nil {
+		return
+	}
+	return parseSnapshotManifest(data)
+}
+
+func parseSnapshotManifest(data []byte) (manifest *snapshotManifest, err error) {
+	manifest = new(snapshotManifest)
+	if err = json.Unmarshal(data, manifest); err != nil {
+		err = errors.NewErrorf("[readSnapshotManifest] Unmarshal: %s", err.Error())
+	}
+	return
+}
+
+// checkSnapshotManifest checks that the manifest of the snapshot in rootDir was stored at the applyID of the
+// apply file, and that each component has the size the manifest records. Snapshots stored without a manifest
+// are not checked.
+func checkSnapshotManifest(rootDir string) (err error) {
+	var manifest *snapshotManifest
+	if manifest, err = readSnapshotManifest(rootDir); err != nil || manifest == nil {
+		return
+	}
+	var data []byte
+	applyID := manifest.ApplyID
+	if data, err = ioutil.ReadFile(path.Join(rootDir, applyIDFile)); os.IsNotExist(err) {
+		// a lost apply file is recovered from the manifest by loadApplyID
+		err = nil
+	} else if err != nil {
+		err = errors.NewErrorf("[checkSnapshotManifest] ReadApplyID: %s", err.Error())
+		return
+	} else if _, err = fmt.Sscanf(string(data), "%d", &applyID); err != nil {
+		err = errors.NewErrorf("[checkSnapshotManifest] ReadApplyID: %s", err.Error())
+		return
+	}
+	if manifest.ApplyID != applyID {
+		return errors.NewErrorf("[checkSnapshotManifest] inconsistent snapshot: dir(%v) manifest applyID(%v) apply file applyID(%v)",
+			rootDir, manifest.ApplyID, applyID)
+	}
+	for _, c := range manifest.Components {
+		var info os.FileInfo
+		if info, err = os.Stat(path.Join(rootDir, c.Name)); err != nil {
+			return errors.NewErrorf("[checkSnapshotManifest] component(%v): %s", c.Name, err.Error())
+		}
+		if info.Size() != c.Size {
+			return errors.NewErrorf("[checkSnapshotManifest] inconsistent snapshot: dir(%v) component(%v) size(%v) manifest size(%v)",
+				rootDir, c.Name, info.Size(), c.Size)
+		}
+	}
+	return
+}
+
+// reconcileSnapshotManifest compares the components of the manifest of the snapshot in rootDir with the files
+// on disk. It fails naming every component missing on disk, e.g. after a partial copy, and returns the files
+// which are neither a snapshot file nor listed by the manifest. Snapshots without a manifest are not checked.
+func reconcileSnapshotManifest(rootDir string) (extra []string, err error) {
+	var manifest *snapshotManifest
+	if manifest, err = readSnapshotManifest(rootDir); err != nil || manifest == nil {
+		return
+	}
+	var missing []string
+	listed := make(map[string]bool)
+	for _, c := range manifest.Components {
+		listed[c.Name] = true
+		if _, statErr := os.Stat(path.Join(rootDir, c.Name)); os.IsNotExist(statErr) {
+			missing = append(missing, c.Name)
+		}
+	}
+	for _, name := range snapshotStreamFiles {
+		listed[name] = true
+	}
+	listed[inodeColumnsDir], listed[storeReportFile] = true, true
+	files, err := ioutil.ReadDir(rootDir)
+	if err != nil {
+		return nil, errors.NewErrorf("[reconcileSnapshotManifest] ReadDir: %s", err.Error())
+	}
+	for _, file := range files {
+		if !listed[file.Name()] {
+			extra = append(extra, file.Name())
+		}
+	}
+	if len(missing) != 0 {
+		err = errors.NewErrorf("[reconcileSnapshotManifest] incomplete snapshot: dir(%v) missing files(%v) listed by the manifest",
+			rootDir, strings.Join(missing, ","))
+	}
+	return
+}
+
+// RebuildManifest recomputes the sign file and the manifest of the snapshot in rootDir from its data files
+// and its apply file, e.g. for a legacy or hand-assembled snapshot. The data files are not modified.
+// It fails if a data file is missing: a manifest and sign file rebuilt without it would vouch for a
+// snapshot which may have lost its records.
+func RebuildManifest(rootDir string) (err error) {
+	var data []byte
+	if data, err = ioutil.ReadFile(path.Join(rootDir, applyIDFile)); err != nil {
+		err = errors.NewErrorf("[RebuildManifest] ReadApplyID: %s", err.Error())
+		return
+	}
+	manifest := &snapshotManifest{StoreTime: storeClock().Unix()}
+	if _, err = fmt.Sscanf(string(data), "%d", &manifest.ApplyID); err != nil {
+		err = errors.NewErrorf("[RebuildManifest] ReadApplyID: %s", err.Error())
+		return
+	}
+	var sign = bytes.NewBuffer(make([]byte, 0, 16))
+	for _, name := range snapshotDataFiles {
+		component := &manifestComponent{Name: name}
+		if component.Size, component.CRC, component.Count, err = scanSnapshotFile(rootDir, name); os.IsNotExist(err) {
+			err = errors.NewErrorf("[RebuildManifest] missing data file: dir(%v) file(%v)", rootDir, name)
+			return
+		} else if err != nil {
+			return
+		}
+		manifest.Components = append(manifest.Components, component)
+		if sign.Len() != 0 {
+			sign.WriteString(" ")
+		}
+		sign.WriteString(fmt.Sprintf("%d", component.CRC))
+	}
+	if err = ioutil.WriteFile(path.Join(rootDir, SnapshotSign), sign.Bytes(), 0775); err != nil {
+		return
+	}
+	if err = writeSnapshotManifest(rootDir, manifest); err != nil {
+		return
+	}
+	log.LogInfof("RebuildManifest: rebuild complete: dir(%v) applyID(%v) sign(%v)", rootDir, manifest.ApplyID, sign.String())
+	return
+}
+
+// scanSnapshotFile computes the size, the CRC and the number of records of a snapshot data file. The records
+// are walked, without being decoded, by the walker of the file in snapshotVerifiers, which tells the formats
+// of the file apart, such as the plain and the deduplicated extend files.
+func scanSnapshotFile(rootDir, name string) (size int64, crc uint32, count uint64, err error) {
+	var verify recordVerifier
+	for _, v := range snapshotVerifiers {
+		if v.name == name {
+			verify = v.verify
+		}
+	}
+	filename := path.Join(rootDir, name)
+	if verify == nil {
+		err = errors.NewErrorf("[scanSnapshotFile] not a snapshot data file: %v", filename)
+		return
+	}
+	fp, err := os.Open(filename)
+	if err != nil {
+		return
+	}
+	defer fp.Close()
+	var info os.FileInfo
+	if info, err = fp.Stat(); err != nil {
+		return
+	}
+	size = info.Size()
+	sign := crc32.NewIEEE()
+	reader := bufio.NewReaderSize(io.TeeReader(fp, sign), 4*1024*1024)
+	if err = verify(reader, size, func(raw []byte) error {
+		count++
+		return nil
+	}); err != nil {
+		err = errors.NewErrorf("[scanSnapshotFile] filename(%v) count(%v): %s", filename, count, err.Error())
+		return
+	}
+	// the CRC covers the whole file, whatever the walker left unread
+	if _, err = io.Copy(ioutil.Discard, reader); err != nil {
+		return
+	}
+	crc = sign.Sum32()
+	return
+}
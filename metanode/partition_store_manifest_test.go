@@ -0,0 +1,153 @@
+// Copyright 2018 The Chubao Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metanode
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestLoadReportsMissingFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metanode_missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mp := newFixturePartition(dir)
+	if err = mp.storeToDir(dir, capturedStoreMsg(mp)); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(path.Join(dir, "inode.orig"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	extra, err := reconcileSnapshotManifest(dir)
+	if err != nil || len(extra) != 1 || extra[0] != "inode.orig" {
+		t.Fatalf("extra(%v) err(%v)", extra, err)
+	}
+	os.Remove(path.Join(dir, dentryFile))
+	os.Remove(path.Join(dir, multipartFile))
+	loaded := NewMetaPartition(&MetaPartitionConfig{PartitionId: 1, VolName: "fixture", Start: 1, End: 1000}, nil).(*metaPartition)
+	err = loaded.LoadSnapshot(dir)
+	if err == nil || !strings.Contains(err.Error(), "missing files(dentry,multipart)") {
+		t.Fatalf("load of an incomplete snapshot: %v", err)
+	}
+}
+
+// TestRecoverApplyID removes the apply file of a snapshot and expects the load to restore it from the manifest.
+func TestRecoverApplyID(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metanode_recover_apply")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mp := newFixturePartition(dir)
+	if err = mp.storeToDir(dir, capturedStoreMsg(mp)); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Remove(path.Join(dir, applyIDFile)); err != nil {
+		t.Fatal(err)
+	}
+	loaded := NewMetaPartition(&MetaPartitionConfig{PartitionId: 1, VolName: "fixture", Start: 1, End: 1000}, nil).(*metaPartition)
+	if err = loaded.LoadSnapshot(dir); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.applyID != mp.applyID {
+		t.Fatalf("applyID %v, want %v", loaded.applyID, mp.applyID)
+	}
+	if _, err = os.Stat(path.Join(dir, applyIDFile)); err != nil {
+		t.Fatalf("apply file not rewritten: %v", err)
+	}
+
+	// without a manifest there is nothing to recover from
+	if err = os.Remove(path.Join(dir, applyIDFile)); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Remove(path.Join(dir, manifestFile)); err != nil {
+		t.Fatal(err)
+	}
+	bare := NewMetaPartition(&MetaPartitionConfig{PartitionId: 1, VolName: "fixture", Start: 1, End: 1000}, nil).(*metaPartition)
+	if err = bare.loadApplyID(dir); err != nil || bare.applyID != 0 {
+		t.Fatalf("applyID(%v) err(%v)", bare.applyID, err)
+	}
+}
+
+func TestWriteSnapshotManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metanode_write_manifest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mp := newFixturePartition(dir)
+	if err = mp.storeToDir(dir, capturedStoreMsg(mp)); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path.Join(dir, manifestFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0644 {
+		t.Fatalf("manifest mode %v", info.Mode().Perm())
+	}
+	if _, err = os.Stat(path.Join(dir, manifestTmpFile)); !os.IsNotExist(err) {
+		t.Fatalf("temporary manifest left: %v", err)
+	}
+	manifest, err := readSnapshotManifest(dir)
+	if err != nil || manifest == nil || manifest.ApplyID != mp.applyID {
+		t.Fatalf("manifest read back: %+v %v", manifest, err)
+	}
+}
+
+func TestRebuildManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metanode_rebuild_manifest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mp := newFixturePartition(dir)
+	for ino := uint64(1); ino <= 3; ino++ {
+		extend := NewExtend(ino)
+		extend.Put([]byte("user.key"), []byte("value"))
+		mp.extendTree.ReplaceOrInsert(extend, true)
+	}
+	mp.config.Snapshot.ExtendDedup = true
+	if err = mp.storeToDir(dir, capturedStoreMsg(mp)); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := readSnapshotManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = RebuildManifest(dir); err != nil {
+		t.Fatal(err)
+	}
+	rebuilt, err := readSnapshotManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, c := range rebuilt.Components {
+		if s := stored.Components[i]; c.Name != s.Name || c.Size != s.Size || c.CRC != s.CRC || c.Count != s.Count {
+			t.Fatalf("rebuilt component %+v, stored %+v", c, s)
+		}
+	}
+	if err = os.Remove(path.Join(dir, multipartFile)); err != nil {
+		t.Fatal(err)
+	}
+	if err = RebuildManifest(dir); err == nil || !strings.Contains(err.Error(), "missing data file") {
+		t.Fatalf("rebuild without a data file: %v", err)
+	}
+}
@@ -0,0 +1,150 @@
+// Copyright 2018 The Chubao Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metanode
+
+import (
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/chubaofs/chubaofs/util/errors"
+	"github.com/chubaofs/chubaofs/util/log"
+)
+
+// loadMergeSource loads the snapshot in dir for MergeSnapshots. With rangeCheck, the inode range of its partition
+// is read from the meta file of the partition directory holding dir, and every inode must be in it.
+func loadMergeSource(dir string, rangeCheck bool) (mp *metaPartition, err error) {
+	mp = NewMetaPartition(&MetaPartitionConfig{RootDir: path.Dir(path.Clean(dir))}, nil).(*metaPartition)
+	if rangeCheck {
+		if err = mp.loadMetadata(); err != nil {
+			return
+		}
+		if mp.config.End == 0 {
+			return nil, errors.NewErrorf("no inode range in %v", path.Join(mp.config.RootDir, metadataFile))
+		}
+	}
+	if err = mp.LoadSnapshot(dir); err != nil {
+		return
+	}
+	if !rangeCheck {
+		return
+	}
+	mp.inodeTree.Ascend(func(i BtreeItem) bool {
+		if ino := i.(*Inode).Inode; ino < mp.config.Start || ino > mp.config.End {
+			err = errors.NewErrorf("inode(%v) out of the range(%v,%v) of partition(%v)", ino,
+				mp.config.Start, mp.config.End, mp.config.PartitionId)
+		}
+		return err == nil
+	})
+	return
+}
+
+// mergeTree inserts the items of src into dst. An item whose key is already in dst fails the merge, unless
+// force is set: the item of dst is then kept, and the collision is counted.
+func mergeTree(dst, src *BTree, force bool, describe func(i BtreeItem) string) (collisions uint64, err error) {
+	src.Ascend(func(i BtreeItem) bool {
+		if _, ok := dst.ReplaceOrInsert(i, false); ok {
+			return true
+		}
+		if !force {
+			err = errors.NewErrorf("collision: %v", describe(i))
+			return false
+		}
+		collisions++
+		log.LogWarnf("MergeSnapshots: collision, the record of the first snapshot is kept: %v", describe(i))
+		return true
+	})
+	return
+}
+
+// MergeSnapshots merges the snapshots in dirA and dirB into a new snapshot in outDir, which must not exist,
+// e.g. to bring back together the metadata of a split gone wrong before splitting it again. The snapshots
+// are loaded, so both are verified, and their records are merged: a record of dirB whose inode, dentry
+// or multipart is also in dirA fails the merge, unless force is set, which keeps the record of dirA.
+// The merged snapshot is stored with its own counts, CRCs and manifest, at the larger applyID and cursor
+// of the two. With rangeCheck, the inode ranges of the two partitions, read from the meta files of their
+// partition directories, must be adjacent, and every inode must be in the range of its own partition.
+func MergeSnapshots(dirA, dirB, outDir string, rangeCheck, force bool) (err error) {
+	if _, err = os.Stat(outDir); err == nil {
+		return errors.NewErrorf("[MergeSnapshots] destination already exists: %v", outDir)
+	}
+	a, err := loadMergeSource(dirA, rangeCheck)
+	if err != nil {
+		return errors.NewErrorf("[MergeSnapshots] load %v: %s", dirA, err.Error())
+	}
+	b, err := loadMergeSource(dirB, rangeCheck)
+	if err != nil {
+		return errors.NewErrorf("[MergeSnapshots] load %v: %s", dirB, err.Error())
+	}
+	if rangeCheck {
+		low, high := a.config, b.config
+		if high.Start < low.Start {
+			low, high = high, low
+		}
+		if low.End+1 != high.Start {
+			return errors.NewErrorf("[MergeSnapshots] ranges not contiguous: partition(%v) range(%v,%v) "+
+				"partition(%v) range(%v,%v)", low.PartitionId, low.Start, low.End, high.PartitionId, high.Start, high.End)
+		}
+		a.config.Start, a.config.End = low.Start, high.End
+	}
+
+	// the records of dirB are merged into the trees of dirA
+	var collisions uint64
+	for _, t := range []struct {
+		dst, src *BTree
+		describe func(i BtreeItem) string
+	}{
+		{a.inodeTree, b.inodeTree, func(i BtreeItem) string { return fmt.Sprintf("inode(%v)", i.(*Inode).Inode) }},
+		{a.dentryTree, b.dentryTree, func(i BtreeItem) string {
+			return fmt.Sprintf("dentry(%v/%v)", i.(*Dentry).ParentId, i.(*Dentry).Name)
+		}},
+		{a.extendTree, b.extendTree, func(i BtreeItem) string { return fmt.Sprintf("extend(%v)", i.(*Extend).inode) }},
+		{a.multipartTree, b.multipartTree, func(i BtreeItem) string {
+			return fmt.Sprintf("multipart(%v/%v)", i.(*Multipart).key, i.(*Multipart).id)
+		}},
+	} {
+		var n uint64
+		if n, err = mergeTree(t.dst, t.src, force, t.describe); err != nil {
+			return errors.NewErrorf("[MergeSnapshots] %s", err.Error())
+		}
+		collisions += n
+	}
+	if b.applyID > a.applyID {
+		a.applyID = b.applyID
+	}
+	if b.config.Cursor > a.config.Cursor {
+		a.config.Cursor = b.config.Cursor
+	}
+
+	if err = os.MkdirAll(outDir, 0755); err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			os.RemoveAll(outDir)
+		}
+	}()
+	sm, err := a.captureStoreMsg(a.applyID)
+	if err != nil {
+		return errors.NewErrorf("[MergeSnapshots] capture: %s", err.Error())
+	}
+	if err = a.storeToDir(outDir, sm); err != nil {
+		return errors.NewErrorf("[MergeSnapshots] store: %s", err.Error())
+	}
+	log.LogInfof("MergeSnapshots: merge complete: dirA(%v) dirB(%v) outDir(%v) range(%v,%v) applyID(%v) inodes(%v) "+
+		"dentries(%v) collisions(%v)", dirA, dirB, outDir, a.config.Start, a.config.End, sm.applyIndex,
+		sm.inodeTree.Len(), sm.dentryTree.Len(), collisions)
+	return
+}
@@ -0,0 +1,79 @@
+// Copyright 2018 The Chubao Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metanode
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/chubaofs/chubaofs/proto"
+)
+
+func TestMergeSnapshots(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metanode_merge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store := func(mp *metaPartition) string {
+		mp.config.Peers = []proto.Peer{{ID: 1, Addr: "127.0.0.1:17210"}}
+		snapshot := path.Join(mp.config.RootDir, mp.config.Snapshot.dirName())
+		os.MkdirAll(snapshot, 0755)
+		if err := mp.persistMetadata(); err != nil {
+			t.Fatal(err)
+		}
+		if err := mp.storeToDir(snapshot, capturedStoreMsg(mp)); err != nil {
+			t.Fatal(err)
+		}
+		return snapshot
+	}
+	a := store(newFixturePartition(path.Join(dir, "a")))
+	second := NewMetaPartition(&MetaPartitionConfig{PartitionId: 2, VolName: "fixture", RootDir: path.Join(dir, "b"),
+		Start: 1001, End: 2000}, nil).(*metaPartition)
+	second.inodeTree.ReplaceOrInsert(NewInode(1001, proto.Mode(0644)), true)
+	second.dentryTree.ReplaceOrInsert(&Dentry{ParentId: 1, Name: "other", Inode: 1001, Type: proto.Mode(0644)}, true)
+	second.applyID = 200
+	b := store(second)
+
+	if err = MergeSnapshots(a, b, path.Join(dir, "merged"), true, false); err != nil {
+		t.Fatal(err)
+	}
+	merged := NewMetaPartition(&MetaPartitionConfig{PartitionId: 3, Start: 1, End: 2000}, nil).(*metaPartition)
+	if err = merged.LoadSnapshot(path.Join(dir, "merged")); err != nil {
+		t.Fatal(err)
+	}
+	if merged.applyID != 200 || merged.inodeTree.Len() != 5 || merged.dentryTree.Len() != 3 || merged.multipartTree.Len() != 1 {
+		t.Fatalf("merged: applyID(%v) inodes(%v) dentries(%v)", merged.applyID, merged.inodeTree.Len(), merged.dentryTree.Len())
+	}
+	// a snapshot merged with itself collides on every record
+	if err = MergeSnapshots(a, a, path.Join(dir, "self"), false, false); err == nil || !strings.Contains(err.Error(), "collision: inode(1)") {
+		t.Fatalf("merge with itself: %v", err)
+	}
+	if _, statErr := os.Stat(path.Join(dir, "self")); !os.IsNotExist(statErr) {
+		t.Fatal("failed merge left its output")
+	}
+	if err = MergeSnapshots(a, a, path.Join(dir, "forced"), false, true); err != nil {
+		t.Fatal(err)
+	}
+	// the ranges must be contiguous
+	second.config.RootDir, second.config.Start = path.Join(dir, "c"), 900
+	if err = MergeSnapshots(a, store(second), path.Join(dir, "overlap"), true, false); err == nil ||
+		!strings.Contains(err.Error(), "not contiguous") {
+		t.Fatalf("merge of overlapping ranges: %v", err)
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2018 The Chubao Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metanode
+
+import (
+	"io/ioutil"
+	"os"
+	"syscall"
+
+	"github.com/chubaofs/chubaofs/util/exporter"
+	"github.com/chubaofs/chubaofs/util/log"
+)
+
+const (
+	MetricSnapshotStoreAttempts    = "snapshot_store_attempts"
+	MetricSnapshotStoreFailures    = "snapshot_store_failures"
+	MetricSnapshotStoreFailedBytes = "snapshot_store_failed_bytes"
+	MetricSnapshotStoreRetries     = "snapshot_store_retries"
+	MetricSnapshotStoreSkipped     = "snapshot_store_skipped"
+)
+
+// reasons of a failed snapshot store
+const (
+	storeFailureNoSpace  = "enospc"
+	storeFailureIO       = "eio"
+	storeFailureAgain    = "eagain"
+	storeFailureReadBack = "readback"
+	storeFailureOther    = "other"
+)
+
+// storeFailureReason classifies the error returned by a store function.
+func storeFailureReason(err error) string {
+	switch e := err.(type) {
+	case *os.PathError:
+		err = e.Err
+	case *os.LinkError:
+		err = e.Err
+	case *os.SyscallError:
+		err = e.Err
+	case *readBackError:
+		return storeFailureReadBack
+	}
+	switch err {
+	case syscall.ENOSPC:
+		return storeFailureNoSpace
+	case syscall.EIO:
+		return storeFailureIO
+	case syscall.EAGAIN:
+		return storeFailureAgain
+	default:
+		return storeFailureOther
+	}
+}
+
+// reportStoreFailure counts a failed store of the snapshot files into dir by its reason,
+// together with the bytes which had been written into dir before the failure.
+func (mp *metaPartition) reportStoreFailure(dir string, err error) {
+	reason := storeFailureReason(err)
+	var written int64
+	if infos, readErr := ioutil.ReadDir(dir); readErr == nil {
+		for _, info := range infos {
+			written += info.Size()
+		}
+	}
+	labels := map[string]string{"reason": reason}
+	exporter.NewCounter(MetricSnapshotStoreFailures).AddWithLabels(1, labels)
+	exporter.NewCounter(MetricSnapshotStoreFailedBytes).AddWithLabels(written, labels)
+	log.LogErrorf("storeToDir: store failed: partitionID(%v) volume(%v) dir(%v) reason(%v) writtenBytes(%v) err(%v)",
+		mp.config.PartitionId, mp.config.VolName, dir, reason, written, err)
+}
@@ -0,0 +1,41 @@
+// Copyright 2018 The Chubao Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metanode
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestStoreFailureReason(t *testing.T) {
+	for _, c := range []struct {
+		err    error
+		reason string
+	}{
+		{&os.PathError{Op: "write", Path: "inode", Err: syscall.ENOSPC}, storeFailureNoSpace},
+		{&os.SyscallError{Syscall: "fsync", Err: syscall.EIO}, storeFailureIO},
+		{&os.LinkError{Op: "rename", Old: "a", New: "b", Err: syscall.EAGAIN}, storeFailureAgain},
+		{syscall.ENOSPC, storeFailureNoSpace},
+		{&readBackError{filename: "inode", expect: 1, actual: 2}, storeFailureReadBack},
+		{&os.PathError{Op: "open", Path: "inode", Err: syscall.ENOENT}, storeFailureOther},
+		{errors.New("unknown"), storeFailureOther},
+	} {
+		if reason := storeFailureReason(c.err); reason != c.reason {
+			t.Errorf("%v: reason %v, want %v", c.err, reason, c.reason)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+// Copyright 2018 The Chubao Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metanode
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+
+	"github.com/chubaofs/chubaofs/util/errors"
+	mmap "github.com/edsrzf/mmap-go"
+)
+
+// maxRecordLength is the largest record a slice can hold, which is 2GB on 32-bit builds.
+var maxRecordLength = uint64(^uint(0) >> 1)
+
+// recordEnd returns the end of the record of the given length at the offset of a file of the given size,
+// or an error if the record does not fit in the file or in a slice.
+func recordEnd(offset int64, length uint64, size int64) (end int64, err error) {
+	if offset < 0 || offset > size || length > uint64(size-offset) {
+		return 0, fmt.Errorf("record out of bounds: offset(%v) length(%v) size(%v)", offset, length, size)
+	}
+	if length > maxRecordLength {
+		return 0, fmt.Errorf("record too large: offset(%v) length(%v) max(%v)", offset, length, maxRecordLength)
+	}
+	return offset + int64(length), nil
+}
+
+// mmapWindow maps a read-only file one window at a time, so that only a bounded part of a large
+// snapshot file is resident while it is decoded. A zero window maps the whole file at once.
+type mmapWindow struct {
+	fp     *os.File
+	size   int64
+	window int64
+	start  int64
+	mem    mmap.MMap
+}
+
+func newMmapWindow(fp *os.File, window uint64) (w *mmapWindow, err error) {
+	var info os.FileInfo
+	if info, err = fp.Stat(); err != nil {
+		return
+	}
+	w = &mmapWindow{fp: fp, size: info.Size()}
+	if window == 0 || int64(window) >= w.size {
+		w.window = w.size
+	} else {
+		// the offset of each mapping must be page aligned, so is the window.
+		pageSize := int64(os.Getpagesize())
+		w.window = (int64(window) + pageSize - 1) / pageSize * pageSize
+	}
+	return
+}
+
+// bytes returns the n bytes at the offset of the file. The window is moved forward to the offset
+// if they are not mapped, and grows beyond the window size if a record straddles the window boundary.
+// The returned slice is only valid until the next call.
+func (w *mmapWindow) bytes(offset int64, n int) ([]byte, error) {
+	end := offset + int64(n)
+	if offset < 0 || end > w.size {
+		return nil, fmt.Errorf("range [%v, %v) out of file size %v", offset, end, w.size)
+	}
+	if w.mem != nil && offset >= w.start && end <= w.start+int64(len(w.mem)) {
+		return w.mem[offset-w.start : end-w.start], nil
+	}
+	if err := w.unmap(); err != nil {
+		return nil, err
+	}
+	start := offset - offset%int64(os.Getpagesize())
+	length := w.window
+	if end-start > length {
+		length = end - start
+	}
+	if start+length > w.size {
+		length = w.size - start
+	}
+	if uint64(length) > maxRecordLength {
+		return nil, fmt.Errorf("range [%v, %v) too large to map", start, start+length)
+	}
+	mem, err := mmap.MapRegion(w.fp, int(length), mmap.RDONLY, 0, start)
+	if err != nil {
+		return nil, err
+	}
+	w.start, w.mem = start, mem
+	return w.mem[offset-start : end-start], nil
+}
+
+// uvarint decodes the uvarint at the offset of the file, and returns it with the number of bytes read.
+func (w *mmapWindow) uvarint(offset int64) (v uint64, n int, err error) {
+	length := int64(binary.MaxVarintLen64)
+	if w.size-offset < length {
+		length = w.size - offset
+	}
+	var raw []byte
+	if raw, err = w.bytes(offset, int(length)); err != nil {
+		return
+	}
+	if v, n = binary.Uvarint(raw); n <= 0 {
+		err = errors.New("invalid uvarint")
+	}
+	return
+}
+
+func (w *mmapWindow) unmap() (err error) {
+	if w.mem != nil {
+		err = w.mem.Unmap()
+		w.mem = nil
+	}
+	return
+}
+
+// mmapFileWriter writes a file through a shared writable mapping instead of a write syscall per call.
+// The file is preallocated to the expected size, grown by doubling when the writes go beyond it,
+// and truncated to the bytes written on close. The caller syncs the file after closing the writer.
+type mmapFileWriter struct {
+	fp     *os.File
+	mem    mmap.MMap
+	offset int64
+}
+
+// minMmapFileSize is the least size preallocated for a mapped file.
+const minMmapFileSize = 1 * MB
+
+func newMmapFileWriter(fp *os.File, sizeHint int64) (w *mmapFileWriter, err error) {
+	w = &mmapFileWriter{fp: fp}
+	if sizeHint < minMmapFileSize {
+		sizeHint = minMmapFileSize
+	}
+	if err = w.remap(sizeHint); err != nil {
+		return nil, err
+	}
+	return
+}
+
+func (w *mmapFileWriter) remap(size int64) (err error) {
+	if w.mem != nil {
+		if err = w.mem.Unmap(); err != nil {
+			return
+		}
+		w.mem = nil
+	}
+	pageSize := int64(os.Getpagesize())
+	size = (size + pageSize - 1) / pageSize * pageSize
+	if err = w.fp.Truncate(size); err != nil {
+		return
+	}
+	w.mem, err = mmap.MapRegion(w.fp, int(size), mmap.RDWR, 0, 0)
+	return
+}
+
+func (w *mmapFileWriter) Write(p []byte) (n int, err error) {
+	end := w.offset + int64(len(p))
+	if end > int64(len(w.mem)) {
+		size := 2 * int64(len(w.mem))
+		if size < end {
+			size = end
+		}
+		if err = w.remap(size); err != nil {
+			return
+		}
+	}
+	n = copy(w.mem[w.offset:end], p)
+	w.offset = end
+	return
+}
+
+// Flush writes the dirty pages of the mapping back to the file.
+func (w *mmapFileWriter) Flush() error {
+	return w.mem.Flush()
+}
+
+// Close unmaps the file and cuts off the preallocated bytes which have not been written.
+func (w *mmapFileWriter) Close() (err error) {
+	if w.mem != nil {
+		if err = w.mem.Unmap(); err != nil {
+			return
+		}
+		w.mem = nil
+	}
+	err = w.fp.Truncate(w.offset)
+	return
+}
@@ -0,0 +1,99 @@
+// Copyright 2018 The Chubao Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metanode
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/chubaofs/chubaofs/proto"
+)
+
+// newLargeInodePartition returns a partition with enough inodes to grow a mapped inode file
+// beyond its preallocated size.
+func newLargeInodePartition(rootDir string, n uint64) *metaPartition {
+	mp := newFixturePartition(rootDir)
+	for id := uint64(10); id < 10+n; id++ {
+		ino := NewInode(id, proto.Mode(0644))
+		ino.Extents.Append(proto.ExtentKey{FileOffset: 0, PartitionId: id, ExtentId: id, Size: 4096})
+		mp.inodeTree.ReplaceOrInsert(ino, true)
+	}
+	return mp
+}
+
+func TestMmapStoreIdentical(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metanode_mmap_store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mp := newLargeInodePartition(dir, 20000)
+	sm := capturedStoreMsg(mp)
+	written := path.Join(dir, "written")
+	mapped := path.Join(dir, "mapped")
+	for _, d := range []string{written, mapped} {
+		if err = os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = mp.storeInode(written, sm); err != nil {
+		t.Fatal(err)
+	}
+	mp.config.Snapshot.MmapStore = true
+	if _, err = mp.storeInode(mapped, sm); err != nil {
+		t.Fatal(err)
+	}
+	expect, err := ioutil.ReadFile(path.Join(written, inodeFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual, err := ioutil.ReadFile(path.Join(mapped, inodeFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(expect) <= minMmapFileSize || !bytes.Equal(expect, actual) {
+		t.Fatalf("mapped inode file differs: expect(%v bytes) actual(%v bytes)", len(expect), len(actual))
+	}
+}
+
+func BenchmarkStoreInode(b *testing.B) {
+	dir, err := ioutil.TempDir("", "metanode_store_bench")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mp := newLargeInodePartition(dir, 50000)
+	sm := capturedStoreMsg(mp)
+	for _, mmapStore := range []bool{false, true} {
+		name := "write"
+		if mmapStore {
+			name = "mmap"
+		}
+		b.Run(name, func(b *testing.B) {
+			mp.config.Snapshot.MmapStore = mmapStore
+			for i := 0; i < b.N; i++ {
+				if _, err := mp.storeInode(dir, sm); err != nil {
+					b.Fatal(err)
+				}
+			}
+			if info, err := os.Stat(path.Join(dir, inodeFile)); err == nil {
+				b.SetBytes(info.Size())
+			}
+		})
+	}
+}
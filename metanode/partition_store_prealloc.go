@@ -0,0 +1,65 @@
+// Copyright 2018 The Chubao Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metanode
+
+import (
+	"os"
+	"path"
+
+	"github.com/chubaofs/chubaofs/util/log"
+)
+
+// openStoreFile creates the data file name of a store in rootDir. With SnapshotConfig.StorePreallocate, the
+// blocks of the size the file is expected to reach, estimated from the previous store, are reserved at once
+// with an eighth more, so the file is allocated in few extents instead of growing a block at a time.
+// A filesystem which can not preallocate only logs it.
+func (mp *metaPartition) openStoreFile(rootDir, name string, sm *storeMsg) (fp *os.File, err error) {
+	if fp, err = os.OpenFile(path.Join(rootDir, name), os.O_RDWR|os.O_TRUNC|os.O_APPEND|os.O_CREATE, 0755); err != nil {
+		return
+	}
+	if !mp.storeConfig().StorePreallocate {
+		return
+	}
+	size := mp.EstimateStoreCost(sm).FileBytes[name]
+	if size <= 0 {
+		return
+	}
+	if allocErr := fallocateKeepSize(fp, size+size/8); allocErr != nil {
+		log.LogWarnf("openStoreFile: preallocate failed: partitionID(%v) volume(%v) file(%v) size(%v) err(%v)",
+			mp.config.PartitionId, mp.config.VolName, name, size+size/8, allocErr)
+	}
+	return
+}
+
+// trimStoreFile releases the blocks preallocated beyond the end of a stored data file.
+func (mp *metaPartition) trimStoreFile(filename string) (err error) {
+	if !mp.storeConfig().StorePreallocate {
+		return
+	}
+	fp, err := os.OpenFile(filename, os.O_RDWR, 0)
+	if err != nil {
+		return
+	}
+	defer fp.Close()
+	info, err := fp.Stat()
+	if err != nil {
+		return
+	}
+	// a truncate to the current size drops the blocks reserved beyond it
+	if err = fp.Truncate(info.Size()); err != nil {
+		return
+	}
+	return mp.syncFile(fp)
+}
@@ -0,0 +1,57 @@
+// Copyright 2018 The Chubao Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metanode
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestStorePreallocate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metanode_prealloc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mp := newFixturePartition(dir)
+	plain := path.Join(dir, "plain")
+	os.MkdirAll(plain, 0755)
+	if err = mp.storeToDir(plain, capturedStoreMsg(mp)); err != nil {
+		t.Fatal(err)
+	}
+	// the second store is preallocated from the sizes of the first one
+	mp.config.Snapshot.StorePreallocate = true
+	if mp.EstimateStoreCost(capturedStoreMsg(mp)).FileBytes[inodeFile] == 0 {
+		t.Fatal("no size to preallocate")
+	}
+	prealloc := path.Join(dir, "prealloc")
+	os.MkdirAll(prealloc, 0755)
+	if err = mp.storeToDir(prealloc, capturedStoreMsg(mp)); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range append(snapshotDataFiles, SnapshotSign) {
+		expect, _ := ioutil.ReadFile(path.Join(plain, name))
+		actual, _ := ioutil.ReadFile(path.Join(prealloc, name))
+		if !bytes.Equal(expect, actual) {
+			t.Fatalf("preallocated %v differs from the plain one", name)
+		}
+	}
+	if err = VerifySnapshot(prealloc); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright 2018 The Chubao Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metanode
+
+import (
+	"container/heap"
+	"sort"
+	"time"
+
+	"github.com/chubaofs/chubaofs/util/log"
+)
+
+// slowRecord describes the decoding cost of a single snapshot record.
+type slowRecord struct {
+	offset   int64
+	size     int
+	duration time.Duration
+}
+
+// slowRecordHeap is a min-heap of records ordered by duration, so the fastest sampled record is evicted first.
+type slowRecordHeap []slowRecord
+
+func (h slowRecordHeap) Len() int            { return len(h) }
+func (h slowRecordHeap) Less(i, j int) bool  { return h[i].duration < h[j].duration }
+func (h slowRecordHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *slowRecordHeap) Push(x interface{}) { *h = append(*h, x.(slowRecord)) }
+func (h *slowRecordHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
+}
+
+// slowRecordTracker keeps the slowest N records decoded while loading a snapshot file.
+// A nil tracker is valid and records nothing, so the load path pays nothing when profiling is disabled.
+type slowRecordTracker struct {
+	file    string
+	limit   int
+	records slowRecordHeap
+}
+
+func newSlowRecordTracker(file string, limit int) *slowRecordTracker {
+	if limit <= 0 {
+		return nil
+	}
+	return &slowRecordTracker{
+		file:    file,
+		limit:   limit,
+		records: make(slowRecordHeap, 0, limit),
+	}
+}
+
+// begin returns the start time of a decode, or the zero time if the tracker is disabled.
+func (t *slowRecordTracker) begin() time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+// add records the decode of the record at the given offset which began at start.
+func (t *slowRecordTracker) add(offset int64, size int, start time.Time) {
+	if t == nil {
+		return
+	}
+	record := slowRecord{offset: offset, size: size, duration: time.Since(start)}
+	if len(t.records) < t.limit {
+		heap.Push(&t.records, record)
+		return
+	}
+	if record.duration > t.records[0].duration {
+		t.records[0] = record
+		heap.Fix(&t.records, 0)
+	}
+}
+
+// report logs the sampled records from the slowest to the fastest.
+func (t *slowRecordTracker) report(partitionID uint64) {
+	if t == nil || len(t.records) == 0 {
+		return
+	}
+	records := append([]slowRecord{}, t.records...)
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].duration > records[j].duration
+	})
+	for i, record := range records {
+		log.LogWarnf("slowRecord: partitionID(%v) file(%v) rank(%v) offset(%v) size(%v) cost(%v)",
+			partitionID, t.file, i+1, record.offset, record.size, record.duration)
+	}
+}
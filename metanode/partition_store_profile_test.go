@@ -0,0 +1,48 @@
+// Copyright 2018 The Chubao Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metanode
+
+import (
+	"testing"
+	"time"
+)
+
+// TestSlowRecordTracker feeds records of known cost and expects only the slowest ones to be kept.
+func TestSlowRecordTracker(t *testing.T) {
+	if newSlowRecordTracker("inode", 0) != nil {
+		t.Fatal("a zero limit must disable the tracker")
+	}
+	var disabled *slowRecordTracker
+	disabled.add(0, 1, disabled.begin())
+	disabled.report(1)
+
+	tracker := newSlowRecordTracker("inode", 3)
+	for i := 1; i <= 10; i++ {
+		tracker.add(int64(i), i, time.Now().Add(-time.Duration(i)*time.Hour))
+	}
+	if len(tracker.records) != 3 {
+		t.Fatalf("kept %v records, want 3", len(tracker.records))
+	}
+	kept := make(map[int64]bool)
+	for _, record := range tracker.records {
+		kept[record.offset] = true
+	}
+	for _, offset := range []int64{8, 9, 10} {
+		if !kept[offset] {
+			t.Fatalf("slow record at offset %v dropped, kept %v", offset, tracker.records)
+		}
+	}
+	tracker.report(1)
+}
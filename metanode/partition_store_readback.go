@@ -0,0 +1,67 @@
+// Copyright 2018 The Chubao Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metanode
+
+import (
+	"bufio"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path"
+
+	"github.com/chubaofs/chubaofs/util/exporter"
+	"github.com/chubaofs/chubaofs/util/log"
+)
+
+const MetricSnapshotStoreReadBackFailures = "snapshot_store_readback_failures"
+
+// readBackError is returned by a store whose file does not read back as it was written.
+type readBackError struct {
+	filename string
+	expect   uint32
+	actual   uint32
+}
+
+func (e *readBackError) Error() string {
+	return fmt.Sprintf("read back crc mismatch: file(%v) expect(%v) actual(%v)", e.filename, e.expect, e.actual)
+}
+
+// readBackStoredFile reads the stored file back and compares its CRC with the one computed while it was
+// written, see SnapshotConfig.StoreReadBack. The pages of the file are dropped from the page cache first,
+// as the file is synced, so the bytes come from the disk, not from the memory they were written from.
+func (mp *metaPartition) readBackStoredFile(filename string, crc uint32) (err error) {
+	fp, err := os.Open(filename)
+	if err != nil {
+		return
+	}
+	defer fp.Close()
+	if adviseErr := fadviseDontNeed(fp); adviseErr != nil {
+		log.LogWarnf("readBackStoredFile: fadvise failed: partitionID(%v) file(%v) err(%v)",
+			mp.config.PartitionId, filename, adviseErr)
+	}
+	sign := crc32.NewIEEE()
+	if _, err = io.Copy(sign, bufio.NewReaderSize(fp, 4*MB)); err != nil {
+		return
+	}
+	if actual := sign.Sum32(); actual != crc {
+		exporter.NewCounter(MetricSnapshotStoreReadBackFailures).AddWithLabels(1,
+			map[string]string{"file": path.Base(filename)})
+		log.LogErrorf("readBackStoredFile: stored file does not read back: partitionID(%v) volume(%v) file(%v) "+
+			"expect(%v) actual(%v)", mp.config.PartitionId, mp.config.VolName, filename, crc, actual)
+		return &readBackError{filename: filename, expect: crc, actual: actual}
+	}
+	return
+}
@@ -0,0 +1,65 @@
+// Copyright 2018 The Chubao Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metanode
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestStoreReadBack(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metanode_readback")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mp := newFixturePartition(dir)
+	mp.config.Snapshot.StoreReadBack = true
+	mp.config.Snapshot.StoreRetryAttempts = 1
+	mp.config.Snapshot.StoreRetryBackoff = time.Millisecond
+	mp.config.Snapshot.StoreRetryTimeout = time.Minute
+	// the dentry file is corrupted on the disk after it is written, once
+	corrupted := 0
+	mp.afterStoreFile = func(filename string) error {
+		if path.Base(filename) == dentryFile && corrupted == 0 {
+			corrupted++
+			fp, err := os.OpenFile(filename, os.O_RDWR, 0)
+			if err != nil {
+				return err
+			}
+			defer fp.Close()
+			_, err = fp.WriteAt([]byte{0xff}, 8)
+			return err
+		}
+		return nil
+	}
+	err = mp.store(capturedStoreMsg(mp))
+	if _, ok := err.(*readBackError); !ok || storeFailureReason(err) != storeFailureReadBack {
+		t.Fatalf("expect read back error, got %v", err)
+	}
+	corrupted = 0
+	if err = mp.storeWithRetry(capturedStoreMsg(mp)); err != nil {
+		t.Fatal(err)
+	}
+	if corrupted != 1 {
+		t.Fatalf("corrupted stores: %v", corrupted)
+	}
+	if _, err = checkSnapshotSign(path.Join(dir, snapshotDir)); err != nil {
+		t.Fatal(err)
+	}
+}
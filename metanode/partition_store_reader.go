@@ -0,0 +1,163 @@
+// Copyright 2018 The Chubao Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metanode
+
+import (
+	"io"
+	"os"
+	"path"
+
+	"github.com/chubaofs/chubaofs/util/errors"
+)
+
+const openActiveSnapshotRetry = 5
+
+// ActiveSnapshot is the set of files of the active snapshot of a partition, opened together so that they
+// all belong to the same store even if a store is in progress.
+//
+// A store never writes into the active snapshot directory. It writes a temporary directory, renames the
+// active directory to the backup directory, renames the temporary directory to the active one and removes
+// the backup. Readers must not open the files of the active directory one by one, since a store can swap the
+// directory between two opens. OpenActiveSnapshot resolves the active set instead: it reads the backup
+// directory while the active one is missing during the swap, and opens the files again if the directory
+// was swapped while they were being opened. The opened files stay readable after the store removes them.
+type ActiveSnapshot struct {
+	Dir   string
+	files map[string]*os.File
+}
+
+// OpenActiveSnapshot opens the files of the active snapshot in the partition directory.
+// The error satisfies os.IsNotExist if the partition has no snapshot.
+func OpenActiveSnapshot(partitionDir string, conf SnapshotConfig) (snap *ActiveSnapshot, err error) {
+	for i := 0; i < openActiveSnapshotRetry; i++ {
+		var swapped bool
+		if snap, swapped, err = openSnapshotSet(partitionDir, conf); !swapped {
+			return
+		}
+	}
+	if os.IsNotExist(err) {
+		// neither the active nor the backup directory was there in any attempt: nothing has been stored
+		return nil, err
+	}
+	return nil, errors.NewErrorf("[OpenActiveSnapshot] snapshot swapped during every attempt: partitionDir(%v)",
+		partitionDir)
+}
+
+func openSnapshotSet(partitionDir string, conf SnapshotConfig) (snap *ActiveSnapshot, swapped bool, err error) {
+	dir := path.Join(partitionDir, conf.dirName())
+	before, err := os.Stat(dir)
+	if os.IsNotExist(err) {
+		// between the two renames of a store only the backup is complete
+		dir = path.Join(partitionDir, conf.backupDirName())
+		before, err = os.Stat(dir)
+	}
+	if err != nil {
+		if os.IsNotExist(err) {
+			// the backup has been removed or renamed back, resolve again
+			return nil, true, err
+		}
+		return nil, false, errors.NewErrorf("[OpenActiveSnapshot] Stat: %s", err.Error())
+	}
+	if snap, err = openSnapshotDir(dir); err != nil {
+		return nil, false, err
+	}
+	if after, statErr := os.Stat(dir); statErr != nil || !os.SameFile(before, after) {
+		snap.Close()
+		return nil, true, nil
+	}
+	return snap, false, nil
+}
+
+// openSnapshotDir opens the files of the snapshot in dir, which no store swaps, e.g. a copy of a snapshot
+// or the snapshot of a partition that does not serve. A missing file is left out, as in OpenActiveSnapshot.
+func openSnapshotDir(dir string) (snap *ActiveSnapshot, err error) {
+	snap = &ActiveSnapshot{Dir: dir, files: make(map[string]*os.File)}
+	for _, name := range snapshotStreamFiles {
+		var fp *os.File
+		if fp, err = os.Open(path.Join(dir, name)); os.IsNotExist(err) {
+			err = nil
+			continue
+		} else if err != nil {
+			snap.Close()
+			return nil, errors.NewErrorf("[openSnapshotDir] OpenFile: %s", err.Error())
+		}
+		snap.files[name] = fp
+	}
+	return
+}
+
+// File returns the opened snapshot file of the given name, or nil if the snapshot has no such file.
+func (s *ActiveSnapshot) File(name string) *os.File {
+	return s.files[name]
+}
+
+// Close closes all the opened files.
+func (s *ActiveSnapshot) Close() (err error) {
+	for name, fp := range s.files {
+		if closeErr := fp.Close(); err == nil {
+			err = closeErr
+		}
+		delete(s.files, name)
+	}
+	return
+}
+
+// open returns a reader of the whole opened file of the given name, which does not move the offset of the file
+// nor of any other reader of it. The error satisfies os.IsNotExist if the snapshot has no such file.
+func (s *ActiveSnapshot) open(name string) (r *io.SectionReader, err error) {
+	fp := s.files[name]
+	if fp == nil {
+		return nil, &os.PathError{Op: "open", Path: path.Join(s.Dir, name), Err: os.ErrNotExist}
+	}
+	info, err := fp.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return io.NewSectionReader(fp, 0, info.Size()), nil
+}
+
+// readFile reads the whole opened file of the given name, as ioutil.ReadFile reads a file by its path.
+func (s *ActiveSnapshot) readFile(name string) (data []byte, err error) {
+	r, err := s.open(name)
+	if err != nil {
+		return
+	}
+	data = make([]byte, r.Size())
+	_, err = io.ReadFull(r, data)
+	return
+}
+
+// readSign reads the CRCs of the data files from the opened sign file, as readSnapshotSign does.
+func (s *ActiveSnapshot) readSign() (crcs []uint32, err error) {
+	var data []byte
+	if data, err = s.readFile(SnapshotSign); err != nil {
+		err = errors.NewErrorf("[readSnapshotSign] ReadSign: %s", err.Error())
+		return
+	}
+	return parseSnapshotSign(s.Dir, data)
+}
+
+// readManifest reads the opened manifest, as readSnapshotManifest does. It is nil without a manifest.
+func (s *ActiveSnapshot) readManifest() (manifest *snapshotManifest, err error) {
+	data, err := s.readFile(manifestFile)
+	if os.IsNotExist(err) {
+		err = nil
+		return
+	}
+	if err != nil {
+		return
+	}
+	return parseSnapshotManifest(data)
+}
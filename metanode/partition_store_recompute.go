@@ -0,0 +1,182 @@
+// Copyright 2018 The Chubao Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metanode
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/chubaofs/chubaofs/util/errors"
+	"github.com/chubaofs/chubaofs/util/log"
+)
+
+// servingLockFile is the file of the partition directory whose flock is held by the started partition, so
+// the tools of other processes can tell that the partition is serving.
+const servingLockFile = ".serving"
+
+// registerServing takes the flock of the serving lock file, which holds the partition ID. It fails if
+// another process serves the partition directory.
+func (mp *metaPartition) registerServing() (err error) {
+	fp, err := os.OpenFile(path.Join(mp.config.RootDir, servingLockFile), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return
+	}
+	locked, err := tryLockFile(fp)
+	if err == nil && !locked {
+		err = errors.NewErrorf("[registerServing] partition directory locked by another process: %v", mp.config.RootDir)
+	}
+	if err == nil {
+		if err = fp.Truncate(0); err == nil {
+			_, err = fp.WriteAt([]byte(strconv.FormatUint(mp.config.PartitionId, 10)), 0)
+		}
+	}
+	if err != nil {
+		fp.Close()
+		return
+	}
+	mp.servingLock = fp
+	return
+}
+
+// unregisterServing releases the flock taken by registerServing.
+func (mp *metaPartition) unregisterServing() {
+	if mp.servingLock != nil {
+		mp.servingLock.Close()
+		mp.servingLock = nil
+	}
+}
+
+// servingPartitionOf returns the ID of the started partition whose snapshot directory or root directory is dir,
+// in this process or another one.
+func servingPartitionOf(dir string) (partitionID uint64, serving bool) {
+	dir = filepath.Clean(dir)
+	for _, d := range []string{dir, filepath.Dir(dir)} {
+		fp, err := os.Open(path.Join(d, servingLockFile))
+		if err != nil {
+			continue
+		}
+		locked, err := tryLockFile(fp)
+		if err == nil && !locked {
+			data, _ := ioutil.ReadAll(fp)
+			partitionID, _ = strconv.ParseUint(string(data), 10, 64)
+			serving = true
+		}
+		fp.Close()
+		if serving {
+			return
+		}
+	}
+	return 0, false
+}
+
+// RecomputeChecksum recomputes the CRC of the data file name of the snapshot in rootDir, e.g. after it was
+// truncated or patched by hand, and records it in the sign file and the manifest, so the snapshot loads
+// again. The file is read record by record first, and nothing is recorded if a record does not decode.
+// The index of the inode or dentry file, which the edit makes stale, is removed. The component of the
+// manifest records the time of the recompute, and the canonical CRCs, which cannot be computed again from
+// the file alone, are dropped, so the digest is computed from the loaded partition. It refuses to run on
+// the snapshot of a started partition, whose CRC mismatch must be a real corruption, and on a snapshot
+// signed with an HMAC, which the edit would no longer match.
+func RecomputeChecksum(rootDir, name string) (err error) {
+	if id, serving := servingPartitionOf(rootDir); serving {
+		return errors.NewErrorf("[RecomputeChecksum] partition is serving: partitionID(%v) dir(%v)", id, rootDir)
+	}
+	index := -1
+	for i, v := range snapshotVerifiers {
+		if v.name == name {
+			index = i
+		}
+	}
+	if index < 0 {
+		return errors.NewErrorf("[RecomputeChecksum] not a snapshot data file: %v", name)
+	}
+	crcs, err := readSnapshotSign(rootDir)
+	if err != nil {
+		return errors.NewErrorf("[RecomputeChecksum] %s", err.Error())
+	}
+	manifest, err := readSnapshotManifest(rootDir)
+	if err != nil {
+		return errors.NewErrorf("[RecomputeChecksum] %s", err.Error())
+	}
+	if manifest != nil && manifest.HMAC != nil {
+		return errors.NewErrorf("[RecomputeChecksum] snapshot signed with HMAC key(%v), restore it from a backup "+
+			"instead: %v", manifest.HMAC.KeyID, rootDir)
+	}
+	snap, err := openSnapshotDir(rootDir)
+	if err != nil {
+		return errors.NewErrorf("[RecomputeChecksum] %s", err.Error())
+	}
+	defer snap.Close()
+	v := snapshotVerifiers[index]
+	count, err := verifySnapshotFile(snap, name, v.verify, v.decode)
+	if err != nil {
+		return errors.NewErrorf("[RecomputeChecksum] %s", err.Error())
+	}
+	// the CRC is computed from the same opened file as the records checked
+	r, err := snap.open(name)
+	if err != nil {
+		return errors.NewErrorf("[RecomputeChecksum] %s", err.Error())
+	}
+	size := r.Size()
+	crc, err := readerCRC(r)
+	if err != nil {
+		return errors.NewErrorf("[RecomputeChecksum] %s", err.Error())
+	}
+
+	staleIndex := map[string]string{inodeFile: inodeIndexFile, dentryFile: dentryIndexFile}[name]
+	if staleIndex != "" {
+		if err = os.Remove(path.Join(rootDir, staleIndex)); err != nil && !os.IsNotExist(err) {
+			return errors.NewErrorf("[RecomputeChecksum] remove stale index: %s", err.Error())
+		}
+		err = nil
+	}
+	oldCRC := crcs[index]
+	crcs[index] = crc
+	fields := make([]string, len(crcs))
+	for i, c := range crcs {
+		fields[i] = fmt.Sprintf("%d", c)
+	}
+	if err = ioutil.WriteFile(path.Join(rootDir, SnapshotSign), []byte(strings.Join(fields, " ")), 0775); err != nil {
+		return errors.NewErrorf("[RecomputeChecksum] write sign: %s", err.Error())
+	}
+	if manifest != nil {
+		for _, c := range manifest.Components {
+			if c.Name == name {
+				c.Size, c.CRC, c.Count, c.RecomputeTime = size, crc, uint64(count), storeClock().Unix()
+			}
+		}
+		if manifest.Settings != nil && name == inodeFile {
+			manifest.Settings.InodeIndex = false
+		}
+		if manifest.Settings != nil && name == dentryFile {
+			manifest.Settings.DentryIndex = false
+		}
+		manifest.CanonicalCRCs = nil
+		if err = writeSnapshotManifest(rootDir, manifest); err != nil {
+			return errors.NewErrorf("[RecomputeChecksum] write manifest: %s", err.Error())
+		}
+	}
+	log.LogWarnf("RecomputeChecksum: checksum of a snapshot file replaced, its content is no longer checked "+
+		"against the store: dir(%v) file(%v) oldCRC(%v) newCRC(%v) size(%v) records(%v)",
+		rootDir, name, oldCRC, crc, size, count)
+	log.LogInfof("Audit: recompute snapshot checksum: dir(%v) file(%v) oldCRC(%v) newCRC(%v) size(%v)",
+		rootDir, name, oldCRC, crc, size)
+	return
+}
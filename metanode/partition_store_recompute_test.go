@@ -0,0 +1,112 @@
+// Copyright 2018 The Chubao Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metanode
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestRecomputeChecksum(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metanode_recompute")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mp := newFixturePartition(dir)
+	mp.config.Snapshot.DentryIndex = true
+	snapshotPath := path.Join(dir, snapshotDir)
+	if err = os.MkdirAll(snapshotPath, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err = mp.storeToDir(snapshotPath, capturedStoreMsg(mp)); err != nil {
+		t.Fatal(err)
+	}
+	// drop the last dentry by hand
+	filename := path.Join(snapshotPath, dentryFile)
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := 4 + int64(binary.BigEndian.Uint32(data))
+	if err = os.Truncate(filename, first+2); err != nil {
+		t.Fatal(err)
+	}
+	if err = RecomputeChecksum(snapshotPath, dentryFile); err == nil {
+		t.Fatalf("checksum recomputed over a truncated record")
+	}
+	if err = os.Truncate(filename, first); err != nil {
+		t.Fatal(err)
+	}
+	if err = mp.registerServing(); err != nil {
+		t.Fatal(err)
+	}
+	if err = RecomputeChecksum(snapshotPath, dentryFile); err == nil || !strings.Contains(err.Error(), "serving") {
+		t.Fatalf("checksum recomputed on a serving partition: %v", err)
+	}
+	mp.unregisterServing()
+	if _, err = checkSnapshotSign(snapshotPath); err == nil {
+		t.Fatalf("sign matches the edited file")
+	}
+	if err = RecomputeChecksum(snapshotPath, dentryFile); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = checkSnapshotSign(snapshotPath); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(path.Join(snapshotPath, dentryIndexFile)); !os.IsNotExist(err) {
+		t.Fatalf("stale dentry index kept: %v", err)
+	}
+	manifest, err := readSnapshotManifest(snapshotPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range manifest.Components {
+		if (c.Name == dentryFile) != (c.RecomputeTime != 0) || (c.Name == dentryFile && c.Count != 1) {
+			t.Fatalf("manifest component: %+v", c)
+		}
+	}
+	if len(manifest.CanonicalCRCs) != 0 {
+		t.Fatalf("canonical CRCs of the stored files kept: %v", manifest.CanonicalCRCs)
+	}
+	loaded := NewMetaPartition(&MetaPartitionConfig{PartitionId: 1, RootDir: dir, Start: 1, End: 1000}, nil).(*metaPartition)
+	if err = loaded.LoadSnapshot(snapshotPath); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.dentryTree.Len() != 1 {
+		t.Fatalf("loaded dentries: %v", loaded.dentryTree.Len())
+	}
+}
+
+func TestRecomputeChecksumHMAC(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metanode_recompute_hmac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mp := newFixturePartition(dir)
+	mp.config.Snapshot.HMACKeyID = "k1"
+	mp.config.Snapshot.HMACKeys = map[string]string{"k1": "secret"}
+	if err = mp.storeToDir(dir, capturedStoreMsg(mp)); err != nil {
+		t.Fatal(err)
+	}
+	if err = RecomputeChecksum(dir, dentryFile); err == nil || !strings.Contains(err.Error(), "HMAC") {
+		t.Fatalf("checksum recomputed on a snapshot signed with an HMAC: %v", err)
+	}
+}
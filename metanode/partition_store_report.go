@@ -0,0 +1,110 @@
+// Copyright 2018 The Chubao Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metanode
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"time"
+
+	"github.com/chubaofs/chubaofs/util/errors"
+	"github.com/chubaofs/chubaofs/util/log"
+)
+
+const (
+	// storeReportFile is the report of the last store in the snapshot directory, see SnapshotConfig.StoreReport.
+	storeReportFile    = "store_report.json"
+	storeReportTmpFile = ".store_report.json"
+	// StoreReportVersion is bumped when a field of StoreReport changes meaning or is removed.
+	StoreReportVersion = 1
+	// the snapshot files are not compressed
+	storeReportCodec = "none"
+)
+
+// StoreReport is the JSON report written into the snapshot directory after each store, for tooling which
+// wants the latest store without parsing the logs: the manifest of the snapshot plus the timing of the store.
+// New fields may be added, the meaning of the existing ones only changes with Version.
+type StoreReport struct {
+	Version     int                `json:"version"`
+	PartitionID uint64             `json:"partition_id"`
+	VolName     string             `json:"vol_name"`
+	ApplyID     uint64             `json:"apply_id"`
+	StoreTime   int64              `json:"store_time"`  // unix seconds, as in the manifest
+	DurationMs  int64              `json:"duration_ms"` // from the start of the store to the publication of the snapshot
+	Codec       string             `json:"codec"`
+	ExtendDedup bool               `json:"extend_dedup"`
+	Files       []*StoreReportFile `json:"files"`
+}
+
+// StoreReportFile describes a data file of the snapshot, Count is its number of records.
+type StoreReportFile struct {
+	Name  string `json:"name"`
+	Size  int64  `json:"size"`
+	Count uint64 `json:"count"`
+	CRC   uint32 `json:"crc"`
+}
+
+// writeStoreReport writes the report of the store which published the snapshot in snapshotDir. The report
+// replaces the former one at once, so a reader never sees a partial report.
+func (mp *metaPartition) writeStoreReport(snapshotDir string, start time.Time) (err error) {
+	manifest, err := readSnapshotManifest(snapshotDir)
+	if err != nil {
+		return
+	}
+	if manifest == nil {
+		return errors.NewErrorf("[writeStoreReport] no manifest: dir(%v)", snapshotDir)
+	}
+	report := &StoreReport{
+		Version:     StoreReportVersion,
+		PartitionID: mp.config.PartitionId,
+		VolName:     mp.config.VolName,
+		ApplyID:     manifest.ApplyID,
+		StoreTime:   manifest.StoreTime,
+		DurationMs:  time.Since(start).Nanoseconds() / int64(time.Millisecond),
+		Codec:       storeReportCodec,
+	}
+	if manifest.Settings != nil {
+		report.ExtendDedup = manifest.Settings.ExtendDedup
+	}
+	for _, c := range manifest.Components {
+		report.Files = append(report.Files, &StoreReportFile{Name: c.Name, Size: c.Size, Count: c.Count, CRC: c.CRC})
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return
+	}
+	tmp := path.Join(snapshotDir, storeReportTmpFile)
+	if err = ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return
+	}
+	if err = os.Rename(tmp, path.Join(snapshotDir, storeReportFile)); err != nil {
+		os.Remove(tmp)
+	}
+	return
+}
+
+// reportStore writes the report of the store if SnapshotConfig.StoreReport is set. The snapshot is already
+// published, so a failed report is only logged.
+func (mp *metaPartition) reportStore(snapshotDir string, start time.Time) {
+	if !mp.config.Snapshot.StoreReport {
+		return
+	}
+	if err := mp.writeStoreReport(snapshotDir, start); err != nil {
+		log.LogWarnf("reportStore: write store report failed: partitionID(%v) volume(%v) err(%v)",
+			mp.config.PartitionId, mp.config.VolName, err)
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright 2018 The Chubao Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metanode
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestStoreReport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metanode_store_report")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mp := newFixturePartition(dir)
+	mp.config.Snapshot.StoreReport = true
+	if err = mp.store(capturedStoreMsg(mp)); err != nil {
+		t.Fatal(err)
+	}
+	snapshotPath := path.Join(dir, mp.config.Snapshot.dirName())
+	data, err := ioutil.ReadFile(path.Join(snapshotPath, storeReportFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := &StoreReport{}
+	if err = json.Unmarshal(data, report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Version != StoreReportVersion || report.PartitionID != 1 || report.ApplyID != mp.applyID ||
+		len(report.Files) != len(snapshotDataFiles) {
+		t.Fatalf("unexpected report %s", data)
+	}
+	crcs, err := readSnapshotSign(snapshotPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, file := range report.Files {
+		if file.Name != snapshotDataFiles[i] || file.CRC != crcs[i] {
+			t.Fatalf("report file %+v does not match the sign file", file)
+		}
+	}
+	if report.Files[0].Count != uint64(mp.inodeTree.Len()) {
+		t.Fatalf("report counts %v inodes, want %v", report.Files[0].Count, mp.inodeTree.Len())
+	}
+	// the report is no stray file of the snapshot
+	if extra, err := reconcileSnapshotManifest(snapshotPath); err != nil || len(extra) != 0 {
+		t.Fatalf("reconcile: extra(%v) err(%v)", extra, err)
+	}
+}
@@ -0,0 +1,295 @@
+// Copyright 2018 The Chubao Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metanode
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/chubaofs/chubaofs/util/errors"
+)
+
+const (
+	snapshotStreamMagic = "CFSSNAP1"
+	snapshotChunkSize   = 4 * MB
+)
+
+// snapshotStreamFiles are the files of a snapshot directory shipped by SnapshotWriter, in stream order.
+var snapshotStreamFiles = []string{inodeFile, inodeGroupFile, inodeIndexFile, dentryFile, dentryIndexFile, extendFile,
+	multipartFile, warmFile, applyIDFile, manifestFile, SnapshotSign}
+
+// snapshotStreamOrder is the order SnapshotWriter sends the files in: the sign file comes first,
+// so the reader can check each data file against it as soon as the file is received.
+var snapshotStreamOrder = func() []string {
+	order := []string{SnapshotSign}
+	for _, name := range snapshotStreamFiles {
+		if name != SnapshotSign {
+			order = append(order, name)
+		}
+	}
+	return order
+}()
+
+// SnapshotWriter streams the files of a snapshot directory, so a SnapshotReader on the other end of a pipe
+// can validate them while they arrive.
+// Stream structure:
+//  +-------+-------+---------+-----+---------+---+
+//  | item  | Magic | Section | ... | Section | 0 |
+//  +-------+-------+---------+-----+---------+---+
+//  | bytes |   8   |         |     |         | 2 |
+//  +-------+-------+---------+-----+---------+---+
+// Section structure:
+//  +-------+---------+---------+-------+-----+-------+---+
+//  | item  | NameLen |  Name   | Chunk | ... | Chunk | 0 |
+//  +-------+---------+---------+-------+-----+-------+---+
+//  | bytes |    2    | NameLen |       |     |       | 4 |
+//  +-------+---------+---------+-------+-----+-------+---+
+// Chunk structure:
+//  +-------+-----+------+-----------------+
+//  | item  | Len | Data | CRC(Name, Data) |
+//  +-------+-----+------+-----------------+
+//  | bytes |  4  | Len  |        4        |
+//  +-------+-----+------+-----------------+
+type SnapshotWriter struct {
+	w *bufio.Writer
+}
+
+// NewSnapshotWriter returns a SnapshotWriter writing into w.
+func NewSnapshotWriter(w io.Writer) *SnapshotWriter {
+	return &SnapshotWriter{w: bufio.NewWriterSize(w, 4*MB)}
+}
+
+// WriteDir streams the snapshot files found in dir.
+func (sw *SnapshotWriter) WriteDir(dir string) (err error) {
+	if _, err = sw.w.WriteString(snapshotStreamMagic); err != nil {
+		return
+	}
+	for _, name := range snapshotStreamOrder {
+		if err = sw.writeSection(dir, name); os.IsNotExist(err) {
+			err = nil
+		} else if err != nil {
+			return
+		}
+	}
+	if err = binary.Write(sw.w, binary.BigEndian, uint16(0)); err != nil {
+		return
+	}
+	err = sw.w.Flush()
+	return
+}
+
+func (sw *SnapshotWriter) writeSection(dir, name string) (err error) {
+	fp, err := os.Open(path.Join(dir, name))
+	if err != nil {
+		return
+	}
+	defer fp.Close()
+	return sw.writeSectionFrom(name, func(w io.Writer) error {
+		_, err := io.Copy(w, fp)
+		return err
+	})
+}
+
+// writeSectionFrom streams the bytes written by write as the section name, cut into chunks.
+func (sw *SnapshotWriter) writeSectionFrom(name string, write func(w io.Writer) error) (err error) {
+	if err = binary.Write(sw.w, binary.BigEndian, uint16(len(name))); err != nil {
+		return
+	}
+	if _, err = sw.w.WriteString(name); err != nil {
+		return
+	}
+	cw := &chunkWriter{sw: sw, name: name, buf: make([]byte, 0, snapshotChunkSize)}
+	if err = write(cw); err != nil {
+		return
+	}
+	if err = cw.flush(); err != nil {
+		return
+	}
+	err = binary.Write(sw.w, binary.BigEndian, uint32(0))
+	return
+}
+
+// chunkWriter writes the chunks of a section, each one full but the last.
+type chunkWriter struct {
+	sw   *SnapshotWriter
+	name string
+	buf  []byte
+}
+
+func (cw *chunkWriter) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		if len(cw.buf) == cap(cw.buf) {
+			if err = cw.flush(); err != nil {
+				return
+			}
+		}
+		m := copy(cw.buf[len(cw.buf):cap(cw.buf)], p)
+		cw.buf = cw.buf[:len(cw.buf)+m]
+		p = p[m:]
+		n += m
+	}
+	return
+}
+
+func (cw *chunkWriter) flush() (err error) {
+	if len(cw.buf) == 0 {
+		return
+	}
+	if err = binary.Write(cw.sw.w, binary.BigEndian, uint32(len(cw.buf))); err != nil {
+		return
+	}
+	if _, err = cw.sw.w.Write(cw.buf); err != nil {
+		return
+	}
+	if err = binary.Write(cw.sw.w, binary.BigEndian, chunkCRC(cw.name, cw.buf)); err != nil {
+		return
+	}
+	cw.buf = cw.buf[:0]
+	return
+}
+
+// SnapshotReader receives a stream produced by SnapshotWriter. Every chunk is checked against its CRC
+// as it arrives, and every data file against the sign file once it is received if the sign file came
+// before it, so a corrupted stream is refused before it is read to the end.
+type SnapshotReader struct {
+	r     *bufio.Reader
+	signs map[string]uint32 // CRCs of the data files, once the sign file is received
+}
+
+// NewSnapshotReader returns a SnapshotReader reading from r.
+func NewSnapshotReader(r io.Reader) *SnapshotReader {
+	return &SnapshotReader{r: bufio.NewReaderSize(r, 4*MB)}
+}
+
+// ReadDir writes the streamed snapshot files into dir, which must not exist yet, and verifies the result
+// against its manifest and sign file. dir is removed if the stream is refused.
+func (sr *SnapshotReader) ReadDir(dir string) (err error) {
+	if _, err = os.Stat(dir); err == nil {
+		return errors.NewErrorf("[SnapshotReader] destination already exists: %v", dir)
+	}
+	magic := make([]byte, len(snapshotStreamMagic))
+	if _, err = io.ReadFull(sr.r, magic); err != nil {
+		return errors.NewErrorf("[SnapshotReader] read magic: %s", err.Error())
+	}
+	if !bytes.Equal(magic, []byte(snapshotStreamMagic)) {
+		return errors.NewErrorf("[SnapshotReader] not a snapshot stream: magic(%q)", magic)
+	}
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			os.RemoveAll(dir)
+		}
+	}()
+	for {
+		var nameLen uint16
+		if err = binary.Read(sr.r, binary.BigEndian, &nameLen); err != nil {
+			return errors.NewErrorf("[SnapshotReader] read section: %s", err.Error())
+		}
+		if nameLen == 0 {
+			break
+		}
+		name := make([]byte, nameLen)
+		if _, err = io.ReadFull(sr.r, name); err != nil {
+			return errors.NewErrorf("[SnapshotReader] read section name: %s", err.Error())
+		}
+		if err = sr.readSection(dir, string(name)); err != nil {
+			return
+		}
+		if string(name) == SnapshotSign {
+			var crcs []uint32
+			if crcs, err = readSnapshotSign(dir); err != nil {
+				return errors.NewErrorf("[SnapshotReader] section(%v): %s", SnapshotSign, err.Error())
+			}
+			sr.signs = make(map[string]uint32, len(crcs))
+			for i, file := range snapshotDataFiles {
+				sr.signs[file] = crcs[i]
+			}
+		}
+	}
+	if err = checkSnapshotManifest(dir); err != nil {
+		return
+	}
+	if _, err = os.Stat(path.Join(dir, SnapshotSign)); err == nil {
+		_, err = checkSnapshotSign(dir)
+	} else if os.IsNotExist(err) {
+		err = nil
+	}
+	return
+}
+
+func (sr *SnapshotReader) readSection(dir, name string) (err error) {
+	known := false
+	for _, file := range snapshotStreamFiles {
+		known = known || file == name
+	}
+	if !known || strings.Contains(name, "/") {
+		return errors.NewErrorf("[SnapshotReader] unknown section: %q", name)
+	}
+	fp, err := os.OpenFile(path.Join(dir, name), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0755)
+	if err != nil {
+		return
+	}
+	defer fp.Close()
+	chunk := make([]byte, snapshotChunkSize)
+	sign := crc32.NewIEEE()
+	for index := 0; ; index++ {
+		var n, crc uint32
+		if err = binary.Read(sr.r, binary.BigEndian, &n); err != nil {
+			return errors.NewErrorf("[SnapshotReader] read chunk: section(%v) chunk(%v): %s", name, index, err.Error())
+		}
+		if n == 0 {
+			break
+		}
+		if n > snapshotChunkSize {
+			return errors.NewErrorf("[SnapshotReader] chunk too large: section(%v) chunk(%v) size(%v)", name, index, n)
+		}
+		if _, err = io.ReadFull(sr.r, chunk[:n]); err != nil {
+			return errors.NewErrorf("[SnapshotReader] read chunk: section(%v) chunk(%v): %s", name, index, err.Error())
+		}
+		if err = binary.Read(sr.r, binary.BigEndian, &crc); err != nil {
+			return errors.NewErrorf("[SnapshotReader] read chunk crc: section(%v) chunk(%v): %s", name, index, err.Error())
+		}
+		if actual := chunkCRC(name, chunk[:n]); actual != crc {
+			return errors.NewErrorf("[SnapshotReader] chunk crc mismatch: section(%v) chunk(%v) expect(%v) actual(%v)",
+				name, index, crc, actual)
+		}
+		if _, err = fp.Write(chunk[:n]); err != nil {
+			return
+		}
+		sign.Write(chunk[:n])
+	}
+	if expect, ok := sr.signs[name]; ok && expect != sign.Sum32() {
+		return errors.NewErrorf("[SnapshotReader] section crc mismatch: section(%v) expect(%v) actual(%v)",
+			name, expect, sign.Sum32())
+	}
+	err = fp.Sync()
+	return
+}
+
+// chunkCRC covers the section name too, so a chunk moved into another section is detected.
+func chunkCRC(name string, data []byte) uint32 {
+	sign := crc32.NewIEEE()
+	sign.Write([]byte(name))
+	sign.Write(data)
+	return sign.Sum32()
+}
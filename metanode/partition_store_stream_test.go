@@ -0,0 +1,98 @@
+// Copyright 2018 The Chubao Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metanode
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestSnapshotStream(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metanode_stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mp := newFixturePartition(dir)
+	handle, err := mp.Checkpoint("stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stream bytes.Buffer
+	if err = NewSnapshotWriter(&stream).WriteDir(handle.Dir); err != nil {
+		t.Fatal(err)
+	}
+	received := path.Join(dir, "received")
+	if err = NewSnapshotReader(bytes.NewReader(stream.Bytes())).ReadDir(received); err != nil {
+		t.Fatalf("read stream: %v", err)
+	}
+	for _, name := range snapshotDataFiles {
+		expect, _ := ioutil.ReadFile(path.Join(handle.Dir, name))
+		actual, _ := ioutil.ReadFile(path.Join(received, name))
+		if !bytes.Equal(expect, actual) {
+			t.Errorf("file %v differs after streaming", name)
+		}
+	}
+
+	corrupted := append([]byte{}, stream.Bytes()...)
+	corrupted[len(snapshotStreamMagic)+len(inodeFile)+10] ^= 0xff
+	refused := path.Join(dir, "refused")
+	if err = NewSnapshotReader(bytes.NewReader(corrupted)).ReadDir(refused); err == nil {
+		t.Fatal("corrupted stream accepted")
+	}
+	if _, err = os.Stat(refused); !os.IsNotExist(err) {
+		t.Fatalf("refused stream left %v behind: %v", refused, err)
+	}
+}
+
+// TestSnapshotStreamSectionSign streams a snapshot whose dentry file no longer matches its sign file,
+// which the chunk CRCs can not tell, and checks that the stream is refused at the dentry section.
+func TestSnapshotStreamSectionSign(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metanode_stream_sign")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mp := newFixturePartition(dir)
+	handle, err := mp.Checkpoint("stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	filename := path.Join(handle.Dir, dentryFile)
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-1] ^= 0xff
+	if err = ioutil.WriteFile(filename, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	var stream bytes.Buffer
+	if err = NewSnapshotWriter(&stream).WriteDir(handle.Dir); err != nil {
+		t.Fatal(err)
+	}
+	received := path.Join(dir, "received")
+	err = NewSnapshotReader(bytes.NewReader(stream.Bytes())).ReadDir(received)
+	if err == nil || !strings.Contains(err.Error(), "section crc mismatch: section(dentry)") {
+		t.Fatalf("stream with a stale sign: %v", err)
+	}
+	if _, err = os.Stat(received); !os.IsNotExist(err) {
+		t.Fatalf("refused stream left %v behind: %v", received, err)
+	}
+}
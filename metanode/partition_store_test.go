@@ -0,0 +1,1019 @@
+// Copyright 2018 The Chubao Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metanode
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/chubaofs/chubaofs/proto"
+)
+
+var updateGolden = flag.Bool("update-golden", false, "rewrite the golden snapshot files under testdata")
+
+const goldenSnapshotDir = "testdata/snapshot"
+
+// goldenFiles are the snapshot files pinned by the golden test. The manifest is left out as it records the store time.
+var goldenFiles = []string{inodeFile, dentryFile, extendFile, multipartFile, applyIDFile, SnapshotSign}
+
+// capturedStoreMsg captures the trees of mp at its applyID, which never fails without a deferred load.
+func capturedStoreMsg(mp *metaPartition) *storeMsg {
+	sm, err := mp.captureStoreMsg(mp.applyID)
+	if err != nil {
+		panic(err)
+	}
+	return sm
+}
+
+// newFixturePartition returns a meta partition holding a fixed set of metadata which covers
+// every field of the snapshot records.
+func newFixturePartition(rootDir string) *metaPartition {
+	mp := NewMetaPartition(&MetaPartitionConfig{
+		PartitionId: 1,
+		VolName:     "fixture",
+		RootDir:     rootDir,
+		Start:       1,
+		End:         1000,
+	}, nil).(*metaPartition)
+	const ts = 1500000000
+	root := &Inode{Inode: 1, Type: proto.Mode(os.ModeDir), Generation: 1, CreateTime: ts, AccessTime: ts,
+		ModifyTime: ts, NLink: 3, Extents: NewSortedExtents()}
+	file := &Inode{Inode: 2, Type: proto.Mode(0644), Uid: 500, Gid: 501, Size: 8192, Generation: 3, CreateTime: ts,
+		AccessTime: ts + 1, ModifyTime: ts + 2, NLink: 1, Reserved: 7, Extents: NewSortedExtents()}
+	file.Extents.Append(proto.ExtentKey{FileOffset: 0, PartitionId: 10, ExtentId: 20, ExtentOffset: 0, Size: 4096, CRC: 1})
+	file.Extents.Append(proto.ExtentKey{FileOffset: 4096, PartitionId: 11, ExtentId: 21, ExtentOffset: 4096, Size: 4096, CRC: 2})
+	link := &Inode{Inode: 3, Type: proto.Mode(os.ModeSymlink), Generation: 1, CreateTime: ts, AccessTime: ts,
+		ModifyTime: ts, LinkTarget: []byte("file"), NLink: 1, Extents: NewSortedExtents()}
+	deleted := &Inode{Inode: 4, Type: proto.Mode(0644), Generation: 1, CreateTime: ts, AccessTime: ts,
+		ModifyTime: ts, Flag: DeleteMarkFlag, Extents: NewSortedExtents()}
+	for _, ino := range []*Inode{root, file, link, deleted} {
+		mp.inodeTree.ReplaceOrInsert(ino, true)
+	}
+	for _, d := range []*Dentry{
+		{ParentId: 1, Name: "file", Inode: 2, Type: proto.Mode(0644)},
+		{ParentId: 1, Name: "link", Inode: 3, Type: proto.Mode(os.ModeSymlink)},
+	} {
+		mp.dentryTree.ReplaceOrInsert(d, true)
+	}
+	extend := NewExtend(2)
+	extend.Put([]byte("user.key"), []byte("value"))
+	mp.extendTree.ReplaceOrInsert(extend, true)
+	multipart := &Multipart{
+		id:       "upload-id",
+		key:      "object",
+		initTime: time.Unix(0, ts*int64(time.Second)),
+		parts: Parts{
+			{ID: 1, UploadTime: time.Unix(0, (ts+1)*int64(time.Second)), MD5: "0123456789abcdef", Size: 4096, Inode: 2},
+		},
+		extend: NewMultipartExtend(),
+	}
+	mp.multipartTree.ReplaceOrInsert(multipart, true)
+	mp.applyID = 100
+	mp.config.Cursor = 4
+	return mp
+}
+
+// TestSnapshotGolden pins the on-disk format: the stored fixture must match the committed golden files byte by byte.
+// Run with -update-golden after an intended format change.
+func TestSnapshotGolden(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metanode_golden")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mp := newFixturePartition(dir)
+	if err = mp.storeToDir(dir, capturedStoreMsg(mp)); err != nil {
+		t.Fatalf("store fixture: %v", err)
+	}
+	for _, name := range goldenFiles {
+		actual, err := ioutil.ReadFile(path.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		golden := path.Join(goldenSnapshotDir, name)
+		if *updateGolden {
+			if err = os.MkdirAll(goldenSnapshotDir, 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err = ioutil.WriteFile(golden, actual, 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		expect, err := ioutil.ReadFile(golden)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(expect, actual) {
+			t.Errorf("file %v differs from golden:\n\texpect: %x\n\tactual: %x", name, expect, actual)
+		}
+	}
+}
+
+// TestSnapshotGoldenDecode loads the committed golden files, which may have been written on another
+// architecture, and checks that they decode into the fixture.
+func TestSnapshotGoldenDecode(t *testing.T) {
+	expect := newFixturePartition("")
+	actual := NewMetaPartition(&MetaPartitionConfig{PartitionId: 1, VolName: "fixture", Start: 1, End: 1000}, nil).(*metaPartition)
+	if err := actual.LoadSnapshot(goldenSnapshotDir); err != nil {
+		t.Fatalf("load golden: %v", err)
+	}
+	if actual.applyID != expect.applyID {
+		t.Fatalf("applyID mismatch: expect(%v) actual(%v)", expect.applyID, actual.applyID)
+	}
+	if actual.config.Cursor != expect.config.Cursor {
+		t.Fatalf("cursor mismatch: expect(%v) actual(%v)", expect.config.Cursor, actual.config.Cursor)
+	}
+	type marshaler func(BtreeItem) ([]byte, error)
+	var trees = []struct {
+		name    string
+		expect  *BTree
+		actual  *BTree
+		marshal marshaler
+	}{
+		{inodeFile, expect.inodeTree, actual.inodeTree, func(i BtreeItem) ([]byte, error) { return i.(*Inode).Marshal() }},
+		{dentryFile, expect.dentryTree, actual.dentryTree, func(i BtreeItem) ([]byte, error) { return i.(*Dentry).Marshal() }},
+		{extendFile, expect.extendTree, actual.extendTree, func(i BtreeItem) ([]byte, error) { return i.(*Extend).Bytes() }},
+		{multipartFile, expect.multipartTree, actual.multipartTree, func(i BtreeItem) ([]byte, error) { return i.(*Multipart).Bytes() }},
+	}
+	for _, tree := range trees {
+		if tree.expect.Len() != tree.actual.Len() {
+			t.Fatalf("%v count mismatch: expect(%v) actual(%v)", tree.name, tree.expect.Len(), tree.actual.Len())
+		}
+		var items [][]byte
+		tree.expect.Ascend(func(i BtreeItem) bool {
+			data, err := tree.marshal(i)
+			if err != nil {
+				t.Fatal(err)
+			}
+			items = append(items, data)
+			return true
+		})
+		var index int
+		tree.actual.Ascend(func(i BtreeItem) bool {
+			data, err := tree.marshal(i)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(items[index], data) {
+				t.Errorf("%v item %v mismatch:\n\texpect: %x\n\tactual: %x", tree.name, index, items[index], data)
+			}
+			index++
+			return true
+		})
+	}
+}
+
+// TestStoreFailureCleanup injects a write error in the middle of a store and checks that the previous
+// snapshot is kept and no temporary file remains.
+func TestStoreFailureCleanup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metanode_cleanup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mp := newFixturePartition(dir)
+	if err = mp.store(capturedStoreMsg(mp)); err != nil {
+		t.Fatal(err)
+	}
+	previous, err := ioutil.ReadFile(path.Join(dir, snapshotDir, applyIDFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	injected := errors.New("injected write error")
+	mp.afterStoreFile = func(filename string) error {
+		if path.Base(filename) == dentryFile {
+			return injected
+		}
+		return nil
+	}
+	mp.applyID++
+	if err = mp.store(capturedStoreMsg(mp)); err != injected {
+		t.Fatalf("expect injected error, got %v", err)
+	}
+	if _, err = os.Stat(path.Join(dir, snapshotDirTmp)); !os.IsNotExist(err) {
+		t.Fatalf("temporary snapshot remains after a failed store: %v", err)
+	}
+	current, err := ioutil.ReadFile(path.Join(dir, snapshotDir, applyIDFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(previous, current) {
+		t.Fatalf("previous snapshot replaced by a failed store: %s -> %s", previous, current)
+	}
+}
+
+func TestLoadExtendWindowed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metanode_window")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mp := newFixturePartition(dir)
+	// records of about 1.5KB straddle the boundaries of a one-page window
+	value := bytes.Repeat([]byte("v"), 1500)
+	for ino := uint64(10); ino < 100; ino++ {
+		extend := NewExtend(ino)
+		extend.Put([]byte("user.key"), value)
+		mp.extendTree.ReplaceOrInsert(extend, true)
+	}
+	if err = mp.store(capturedStoreMsg(mp)); err != nil {
+		t.Fatal(err)
+	}
+	loaded := NewMetaPartition(&MetaPartitionConfig{
+		PartitionId: 1,
+		RootDir:     dir,
+		Start:       1,
+		End:         1000,
+		Snapshot:    SnapshotConfig{ExtendMmapWindow: 1},
+	}, nil).(*metaPartition)
+	if err = loaded.loadExtend(path.Join(dir, snapshotDir)); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.extendTree.Len() != mp.extendTree.Len() {
+		t.Fatalf("expect %v extends, got %v", mp.extendTree.Len(), loaded.extendTree.Len())
+	}
+	for ino := uint64(10); ino < 100; ino++ {
+		item := loaded.extendTree.Get(NewExtend(ino))
+		if item == nil {
+			t.Fatalf("extend of inode %v not loaded", ino)
+		}
+		if got, _ := item.(*Extend).Get([]byte("user.key")); !bytes.Equal(got, value) {
+			t.Fatalf("extend of inode %v mismatch", ino)
+		}
+	}
+}
+
+func TestLoadMultipartWindowed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metanode_window_multipart")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mp := newFixturePartition(dir)
+	// records with long keys straddle the boundaries of a one-page window
+	key := strings.Repeat("k", 1500)
+	for i := 0; i < 90; i++ {
+		mp.multipartTree.ReplaceOrInsert(&Multipart{id: fmt.Sprintf("upload-%v", i), key: key,
+			initTime: time.Unix(1500000000, 0), extend: NewMultipartExtend()}, true)
+	}
+	if err = mp.store(capturedStoreMsg(mp)); err != nil {
+		t.Fatal(err)
+	}
+	loaded := NewMetaPartition(&MetaPartitionConfig{
+		PartitionId: 1,
+		RootDir:     dir,
+		Start:       1,
+		End:         1000,
+		Snapshot:    SnapshotConfig{ExtendMmapWindow: 1},
+	}, nil).(*metaPartition)
+	if err = loaded.loadMultipart(path.Join(dir, snapshotDir)); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.multipartTree.Len() != mp.multipartTree.Len() {
+		t.Fatalf("expect %v multiparts, got %v", mp.multipartTree.Len(), loaded.multipartTree.Len())
+	}
+	for i := 0; i < 90; i++ {
+		if loaded.multipartTree.Get(&Multipart{id: fmt.Sprintf("upload-%v", i), key: key}) == nil {
+			t.Fatalf("multipart %v not loaded", i)
+		}
+	}
+}
+
+func TestLoadTrailingBytes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metanode_trailing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mp := newFixturePartition(dir)
+	if err = mp.store(capturedStoreMsg(mp)); err != nil {
+		t.Fatal(err)
+	}
+	snapshotPath := path.Join(dir, snapshotDir)
+	for _, name := range []string{extendFile, multipartFile} {
+		filename := path.Join(snapshotPath, name)
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = ioutil.WriteFile(filename, append(data, 0), 0644); err != nil {
+			t.Fatal(err)
+		}
+		loaded := newFixturePartition(dir)
+		load := loaded.loadExtend
+		if name == multipartFile {
+			load = loaded.loadMultipart
+		}
+		if err = load(snapshotPath); err == nil {
+			t.Fatalf("%v: trailing byte not detected", name)
+		}
+		if err = VerifySnapshot(snapshotPath); err == nil {
+			t.Fatalf("%v: trailing byte not detected by VerifySnapshot", name)
+		}
+		if err = ioutil.WriteFile(filename, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// TestStoreDeterministic stores the same fsm state twice and expects byte-identical snapshots,
+// which the comparison of replicas by digest relies on.
+func TestStoreDeterministic(t *testing.T) {
+	storeClock = func() time.Time { return time.Unix(1500000000, 0) }
+	defer func() {
+		storeClock = time.Now
+	}()
+	var dirs []string
+	for i := 0; i < 2; i++ {
+		dir, err := ioutil.TempDir("", "metanode_deterministic")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		mp := newFixturePartition(dir)
+		// attributes are kept in maps, whose iteration order differs between runs
+		extend := NewExtend(1)
+		multipart := mp.multipartTree.Get(&Multipart{id: "upload-id", key: "object"}).(*Multipart)
+		for k := 0; k < 32; k++ {
+			extend.Put([]byte(fmt.Sprintf("user.key%v", k)), []byte(fmt.Sprintf("value%v", k)))
+			multipart.extend[fmt.Sprintf("oss::key%v", k)] = fmt.Sprintf("value%v", k)
+		}
+		mp.extendTree.ReplaceOrInsert(extend, true)
+		if err = mp.store(capturedStoreMsg(mp)); err != nil {
+			t.Fatal(err)
+		}
+		dirs = append(dirs, path.Join(dir, snapshotDir))
+	}
+	for _, name := range append(snapshotDataFiles, applyIDFile, manifestFile, SnapshotSign) {
+		first, err := ioutil.ReadFile(path.Join(dirs[0], name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		second, err := ioutil.ReadFile(path.Join(dirs[1], name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(first, second) {
+			t.Fatalf("%v differs between two stores of the same state", name)
+		}
+	}
+}
+
+// TestLoadInvalidInode appends a record which decodes into an inode with a zero inode number,
+// and expects the strict check to fail the load and the lenient one to skip it.
+func TestLoadInvalidInode(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metanode_invalid_inode")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mp := newFixturePartition(dir)
+	if err = mp.store(capturedStoreMsg(mp)); err != nil {
+		t.Fatal(err)
+	}
+	snapshotPath := path.Join(dir, snapshotDir)
+	filename := path.Join(snapshotPath, inodeFile)
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, err := NewInode(0, proto.Mode(0644)).Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := make([]byte, 4)
+	binary.BigEndian.PutUint32(header, uint32(len(record)))
+	data = append(append(data, header...), record...)
+	if err = ioutil.WriteFile(filename, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	strict := newFixturePartition(dir)
+	strict.config.Snapshot.StrictInodeCheck = true
+	if err = strict.loadInode(snapshotPath); err == nil {
+		t.Fatal("invalid inode not detected")
+	}
+	if err = VerifySnapshot(snapshotPath); err == nil || !strings.Contains(err.Error(), fmt.Sprintf("offset %v", len(data)-len(record)-4)) {
+		t.Fatalf("invalid inode not detected by VerifySnapshot at its offset: %v", err)
+	}
+	lenient := newFixturePartition(dir)
+	lenient.inodeTree = NewBtree()
+	if err = lenient.loadInode(snapshotPath); err != nil {
+		t.Fatal(err)
+	}
+	if lenient.inodeTree.Len() != mp.inodeTree.Len() {
+		t.Fatalf("inodes: got %v, want %v", lenient.inodeTree.Len(), mp.inodeTree.Len())
+	}
+}
+
+// TestLoadCursorOrder loads the inode file and the apply file in both orders and expects the cursor
+// to end at the largest of the cursors they carry.
+func TestLoadCursorOrder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metanode_cursor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mp := newFixturePartition(dir)
+	if err = mp.storeToDir(dir, capturedStoreMsg(mp)); err != nil {
+		t.Fatal(err)
+	}
+	// the largest inode of the fixture is 4
+	for _, applyCursor := range []uint64{2, 4, 500} {
+		if err = ioutil.WriteFile(path.Join(dir, applyIDFile), []byte(fmt.Sprintf("%d|%d", mp.applyID, applyCursor)), 0644); err != nil {
+			t.Fatal(err)
+		}
+		expect := applyCursor
+		if expect < 4 {
+			expect = 4
+		}
+		for _, inodeFirst := range []bool{true, false} {
+			loaded := NewMetaPartition(&MetaPartitionConfig{PartitionId: 1, VolName: "fixture", Start: 1, End: 1000}, nil).(*metaPartition)
+			loads := []func(string) error{loaded.loadInode, loaded.loadApplyID}
+			if !inodeFirst {
+				loads[0], loads[1] = loads[1], loads[0]
+			}
+			for _, load := range loads {
+				if err = load(dir); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if cursor := loaded.GetCursor(); cursor != expect {
+				t.Errorf("apply cursor(%v) inode first(%v): cursor %v, want %v", applyCursor, inodeFirst, cursor, expect)
+			}
+		}
+	}
+}
+
+// TestLoadDentryConflict appends a dentry taking the name of another one, and expects the strict load
+// to fail and the tolerant one to keep the first dentry.
+func TestLoadDentryConflict(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metanode_dentry_conflict")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mp := newFixturePartition(dir)
+	if err = mp.storeToDir(dir, capturedStoreMsg(mp)); err != nil {
+		t.Fatal(err)
+	}
+	filename := path.Join(dir, dentryFile)
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, err := (&Dentry{ParentId: 1, Name: "file", Inode: 9, Type: proto.Mode(0644)}).Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := make([]byte, 4)
+	binary.BigEndian.PutUint32(header, uint32(len(record)))
+	if err = ioutil.WriteFile(filename, append(append(data, header...), record...), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	strict := NewMetaPartition(&MetaPartitionConfig{PartitionId: 1, Start: 1, End: 1000}, nil).(*metaPartition)
+	if err = strict.loadDentry(dir); err == nil {
+		t.Fatal("conflicting dentry not detected")
+	}
+	tolerant := NewMetaPartition(&MetaPartitionConfig{PartitionId: 1, Start: 1, End: 1000}, nil).(*metaPartition)
+	tolerant.config.Snapshot.TolerateDentryConflicts = true
+	if err = tolerant.loadDentry(dir); err != nil {
+		t.Fatal(err)
+	}
+	item := tolerant.dentryTree.Get(&Dentry{ParentId: 1, Name: "file"})
+	if tolerant.dentryTree.Len() != 2 || item == nil || item.(*Dentry).Inode != 2 {
+		t.Fatalf("dentries(%v) file(%v)", tolerant.dentryTree.Len(), item)
+	}
+	if !tolerant.isForceFullStore() {
+		t.Fatal("skipped dentry does not force the next store")
+	}
+}
+
+func TestVolumeOverrides(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metanode_volume_overrides")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	enabled, interval := true, uint64(8)
+	mp := newFixturePartition(dir)
+	mp.config.Snapshot.VolumeOverrides = map[string]*SnapshotOverride{
+		"fixture": {DentryIndex: &enabled, StoreSyncIntervalMB: &interval},
+		"other":   {InodeIndex: &enabled},
+	}
+	if err = mp.storeToDir(dir, capturedStoreMsg(mp)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(path.Join(dir, dentryIndexFile)); err != nil {
+		t.Fatalf("dentry index of the overridden volume: %v", err)
+	}
+	if _, err = os.Stat(path.Join(dir, inodeIndexFile)); !os.IsNotExist(err) {
+		t.Fatalf("inode index of another volume applied: %v", err)
+	}
+	desc, err := DescribeSnapshot(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := desc.Settings; s == nil || !s.DentryIndex || s.InodeIndex || s.StoreSyncInterval != 8*MB {
+		t.Fatalf("recorded settings: %+v", s)
+	}
+}
+
+func TestLoadLargeMultipart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metanode_large_multipart")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mp := newFixturePartition(dir)
+	multipart := &Multipart{id: "large", key: "object", initTime: time.Unix(1500000000, 0), extend: NewMultipartExtend()}
+	for i := uint16(1); i <= 10000; i++ {
+		multipart.parts = append(multipart.parts, &Part{ID: i, MD5: "0123456789abcdef", Size: 4096, Inode: 2})
+	}
+	mp.multipartTree.ReplaceOrInsert(multipart, true)
+	if err = mp.storeToDir(dir, capturedStoreMsg(mp)); err != nil {
+		t.Fatal(err)
+	}
+	loaded := NewMetaPartition(&MetaPartitionConfig{PartitionId: 1, RootDir: dir, Start: 1, End: 1000}, nil).(*metaPartition)
+	if err = loaded.loadMultipart(dir); err != nil {
+		t.Fatal(err)
+	}
+	item := loaded.multipartTree.Get(&Multipart{id: "large", key: "object"})
+	if item == nil || len(item.(*Multipart).parts) != 10000 {
+		t.Fatalf("large multipart not loaded: %v", item)
+	}
+
+	// the same record can not be held in a slice of a 32-bit build once it is past 2GB
+	defer func(max uint64) { maxRecordLength = max }(maxRecordLength)
+	maxRecordLength = math.MaxInt32
+	if end, err := recordEnd(math.MaxInt32-10, 10, math.MaxInt32+100); err != nil || end != math.MaxInt32 {
+		t.Fatalf("record below the boundary: end(%v) err(%v)", end, err)
+	}
+	if _, err = recordEnd(10, math.MaxInt32+1, math.MaxInt32+100); err == nil {
+		t.Fatalf("record past the boundary accepted")
+	}
+	if _, err = recordEnd(math.MaxInt32, math.MaxUint64-10, math.MaxInt32+100); err == nil {
+		t.Fatalf("overflowing record accepted")
+	}
+}
+
+func TestEmptyPartitionRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metanode_empty")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mp := NewMetaPartition(&MetaPartitionConfig{PartitionId: 7, VolName: "empty", RootDir: dir, Start: 1, End: 1000,
+		Cursor: 1, Peers: []proto.Peer{{ID: 1, Addr: "127.0.0.1:17210"}}}, nil).(*metaPartition)
+	mp.applyID = 5
+	if err = mp.persistMetadata(); err != nil {
+		t.Fatal(err)
+	}
+	if err = mp.store(capturedStoreMsg(mp)); err != nil {
+		t.Fatal(err)
+	}
+	snapshotPath := path.Join(dir, snapshotDir)
+	if err = VerifySnapshot(snapshotPath); err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := readSnapshotManifest(snapshotPath)
+	if err != nil || manifest == nil || manifest.ApplyID != 5 || len(manifest.Components) != len(snapshotDataFiles) {
+		t.Fatalf("manifest of an empty partition: %+v %v", manifest, err)
+	}
+	for _, c := range manifest.Components {
+		if c.Count != 0 {
+			t.Fatalf("component of an empty partition: %+v", c)
+		}
+	}
+	load := func() *metaPartition {
+		loaded := NewMetaPartition(&MetaPartitionConfig{PartitionId: 7, RootDir: dir}, nil).(*metaPartition)
+		if err = loaded.load(); err != nil {
+			t.Fatal(err)
+		}
+		if loaded.applyID != 5 || loaded.config.Cursor != 1 || loaded.inodeTree.Len() != 0 ||
+			loaded.dentryTree.Len() != 0 || loaded.extendTree.Len() != 0 || loaded.multipartTree.Len() != 0 {
+			t.Fatalf("loaded empty partition: applyID(%v) cursor(%v) inodes(%v) dentries(%v)",
+				loaded.applyID, loaded.config.Cursor, loaded.inodeTree.Len(), loaded.dentryTree.Len())
+		}
+		return loaded
+	}
+	load()
+	// an empty extend or multipart file, as written by a tool without a manifest, holds no record as a missing one
+	for _, name := range []string{extendFile, multipartFile} {
+		if err = ioutil.WriteFile(path.Join(snapshotPath, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = os.Remove(path.Join(snapshotPath, manifestFile)); err != nil {
+		t.Fatal(err)
+	}
+	load()
+}
+
+func TestCheckApplyIDRange(t *testing.T) {
+	for _, c := range []struct {
+		applyID, first, last uint64
+		ok                   bool
+	}{
+		{0, 1, 0, true},      // new partition, empty log
+		{100, 51, 120, true}, // log truncated before the snapshot
+		{100, 101, 100, true},
+		{100, 101, 150, true},
+		{100, 102, 150, false}, // entry 101 is missing
+		{100, 1, 99, false},    // log ends before the snapshot
+	} {
+		if err := checkApplyIDRange(c.applyID, c.first, c.last); (err == nil) != c.ok {
+			t.Fatalf("applyID(%v) first(%v) last(%v): %v", c.applyID, c.first, c.last, err)
+		}
+	}
+}
+
+func TestStoreBigExtend(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metanode_big_extend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mp := newFixturePartition(dir)
+	big := bytes.Repeat([]byte("0123456789"), 300*KB)
+	extend := NewExtend(3)
+	extend.Put([]byte("user.big"), big)
+	extend.Put([]byte("user.small"), []byte("value"))
+	mp.extendTree.ReplaceOrInsert(extend, true)
+	raw, err := extend.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if extend.encodedLen() != len(raw) {
+		t.Fatalf("encoded length %v, want %v", extend.encodedLen(), len(raw))
+	}
+	if err = mp.storeToDir(dir, capturedStoreMsg(mp)); err != nil {
+		t.Fatal(err)
+	}
+	loaded := NewMetaPartition(&MetaPartitionConfig{PartitionId: 1, Start: 1, End: 1000}, nil).(*metaPartition)
+	if err = loaded.LoadSnapshot(dir); err != nil {
+		t.Fatal(err)
+	}
+	item := loaded.extendTree.Get(NewExtend(3))
+	if item == nil {
+		t.Fatal("big extend not loaded")
+	}
+	if value, _ := item.(*Extend).Get([]byte("user.big")); !bytes.Equal(value, big) {
+		t.Fatalf("big value of %v bytes loaded as %v bytes", len(big), len(value))
+	}
+}
+
+// TestLoadTruncatedRecords truncates the stored extend and multipart files at every offset and expects
+// a corruption error from the load, not a panic nor a silent partial load.
+func TestLoadTruncatedRecords(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metanode_truncated")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mp := newFixturePartition(dir)
+	if err = mp.store(capturedStoreMsg(mp)); err != nil {
+		t.Fatal(err)
+	}
+	snapshotPath := path.Join(dir, snapshotDir)
+	for _, name := range []string{extendFile, multipartFile} {
+		filename := path.Join(snapshotPath, name)
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// an empty file holds no record, as a missing one
+		for size := 1; size < len(data); size++ {
+			if err = ioutil.WriteFile(filename, data[:size], 0644); err != nil {
+				t.Fatal(err)
+			}
+			loaded := newFixturePartition(dir)
+			load := loaded.loadExtend
+			if name == multipartFile {
+				load = loaded.loadMultipart
+			}
+			if err = load(snapshotPath); err == nil || !strings.Contains(err.Error(), "corrupted") {
+				t.Fatalf("%v truncated to %v of %v bytes: err(%v)", name, size, len(data), err)
+			}
+		}
+		if err = ioutil.WriteFile(filename, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// TestLoadInodeRange stores an inode outside the partition range, and expects the strict load to fail
+// and the lenient one to load it without moving the cursor.
+func TestLoadInodeRange(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metanode_inode_range")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mp := newFixturePartition(dir)
+	mp.inodeTree.ReplaceOrInsert(NewInode(5000, proto.Mode(0644)), true)
+	if err = mp.storeToDir(dir, capturedStoreMsg(mp)); err != nil {
+		t.Fatal(err)
+	}
+
+	strict := NewMetaPartition(&MetaPartitionConfig{PartitionId: 1, Start: 1, End: 1000}, nil).(*metaPartition)
+	strict.config.Snapshot.StrictInodeRange = true
+	if err = strict.loadInode(dir); err == nil || !strings.Contains(err.Error(), "out of range") {
+		t.Fatalf("out of range inode not detected: %v", err)
+	}
+	lenient := NewMetaPartition(&MetaPartitionConfig{PartitionId: 1, Start: 1, End: 1000}, nil).(*metaPartition)
+	if err = lenient.loadInode(dir); err != nil {
+		t.Fatal(err)
+	}
+	if lenient.inodeTree.Len() != mp.inodeTree.Len() {
+		t.Fatalf("inodes: got %v, want %v", lenient.inodeTree.Len(), mp.inodeTree.Len())
+	}
+	if cursor := lenient.GetCursor(); cursor != 4 {
+		t.Fatalf("cursor %v, want 4", cursor)
+	}
+}
+
+// TestStoreSpaceRefused asks for more free space than any disk has, and expects the store to be refused
+// while the previous snapshot is kept.
+func TestStoreSpaceRefused(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metanode_store_space")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mp := newFixturePartition(dir)
+	mp.config.Snapshot.StoreMinFreeSpace = 1
+	if err = mp.store(capturedStoreMsg(mp)); err != nil {
+		t.Fatal(err)
+	}
+	snapshotPath := path.Join(dir, snapshotDir)
+	before, err := ioutil.ReadFile(path.Join(snapshotPath, SnapshotSign))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mp.config.Snapshot.StoreMinFreeSpace = math.MaxUint64 / 2
+	mp.applyID++
+	mp.inodeTree.ReplaceOrInsert(NewInode(5, proto.Mode(0644)), true)
+	if err = mp.store(capturedStoreMsg(mp)); err == nil || !strings.Contains(err.Error(), "not enough free space") {
+		t.Fatalf("store on a full disk: %v", err)
+	}
+	after, err := ioutil.ReadFile(path.Join(snapshotPath, SnapshotSign))
+	if err != nil || !bytes.Equal(before, after) {
+		t.Fatalf("previous snapshot changed by a refused store: %v", err)
+	}
+	if _, err = os.Stat(path.Join(dir, snapshotDirTmp)); !os.IsNotExist(err) {
+		t.Fatalf("refused store left a temporary directory: %v", err)
+	}
+}
+
+// TestStoreWithRetry fails stores with transient and permanent errors, and expects only the transient ones
+// to be retried, up to the configured attempts.
+func TestStoreWithRetry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metanode_store_retry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mp := newFixturePartition(dir)
+	mp.config.Snapshot.StoreRetryAttempts = 2
+	mp.config.Snapshot.StoreRetryBackoff = time.Millisecond
+	mp.config.Snapshot.StoreRetryTimeout = time.Minute
+	for _, c := range []struct {
+		failures int
+		err      error
+		stores   int
+		ok       bool
+	}{
+		{failures: 2, err: syscall.EIO, stores: 3, ok: true},
+		{failures: 3, err: syscall.EAGAIN, stores: 3, ok: false},
+		{failures: 1, err: syscall.ENOSPC, stores: 1, ok: false},
+		{failures: 1, err: errors.New("permanent"), stores: 1, ok: false},
+	} {
+		stores, failures := 0, c.failures
+		mp.afterStoreFile = func(filename string) error {
+			if path.Base(filename) != inodeFile {
+				return nil
+			}
+			stores++
+			if failures > 0 {
+				failures--
+				return &os.PathError{Op: "write", Path: filename, Err: c.err}
+			}
+			return nil
+		}
+		mp.applyID++
+		err = mp.storeWithRetry(capturedStoreMsg(mp))
+		if (err == nil) != c.ok || stores != c.stores {
+			t.Errorf("%v failures of %v: stores(%v) err(%v), want stores(%v) ok(%v)", c.failures, c.err, stores, err, c.stores, c.ok)
+		}
+	}
+}
+
+// TestStoreSyncInterval expects the syncer to flush and sync once per interval, and a store syncing
+// after every record to write the same files as one which does not.
+func TestStoreSyncInterval(t *testing.T) {
+	fp, err := ioutil.TempFile("", "metanode_store_sync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(fp.Name())
+	defer fp.Close()
+	mp := newFixturePartition("")
+	mp.config.Snapshot.StoreSyncInterval = 100
+	flushes := 0
+	syncer := mp.newStoreSyncer(fp, func() error {
+		flushes++
+		return nil
+	})
+	for i := 0; i < 10; i++ {
+		if err = syncer.wrote(30); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if flushes != 2 {
+		t.Fatalf("flushes %v, want 2", flushes)
+	}
+
+	dir, err := ioutil.TempDir("", "metanode_store_sync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, interval := range []uint64{0, 1} {
+		mp = newFixturePartition(dir)
+		mp.config.Snapshot.StoreSyncInterval = interval
+		d := path.Join(dir, fmt.Sprint(interval))
+		if err = os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err = mp.storeToDir(d, capturedStoreMsg(mp)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range snapshotDataFiles {
+		plain, err := ioutil.ReadFile(path.Join(dir, "0", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		synced, err := ioutil.ReadFile(path.Join(dir, "1", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(plain, synced) {
+			t.Errorf("file %v differs when synced during the store", name)
+		}
+	}
+}
+
+// newWarmStatePartition returns a partition holding the given number of inodes, every tenth of them
+// deleted, so a load puts them into the free list.
+func newWarmStatePartition(rootDir string, inodes int) *metaPartition {
+	mp := NewMetaPartition(&MetaPartitionConfig{PartitionId: 1, VolName: "warm", RootDir: rootDir, Start: 1,
+		End: math.MaxUint64}, nil).(*metaPartition)
+	for i := 1; i <= inodes; i++ {
+		ino := NewInode(uint64(i), proto.Mode(0644))
+		if i%10 == 0 {
+			ino.Flag = DeleteMarkFlag
+		}
+		mp.inodeTree.ReplaceOrInsert(ino, true)
+	}
+	mp.config.Cursor = uint64(inodes)
+	return mp
+}
+
+// storeWarmStateDirs stores the partition into the cold and warm directories under dir, with and without
+// the warm state.
+func storeWarmStateDirs(mp *metaPartition, dir string) (cold, warm string, err error) {
+	cold, warm = path.Join(dir, "cold"), path.Join(dir, "warm")
+	for _, d := range []string{cold, warm} {
+		if err = os.MkdirAll(d, 0755); err != nil {
+			return
+		}
+		mp.config.Snapshot.WarmSnapshot = d == warm
+		if err = mp.storeToDir(d, capturedStoreMsg(mp)); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// TestLoadWarmState expects a load through the warm state to restore the same free list and cursor
+// as a load checking every inode.
+func TestLoadWarmState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metanode_warm_state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cold, warm, err := storeWarmStateDirs(newWarmStatePartition(dir, 1000), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range []string{cold, warm} {
+		loaded := NewMetaPartition(&MetaPartitionConfig{PartitionId: 1, Start: 1, End: math.MaxUint64}, nil).(*metaPartition)
+		if err = loaded.LoadSnapshot(d); err != nil {
+			t.Fatal(err)
+		}
+		if loaded.freeList.Len() != 100 || loaded.GetCursor() != 1000 {
+			t.Errorf("%v: free inodes(%v) cursor(%v)", path.Base(d), loaded.freeList.Len(), loaded.GetCursor())
+		}
+	}
+}
+
+// BenchmarkLoadWarmState measures the restart time of a partition, loading its inodes with and without
+// the warm state.
+func BenchmarkLoadWarmState(b *testing.B) {
+	dir, err := ioutil.TempDir("", "metanode_warm_state")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cold, warm, err := storeWarmStateDirs(newWarmStatePartition(dir, 200000), dir)
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, d := range []string{cold, warm} {
+		b.Run(path.Base(d), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				loaded := NewMetaPartition(&MetaPartitionConfig{PartitionId: 1, Start: 1, End: math.MaxUint64}, nil).(*metaPartition)
+				if err := loaded.loadInode(d); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkLoadFadvise measures the cold cache load of an inode file larger than fadviseMinFileSize,
+// with and without the sequential hint. The pages of the file are dropped before each load.
+func BenchmarkLoadFadvise(b *testing.B) {
+	dir, err := ioutil.TempDir("", "metanode_fadvise")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mp := NewMetaPartition(&MetaPartitionConfig{PartitionId: 1, Start: 1, End: math.MaxUint64}, nil).(*metaPartition)
+	for i := uint64(1); i <= 20000; i++ {
+		ino := NewInode(i, proto.Mode(0644))
+		for j := uint64(0); j < 100; j++ {
+			ino.Extents.Append(proto.ExtentKey{FileOffset: j * 4096, PartitionId: 1, ExtentId: i, ExtentOffset: j * 4096, Size: 4096})
+		}
+		mp.inodeTree.ReplaceOrInsert(ino, true)
+	}
+	if err = mp.storeToDir(dir, capturedStoreMsg(mp)); err != nil {
+		b.Fatal(err)
+	}
+	if info, err := os.Stat(path.Join(dir, inodeFile)); err != nil || info.Size() < fadviseMinFileSize {
+		b.Fatalf("inode file too small to be advised: %v", err)
+	}
+	dropCache := func() {
+		fp, err := os.Open(path.Join(dir, inodeFile))
+		if err != nil {
+			b.Fatal(err)
+		}
+		defer fp.Close()
+		if err = fadviseDontNeed(fp); err != nil {
+			b.Fatal(err)
+		}
+	}
+	for _, advise := range []bool{false, true} {
+		b.Run(fmt.Sprintf("fadvise=%v", advise), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				dropCache()
+				loaded := NewMetaPartition(&MetaPartitionConfig{PartitionId: 1, Start: 1, End: math.MaxUint64}, nil).(*metaPartition)
+				loaded.config.Snapshot.LoadFadvise = advise
+				b.StartTimer()
+				if err := loaded.loadInode(dir); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -16,6 +16,8 @@ package metanode
 
 import (
 	"encoding/binary"
+	"path"
+	"sync/atomic"
 	"time"
 
 	"github.com/chubaofs/chubaofs/cmd/common"
@@ -27,22 +29,80 @@ import (
 type storeMsg struct {
 	command       uint32
 	applyIndex    uint64
+	mutations     uint64 // mutation counter of the partition when the trees were captured
 	inodeTree     *BTree
 	dentryTree    *BTree
 	extendTree    *BTree
 	multipartTree *BTree
 }
 
+// captureStoreMsg captures the trees to store at the given applyID.
+// All the trees must be captured between the same two applied commands, otherwise the stored set could be
+// inconsistent, e.g. a dentry refers to an inode which is not in the inode file. The callers guarantee it by
+// calling it from Apply or with applyMu held. The captured trees are copy-on-write clones, so later commands
+// never add or remove their items. It fails if the extends and multiparts deferred by a lazy load cannot be loaded.
+func (mp *metaPartition) captureStoreMsg(applyIndex uint64) (sm *storeMsg, err error) {
+	if err = mp.loadDeferred("store"); err != nil {
+		return
+	}
+	sm = &storeMsg{
+		command:       opFSMStoreTick,
+		applyIndex:    applyIndex,
+		mutations:     atomic.LoadUint64(&mp.mutations),
+		inodeTree:     mp.getInodeTree(),
+		dentryTree:    mp.getDentryTree(),
+		extendTree:    mp.extendTree.GetTree(),
+		multipartTree: mp.multipartTree.GetTree(),
+	}
+	return
+}
+
+// storeUnchanged tells whether the active snapshot was stored at the applyID of sm, as recorded by its
+// manifest, in which case storing sm would write the same files again. A forced store is never skipped:
+// it replaces a snapshot which could not be loaded.
+func (mp *metaPartition) storeUnchanged(sm *storeMsg) bool {
+	if mp.isForceFullStore() {
+		return false
+	}
+	manifest, err := readSnapshotManifest(path.Join(mp.config.RootDir, mp.config.Snapshot.dirName()))
+	if err != nil || manifest == nil || manifest.ApplyID != sm.applyIndex {
+		return false
+	}
+	exporter.NewCounter(MetricSnapshotStoreSkipped).Add(1)
+	mp.phaseInfof(snapshotPhaseStore, "store: nothing applied since the last store, skip: partitionID(%v) volume(%v) applyID(%v)",
+		mp.config.PartitionId, mp.config.VolName, sm.applyIndex)
+	return true
+}
+
+// markStored records that the store of msg succeeded: the mutations it captured are no longer pending.
+func (mp *metaPartition) markStored(msg *storeMsg) {
+	atomic.StoreUint64(&mp.storedMutations, msg.mutations)
+	atomic.CompareAndSwapUint32(&mp.forceFullStore, 1, 0)
+}
+
+// storeIdle tells whether the periodic store can be skipped under SnapshotConfig.StoreMutationThreshold:
+// nothing was applied since the last store, and no full store is forced.
+func (mp *metaPartition) storeIdle() bool {
+	return mp.config.Snapshot.StoreMutationThreshold > 0 && mp.mutationsSinceStore() == 0 && !mp.isForceFullStore()
+}
+
+// mutationStoreDue tells whether the mutations since the last store reach SnapshotConfig.StoreMutationThreshold.
+func (mp *metaPartition) mutationStoreDue() bool {
+	threshold := mp.config.Snapshot.StoreMutationThreshold
+	return threshold > 0 && mp.mutationsSinceStore() >= threshold
+}
+
 func (mp *metaPartition) startSchedule(curIndex uint64) {
 	timer := time.NewTimer(time.Hour * 24 * 365)
 	timer.Stop()
 	timerCursor := time.NewTimer(intervalToSyncCursor)
+	timerMutation := time.NewTimer(intervalToCheckMutation)
 	scheduleState := common.StateStopped
 	dumpFunc := func(msg *storeMsg) {
 		log.LogDebugf("[startSchedule] partitionId=%d: nowAppID"+
 			"=%d, applyID=%d", mp.config.PartitionId, curIndex,
 			msg.applyIndex)
-		if err := mp.store(msg); err == nil {
+		if err := mp.storeWithRetry(msg); err == nil {
 			// truncate raft log
 			if mp.raftPartition != nil {
 				mp.raftPartition.Truncate(curIndex)
@@ -52,6 +112,7 @@ func (mp *metaPartition) startSchedule(curIndex uint64) {
 					" truncate raft log")
 			}
 			curIndex = msg.applyIndex
+			mp.markStored(msg)
 		} else {
 			// retry again
 			mp.storeChan <- msg
@@ -86,11 +147,12 @@ func (mp *metaPartition) startSchedule(curIndex uint64) {
 					maxIdx uint64
 					maxMsg *storeMsg
 				)
+				force := mp.isForceFullStore()
 				for _, msg := range msgs {
-					if curIndex >= msg.applyIndex {
+					if curIndex >= msg.applyIndex && !force {
 						continue
 					}
-					if maxIdx < msg.applyIndex {
+					if maxMsg == nil || maxIdx < msg.applyIndex {
 						maxIdx = msg.applyIndex
 						maxMsg = msg
 					}
@@ -109,7 +171,14 @@ func (mp *metaPartition) startSchedule(curIndex uint64) {
 					msgs = append(msgs, msg)
 				}
 			case <-timer.C:
-				if mp.applyID <= curIndex {
+				force := mp.isForceFullStore()
+				if mp.applyID <= curIndex && !force {
+					timer.Reset(intervalToPersistData)
+					continue
+				}
+				if mp.storeIdle() {
+					log.LogDebugf("[startSchedule] partitionId=%d: no mutation since last store, skip",
+						mp.config.PartitionId)
 					timer.Reset(intervalToPersistData)
 					continue
 				}
@@ -130,11 +199,40 @@ func (mp *metaPartition) startSchedule(curIndex uint64) {
 					log.LogErrorf("[startSchedule] raft submit: %s", err.Error())
 				}
 				timerCursor.Reset(intervalToSyncCursor)
+			case <-timerMutation.C:
+				if scheduleState == common.StateStopped {
+					mp.maintainSnapshot()
+				}
+				if _, ok := mp.IsLeader(); !ok || scheduleState != common.StateStopped {
+					timerMutation.Reset(intervalToCheckMutation)
+					continue
+				}
+				if mp.mutationStoreDue() {
+					log.LogDebugf("[startSchedule] partitionId=%d: mutations(%v) reach threshold(%v), trigger store",
+						mp.config.PartitionId, mp.mutationsSinceStore(), mp.config.Snapshot.StoreMutationThreshold)
+					if _, err := mp.submit(opFSMStoreTick, nil); err != nil {
+						log.LogErrorf("[startSchedule] raft submit: %s", err.Error())
+					}
+				}
+				timerMutation.Reset(intervalToCheckMutation)
 			}
 		}
 	}(mp.stopC)
 }
 
+// maintainSnapshot moves the idle snapshot into the cold directory and ages the old checkpoints in the
+// background, unless the ones started by a former tick are still running.
+func (mp *metaPartition) maintainSnapshot() {
+	if !atomic.CompareAndSwapInt32(&mp.maintaining, 0, 1) {
+		return
+	}
+	go func() {
+		defer atomic.StoreInt32(&mp.maintaining, 0)
+		mp.checkSnapshotTier()
+		mp.ageCheckpoints()
+	}()
+}
+
 func (mp *metaPartition) stop() {
 	if mp.stopC != nil {
 		close(mp.stopC)
@@ -0,0 +1,220 @@
+// Copyright 2018 The Chubao Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metanode
+
+import (
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/chubaofs/chubaofs/proto"
+)
+
+// TestStoreConsistentView mutates the partition after the trees are captured and checks that the stored set
+// is still the captured one, with every dentry referring to a stored inode.
+func TestStoreConsistentView(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metanode_view")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mp := newFixturePartition(dir)
+	sm := capturedStoreMsg(mp)
+
+	// remove an inode with its dentry, and add a new pair
+	mp.dentryTree.Delete(&Dentry{ParentId: 1, Name: "file"})
+	mp.inodeTree.Delete(NewInode(2, 0))
+	mp.inodeTree.ReplaceOrInsert(NewInode(5, proto.Mode(0644)), true)
+	mp.dentryTree.ReplaceOrInsert(&Dentry{ParentId: 1, Name: "new", Inode: 5, Type: proto.Mode(0644)}, true)
+
+	if err = mp.storeToDir(dir, sm); err != nil {
+		t.Fatal(err)
+	}
+	loaded := NewMetaPartition(&MetaPartitionConfig{PartitionId: 1, VolName: "fixture", Start: 1, End: 1000}, nil).(*metaPartition)
+	if err = loaded.LoadSnapshot(dir); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.inodeTree.Len() != 4 || loaded.dentryTree.Len() != 2 {
+		t.Fatalf("stored set is not the captured one: inodes(%v) dentries(%v)", loaded.inodeTree.Len(), loaded.dentryTree.Len())
+	}
+	loaded.dentryTree.Ascend(func(i BtreeItem) bool {
+		dentry := i.(*Dentry)
+		if loaded.inodeTree.Get(NewInode(dentry.Inode, 0)) == nil {
+			t.Errorf("dentry %v refers to inode %v which is not stored", dentry.Name, dentry.Inode)
+		}
+		return true
+	})
+}
+
+func TestStoreSkipsUnchanged(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metanode_store_skip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mp := newFixturePartition(dir)
+	if err = mp.store(capturedStoreMsg(mp)); err != nil {
+		t.Fatal(err)
+	}
+	snapshotPath := path.Join(dir, mp.config.Snapshot.dirName())
+	before, _ := os.Stat(path.Join(snapshotPath, inodeFile))
+	if err = mp.store(capturedStoreMsg(mp)); err != nil {
+		t.Fatal(err)
+	}
+	if after, _ := os.Stat(path.Join(snapshotPath, inodeFile)); !os.SameFile(before, after) {
+		t.Fatal("store at the stored applyID rewrote the snapshot")
+	}
+	// a forced store replaces the snapshot whatever its applyID
+	mp.ForceFullStore()
+	if err = mp.store(capturedStoreMsg(mp)); err != nil {
+		t.Fatal(err)
+	}
+	if after, _ := os.Stat(path.Join(snapshotPath, inodeFile)); os.SameFile(before, after) {
+		t.Fatal("forced store skipped")
+	}
+}
+
+// TestMutationStorePolicy applies commands and checks the mutations since the last store drive the store policy.
+func TestMutationStorePolicy(t *testing.T) {
+	mp := NewMetaPartition(&MetaPartitionConfig{PartitionId: 1, Start: 1, End: 1000,
+		Snapshot: SnapshotConfig{StoreMutationThreshold: 2}}, nil).(*metaPartition)
+	if !mp.storeIdle() || mp.mutationStoreDue() {
+		t.Fatal("a partition without mutation must skip its periodic store")
+	}
+	apply := func(ino uint64, index uint64) {
+		raw, err := NewInode(ino, 0).Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		cmd, err := NewMetaItem(opFSMCreateInode, nil, raw).MarshalJson()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = mp.Apply(cmd, index); err != nil {
+			t.Fatal(err)
+		}
+	}
+	apply(10, 1)
+	if mp.storeIdle() || mp.mutationStoreDue() {
+		t.Fatalf("one mutation: idle(%v) due(%v)", mp.storeIdle(), mp.mutationStoreDue())
+	}
+	apply(11, 2)
+	if !mp.mutationStoreDue() {
+		t.Fatal("threshold reached but no store due")
+	}
+	msg := capturedStoreMsg(mp)
+	apply(12, 3)
+	mp.markStored(msg)
+	if got := mp.mutationsSinceStore(); got != 1 {
+		t.Fatalf("mutations since store %v, want the one applied after the capture", got)
+	}
+	// a forced store is never skipped
+	apply(13, 4)
+	mp.markStored(capturedStoreMsg(mp))
+	mp.ForceFullStore()
+	if mp.storeIdle() {
+		t.Fatal("forced store skipped")
+	}
+	// without a threshold the timer alone stores
+	mp.config.Snapshot.StoreMutationThreshold = 0
+	if mp.storeIdle() || mp.mutationStoreDue() {
+		t.Fatal("policy active without a threshold")
+	}
+}
+
+// TestStoreTickReleasesApplyMu applies a store tick while the store loop does not receive, and expects applyMu
+// to be released before the store message is delivered.
+func TestStoreTickReleasesApplyMu(t *testing.T) {
+	mp := newFixturePartition("")
+	mp.storeChan = make(chan *storeMsg)
+	cmd, err := NewMetaItem(opFSMStoreTick, nil, nil).MarshalJson()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go mp.Apply(cmd, mp.applyID+1)
+	locked := make(chan struct{})
+	go func() {
+		// the tick is applied once the applyID moves, its message is still waiting for the store loop
+		for atomic.LoadUint64(&mp.applyID) != 101 {
+			time.Sleep(time.Millisecond)
+		}
+		mp.applyMu.Lock()
+		mp.applyMu.Unlock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-time.After(5 * time.Second):
+		t.Fatal("applyMu held while the store message waits for the store loop")
+	}
+	if sm := <-mp.storeChan; sm.applyIndex != 101 {
+		t.Fatalf("store message applyID %v", sm.applyIndex)
+	}
+}
+
+// applyIDIterator yields only the applyID of a snapshot.
+type applyIDIterator struct {
+	applyID uint64
+	done    bool
+}
+
+func (it *applyIDIterator) Next() ([]byte, error) {
+	if it.done {
+		return nil, io.EOF
+	}
+	it.done = true
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint64(data, it.applyID)
+	return data, nil
+}
+
+func TestApplySnapshotReleasesApplyMu(t *testing.T) {
+	mp := newFixturePartition("")
+	mp.storeChan = make(chan *storeMsg)
+	mp.extReset = make(chan struct{}, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- mp.ApplySnapshot(nil, &applyIDIterator{applyID: 200})
+	}()
+	locked := make(chan struct{})
+	go func() {
+		for {
+			mp.applyMu.Lock()
+			applyID := mp.applyID
+			mp.applyMu.Unlock()
+			if applyID == 200 {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-time.After(5 * time.Second):
+		t.Fatal("applyMu held while the store message waits for the store loop")
+	}
+	if sm := <-mp.storeChan; sm.applyIndex != 200 || sm.inodeTree.Len() != 0 {
+		t.Fatalf("store message applyID(%v) inodes(%v)", sm.applyIndex, sm.inodeTree.Len())
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
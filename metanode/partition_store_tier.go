@@ -0,0 +1,180 @@
+// Copyright 2018 The Chubao Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metanode
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync/atomic"
+	"time"
+
+	"github.com/chubaofs/chubaofs/util/errors"
+	"github.com/chubaofs/chubaofs/util/log"
+)
+
+// A cold snapshot is a snapshot whose files were moved into the cold directory, see SnapshotConfig.ColdDir.
+// The snapshot directory of the partition is then a symbolic link to them, so every reader of the
+// snapshot files goes on working, and the manifest records the cold directory. A store always writes into the partition directory and removes the
+// cold files once its snapshot is published, and the load moves them back before it reads them.
+
+// touchSnapshot records that the snapshot was loaded or stored, which keeps it out of the cold directory.
+func (mp *metaPartition) touchSnapshot() {
+	atomic.StoreInt64(&mp.snapshotAccess, time.Now().UnixNano())
+}
+
+// snapshotIdle returns how long ago the snapshot was last loaded or stored.
+func (mp *metaPartition) snapshotIdle() time.Duration {
+	return time.Since(time.Unix(0, atomic.LoadInt64(&mp.snapshotAccess)))
+}
+
+// coldTarget returns the cold directory the snapshot directory dir links to, empty if it is not cold.
+func coldTarget(dir string) (target string, err error) {
+	info, err := os.Lstat(dir)
+	if err != nil || info.Mode()&os.ModeSymlink == 0 {
+		return "", nil
+	}
+	return os.Readlink(dir)
+}
+
+// copySnapshotDir copies the files of the snapshot directory src into dst, which is created.
+func copySnapshotDir(src, dst string) (err error) {
+	if err = os.MkdirAll(dst, 0775); err != nil {
+		return
+	}
+	files, err := ioutil.ReadDir(src)
+	if err != nil {
+		return
+	}
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		if err = copySnapshotFile(path.Join(src, file.Name()), path.Join(dst, file.Name())); err != nil {
+			return errors.NewErrorf("copy %v: %s", file.Name(), err.Error())
+		}
+	}
+	return
+}
+
+// swapSnapshotDir replaces the snapshot directory by the temporary one, in the order of a store, so an
+// interrupted swap leaves the former snapshot as the backup, which is restored by the next load.
+func (mp *metaPartition) swapSnapshotDir() (err error) {
+	snapshotDir := path.Join(mp.config.RootDir, mp.config.Snapshot.dirName())
+	backupDir := path.Join(mp.config.RootDir, mp.config.Snapshot.backupDirName())
+	tmpDir := path.Join(mp.config.RootDir, mp.config.Snapshot.tmpDirName())
+	if err = os.RemoveAll(backupDir); err != nil {
+		return
+	}
+	if err = os.Rename(snapshotDir, backupDir); err != nil {
+		return
+	}
+	if err = os.Rename(tmpDir, snapshotDir); err != nil {
+		_ = os.Rename(backupDir, snapshotDir)
+		return
+	}
+	return os.RemoveAll(backupDir)
+}
+
+// demoteSnapshot moves the snapshot files into the cold directory, and links the snapshot directory to them.
+// It does nothing if the snapshot is already cold. The files are checked against the sign file once copied.
+func (mp *metaPartition) demoteSnapshot() (err error) {
+	mp.tierMu.Lock()
+	defer mp.tierMu.Unlock()
+	snapshotDir := path.Join(mp.config.RootDir, mp.config.Snapshot.dirName())
+	if info, statErr := os.Lstat(snapshotDir); statErr != nil || !info.IsDir() {
+		return
+	}
+	cold := path.Join(mp.config.Snapshot.ColdDir, fmt.Sprintf("partition_%v_%v", mp.config.PartitionId,
+		time.Now().Format("20060102150405.000000")))
+	if err = copySnapshotDir(snapshotDir, cold); err == nil {
+		_, err = checkSnapshotSign(cold)
+	}
+	if err == nil {
+		err = setManifestColdDir(cold, cold)
+	}
+	if err != nil {
+		os.RemoveAll(cold)
+		return errors.NewErrorf("[demoteSnapshot] copy into %v: %s", cold, err.Error())
+	}
+	tmpDir := path.Join(mp.config.RootDir, mp.config.Snapshot.tmpDirName())
+	os.RemoveAll(tmpDir)
+	if err = os.Symlink(cold, tmpDir); err == nil {
+		err = mp.swapSnapshotDir()
+	}
+	if err != nil {
+		os.Remove(tmpDir)
+		os.RemoveAll(cold)
+		return errors.NewErrorf("[demoteSnapshot] link %v: %s", cold, err.Error())
+	}
+	log.LogInfof("demoteSnapshot: snapshot moved to the cold directory: partitionID(%v) volume(%v) dir(%v) idle(%v)",
+		mp.config.PartitionId, mp.config.VolName, cold, mp.snapshotIdle())
+	return
+}
+
+// promoteSnapshot moves the files of a cold snapshot back into the partition directory, before it is loaded.
+func (mp *metaPartition) promoteSnapshot() (err error) {
+	mp.tierMu.Lock()
+	defer mp.tierMu.Unlock()
+	snapshotDir := path.Join(mp.config.RootDir, mp.config.Snapshot.dirName())
+	cold, err := coldTarget(snapshotDir)
+	if err != nil || cold == "" {
+		return
+	}
+	tmpDir := path.Join(mp.config.RootDir, mp.config.Snapshot.tmpDirName())
+	os.RemoveAll(tmpDir)
+	if err = copySnapshotDir(cold, tmpDir); err == nil {
+		err = setManifestColdDir(tmpDir, "")
+	}
+	if err == nil {
+		err = mp.swapSnapshotDir()
+	}
+	if err != nil {
+		os.RemoveAll(tmpDir)
+		return errors.NewErrorf("[promoteSnapshot] copy from %v: %s", cold, err.Error())
+	}
+	os.RemoveAll(cold)
+	log.LogInfof("promoteSnapshot: snapshot moved back from the cold directory: partitionID(%v) volume(%v) dir(%v)",
+		mp.config.PartitionId, mp.config.VolName, cold)
+	return
+}
+
+// setManifestColdDir records in the manifest of the snapshot in rootDir the cold directory holding its files.
+// A snapshot without a manifest is left as is.
+func setManifestColdDir(rootDir, coldDir string) (err error) {
+	manifest, err := readSnapshotManifest(rootDir)
+	if err != nil || manifest == nil {
+		return
+	}
+	manifest.ColdDir = coldDir
+	return writeSnapshotManifest(rootDir, manifest)
+}
+
+// checkSnapshotTier moves the snapshot into the cold directory once it has been neither loaded nor stored
+// for SnapshotConfig.ColdAfter, and nothing is left to store.
+func (mp *metaPartition) checkSnapshotTier() {
+	conf := mp.config.Snapshot
+	if conf.ColdDir == "" || conf.ColdAfter <= 0 || mp.snapshotIdle() < conf.ColdAfter ||
+		mp.mutationsSinceStore() != 0 {
+		return
+	}
+	if err := mp.demoteSnapshot(); err != nil {
+		log.LogWarnf("checkSnapshotTier: demote failed: partitionID(%v) volume(%v) err(%v)",
+			mp.config.PartitionId, mp.config.VolName, err)
+	}
+	// a failed demotion is tried again after another idle period
+	mp.touchSnapshot()
+}
@@ -0,0 +1,109 @@
+// Copyright 2018 The Chubao Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metanode
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/chubaofs/chubaofs/proto"
+)
+
+func TestColdSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metanode_cold")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mp := newFixturePartition(dir)
+	mp.config.Peers = []proto.Peer{{ID: 1, Addr: "127.0.0.1:17210"}}
+	mp.config.Snapshot.ColdDir = path.Join(dir, "cold")
+	mp.config.Snapshot.ColdAfter = time.Hour
+	if err = mp.persistMetadata(); err != nil {
+		t.Fatal(err)
+	}
+	if err = mp.store(capturedStoreMsg(mp)); err != nil {
+		t.Fatal(err)
+	}
+	snapshotPath := path.Join(dir, snapshotDir)
+	demote := func() string {
+		mp.checkSnapshotTier()
+		if cold, _ := coldTarget(snapshotPath); cold != "" {
+			t.Fatalf("snapshot stored %v ago moved to the cold directory", mp.snapshotIdle())
+		}
+		atomic.StoreInt64(&mp.snapshotAccess, time.Now().Add(-2*time.Hour).UnixNano())
+		mp.checkSnapshotTier()
+		cold, _ := coldTarget(snapshotPath)
+		if cold == "" {
+			t.Fatalf("idle snapshot not moved to the cold directory")
+		}
+		// the snapshot is still read through the link
+		if err = VerifySnapshot(snapshotPath); err != nil {
+			t.Fatal(err)
+		}
+		if manifest, _ := readSnapshotManifest(snapshotPath); manifest == nil || manifest.ColdDir != cold {
+			t.Fatalf("manifest does not record the cold directory %v: %+v", cold, manifest)
+		}
+		return cold
+	}
+	cold := demote()
+	loaded := NewMetaPartition(&MetaPartitionConfig{PartitionId: 1, RootDir: dir}, nil).(*metaPartition)
+	if err = loaded.load(); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Lstat(snapshotPath); err != nil || !info.IsDir() || loaded.inodeTree.Len() != 4 {
+		t.Fatalf("cold snapshot not moved back by the load: %v %v", info, err)
+	}
+	if _, err = os.Stat(cold); !os.IsNotExist(err) {
+		t.Fatalf("cold files kept after the load: %v", err)
+	}
+	if manifest, _ := readSnapshotManifest(snapshotPath); manifest == nil || manifest.ColdDir != "" {
+		t.Fatalf("manifest of the promoted snapshot still records a cold directory: %+v", manifest)
+	}
+	cold = demote()
+	// a store at the same applyID is skipped, see storeUnchanged
+	mp.applyID++
+	if err = mp.store(capturedStoreMsg(mp)); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Lstat(snapshotPath); err != nil || !info.IsDir() {
+		t.Fatalf("store did not write into the partition directory: %v %v", info, err)
+	}
+	if _, err = os.Stat(cold); !os.IsNotExist(err) {
+		t.Fatalf("cold files kept after the store: %v", err)
+	}
+}
+
+// TestMaintainSnapshotOverlap expects a tick to skip the snapshot maintenance while a former one still runs.
+func TestMaintainSnapshotOverlap(t *testing.T) {
+	mp := newFixturePartition("")
+	atomic.StoreInt32(&mp.maintaining, 1)
+	mp.maintainSnapshot()
+	if atomic.LoadInt32(&mp.maintaining) != 1 {
+		t.Fatal("maintenance started while a former one runs")
+	}
+	atomic.StoreInt32(&mp.maintaining, 0)
+	mp.maintainSnapshot()
+	for i := 0; atomic.LoadInt32(&mp.maintaining) != 0; i++ {
+		if i == 1000 {
+			t.Fatal("maintenance did not complete")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,199 @@
+// Copyright 2018 The Chubao Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metanode
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"strings"
+	"sync/atomic"
+
+	"github.com/chubaofs/chubaofs/util/errors"
+	"github.com/chubaofs/chubaofs/util/log"
+)
+
+// countingWriter counts the bytes written through it and adds them to the CRC of the file.
+type countingWriter struct {
+	w    io.Writer
+	sign io.Writer
+	n    int64
+}
+
+func (c *countingWriter) Write(p []byte) (n int, err error) {
+	if n, err = c.w.Write(p); err != nil {
+		return
+	}
+	c.sign.Write(p[:n])
+	c.n += int64(n)
+	return
+}
+
+// writePrefixedRecords writes the items of the tree as the records of the inode and dentry files.
+func writePrefixedRecords(w io.Writer, tree *BTree, marshal func(i BtreeItem) ([]byte, error)) (err error) {
+	lenBuf := make([]byte, 4)
+	tree.Ascend(func(i BtreeItem) bool {
+		var data []byte
+		if data, err = marshal(i); err != nil {
+			return false
+		}
+		binary.BigEndian.PutUint32(lenBuf, uint32(len(data)))
+		if _, err = w.Write(lenBuf); err != nil {
+			return false
+		}
+		_, err = w.Write(data)
+		return err == nil
+	})
+	return
+}
+
+// writeCountedRecords writes the items of the tree as the records of the extend and multipart files.
+func writeCountedRecords(w io.Writer, tree *BTree, marshal func(i BtreeItem) ([]byte, error)) (err error) {
+	varintTmp := make([]byte, binary.MaxVarintLen64)
+	if _, err = w.Write(varintTmp[:binary.PutUvarint(varintTmp, uint64(tree.Len()))]); err != nil {
+		return
+	}
+	tree.Ascend(func(i BtreeItem) bool {
+		var raw []byte
+		if raw, err = marshal(i); err != nil {
+			return false
+		}
+		if _, err = w.Write(varintTmp[:binary.PutUvarint(varintTmp, uint64(len(raw)))]); err != nil {
+			return false
+		}
+		_, err = w.Write(raw)
+		return err == nil
+	})
+	return
+}
+
+// writeSnapshotStream encodes the trees of sm straight into a snapshot stream, without writing any file.
+// The data files are encoded as storeToDir encodes them, in inode order and without index nor warm file,
+// and come with the apply file, the manifest and the sign file. The CRCs are only known once the data
+// files are sent, so the sign file comes last: the reader checks the files against it at the end.
+func (mp *metaPartition) writeSnapshotStream(w io.Writer, sm *storeMsg) (err error) {
+	conf := mp.storeConfig()
+	conf.GroupInodesByType, conf.InodeIndex, conf.DentryIndex, conf.MmapStore = false, false, false, false
+	sw := NewSnapshotWriter(w)
+	if _, err = sw.w.WriteString(snapshotStreamMagic); err != nil {
+		return
+	}
+	sections := []func(w io.Writer) error{
+		func(w io.Writer) error {
+			return writePrefixedRecords(w, sm.inodeTree, func(i BtreeItem) ([]byte, error) { return i.(*Inode).Marshal() })
+		},
+		func(w io.Writer) error {
+			return writePrefixedRecords(w, sm.dentryTree, func(i BtreeItem) ([]byte, error) { return i.(*Dentry).Marshal() })
+		},
+		func(w io.Writer) (err error) {
+			if conf.ExtendDedup {
+				_, err = mp.writeExtendDedup(w, crc32.NewIEEE(), &storeSyncer{}, sm.extendTree)
+				return
+			}
+			return writeCountedRecords(w, sm.extendTree, func(i BtreeItem) ([]byte, error) { return i.(*Extend).Bytes() })
+		},
+		func(w io.Writer) error {
+			return writeCountedRecords(w, sm.multipartTree, func(i BtreeItem) ([]byte, error) { return i.(*Multipart).Bytes() })
+		},
+	}
+	// the HMAC is computed as the files are sent, in the order snapshotHMAC reads them
+	var mac hash.Hash
+	if conf.HMACKeyID != "" {
+		mac = hmac.New(sha256.New, []byte(conf.HMACKeys[conf.HMACKeyID]))
+	}
+	crcs := make([]uint32, len(snapshotDataFiles))
+	sizes := make([]int64, len(snapshotDataFiles))
+	for i, name := range snapshotDataFiles {
+		var sign hash.Hash32 = crc32.NewIEEE()
+		err = sw.writeSectionFrom(name, func(w io.Writer) error {
+			counter := &countingWriter{w: w, sign: sign}
+			if mac != nil {
+				counter.sign = io.MultiWriter(sign, mac)
+			}
+			err := sections[i](counter)
+			sizes[i] = counter.n
+			return err
+		})
+		if err != nil {
+			return errors.NewErrorf("[writeSnapshotStream] section(%v): %s", name, err.Error())
+		}
+		crcs[i] = sign.Sum32()
+		if mac != nil {
+			writeHMACTrailer(mac, name, sizes[i])
+		}
+	}
+	apply := fmt.Sprintf("%d|%d", sm.applyIndex, atomic.LoadUint64(&mp.config.Cursor))
+	snapshotManifest := mp.newSnapshotManifest(sm, crcs, sizes, conf)
+	if snapshotManifest.CanonicalCRCs, err = canonicalCRCs(sm, crcs, conf); err != nil {
+		return
+	}
+	if mac != nil {
+		mac.Write([]byte(apply))
+		writeHMACTrailer(mac, applyIDFile, int64(len(apply)))
+		snapshotManifest.HMAC = &manifestHMAC{KeyID: conf.HMACKeyID, Sum: hex.EncodeToString(mac.Sum(nil))}
+	}
+	manifest, err := json.Marshal(snapshotManifest)
+	if err != nil {
+		return
+	}
+	fields := make([]string, len(crcs))
+	for i, crc := range crcs {
+		fields[i] = fmt.Sprintf("%d", crc)
+	}
+	for _, section := range []struct {
+		name string
+		data string
+	}{
+		{applyIDFile, apply},
+		{manifestFile, string(manifest)},
+		{SnapshotSign, strings.Join(fields, " ")},
+	} {
+		data := section.data
+		if err = sw.writeSectionFrom(section.name, func(w io.Writer) error {
+			_, err := io.WriteString(w, data)
+			return err
+		}); err != nil {
+			return
+		}
+	}
+	if err = binary.Write(sw.w, binary.BigEndian, uint16(0)); err != nil {
+		return
+	}
+	return sw.w.Flush()
+}
+
+// StoreToStream streams the current state of the meta partition into w as a snapshot stream, e.g. to migrate
+// it, without the round trip through the disk of storing a snapshot and streaming its files. The receiver lands
+// it with SnapshotReader.ReadDir, which verifies it as any stream. The snapshot directory is not touched.
+func (mp *metaPartition) StoreToStream(w io.Writer) (err error) {
+	mp.applyMu.Lock()
+	sm, err := mp.captureStoreMsg(atomic.LoadUint64(&mp.applyID))
+	mp.applyMu.Unlock()
+	if err != nil {
+		return errors.NewErrorf("[StoreToStream] partitionID(%v): %s", mp.config.PartitionId, err.Error())
+	}
+	if err = mp.writeSnapshotStream(w, sm); err != nil {
+		return errors.NewErrorf("[StoreToStream] partitionID(%v): %s", mp.config.PartitionId, err.Error())
+	}
+	log.LogInfof("StoreToStream: stream complete: partitionID(%v) volume(%v) applyID(%v) inodes(%v) dentries(%v)",
+		mp.config.PartitionId, mp.config.VolName, sm.applyIndex, sm.inodeTree.Len(), sm.dentryTree.Len())
+	return
+}
@@ -0,0 +1,68 @@
+// Copyright 2018 The Chubao Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metanode
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestStoreToStream(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metanode_store_stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, dedup := range []bool{false, true} {
+		mp := newFixturePartition(dir)
+		mp.config.Snapshot.ExtendDedup = dedup
+		stored := path.Join(dir, fmt.Sprintf("stored_%v", dedup))
+		if err = os.MkdirAll(stored, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err = mp.storeToDir(stored, capturedStoreMsg(mp)); err != nil {
+			t.Fatal(err)
+		}
+		stream := bytes.NewBuffer(nil)
+		if err = mp.StoreToStream(stream); err != nil {
+			t.Fatal(err)
+		}
+		received := path.Join(dir, fmt.Sprintf("received_%v", dedup))
+		if err = NewSnapshotReader(stream).ReadDir(received); err != nil {
+			t.Fatal(err)
+		}
+		// the data files are encoded as the store encodes them
+		for _, name := range append(snapshotDataFiles, SnapshotSign, applyIDFile) {
+			expect, _ := ioutil.ReadFile(path.Join(stored, name))
+			actual, _ := ioutil.ReadFile(path.Join(received, name))
+			if !bytes.Equal(expect, actual) {
+				t.Fatalf("dedup(%v): streamed %v differs from the stored one", dedup, name)
+			}
+		}
+		loaded := NewMetaPartition(&MetaPartitionConfig{PartitionId: 1, RootDir: dir, Start: 1, End: 1000}, nil).(*metaPartition)
+		if err = loaded.LoadSnapshot(received); err != nil {
+			t.Fatal(err)
+		}
+		if loaded.applyID != 100 || loaded.inodeTree.Len() != 4 || loaded.dentryTree.Len() != 2 ||
+			loaded.extendTree.Len() != 1 || loaded.multipartTree.Len() != 1 {
+			t.Fatalf("dedup(%v): loaded stream: applyID(%v) inodes(%v) dentries(%v)", dedup, loaded.applyID,
+				loaded.inodeTree.Len(), loaded.dentryTree.Len())
+		}
+	}
+}
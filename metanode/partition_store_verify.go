@@ -0,0 +1,341 @@
+// Copyright 2018 The Chubao Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metanode
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path"
+
+	"github.com/chubaofs/chubaofs/util/errors"
+)
+
+// VerifySnapshot decodes every record of the snapshot data files in rootDir and discards it,
+// without building any tree or touching a partition. It is a lighter pre-flight check than a
+// full load before promoting a copied snapshot, and reports the first decode error with the
+// file and the offset of the record. Missing data files are skipped as the load does.
+// The CRCs of the inode and dentry indexes are checked too if there are. Inodes refused by the strict
+// inode check of the load, such as inode number zero, are reported as corrupted records.
+func VerifySnapshot(rootDir string) (err error) {
+	snap, err := openSnapshotDir(rootDir)
+	if err != nil {
+		return errors.NewErrorf("[VerifySnapshot] %s", err.Error())
+	}
+	defer snap.Close()
+	for _, v := range snapshotVerifiers {
+		if _, err = verifySnapshotFile(snap, v.name, v.verify, v.decode); err != nil {
+			return
+		}
+	}
+	return verifyIndexFiles(snap)
+}
+
+// FastVerifySnapshot checks that each data file of the snapshot in rootDir still hashes to its CRC in the sign
+// file, and to the size and CRC of its manifest component if there is a manifest, without decoding any record.
+// It is much cheaper than VerifySnapshot, e.g. for a frequent scrub, but does not find a record corrupted before
+// it was stored, which only a decode finds. A missing data file is checked as an empty one, as it is loaded.
+func FastVerifySnapshot(rootDir string) (err error) {
+	snap, err := openSnapshotDir(rootDir)
+	if err != nil {
+		return errors.NewErrorf("[FastVerifySnapshot] %s", err.Error())
+	}
+	defer snap.Close()
+	return fastVerifySnapshot(snap, readerCRC)
+}
+
+// fastVerifySnapshot does what FastVerifySnapshot does on the opened files of snap, computing the CRC of each
+// file with crcOf.
+func fastVerifySnapshot(snap *ActiveSnapshot, crcOf func(r io.Reader) (uint32, error)) (err error) {
+	crcs, err := snap.readSign()
+	if err != nil {
+		return errors.NewErrorf("[FastVerifySnapshot] %s", err.Error())
+	}
+	manifest, err := snap.readManifest()
+	if err != nil {
+		return errors.NewErrorf("[FastVerifySnapshot] %s", err.Error())
+	}
+	components := make(map[string]*manifestComponent)
+	if manifest != nil {
+		for _, c := range manifest.Components {
+			components[c.Name] = c
+		}
+	}
+	for i, name := range snapshotDataFiles {
+		filename := path.Join(snap.Dir, name)
+		var (
+			crc  uint32
+			size int64
+		)
+		r, openErr := snap.open(name)
+		if openErr == nil {
+			size = r.Size()
+			if crc, err = crcOf(r); err != nil {
+				return errors.NewErrorf("[FastVerifySnapshot] %s", err.Error())
+			}
+		} else if !os.IsNotExist(openErr) {
+			return errors.NewErrorf("[FastVerifySnapshot] %s", openErr.Error())
+		}
+		if crc != crcs[i] {
+			return errors.NewErrorf("[FastVerifySnapshot] crc mismatch: file(%v) sign(%v) actual(%v)", filename, crcs[i], crc)
+		}
+		if c := components[name]; c != nil && (c.Size != size || c.CRC != crc) {
+			return errors.NewErrorf("[FastVerifySnapshot] manifest mismatch: file(%v) manifest size(%v) crc(%v) "+
+				"actual size(%v) crc(%v)", filename, c.Size, c.CRC, size, crc)
+		}
+	}
+	return
+}
+
+// SnapshotVerifyReport is the result of CheckSnapshot. Error is the first error found, empty if the snapshot is sound.
+type SnapshotVerifyReport struct {
+	Dir   string                    `json:"dir"`
+	OK    bool                      `json:"ok"`
+	Error string                    `json:"error,omitempty"`
+	Files []*SnapshotFileVerifyInfo `json:"files"`
+}
+
+// SnapshotFileVerifyInfo is the result of the check of a data file. CRCStatus is "ok", "mismatch"
+// or "unsigned" when the sign file can not be read. Count is the number of records decoded before Error.
+type SnapshotFileVerifyInfo struct {
+	Name      string `json:"name"`
+	Size      int64  `json:"size"`
+	Count     int64  `json:"count"`
+	CRC       uint32 `json:"crc"`
+	SignCRC   uint32 `json:"sign_crc"`
+	CRCStatus string `json:"crc_status"`
+	Error     string `json:"error,omitempty"`
+}
+
+const (
+	crcStatusOK       = "ok"
+	crcStatusMismatch = "mismatch"
+	crcStatusUnsigned = "unsigned"
+)
+
+// CheckSnapshot does what VerifySnapshot does, and also compares the CRC of each data file with the sign file,
+// but does not stop at the first error: every file is checked, and the result of each is reported.
+func CheckSnapshot(rootDir string) (report *SnapshotVerifyReport) {
+	snap, err := openSnapshotDir(rootDir)
+	if err != nil {
+		return &SnapshotVerifyReport{Dir: rootDir, Error: err.Error()}
+	}
+	defer snap.Close()
+	return checkSnapshot(snap)
+}
+
+// checkSnapshot does what CheckSnapshot does on the opened files of snap.
+func checkSnapshot(snap *ActiveSnapshot) (report *SnapshotVerifyReport) {
+	report = &SnapshotVerifyReport{Dir: snap.Dir}
+	fail := func(err error) {
+		if report.Error == "" {
+			report.Error = err.Error()
+		}
+	}
+	signs, signErr := snap.readSign()
+	if signErr != nil {
+		fail(signErr)
+	}
+	for i, v := range snapshotVerifiers {
+		filename := path.Join(snap.Dir, v.name)
+		r, err := snap.open(v.name)
+		if os.IsNotExist(err) {
+			continue
+		}
+		file := &SnapshotFileVerifyInfo{Name: v.name, CRCStatus: crcStatusUnsigned}
+		report.Files = append(report.Files, file)
+		if err != nil {
+			file.Error = err.Error()
+			fail(err)
+			continue
+		}
+		file.Size = r.Size()
+		if file.CRC, err = readerCRC(r); err != nil {
+			file.Error = err.Error()
+			fail(err)
+			continue
+		}
+		if signErr == nil {
+			file.SignCRC = signs[i]
+			if file.CRC == file.SignCRC {
+				file.CRCStatus = crcStatusOK
+			} else {
+				file.CRCStatus = crcStatusMismatch
+				fail(errors.NewErrorf("[CheckSnapshot] crc mismatch: file(%v) expect(%v) actual(%v)",
+					filename, file.SignCRC, file.CRC))
+			}
+		}
+		if file.Count, err = verifySnapshotFile(snap, v.name, v.verify, v.decode); err != nil {
+			file.Error = err.Error()
+			fail(err)
+		}
+	}
+	if err := verifyIndexFiles(snap); err != nil {
+		fail(err)
+	}
+	report.OK = report.Error == ""
+	return
+}
+
+type recordVerifier func(reader *bufio.Reader, size int64, decode func(raw []byte) error) error
+
+// snapshotVerifiers tells how to walk and decode the records of each snapshot data file, in the order of snapshotDataFiles.
+var snapshotVerifiers = []struct {
+	name   string
+	verify recordVerifier
+	decode func(raw []byte) error
+}{
+	{inodeFile, verifyPrefixedRecords, func(raw []byte) error {
+		ino := NewInode(0, 0)
+		if err := ino.Unmarshal(raw); err != nil {
+			return err
+		}
+		if reason := checkLoadedInode(ino); reason != "" {
+			return fmt.Errorf("invalid inode(%v): %v", ino.Inode, reason)
+		}
+		return nil
+	}},
+	{dentryFile, verifyPrefixedRecords, func(raw []byte) error {
+		return (&Dentry{}).Unmarshal(raw)
+	}},
+	{extendFile, verifyExtendRecords, func(raw []byte) error {
+		_, err := NewExtendFromBytes(raw)
+		return err
+	}},
+	{multipartFile, verifyCountedRecords, func(raw []byte) error {
+		_, err := MultipartFromBytes(raw)
+		return err
+	}},
+}
+
+// verifySnapshotFile returns the number of records decoded from the opened file, which is zero if it is missing.
+func verifySnapshotFile(snap *ActiveSnapshot, name string, verify recordVerifier, decode func(raw []byte) error) (count int64, err error) {
+	filename := path.Join(snap.Dir, name)
+	r, err := snap.open(name)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, errors.NewErrorf("[VerifySnapshot] open file: filename(%v): %s", filename, err.Error())
+	}
+	reader := bufio.NewReaderSize(r, 4*1024*1024)
+	err = verify(reader, r.Size(), func(raw []byte) (err error) {
+		// a decoder bug must be reported as a corrupted record instead of crashing the caller
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("%v", r)
+			}
+		}()
+		if err = decode(raw); err == nil {
+			count++
+		}
+		return
+	})
+	if err != nil {
+		return count, errors.NewErrorf("[VerifySnapshot] filename(%v): %s", filename, err.Error())
+	}
+	return
+}
+
+// verifyPrefixedRecords walks the records of the inode and dentry files, each prefixed with its 4-byte length.
+func verifyPrefixedRecords(reader *bufio.Reader, size int64, decode func(raw []byte) error) (err error) {
+	var (
+		offset int64
+		header = make([]byte, 4)
+		buf    []byte
+	)
+	for {
+		if _, err = io.ReadFull(reader, header); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("truncated record header at offset %v", offset)
+		}
+		length := binary.BigEndian.Uint32(header)
+		if int64(length) > size-offset-4 {
+			return fmt.Errorf("record out of bounds at offset %v: length(%v) size(%v)", offset, length, size)
+		}
+		if uint32(cap(buf)) >= length {
+			buf = buf[:length]
+		} else {
+			buf = make([]byte, length)
+		}
+		if _, err = io.ReadFull(reader, buf); err != nil {
+			return fmt.Errorf("truncated record body at offset %v: length(%v)", offset, length)
+		}
+		if err = decode(buf); err != nil {
+			return fmt.Errorf("decode record at offset %v: %v", offset, err)
+		}
+		offset += 4 + int64(length)
+	}
+}
+
+// verifyCountedRecords walks the records of the extend and multipart files, which start with the number of
+// records, each prefixed with its uvarint length.
+func verifyCountedRecords(reader *bufio.Reader, size int64, decode func(raw []byte) error) (err error) {
+	counter := &countingByteReader{reader: reader}
+	var count uint64
+	if count, err = binary.ReadUvarint(counter); err == io.EOF {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("read count: %v", err)
+	}
+	var buf []byte
+	for i := uint64(0); i < count; i++ {
+		offset := counter.offset
+		var length uint64
+		if length, err = binary.ReadUvarint(counter); err != nil {
+			return fmt.Errorf("read length of record %v at offset %v: %v", i, offset, err)
+		}
+		if length > uint64(size-counter.offset) {
+			return fmt.Errorf("record %v out of bounds at offset %v: length(%v) size(%v)", i, offset, length, size)
+		}
+		if uint64(cap(buf)) >= length {
+			buf = buf[:length]
+		} else {
+			buf = make([]byte, length)
+		}
+		if _, err = io.ReadFull(counter, buf); err != nil {
+			return fmt.Errorf("truncated record %v at offset %v: length(%v)", i, offset, length)
+		}
+		if err = decode(buf); err != nil {
+			return fmt.Errorf("decode record %v at offset %v: %v", i, offset, err)
+		}
+	}
+	if counter.offset != size {
+		return fmt.Errorf("trailing bytes after %v records: offset(%v) size(%v)", count, counter.offset, size)
+	}
+	return nil
+}
+
+// countingByteReader tracks the offset of a reader consumed by both binary.ReadUvarint and io.ReadFull.
+type countingByteReader struct {
+	reader *bufio.Reader
+	offset int64
+}
+
+func (r *countingByteReader) ReadByte() (b byte, err error) {
+	if b, err = r.reader.ReadByte(); err == nil {
+		r.offset++
+	}
+	return
+}
+
+func (r *countingByteReader) Read(p []byte) (n int, err error) {
+	n, err = r.reader.Read(p)
+	r.offset += int64(n)
+	return
+}
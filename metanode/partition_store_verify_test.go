@@ -0,0 +1,116 @@
+// Copyright 2018 The Chubao Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metanode
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestCheckSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metanode_check_snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mp := newFixturePartition(dir)
+	if err = mp.storeToDir(dir, capturedStoreMsg(mp)); err != nil {
+		t.Fatal(err)
+	}
+	report := CheckSnapshot(dir)
+	if !report.OK || len(report.Files) != 4 || report.Files[0].Count != 4 || report.Files[1].Count != 2 {
+		t.Fatalf("sound snapshot: %+v %+v", report, report.Files)
+	}
+	// corrupt the dentry file: its CRC no longer matches and its record can not be decoded
+	filename := path.Join(dir, dentryFile)
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filename, data[:len(data)-1], 0644); err != nil {
+		t.Fatal(err)
+	}
+	report = CheckSnapshot(dir)
+	if dentry := report.Files[1]; report.OK || dentry.CRCStatus != crcStatusMismatch || dentry.Error == "" || dentry.Count != 1 {
+		t.Fatalf("corrupted snapshot: %+v %+v", report, dentry)
+	}
+	if multipart := report.Files[3]; multipart.CRCStatus != crcStatusOK || multipart.Error != "" {
+		t.Fatalf("file after the corrupted one: %+v", multipart)
+	}
+}
+
+func TestFastVerifySnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metanode_fast_verify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mp := newFixturePartition(dir)
+	if err = mp.storeToDir(dir, capturedStoreMsg(mp)); err != nil {
+		t.Fatal(err)
+	}
+	if err = FastVerifySnapshot(dir); err != nil {
+		t.Fatal(err)
+	}
+	// a flipped byte keeps the size of the file, only its CRC tells
+	data, _ := ioutil.ReadFile(path.Join(dir, inodeFile))
+	data[len(data)-1] ^= 0xff
+	ioutil.WriteFile(path.Join(dir, inodeFile), data, 0644)
+	if err = FastVerifySnapshot(dir); err == nil || !strings.Contains(err.Error(), "crc mismatch") {
+		t.Fatalf("fast verify of a flipped byte: %v", err)
+	}
+}
+
+func TestCheckActiveSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metanode_check_active")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mp := newFixturePartition(dir)
+	if _, err = OpenActiveSnapshot(dir, mp.config.Snapshot); !os.IsNotExist(err) {
+		t.Fatalf("open before the first store: %v", err)
+	}
+	if err = mp.store(capturedStoreMsg(mp)); err != nil {
+		t.Fatal(err)
+	}
+	snap, err := OpenActiveSnapshot(dir, mp.config.Snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snap.Close()
+	// a store replaces the snapshot after it is opened: the held files are still checked as one set
+	mp.inodeTree.Delete(&Inode{Inode: 4})
+	mp.applyID++
+	if err = mp.store(capturedStoreMsg(mp)); err != nil {
+		t.Fatal(err)
+	}
+	if report := checkSnapshot(snap); !report.OK || report.Files[0].Count != 4 {
+		t.Fatalf("snapshot replaced during the check: %+v %+v", report, report.Files)
+	}
+	if err = fastVerifySnapshot(snap, readerCRC); err != nil {
+		t.Fatal(err)
+	}
+	desc, err := describeSnapshot(snap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if desc.ApplyID != 100 {
+		t.Fatalf("described applyID %v, want the one of the opened snapshot", desc.ApplyID)
+	}
+}
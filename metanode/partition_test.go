@@ -0,0 +1,196 @@
+// Copyright 2018 The Chubao Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metanode
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/chubaofs/chubaofs/proto"
+)
+
+// TestCheckpoint takes a checkpoint and expects a verified set matching a store, next to the live snapshot.
+func TestCheckpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metanode_checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mp := newFixturePartition(dir)
+	handle, err := mp.Checkpoint("backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if handle.Dir != path.Join(dir, checkpointPrefix+"backup") || handle.ApplyID != mp.applyID {
+		t.Fatalf("unexpected handle %+v", handle)
+	}
+	crcs, err := checkSnapshotSign(handle.Dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snapshotDigest(crcs) != handle.Digest {
+		t.Fatalf("digest %v does not match the files %v", handle.Digest, snapshotDigest(crcs))
+	}
+	if _, err = os.Stat(path.Join(dir, snapshotDir)); !os.IsNotExist(err) {
+		t.Fatal("checkpoint touched the snapshot directory")
+	}
+	// a checkpoint is never overwritten, and its name must be a plain name
+	for _, name := range []string{"backup", "", "a/b"} {
+		if _, err = mp.Checkpoint(name); err == nil {
+			t.Fatalf("checkpoint %q accepted", name)
+		}
+	}
+	if _, err = os.Stat(handle.Dir); err != nil {
+		t.Fatalf("refused checkpoint removed the existing one: %v", err)
+	}
+}
+
+func TestSnapshotConfigNames(t *testing.T) {
+	if err := (SnapshotConfig{}).checkNames(); err != nil {
+		t.Fatalf("default names: %v", err)
+	}
+	for _, c := range []SnapshotConfig{
+		{DirName: "a/b"},
+		{TmpDirName: ".."},
+		{BackupDirName: checkpointPrefix + "x"},
+		{DirName: "snap", TmpDirName: "snap"},
+		{MetadataTmpName: metadataFile},
+	} {
+		if err := c.checkNames(); err == nil {
+			t.Errorf("names %+v accepted", c)
+		}
+	}
+}
+
+// TestStoreCustomNames stores into renamed snapshot directories and loads the partition back from them.
+func TestStoreCustomNames(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metanode_names")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	names := SnapshotConfig{DirName: "snap", TmpDirName: "snap.tmp", BackupDirName: "snap.bak", MetadataTmpName: "meta.tmp"}
+	mp := newFixturePartition(dir)
+	mp.config.Snapshot = names
+	mp.config.Peers = []proto.Peer{{ID: 1, Addr: "127.0.0.1:17210"}}
+	if err = mp.store(capturedStoreMsg(mp)); err != nil {
+		t.Fatal(err)
+	}
+	if err = mp.persistMetadata(); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{snapshotDir, snapshotBackup, snapshotDirTmp} {
+		if _, err = os.Stat(path.Join(dir, name)); !os.IsNotExist(err) {
+			t.Fatalf("default name %v used", name)
+		}
+	}
+	if _, err = os.Stat(path.Join(dir, names.DirName)); err != nil {
+		t.Fatalf("snapshot not stored under its name: %v", err)
+	}
+	loaded := NewMetaPartition(&MetaPartitionConfig{PartitionId: 1, RootDir: dir, Snapshot: names}, nil).(*metaPartition)
+	if err = loaded.load(); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.applyID != mp.applyID || loaded.inodeTree.Len() != mp.inodeTree.Len() {
+		t.Fatalf("loaded applyID(%v) inodes(%v)", loaded.applyID, loaded.inodeTree.Len())
+	}
+}
+
+// TestDurabilityMode expects nosync to be accepted and to skip the sync only in builds with the nosync tag.
+func TestDurabilityMode(t *testing.T) {
+	for _, mode := range []string{"", DurabilityFull} {
+		if err := (SnapshotConfig{DurabilityMode: mode}).checkDurability(); err != nil {
+			t.Fatalf("mode %q: %v", mode, err)
+		}
+	}
+	if err := (SnapshotConfig{DurabilityMode: "async"}).checkDurability(); err == nil {
+		t.Fatal("unknown mode accepted")
+	}
+	noSync := SnapshotConfig{DurabilityMode: DurabilityNoSync}
+	if err := noSync.checkDurability(); (err == nil) != noSyncAllowed {
+		t.Fatalf("nosync allowed(%v): %v", noSyncAllowed, err)
+	}
+	// syncing a closed file fails, so a nil error shows the sync was skipped
+	fp, err := ioutil.TempFile("", "metanode_durability")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(fp.Name())
+	fp.Close()
+	mp := NewMetaPartition(&MetaPartitionConfig{PartitionId: 1, Snapshot: noSync}, nil).(*metaPartition)
+	if err = mp.syncFile(fp); (err == nil) != noSyncAllowed {
+		t.Fatalf("nosync allowed(%v): sync err %v", noSyncAllowed, err)
+	}
+}
+
+// TestLoadBackupReadRepair corrupts the snapshot, and expects the load to fall back to the backup
+// and the read repair to store a good snapshot again.
+func TestLoadBackupReadRepair(t *testing.T) {
+	for _, repair := range []bool{false, true} {
+		dir, err := ioutil.TempDir("", "metanode_read_repair")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		mp := newFixturePartition(dir)
+		mp.config.Peers = []proto.Peer{{ID: 1, Addr: "127.0.0.1:17210"}}
+		if err = mp.persistMetadata(); err != nil {
+			t.Fatal(err)
+		}
+		snapshotPath := path.Join(dir, snapshotDir)
+		for _, d := range []string{snapshotPath, path.Join(dir, snapshotBackup)} {
+			if err = os.MkdirAll(d, 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err = mp.storeToDir(d, capturedStoreMsg(mp)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err = os.Truncate(path.Join(snapshotPath, dentryFile), 10); err != nil {
+			t.Fatal(err)
+		}
+		conf := &MetaPartitionConfig{PartitionId: 1, RootDir: dir}
+		conf.Snapshot.ReadRepair = repair
+		loaded := NewMetaPartition(conf, nil).(*metaPartition)
+		if err = loaded.load(); err != nil {
+			t.Fatal(err)
+		}
+		if loaded.applyID != mp.applyID || loaded.dentryTree.Len() != mp.dentryTree.Len() {
+			t.Fatalf("repair(%v): loaded applyID(%v) dentries(%v)", repair, loaded.applyID, loaded.dentryTree.Len())
+		}
+		if !repair {
+			if !loaded.isForceFullStore() {
+				t.Fatal("load from the backup does not force the next store")
+			}
+			continue
+		}
+		if err = VerifySnapshot(snapshotPath); err != nil {
+			t.Fatalf("snapshot not repaired: %v", err)
+		}
+	}
+}
+
+func TestSnapshotConfigString(t *testing.T) {
+	conf := SnapshotConfig{HMACKeyID: "k2", HMACKeys: map[string]string{"k1": "first-secret", "k2": "second-secret"}}
+	for _, s := range []string{conf.String(), fmt.Sprintf("%+v", conf), fmt.Sprintf("%v", &MetaPartitionConfig{Snapshot: conf})} {
+		if strings.Contains(s, "secret") || !strings.Contains(s, "k1") {
+			t.Fatalf("printed config: %s", s)
+		}
+	}
+}
@@ -31,6 +31,7 @@ import (
 // RaftStore defines the interface for the raft store.
 type RaftStore interface {
 	CreatePartition(cfg *PartitionConfig) (Partition, error)
+	LogRange(cfg *PartitionConfig) (first, last uint64, err error)
 	Stop()
 	RaftConfig() *raft.Config
 	RaftStatus(raftID uint64) (raftStatus *raft.Status)
@@ -141,6 +142,28 @@ func (s *raftStore) RaftServer() *raft.RaftServer {
 	return s.raftServer
 }
 
+func (s *raftStore) walPath(cfg *PartitionConfig) string {
+	if cfg.WalPath == "" {
+		return path.Join(s.raftPath, strconv.FormatUint(cfg.ID, 10))
+	}
+	return path.Join(cfg.WalPath, "wal_"+strconv.FormatUint(cfg.ID, 10))
+}
+
+// LogRange returns the indexes of the first and the last entries of the raft log of a partition which is not
+// created yet, i.e. the entries replayed by the partition once created. An empty log has last = first - 1.
+func (s *raftStore) LogRange(cfg *PartitionConfig) (first, last uint64, err error) {
+	ws, err := wal.NewStorage(s.walPath(cfg), &wal.Config{})
+	if err != nil {
+		return
+	}
+	defer ws.Close()
+	if first, err = ws.FirstIndex(); err != nil {
+		return
+	}
+	last, err = ws.LastIndex()
+	return
+}
+
 // CreatePartition creates a new partition in the raft store.
 func (s *raftStore) CreatePartition(cfg *PartitionConfig) (p Partition, err error) {
 	// Init WaL Storage for this partition.
@@ -148,12 +171,7 @@ func (s *raftStore) CreatePartition(cfg *PartitionConfig) (p Partition, err erro
 	// wc: WaL Configuration.
 	// wp: WaL Path.
 	// ws: WaL Storage.
-	var walPath string
-	if cfg.WalPath == "" {
-		walPath = path.Join(s.raftPath, strconv.FormatUint(cfg.ID, 10))
-	} else {
-		walPath = path.Join(cfg.WalPath, "wal_"+strconv.FormatUint(cfg.ID, 10))
-	}
+	walPath := s.walPath(cfg)
 
 	wc := &wal.Config{}
 	ws, err := wal.NewStorage(walPath, wc)
@@ -152,6 +152,11 @@ func (c *Config) GetInt64(key string) int64 {
 	return 0
 }
 
+// GetValue returns the raw value decoded from JSON for the config key, or nil if it is not present.
+func (c *Config) GetValue(key string) interface{} {
+	return c.data[key]
+}
+
 // GetSlice returns an array for the config key.
 func (c *Config) GetSlice(key string) []interface{} {
 	result, present := c.data[key]